@@ -0,0 +1,53 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+var (
+	ErrAddressNotFound = errors.New("address not found")
+	ErrInvalidAddress  = errors.New("invalid address")
+)
+
+// Address is an entry in an account's address book. Country is an ISO
+// 3166-1 alpha-2 code.
+type Address struct {
+	ID         int32  `json:"id"`
+	AccountID  int32  `json:"account_id"`
+	Name       string `json:"name"`
+	Line1      string `json:"line1"`
+	Line2      string `json:"line2"`
+	City       string `json:"city"`
+	Region     string `json:"region"`
+	PostalCode string `json:"postal_code"`
+	Country    string `json:"country"`
+	Phone      string `json:"phone"`
+	IsDefault  bool   `json:"is_default"`
+}
+
+var countryCode = regexp.MustCompile(`^[A-Z]{2}$`)
+
+// normalize trims a's fields and upper-cases the country, then checks the
+// ones every carrier needs are there.
+func (a Address) normalize() (Address, error) {
+	for _, field := range []*string{&a.Name, &a.Line1, &a.Line2, &a.City, &a.Region, &a.PostalCode, &a.Country, &a.Phone} {
+		*field = strings.TrimSpace(*field)
+	}
+	a.Country = strings.ToUpper(a.Country)
+
+	switch {
+	case a.Name == "":
+		return Address{}, fmt.Errorf("%w: name is required", ErrInvalidAddress)
+	case a.Line1 == "":
+		return Address{}, fmt.Errorf("%w: line1 is required", ErrInvalidAddress)
+	case a.City == "":
+		return Address{}, fmt.Errorf("%w: city is required", ErrInvalidAddress)
+	case !countryCode.MatchString(a.Country):
+		return Address{}, fmt.Errorf("%w: country must be a two letter ISO 3166-1 code", ErrInvalidAddress)
+	}
+
+	return a, nil
+}
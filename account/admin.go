@@ -0,0 +1,58 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/airlangga-hub/microservices/account/config"
+)
+
+// adminScopes are the scopes of the key the admin-key command issues.
+var adminScopes = []string{"*:*:*"}
+
+// runAdminKey implements `account admin-key <account_id>`: it makes the
+// account an admin and prints a new key scoped for everything. It is how
+// the first admin gets a key, since every RPC that creates one needs a key
+// already.
+func runAdminKey(cfg config.Config, args []string) error {
+	if len(args) != 1 {
+		return fmt.Errorf("want admin-key <account_id>")
+	}
+
+	accountID, err := strconv.ParseInt(args[0], 10, 32)
+	if err != nil || accountID < 1 {
+		return fmt.Errorf("invalid account id %q", args[0])
+	}
+
+	repository, err := NewRepository(cfg.DatabaseURL, cfg.DB)
+	if err != nil {
+		return fmt.Errorf("opening repository: %w", err)
+	}
+	defer repository.Close()
+
+	// the command sends no verification emails, so it loads no token settings
+	key, err := issueAdminKey(context.Background(), NewService(repository, NewStdoutMailer(), 0), int32(accountID))
+	if err != nil {
+		return err
+	}
+
+	fmt.Println(key)
+
+	return nil
+}
+
+// issueAdminKey gives accountID the admin role and a key with adminScopes.
+func issueAdminKey(ctx context.Context, svc Service, accountID int32) (string, error) {
+	if _, err := svc.AssignRole(ctx, accountID, "admin"); err != nil {
+		return "", err
+	}
+
+	_, key, err := svc.CreateAPIKey(ctx, accountID, "admin", adminScopes, time.Time{})
+	if err != nil {
+		return "", err
+	}
+
+	return key, nil
+}
@@ -0,0 +1,207 @@
+package main
+
+import (
+	"context"
+	"errors"
+
+	"github.com/airlangga-hub/microservices/account/pb"
+	"github.com/airlangga-hub/microservices/audit"
+	"google.golang.org/protobuf/proto"
+)
+
+// auditSpecs lists the account RPCs that change something. Secrets, like
+// a new API key or a verification token, are never part of an entry.
+func (s *Server) auditSpecs() map[string]audit.Spec {
+	account := func(ctx context.Context, id int32) (proto.Message, error) {
+		a, err := s.Svc.GetAccount(ctx, id)
+		if errors.Is(err, ErrAccountNotFound) {
+			return nil, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		return pbAccount(a), nil
+	}
+
+	address := func(ctx context.Context, accountID, addressID int32) (proto.Message, error) {
+		addresses, err := s.Svc.ListAddresses(ctx, accountID)
+		if err != nil {
+			return nil, err
+		}
+		for _, a := range addresses {
+			if a.ID == addressID {
+				return pbAddress(a), nil
+			}
+		}
+		return nil, nil
+	}
+
+	roles := func(ctx context.Context, accountID int32) (proto.Message, error) {
+		roles, err := s.Svc.ListRoles(ctx, accountID)
+		if errors.Is(err, ErrAccountNotFound) {
+			return nil, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		return &pb.ListRolesResponse{Roles: pbRoles(roles)}, nil
+	}
+
+	apiKey := func(ctx context.Context, accountID, id int32) (proto.Message, error) {
+		keys, err := s.Svc.ListAPIKeys(ctx, accountID)
+		if err != nil {
+			return nil, err
+		}
+		for _, k := range keys {
+			if k.ID == id {
+				return pbAPIKey(k)
+			}
+		}
+		return nil, nil
+	}
+
+	return map[string]audit.Spec{
+		pb.AccountService_PostAccount_FullMethodName: audit.Audited(
+			"account",
+			func(_ *pb.PostAccountRequest, resp *pb.PostAccountResponse) string {
+				return audit.IntID(resp.Account.Id)
+			},
+			nil,
+			func(_ context.Context, _ *pb.PostAccountRequest, resp *pb.PostAccountResponse) (proto.Message, error) {
+				return resp.Account, nil
+			},
+		),
+		pb.AccountService_AddAddress_FullMethodName: audit.Audited(
+			"address",
+			func(_ *pb.AddAddressRequest, resp *pb.AddAddressResponse) string { return audit.IntID(resp.Address.Id) },
+			nil,
+			func(_ context.Context, _ *pb.AddAddressRequest, resp *pb.AddAddressResponse) (proto.Message, error) {
+				return resp.Address, nil
+			},
+		),
+		pb.AccountService_UpdateAddress_FullMethodName: audit.Audited(
+			"address",
+			func(req *pb.UpdateAddressRequest, _ *pb.UpdateAddressResponse) string {
+				return audit.IntID(req.Address.GetId())
+			},
+			func(ctx context.Context, req *pb.UpdateAddressRequest) (proto.Message, error) {
+				return address(ctx, req.AccountId, req.Address.GetId())
+			},
+			func(_ context.Context, _ *pb.UpdateAddressRequest, resp *pb.UpdateAddressResponse) (proto.Message, error) {
+				return resp.Address, nil
+			},
+		),
+		pb.AccountService_DeleteAddress_FullMethodName: audit.Audited(
+			"address",
+			func(req *pb.DeleteAddressRequest, _ *pb.DeleteAddressResponse) string {
+				return audit.IntID(req.AddressId)
+			},
+			func(ctx context.Context, req *pb.DeleteAddressRequest) (proto.Message, error) {
+				return address(ctx, req.AccountId, req.AddressId)
+			},
+			nil,
+		),
+		pb.AccountService_SetDefaultAddress_FullMethodName: audit.Audited(
+			"address",
+			func(req *pb.SetDefaultAddressRequest, _ *pb.SetDefaultAddressResponse) string {
+				return audit.IntID(req.AddressId)
+			},
+			func(ctx context.Context, req *pb.SetDefaultAddressRequest) (proto.Message, error) {
+				return address(ctx, req.AccountId, req.AddressId)
+			},
+			func(_ context.Context, _ *pb.SetDefaultAddressRequest, resp *pb.SetDefaultAddressResponse) (proto.Message, error) {
+				return resp.Address, nil
+			},
+		),
+		pb.AccountService_RequestEmailVerification_FullMethodName: audit.Audited(
+			"account",
+			func(req *pb.RequestEmailVerificationRequest, _ *pb.RequestEmailVerificationResponse) string {
+				return audit.IntID(req.AccountId)
+			},
+			nil,
+			nil,
+		),
+		pb.AccountService_VerifyEmail_FullMethodName: audit.Audited(
+			"account",
+			func(_ *pb.VerifyEmailRequest, resp *pb.VerifyEmailResponse) string {
+				return audit.IntID(resp.Account.Id)
+			},
+			nil,
+			func(_ context.Context, _ *pb.VerifyEmailRequest, resp *pb.VerifyEmailResponse) (proto.Message, error) {
+				return resp.Account, nil
+			},
+		),
+		pb.AccountService_AssignRole_FullMethodName: audit.Audited(
+			"account_roles",
+			func(req *pb.AssignRoleRequest, _ *pb.AssignRoleResponse) string { return audit.IntID(req.AccountId) },
+			func(ctx context.Context, req *pb.AssignRoleRequest) (proto.Message, error) {
+				return roles(ctx, req.AccountId)
+			},
+			func(_ context.Context, _ *pb.AssignRoleRequest, resp *pb.AssignRoleResponse) (proto.Message, error) {
+				return &pb.ListRolesResponse{Roles: resp.Roles}, nil
+			},
+		),
+		pb.AccountService_RevokeRole_FullMethodName: audit.Audited(
+			"account_roles",
+			func(req *pb.RevokeRoleRequest, _ *pb.RevokeRoleResponse) string { return audit.IntID(req.AccountId) },
+			func(ctx context.Context, req *pb.RevokeRoleRequest) (proto.Message, error) {
+				return roles(ctx, req.AccountId)
+			},
+			func(_ context.Context, _ *pb.RevokeRoleRequest, resp *pb.RevokeRoleResponse) (proto.Message, error) {
+				return &pb.ListRolesResponse{Roles: resp.Roles}, nil
+			},
+		),
+		pb.AccountService_CreateAPIKey_FullMethodName: audit.Audited(
+			"api_key",
+			func(_ *pb.CreateAPIKeyRequest, resp *pb.CreateAPIKeyResponse) string {
+				return audit.IntID(resp.ApiKey.Id)
+			},
+			nil,
+			func(_ context.Context, _ *pb.CreateAPIKeyRequest, resp *pb.CreateAPIKeyResponse) (proto.Message, error) {
+				return resp.ApiKey, nil
+			},
+		),
+		pb.AccountService_RevokeAPIKey_FullMethodName: audit.Audited(
+			"api_key",
+			func(req *pb.RevokeAPIKeyRequest, _ *pb.RevokeAPIKeyResponse) string { return audit.IntID(req.ApiKeyId) },
+			func(ctx context.Context, req *pb.RevokeAPIKeyRequest) (proto.Message, error) {
+				return apiKey(ctx, req.AccountId, req.ApiKeyId)
+			},
+			func(ctx context.Context, req *pb.RevokeAPIKeyRequest, _ *pb.RevokeAPIKeyResponse) (proto.Message, error) {
+				return apiKey(ctx, req.AccountId, req.ApiKeyId)
+			},
+		),
+		pb.AccountService_SuspendAccount_FullMethodName: audit.Audited(
+			"account",
+			func(req *pb.SuspendAccountRequest, _ *pb.SuspendAccountResponse) string { return audit.IntID(req.Id) },
+			func(ctx context.Context, req *pb.SuspendAccountRequest) (proto.Message, error) {
+				return account(ctx, req.Id)
+			},
+			func(_ context.Context, _ *pb.SuspendAccountRequest, resp *pb.SuspendAccountResponse) (proto.Message, error) {
+				return resp.Account, nil
+			},
+		),
+		pb.AccountService_ReactivateAccount_FullMethodName: audit.Audited(
+			"account",
+			func(req *pb.ReactivateAccountRequest, _ *pb.ReactivateAccountResponse) string {
+				return audit.IntID(req.Id)
+			},
+			func(ctx context.Context, req *pb.ReactivateAccountRequest) (proto.Message, error) {
+				return account(ctx, req.Id)
+			},
+			func(_ context.Context, _ *pb.ReactivateAccountRequest, resp *pb.ReactivateAccountResponse) (proto.Message, error) {
+				return resp.Account, nil
+			},
+		),
+		pb.AccountService_CloseAccount_FullMethodName: audit.Audited(
+			"account",
+			func(req *pb.CloseAccountRequest, _ *pb.CloseAccountResponse) string { return audit.IntID(req.Id) },
+			func(ctx context.Context, req *pb.CloseAccountRequest) (proto.Message, error) {
+				return account(ctx, req.Id)
+			},
+			func(_ context.Context, _ *pb.CloseAccountRequest, resp *pb.CloseAccountResponse) (proto.Message, error) {
+				return resp.Account, nil
+			},
+		),
+	}
+}
@@ -0,0 +1,46 @@
+package main
+
+import (
+	"context"
+
+	"github.com/airlangga-hub/microservices/account/pb"
+	"github.com/airlangga-hub/microservices/auth"
+)
+
+// methodScopes is the scope an API key needs for each account RPC.
+var methodScopes = auth.MethodScopes{
+	pb.AccountService_PostAccount_FullMethodName:              "account:accounts:write",
+	pb.AccountService_GetAccount_FullMethodName:               "account:accounts:read",
+	pb.AccountService_GetAccountByEmail_FullMethodName:        "account:accounts:read",
+	pb.AccountService_GetAccounts_FullMethodName:              "account:accounts:read",
+	pb.AccountService_AddAddress_FullMethodName:               "account:addresses:write",
+	pb.AccountService_ListAddresses_FullMethodName:            "account:addresses:read",
+	pb.AccountService_UpdateAddress_FullMethodName:            "account:addresses:write",
+	pb.AccountService_DeleteAddress_FullMethodName:            "account:addresses:write",
+	pb.AccountService_SetDefaultAddress_FullMethodName:        "account:addresses:write",
+	pb.AccountService_RequestEmailVerification_FullMethodName: "account:accounts:write",
+	pb.AccountService_VerifyEmail_FullMethodName:              "account:accounts:write",
+	pb.AccountService_ListRoles_FullMethodName:                "account:roles:read",
+	pb.AccountService_AssignRole_FullMethodName:               "account:roles:manage",
+	pb.AccountService_RevokeRole_FullMethodName:               "account:roles:manage",
+	pb.AccountService_CheckPermission_FullMethodName:          "account:roles:read",
+	pb.AccountService_CreateAPIKey_FullMethodName:             "account:api_keys:write",
+	pb.AccountService_ListAPIKeys_FullMethodName:              "account:api_keys:read",
+	pb.AccountService_RevokeAPIKey_FullMethodName:             "account:api_keys:write",
+	pb.AccountService_AuthenticateAPIKey_FullMethodName:       "account:api_keys:read",
+	pb.AccountService_SuspendAccount_FullMethodName:           "account:accounts:write",
+	pb.AccountService_ReactivateAccount_FullMethodName:        "account:accounts:write",
+	pb.AccountService_CloseAccount_FullMethodName:             "account:accounts:write",
+	pb.AccountService_QueryAuditLog_FullMethodName:            "account:audit:read",
+}
+
+// authenticateAPIKey resolves the API keys callers send the account service
+// itself; other services ask AuthenticateAPIKey.
+func (s *Server) authenticateAPIKey(ctx context.Context, apiKey string) (auth.Identity, error) {
+	k, permissions, err := s.Svc.AuthenticateAPIKey(ctx, apiKey)
+	if err != nil {
+		return auth.Identity{}, apiKeyError(err)
+	}
+
+	return auth.Identity{AccountID: k.AccountID, Scopes: k.Scopes, Permissions: permissions}, nil
+}
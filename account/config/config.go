@@ -0,0 +1,143 @@
+// Package config loads and validates the account service configuration.
+//
+// Every setting is read from its environment variable, falling back to the
+// JSON file named by ACCOUNT_CONFIG_FILE, then to a default. Load reports all
+// invalid or missing settings at once.
+package config
+
+import (
+	"time"
+
+	"github.com/airlangga-hub/microservices/settings"
+)
+
+const (
+	StoragePostgres = "postgres"
+	StorageMemory   = "memory"
+
+	EventBusMemory = "memory"
+	EventBusNATS   = "nats"
+
+	MailerStdout = "stdout"
+	MailerFile   = "file"
+)
+
+type Config struct {
+	Port            string
+	HTTPPort        string
+	Storage         string
+	DatabaseURL     string
+	MigrateOnStart  bool
+	DB              DB
+	Events          Events
+	Mailer          Mailer
+	Tokens          Tokens
+	ShutdownTimeout time.Duration
+}
+
+// Mailer picks where emails go: stdout, or appended to File. Both are meant
+// for local runs.
+type Mailer struct {
+	Kind string
+	File string
+}
+
+// Tokens configures the emailed tokens: how long they stay valid, and how
+// many requests or attempts a caller gets per RateWindow.
+type Tokens struct {
+	VerificationTTL time.Duration
+	RateLimit       int
+	RateWindow      time.Duration
+}
+
+// DB holds the database/sql connection pool settings.
+type DB struct {
+	MaxOpenConns    int
+	MaxIdleConns    int
+	ConnMaxLifetime time.Duration
+}
+
+// Events configures the bus domain events are published to and how often the
+// outbox relay polls for them.
+type Events struct {
+	Bus          string
+	NATSURL      string
+	PollInterval time.Duration
+	BatchSize    int
+}
+
+func Load() (Config, error) {
+	l := settings.NewLoader("ACCOUNT_CONFIG_FILE")
+
+	cfg := Config{
+		Port:           l.String("ACCOUNT_PORT", ""),
+		HTTPPort:       l.String("ACCOUNT_HTTP_PORT", ":8080"),
+		MigrateOnStart: l.Bool("ACCOUNT_MIGRATE_ON_START", false),
+		Events: Events{
+			Bus:          l.String("ACCOUNT_EVENT_BUS", EventBusMemory),
+			NATSURL:      l.String("NATS_URL", ""),
+			PollInterval: l.Duration("ACCOUNT_OUTBOX_POLL_INTERVAL", time.Second),
+			BatchSize:    l.Int("ACCOUNT_OUTBOX_BATCH_SIZE", 100),
+		},
+		Mailer: Mailer{
+			Kind: l.String("ACCOUNT_MAILER", MailerStdout),
+			File: l.String("ACCOUNT_MAILER_FILE", ""),
+		},
+		Tokens: Tokens{
+			VerificationTTL: l.Duration("ACCOUNT_EMAIL_VERIFICATION_TTL", 24*time.Hour),
+			RateLimit:       l.Int("ACCOUNT_TOKEN_RATE_LIMIT", 5),
+			RateWindow:      l.Duration("ACCOUNT_TOKEN_RATE_WINDOW", 15*time.Minute),
+		},
+		ShutdownTimeout: l.Duration("ACCOUNT_SHUTDOWN_TIMEOUT", 10*time.Second),
+	}
+
+	loadDatabase(l, &cfg)
+
+	l.Address("ACCOUNT_PORT", cfg.Port)
+	l.Address("ACCOUNT_HTTP_PORT", cfg.HTTPPort)
+	l.Check(cfg.Events.Bus == EventBusMemory || cfg.Events.Bus == EventBusNATS, "ACCOUNT_EVENT_BUS must be %q or %q", EventBusMemory, EventBusNATS)
+	if cfg.Events.Bus == EventBusNATS {
+		l.Required("NATS_URL", cfg.Events.NATSURL)
+	}
+	l.Check(cfg.Events.PollInterval > 0, "ACCOUNT_OUTBOX_POLL_INTERVAL must be positive")
+	l.Check(cfg.Events.BatchSize > 0, "ACCOUNT_OUTBOX_BATCH_SIZE must be positive")
+	l.Check(cfg.Mailer.Kind == MailerStdout || cfg.Mailer.Kind == MailerFile, "ACCOUNT_MAILER must be %q or %q", MailerStdout, MailerFile)
+	if cfg.Mailer.Kind == MailerFile {
+		l.Required("ACCOUNT_MAILER_FILE", cfg.Mailer.File)
+	}
+	l.Check(cfg.Tokens.VerificationTTL > 0, "ACCOUNT_EMAIL_VERIFICATION_TTL must be positive")
+	l.Check(cfg.Tokens.RateLimit > 0, "ACCOUNT_TOKEN_RATE_LIMIT must be positive")
+	l.Check(cfg.Tokens.RateWindow > 0, "ACCOUNT_TOKEN_RATE_WINDOW must be positive")
+	l.Check(cfg.ShutdownTimeout > 0, "ACCOUNT_SHUTDOWN_TIMEOUT must be positive")
+
+	return cfg, l.Err("account")
+}
+
+// LoadDatabase loads only the storage and database settings, for commands
+// like migrate that don't run the service.
+func LoadDatabase() (Config, error) {
+	l := settings.NewLoader("ACCOUNT_CONFIG_FILE")
+
+	cfg := Config{}
+	loadDatabase(l, &cfg)
+
+	return cfg, l.Err("account")
+}
+
+func loadDatabase(l *settings.Loader, cfg *Config) {
+	cfg.Storage = l.String("ACCOUNT_STORAGE", StoragePostgres)
+	cfg.DatabaseURL = l.String("ACCOUNT_DB_URL", "")
+	cfg.DB = DB{
+		MaxOpenConns:    l.Int("ACCOUNT_DB_MAX_OPEN_CONNS", 25),
+		MaxIdleConns:    l.Int("ACCOUNT_DB_MAX_IDLE_CONNS", 5),
+		ConnMaxLifetime: l.Duration("ACCOUNT_DB_CONN_MAX_LIFETIME", 30*time.Minute),
+	}
+
+	l.Check(cfg.Storage == StoragePostgres || cfg.Storage == StorageMemory, "ACCOUNT_STORAGE must be %q or %q", StoragePostgres, StorageMemory)
+	if cfg.Storage == StoragePostgres {
+		l.Required("ACCOUNT_DB_URL", cfg.DatabaseURL)
+	}
+	l.Check(cfg.DB.MaxOpenConns > 0, "ACCOUNT_DB_MAX_OPEN_CONNS must be positive")
+	l.Check(cfg.DB.MaxIdleConns >= 0 && cfg.DB.MaxIdleConns <= cfg.DB.MaxOpenConns, "ACCOUNT_DB_MAX_IDLE_CONNS must be between 0 and ACCOUNT_DB_MAX_OPEN_CONNS")
+	l.Check(cfg.DB.ConnMaxLifetime > 0, "ACCOUNT_DB_CONN_MAX_LIFETIME must be positive")
+}
@@ -0,0 +1,109 @@
+package config
+
+import (
+	"maps"
+	"strings"
+	"testing"
+	"time"
+)
+
+// required is the least a postgres-backed account service needs set.
+var required = map[string]string{
+	"ACCOUNT_PORT":   ":9090",
+	"ACCOUNT_DB_URL": "postgres://account_db/account",
+}
+
+// setEnv sets env, clearing the required settings it leaves out and the
+// config file.
+func setEnv(t *testing.T, env map[string]string) {
+	t.Helper()
+
+	t.Setenv("ACCOUNT_CONFIG_FILE", "")
+	for key := range required {
+		t.Setenv(key, "")
+	}
+	for key, value := range env {
+		t.Setenv(key, value)
+	}
+}
+
+func with(changes map[string]string) map[string]string {
+	env := maps.Clone(required)
+	maps.Copy(env, changes)
+	return env
+}
+
+func TestLoadReportsProblems(t *testing.T) {
+	tests := []struct {
+		name     string
+		env      map[string]string
+		problems []string
+	}{
+		{"valid", required, nil},
+		{"nothing set", nil, []string{"ACCOUNT_PORT is required", "ACCOUNT_DB_URL is required"}},
+		{"memory storage needs no database", with(map[string]string{"ACCOUNT_STORAGE": "memory", "ACCOUNT_DB_URL": ""}), nil},
+		{"nats needs a url", with(map[string]string{"ACCOUNT_EVENT_BUS": "nats"}), []string{"NATS_URL is required"}},
+		{"file mailer needs a file", with(map[string]string{"ACCOUNT_MAILER": "file"}), []string{"ACCOUNT_MAILER_FILE is required"}},
+		{"bad values", with(map[string]string{"ACCOUNT_PORT": "9090", "ACCOUNT_TOKEN_RATE_LIMIT": "0"}), []string{`ACCOUNT_PORT: "9090" is not a [host]:port address`, "ACCOUNT_TOKEN_RATE_LIMIT must be positive"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setEnv(t, tt.env)
+
+			_, err := Load()
+			if len(tt.problems) == 0 {
+				if err != nil {
+					t.Errorf("Load = %v, want no problems", err)
+				}
+				return
+			}
+
+			if err == nil {
+				t.Fatalf("Load succeeded, want %v", tt.problems)
+			}
+			for _, problem := range tt.problems {
+				if !strings.Contains(err.Error(), problem) {
+					t.Errorf("Load = %v, want it to report %s", err, problem)
+				}
+			}
+		})
+	}
+}
+
+func TestLoadDefaults(t *testing.T) {
+	setEnv(t, required)
+
+	cfg, err := Load()
+	if err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+
+	if cfg.Storage != StoragePostgres || cfg.HTTPPort != ":8080" || cfg.Mailer.Kind != MailerStdout {
+		t.Errorf("Load = %+v, want postgres storage, HTTP on :8080 and the stdout mailer", cfg)
+	}
+	if cfg.DB != (DB{MaxOpenConns: 25, MaxIdleConns: 5, ConnMaxLifetime: 30 * time.Minute}) {
+		t.Errorf("DB = %+v, want the default pool", cfg.DB)
+	}
+	if cfg.Tokens != (Tokens{VerificationTTL: 24 * time.Hour, RateLimit: 5, RateWindow: 15 * time.Minute}) {
+		t.Errorf("Tokens = %+v, want the defaults", cfg.Tokens)
+	}
+}
+
+// The migrate command runs without the service's port.
+func TestLoadDatabase(t *testing.T) {
+	setEnv(t, map[string]string{"ACCOUNT_DB_URL": "postgres://account_db/account"})
+
+	cfg, err := LoadDatabase()
+	if err != nil {
+		t.Fatalf("LoadDatabase: %v", err)
+	}
+	if cfg.Storage != StoragePostgres || cfg.DatabaseURL != "postgres://account_db/account" || cfg.DB.MaxOpenConns != 25 {
+		t.Errorf("LoadDatabase = %+v, want the database settings", cfg)
+	}
+
+	setEnv(t, nil)
+	if _, err := LoadDatabase(); err == nil || !strings.Contains(err.Error(), "ACCOUNT_DB_URL is required") {
+		t.Errorf("LoadDatabase without a database = %v, want ACCOUNT_DB_URL required", err)
+	}
+}
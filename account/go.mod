@@ -3,14 +3,42 @@ module github.com/airlangga-hub/microservices/account
 go 1.25.3
 
 require (
+	github.com/airlangga-hub/microservices/audit v0.0.0
+	github.com/airlangga-hub/microservices/auth v0.0.0
+	github.com/airlangga-hub/microservices/events v0.0.0
+	github.com/airlangga-hub/microservices/healthcheck v0.0.0
+	github.com/airlangga-hub/microservices/migrate v0.0.0
+	github.com/airlangga-hub/microservices/settings v0.0.0
 	github.com/lib/pq v1.10.9
+	github.com/prometheus/client_golang v1.23.2
 	google.golang.org/grpc v1.78.0
 	google.golang.org/protobuf v1.36.11
 )
 
 require (
+	github.com/beorn7/perks v1.0.1 // indirect
+	github.com/cespare/xxhash/v2 v2.3.0 // indirect
+	github.com/klauspost/compress v1.18.0 // indirect
+	github.com/munnerz/goautoneg v0.0.0-20191010083416-a7dc8b61c822 // indirect
+	github.com/nats-io/nats.go v1.47.0 // indirect
+	github.com/nats-io/nkeys v0.4.11 // indirect
+	github.com/nats-io/nuid v1.0.1 // indirect
+	github.com/prometheus/client_model v0.6.2 // indirect
+	github.com/prometheus/common v0.66.1 // indirect
+	github.com/prometheus/procfs v0.16.1 // indirect
+	go.yaml.in/yaml/v2 v2.4.2 // indirect
+	golang.org/x/crypto v0.44.0 // indirect
 	golang.org/x/net v0.47.0 // indirect
 	golang.org/x/sys v0.38.0 // indirect
 	golang.org/x/text v0.31.0 // indirect
 	google.golang.org/genproto/googleapis/rpc v0.0.0-20251029180050-ab9386a59fda // indirect
 )
+
+replace (
+	github.com/airlangga-hub/microservices/audit => ../audit
+	github.com/airlangga-hub/microservices/auth => ../auth
+	github.com/airlangga-hub/microservices/events => ../events
+	github.com/airlangga-hub/microservices/healthcheck => ../healthcheck
+	github.com/airlangga-hub/microservices/migrate => ../migrate
+	github.com/airlangga-hub/microservices/settings => ../settings
+)
@@ -0,0 +1,45 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"time"
+)
+
+// Mailer delivers the emails the account service sends, like verification
+// codes. Production deployments plug in their email provider behind it.
+type Mailer interface {
+	Send(ctx context.Context, to, subject, body string) error
+}
+
+// writerMailer writes each email as plain text to w, for local runs where
+// the developer reads codes off stdout or a file.
+type writerMailer struct {
+	mu sync.Mutex
+	w  io.Writer
+}
+
+func NewStdoutMailer() Mailer {
+	return &writerMailer{w: os.Stdout}
+}
+
+// NewFileMailer appends emails to the file at path, creating it if needed.
+func NewFileMailer(path string) (Mailer, error) {
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o600)
+	if err != nil {
+		return nil, err
+	}
+
+	return &writerMailer{w: f}, nil
+}
+
+func (m *writerMailer) Send(ctx context.Context, to, subject, body string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	_, err := fmt.Fprintf(m.w, "Date: %s\nTo: %s\nSubject: %s\n\n%s\n\n", time.Now().UTC().Format(time.RFC1123Z), to, subject, body)
+	return err
+}
@@ -1,31 +1,125 @@
 package main
 
 import (
+	"context"
 	"fmt"
 	"log"
 	"net"
+	"net/http"
 	"os"
 	"os/signal"
 	"syscall"
 
+	"github.com/airlangga-hub/microservices/account/config"
 	"github.com/airlangga-hub/microservices/account/pb"
+	"github.com/airlangga-hub/microservices/audit"
+	"github.com/airlangga-hub/microservices/auth"
+	"github.com/airlangga-hub/microservices/events"
+	"github.com/airlangga-hub/microservices/healthcheck"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/reflection"
 )
 
 func main() {
-	dbUrl := os.Getenv("ACCOUNT_DB_URL")
-	port := os.Getenv("ACCOUNT_PORT")
+	if len(os.Args) > 1 && os.Args[1] == "migrate" {
+		cfg, err := config.LoadDatabase()
+		if err != nil {
+			log.Fatalf("ERROR: account main: %v", err)
+		}
+		if cfg.Storage != config.StoragePostgres {
+			log.Fatalf("ERROR: account main: migrate needs %s storage", config.StoragePostgres)
+		}
+		if err := runMigrate(cfg.DatabaseURL, os.Args[2:]); err != nil {
+			log.Fatalf("ERROR: account main: migrate: %v", err)
+		}
+		return
+	}
+
+	if len(os.Args) > 1 && os.Args[1] == "admin-key" {
+		cfg, err := config.LoadDatabase()
+		if err != nil {
+			log.Fatalf("ERROR: account main: %v", err)
+		}
+		if cfg.Storage != config.StoragePostgres {
+			log.Fatalf("ERROR: account main: admin-key needs %s storage", config.StoragePostgres)
+		}
+		if err := runAdminKey(cfg, os.Args[2:]); err != nil {
+			log.Fatalf("ERROR: account main: admin-key: %v", err)
+		}
+		return
+	}
+
+	cfg, err := config.Load()
+	if err != nil {
+		log.Fatalf("ERROR: account main: %v", err)
+	}
+
+	if cfg.MigrateOnStart && cfg.Storage == config.StoragePostgres {
+		if err := runMigrate(cfg.DatabaseURL, nil); err != nil {
+			log.Fatalf("ERROR: account main: migrate on start: %v", err)
+		}
+	}
 
-	repository, err := NewRepository(dbUrl)
+	repository, err := openRepository(cfg)
 	if err != nil {
 		log.Fatalf("ERROR: account main: couldn't create repository: %v", err)
 	}
 	defer repository.Close()
 
-	service := NewService(repository)
+	bus, err := openBus(cfg)
+	if err != nil {
+		log.Fatalf("ERROR: account main: couldn't create event bus: %v", err)
+	}
+	defer bus.Close()
+
+	mailer, err := openMailer(cfg)
+	if err != nil {
+		log.Fatalf("ERROR: account main: couldn't create mailer: %v", err)
+	}
+
+	service := NewService(repository, mailer, cfg.Tokens.VerificationTTL)
+
+	lis, err := net.Listen("tcp", cfg.Port)
+	if err != nil {
+		log.Fatalf("ERROR: account main: couldn't listen on %s: %v", cfg.Port, err)
+	}
+
+	server := &Server{Svc: service, Limiter: newRateLimiter(cfg.Tokens.RateLimit, cfg.Tokens.RateWindow)}
+
+	s := grpc.NewServer(grpc.ChainUnaryInterceptor(
+		auth.UnaryInterceptor(server.authenticateAPIKey, methodScopes),
+		audit.Interceptor("account", repository, server.auditSpecs()),
+	))
+	pb.RegisterAccountServiceServer(s, server)
+
+	healthServer := health.NewServer()
+	healthServer.SetServingStatus("", healthpb.HealthCheckResponse_NOT_SERVING)
+	healthpb.RegisterHealthServer(s, healthServer)
+	reflection.Register(s)
+
+	checker := healthcheck.New(healthServer, pb.AccountService_ServiceDesc.ServiceName, repository.Ping)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
 
-	s := grpc.NewServer()
-	pb.RegisterAccountServiceServer(s, &Server{Svc: service})
+	go checker.Run(ctx)
+
+	relay := events.NewRelay(repository, bus, cfg.Events.PollInterval, cfg.Events.BatchSize)
+	relayDone := make(chan struct{})
+
+	go func() {
+		relay.Run(ctx)
+		close(relayDone)
+	}()
+
+	mux := http.NewServeMux()
+	mux.Handle("/", checker.HTTPHandler())
+	mux.Handle("/metrics", promhttp.Handler())
+
+	httpServer := &http.Server{Addr: cfg.HTTPPort, Handler: mux}
 
 	exitChan := make(chan error, 1)
 
@@ -37,15 +131,64 @@ func main() {
 	}()
 
 	go func() {
-		lis, _ := net.Listen("tcp", port)
 		err := s.Serve(lis)
 		if err != nil && err != grpc.ErrServerStopped {
 			exitChan <- fmt.Errorf("grpc serve error: %v", err)
 		}
 	}()
 
+	go func() {
+		err := httpServer.ListenAndServe()
+		if err != nil && err != http.ErrServerClosed {
+			exitChan <- fmt.Errorf("http serve error: %v", err)
+		}
+	}()
+
 	err = <-exitChan
 	log.Printf("Shutting down. Reason: %v", err)
 
-	s.GracefulStop()
+	cancel()
+	<-relayDone
+	healthServer.Shutdown()
+
+	shutdownCtx, shutdownCancel := context.WithTimeout(context.Background(), cfg.ShutdownTimeout)
+	defer shutdownCancel()
+	httpServer.Shutdown(shutdownCtx)
+
+	stopped := make(chan struct{})
+	go func() {
+		s.GracefulStop()
+		close(stopped)
+	}()
+
+	select {
+	case <-stopped:
+	case <-shutdownCtx.Done():
+		s.Stop()
+	}
+}
+
+func openRepository(cfg config.Config) (Repository, error) {
+	if cfg.Storage == config.StorageMemory {
+		log.Println("WARNING: account main: using in-memory storage, data is lost on restart")
+		return NewMemoryRepository(), nil
+	}
+
+	return NewRepository(cfg.DatabaseURL, cfg.DB)
+}
+
+func openBus(cfg config.Config) (events.Bus, error) {
+	if cfg.Events.Bus == config.EventBusNATS {
+		return events.NewNATSBus(cfg.Events.NATSURL, "account")
+	}
+
+	return events.NewMemoryBus(), nil
+}
+
+func openMailer(cfg config.Config) (Mailer, error) {
+	if cfg.Mailer.Kind == config.MailerFile {
+		return NewFileMailer(cfg.Mailer.File)
+	}
+
+	return NewStdoutMailer(), nil
 }
@@ -0,0 +1,465 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/airlangga-hub/microservices/audit"
+	"github.com/airlangga-hub/microservices/events"
+)
+
+// memoryRepository is a concurrency-safe, in-memory Repository with the same
+// semantics as the postgres one: serial IDs and listing newest first. It is
+// used for running without a database and as a fake in tests.
+type memoryRepository struct {
+	mu            sync.RWMutex
+	accounts      map[int32]Account
+	nextID        int32
+	outbox        []events.Event
+	addresses     map[int32]Address
+	nextAddressID int32
+	tokens        map[string]memoryToken
+	accountRoles  map[int32][]string
+	apiKeys       map[int32]APIKey
+	nextAPIKeyID  int32
+	auditLog      []audit.Entry
+}
+
+type memoryToken struct {
+	Token
+	used bool
+}
+
+func NewMemoryRepository() Repository {
+	return &memoryRepository{
+		accounts:     map[int32]Account{},
+		addresses:    map[int32]Address{},
+		tokens:       map[string]memoryToken{},
+		accountRoles: map[int32][]string{},
+		apiKeys:      map[int32]APIKey{},
+	}
+}
+
+func (r *memoryRepository) Close() error {
+	return nil
+}
+
+func (r *memoryRepository) Ping(ctx context.Context) error {
+	return nil
+}
+
+func (r *memoryRepository) CreateAccount(ctx context.Context, a Account) (Account, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for _, other := range r.accounts {
+		if a.Email != "" && strings.EqualFold(other.Email, a.Email) {
+			return Account{}, ErrEmailTaken
+		}
+	}
+
+	a.ID = r.nextID + 1
+
+	event, err := events.New(EventAccountCreated, strconv.Itoa(int(a.ID)), a)
+	if err != nil {
+		return Account{}, errors.New("error creating account")
+	}
+
+	r.nextID = a.ID
+	r.accounts[a.ID] = a
+	r.outbox = append(r.outbox, event)
+
+	return a, nil
+}
+
+func (r *memoryRepository) UpdateAccountStatus(ctx context.Context, id int32, from, to AccountStatus, reason string) (Account, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	a, exist := r.accounts[id]
+	if !exist || a.Status != from {
+		return Account{}, ErrInvalidStatusTransition
+	}
+
+	a.Status = to
+	a.StatusReason = reason
+
+	event, err := events.New(EventAccountStatusChanged, strconv.Itoa(int(a.ID)), a)
+	if err != nil {
+		return Account{}, errors.New("error updating account status")
+	}
+
+	r.accounts[id] = a
+	r.outbox = append(r.outbox, event)
+
+	return a, nil
+}
+
+func (r *memoryRepository) GetAccountByID(ctx context.Context, id int32) (Account, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	a, exist := r.accounts[id]
+	if !exist {
+		return Account{}, ErrAccountNotFound
+	}
+
+	return a, nil
+}
+
+func (r *memoryRepository) GetAccountByEmail(ctx context.Context, email string) (Account, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	for _, a := range r.accounts {
+		if a.Email != "" && strings.EqualFold(a.Email, email) {
+			return a, nil
+		}
+	}
+
+	return Account{}, ErrAccountNotFound
+}
+
+func (r *memoryRepository) ListAccounts(ctx context.Context, offset, limit int32) ([]Account, error) {
+	if offset < 0 || limit < 0 {
+		return nil, errors.New("error listing accounts")
+	}
+
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	accounts := []Account{}
+	for _, a := range r.accounts {
+		accounts = append(accounts, a)
+	}
+
+	sort.Slice(accounts, func(i, j int) bool { return accounts[i].ID > accounts[j].ID })
+
+	if int(offset) >= len(accounts) {
+		return []Account{}, nil
+	}
+	accounts = accounts[offset:]
+
+	if int(limit) < len(accounts) {
+		accounts = accounts[:limit]
+	}
+
+	return accounts, nil
+}
+
+func (r *memoryRepository) CreateAddress(ctx context.Context, a Address) (Address, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if _, exist := r.accounts[a.AccountID]; !exist {
+		return Address{}, errors.New("error creating address")
+	}
+
+	a.ID = r.nextAddressID + 1
+	a.IsDefault = true
+	for _, o := range r.addresses {
+		if o.AccountID == a.AccountID {
+			a.IsDefault = false
+			break
+		}
+	}
+
+	r.nextAddressID = a.ID
+	r.addresses[a.ID] = a
+
+	return a, nil
+}
+
+func (r *memoryRepository) ListAddresses(ctx context.Context, accountID int32) ([]Address, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	addresses := []Address{}
+	for _, a := range r.addresses {
+		if a.AccountID == accountID {
+			addresses = append(addresses, a)
+		}
+	}
+
+	sort.Slice(addresses, func(i, j int) bool { return addresses[i].ID < addresses[j].ID })
+
+	return addresses, nil
+}
+
+func (r *memoryRepository) UpdateAddress(ctx context.Context, a Address) (Address, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	stored, exist := r.addresses[a.ID]
+	if !exist || stored.AccountID != a.AccountID {
+		return Address{}, ErrAddressNotFound
+	}
+
+	a.IsDefault = stored.IsDefault
+	r.addresses[a.ID] = a
+
+	return a, nil
+}
+
+func (r *memoryRepository) DeleteAddress(ctx context.Context, accountID, addressID int32) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if a, exist := r.addresses[addressID]; !exist || a.AccountID != accountID {
+		return ErrAddressNotFound
+	}
+
+	delete(r.addresses, addressID)
+
+	return nil
+}
+
+func (r *memoryRepository) SetDefaultAddress(ctx context.Context, accountID, addressID int32) (Address, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if a, exist := r.addresses[addressID]; !exist || a.AccountID != accountID {
+		return Address{}, ErrAddressNotFound
+	}
+
+	for id, a := range r.addresses {
+		if a.AccountID == accountID {
+			a.IsDefault = id == addressID
+			r.addresses[id] = a
+		}
+	}
+
+	return r.addresses[addressID], nil
+}
+
+func (r *memoryRepository) CreateToken(ctx context.Context, t Token) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for hash, other := range r.tokens {
+		if other.AccountID == t.AccountID && other.Purpose == t.Purpose && !other.used {
+			delete(r.tokens, hash)
+		}
+	}
+
+	r.tokens[t.Hash] = memoryToken{Token: t}
+
+	return nil
+}
+
+func (r *memoryRepository) VerifyEmail(ctx context.Context, hash string, now time.Time) (Account, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	t, exist := r.tokens[hash]
+	if !exist || t.used || t.Purpose != TokenEmailVerification || !now.Before(t.ExpiresAt) {
+		return Account{}, ErrInvalidToken
+	}
+
+	t.used = true
+	r.tokens[hash] = t
+
+	a := r.accounts[t.AccountID]
+	a.EmailVerified = true
+	r.accounts[a.ID] = a
+
+	return a, nil
+}
+
+// ListRoles returns defaultRoles: the memory repository has no way to add
+// roles.
+func (r *memoryRepository) ListRoles(ctx context.Context) ([]Role, error) {
+	return slices.Clone(defaultRoles), nil
+}
+
+func (r *memoryRepository) ListAccountRoles(ctx context.Context, accountID int32) ([]Role, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	roles := []Role{}
+
+	for _, role := range defaultRoles {
+		if slices.Contains(r.accountRoles[accountID], role.Name) {
+			roles = append(roles, role)
+		}
+	}
+
+	return roles, nil
+}
+
+func (r *memoryRepository) AssignRole(ctx context.Context, accountID int32, role string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if _, exist := r.accounts[accountID]; !exist {
+		return ErrAccountNotFound
+	}
+	if !slices.ContainsFunc(defaultRoles, func(other Role) bool { return other.Name == role }) {
+		return ErrRoleNotFound
+	}
+
+	if !slices.Contains(r.accountRoles[accountID], role) {
+		r.accountRoles[accountID] = append(r.accountRoles[accountID], role)
+	}
+
+	return nil
+}
+
+func (r *memoryRepository) RevokeRole(ctx context.Context, accountID int32, role string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	i := slices.Index(r.accountRoles[accountID], role)
+	if i < 0 {
+		return ErrRoleNotAssigned
+	}
+
+	r.accountRoles[accountID] = slices.Delete(r.accountRoles[accountID], i, i+1)
+
+	return nil
+}
+
+func (r *memoryRepository) CreateAPIKey(ctx context.Context, k APIKey) (APIKey, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.nextAPIKeyID++
+	k.ID = r.nextAPIKeyID
+	k.Scopes = slices.Clone(k.Scopes)
+	k.CreatedAt = time.Now()
+	r.apiKeys[k.ID] = k
+
+	return k, nil
+}
+
+func (r *memoryRepository) ListAPIKeys(ctx context.Context, accountID int32) ([]APIKey, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	keys := []APIKey{}
+
+	for _, k := range r.apiKeys {
+		if k.AccountID == accountID {
+			keys = append(keys, k)
+		}
+	}
+
+	sort.Slice(keys, func(i, j int) bool { return keys[i].ID < keys[j].ID })
+
+	return keys, nil
+}
+
+func (r *memoryRepository) GetAPIKeyByPrefix(ctx context.Context, prefix string) (APIKey, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	for _, k := range r.apiKeys {
+		if k.Prefix == prefix {
+			return k, nil
+		}
+	}
+
+	return APIKey{}, ErrAPIKeyNotFound
+}
+
+func (r *memoryRepository) RevokeAPIKey(ctx context.Context, accountID, id int32, at time.Time) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	k, exist := r.apiKeys[id]
+	if !exist || k.AccountID != accountID || !k.RevokedAt.IsZero() {
+		return ErrAPIKeyNotFound
+	}
+
+	k.RevokedAt = at
+	r.apiKeys[id] = k
+
+	return nil
+}
+
+func (r *memoryRepository) TouchAPIKey(ctx context.Context, id int32, at time.Time) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	k, exist := r.apiKeys[id]
+	if !exist {
+		return ErrAPIKeyNotFound
+	}
+
+	k.LastUsedAt = at
+	r.apiKeys[id] = k
+
+	return nil
+}
+
+func (r *memoryRepository) RecordAudit(ctx context.Context, e audit.Entry) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.auditLog = append(r.auditLog, e)
+
+	return nil
+}
+
+func (r *memoryRepository) QueryAuditLog(ctx context.Context, q audit.Query) ([]audit.Entry, error) {
+	if q.Offset < 0 || q.Limit < 0 {
+		return nil, errors.New("error querying audit log")
+	}
+
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	entries := []audit.Entry{}
+
+	// newest first: entries are recorded in order
+	for _, e := range slices.Backward(r.auditLog) {
+		if q.Matches(e) {
+			entries = append(entries, e)
+		}
+	}
+
+	if int(q.Offset) >= len(entries) {
+		return []audit.Entry{}, nil
+	}
+	entries = entries[q.Offset:]
+
+	if int(q.Limit) < len(entries) {
+		entries = entries[:q.Limit]
+	}
+
+	return entries, nil
+}
+
+// DispatchEvents publishes outside the lock so bus subscribers may call back
+// into the repository.
+func (r *memoryRepository) DispatchEvents(ctx context.Context, limit int, publish func(ctx context.Context, e events.Event) error) (int, error) {
+	r.mu.RLock()
+	pending := slices.Clone(r.outbox[:min(limit, len(r.outbox))])
+	r.mu.RUnlock()
+
+	published := map[string]bool{}
+	var publishErr error
+
+	for _, e := range pending {
+		if publishErr = publish(ctx, e); publishErr != nil {
+			break
+		}
+		published[e.ID] = true
+	}
+
+	r.mu.Lock()
+	r.outbox = slices.DeleteFunc(r.outbox, func(e events.Event) bool { return published[e.ID] })
+	r.mu.Unlock()
+
+	if publishErr != nil {
+		return len(published), errors.New("error publishing events")
+	}
+
+	return len(published), nil
+}
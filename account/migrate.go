@@ -0,0 +1,54 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log"
+	"strconv"
+
+	"github.com/airlangga-hub/microservices/account/migrations"
+)
+
+// runMigrate implements `account migrate [up | down [N] | version]`.
+// With no arguments it applies all pending migrations.
+func runMigrate(dbUrl string, args []string) error {
+	db, err := sql.Open("postgres", dbUrl)
+	if err != nil {
+		return fmt.Errorf("opening db: %w", err)
+	}
+	defer db.Close()
+
+	ctx := context.Background()
+
+	command := "up"
+	if len(args) > 0 {
+		command = args[0]
+	}
+
+	switch command {
+	case "up":
+		return migrations.Up(ctx, db)
+
+	case "down":
+		steps := 1
+		if len(args) > 1 {
+			steps, err = strconv.Atoi(args[1])
+			if err != nil || steps < 1 {
+				return fmt.Errorf("invalid number of steps %q", args[1])
+			}
+		}
+		return migrations.Down(ctx, db, steps)
+
+	case "version":
+		version, err := migrations.Version(ctx, db)
+		if err != nil {
+			return err
+		}
+		log.Printf("account schema version: %d", version)
+		return nil
+
+	default:
+		return fmt.Errorf("unknown migrate command %q (want up, down [N] or version)", command)
+	}
+}
@@ -0,0 +1,4 @@
+DROP INDEX IF EXISTS idx_accounts_email;
+
+ALTER TABLE accounts DROP COLUMN IF EXISTS email_verified;
+ALTER TABLE accounts DROP COLUMN IF EXISTS email;
@@ -0,0 +1,30 @@
+CREATE TABLE IF NOT EXISTS roles (
+    name TEXT PRIMARY KEY,
+    description TEXT NOT NULL DEFAULT ''
+);
+
+CREATE TABLE IF NOT EXISTS role_permissions (
+    role TEXT NOT NULL REFERENCES roles (name) ON DELETE CASCADE,
+    permission TEXT NOT NULL,
+    PRIMARY KEY (role, permission)
+);
+
+CREATE TABLE IF NOT EXISTS account_roles (
+    account_id INTEGER NOT NULL REFERENCES accounts (id) ON DELETE CASCADE,
+    role TEXT NOT NULL REFERENCES roles (name) ON DELETE CASCADE,
+    assigned_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
+    PRIMARY KEY (account_id, role)
+);
+
+-- keep in step with defaultRoles in role.go
+INSERT INTO roles (name, description) VALUES
+    ('admin', 'Manages the catalog, roles and every account''s orders'),
+    ('support', 'Reads every account''s orders')
+ON CONFLICT (name) DO NOTHING;
+
+INSERT INTO role_permissions (role, permission) VALUES
+    ('admin', 'account:roles:manage'),
+    ('admin', 'catalog:products:write'),
+    ('admin', 'order:orders:read_any'),
+    ('support', 'order:orders:read_any')
+ON CONFLICT (role, permission) DO NOTHING;
@@ -0,0 +1,3 @@
+ALTER TABLE accounts DROP COLUMN IF EXISTS status_changed_at;
+ALTER TABLE accounts DROP COLUMN IF EXISTS status_reason;
+ALTER TABLE accounts DROP COLUMN IF EXISTS status;
@@ -0,0 +1,2 @@
+DELETE FROM role_permissions
+WHERE role = 'admin' AND permission IN ('account:audit:read', 'catalog:audit:read', 'order:audit:read');
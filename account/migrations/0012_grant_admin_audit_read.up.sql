@@ -0,0 +1,6 @@
+-- keep in step with defaultRoles in role.go
+INSERT INTO role_permissions (role, permission) VALUES
+    ('admin', 'account:audit:read'),
+    ('admin', 'catalog:audit:read'),
+    ('admin', 'order:audit:read')
+ON CONFLICT (role, permission) DO NOTHING;
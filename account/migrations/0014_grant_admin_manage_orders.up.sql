@@ -0,0 +1,4 @@
+-- keep in step with defaultRoles in role.go
+INSERT INTO role_permissions (role, permission) VALUES
+    ('admin', 'order:orders:manage')
+ON CONFLICT (role, permission) DO NOTHING;
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"log"
+
+	"github.com/airlangga-hub/microservices/events"
+	"github.com/lib/pq"
+)
+
+const (
+	EventAccountCreated       = "AccountCreated"
+	EventAccountStatusChanged = "AccountStatusChanged"
+)
+
+// insertEvent writes e to the outbox inside tx, so the event exists if and
+// only if the change it describes is committed.
+func insertEvent(ctx context.Context, tx *sql.Tx, e events.Event) error {
+	_, err := tx.ExecContext(
+		ctx,
+		`INSERT INTO outbox (id, type, aggregate_id, payload, occurred_at)
+		VALUES ($1, $2, $3, $4, $5);`,
+		e.ID, e.Type, e.AggregateID, []byte(e.Payload), e.OccurredAt,
+	)
+	return err
+}
+
+// DispatchEvents locks a batch of pending events, so concurrent relays skip
+// them, and deletes the ones published successfully.
+func (r *repository) DispatchEvents(ctx context.Context, limit int, publish func(ctx context.Context, e events.Event) error) (int, error) {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		log.Println("ERROR: account repo DispatchEvents (tx init): ", err)
+		return 0, errors.New("error dispatching events")
+	}
+	defer tx.Rollback()
+
+	rows, err := tx.QueryContext(
+		ctx,
+		`SELECT
+			id,
+			type,
+			aggregate_id,
+			payload,
+			occurred_at
+		FROM outbox
+		ORDER BY occurred_at, id
+		LIMIT $1
+		FOR UPDATE SKIP LOCKED;`,
+		limit,
+	)
+	if err != nil {
+		log.Println("ERROR: account repo DispatchEvents (tx.QueryContext): ", err)
+		return 0, errors.New("error dispatching events")
+	}
+
+	pending := []events.Event{}
+
+	for rows.Next() {
+		e := events.Event{}
+		if err := rows.Scan(&e.ID, &e.Type, &e.AggregateID, &e.Payload, &e.OccurredAt); err != nil {
+			rows.Close()
+			log.Println("ERROR: account repo DispatchEvents (rows.Scan): ", err)
+			return 0, errors.New("error dispatching events")
+		}
+		pending = append(pending, e)
+	}
+	rows.Close()
+
+	if err := rows.Err(); err != nil {
+		log.Println("ERROR: account repo DispatchEvents (rows.Err): ", err)
+		return 0, errors.New("error dispatching events")
+	}
+
+	published := []string{}
+	var publishErr error
+
+	for _, e := range pending {
+		if publishErr = publish(ctx, e); publishErr != nil {
+			break
+		}
+		published = append(published, e.ID)
+	}
+
+	if len(published) > 0 {
+		if _, err := tx.ExecContext(ctx, `DELETE FROM outbox WHERE id = ANY($1);`, pq.Array(published)); err != nil {
+			log.Println("ERROR: account repo DispatchEvents (delete published): ", err)
+			return 0, errors.New("error dispatching events")
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		log.Println("ERROR: account repo DispatchEvents (tx commit): ", err)
+		return 0, errors.New("error dispatching events")
+	}
+
+	if publishErr != nil {
+		log.Println("ERROR: account repo DispatchEvents (publish): ", publishErr)
+		return len(published), errors.New("error publishing events")
+	}
+
+	return len(published), nil
+}
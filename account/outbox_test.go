@@ -0,0 +1,56 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/airlangga-hub/microservices/events"
+)
+
+func TestAccountChangesPublishEvents(t *testing.T) {
+	repo := NewMemoryRepository()
+	svc := NewService(repo, &recordingMailer{}, time.Hour)
+	ctx := context.Background()
+
+	a, err := svc.PostAccount(ctx, "angga", "angga@example.com")
+	if err != nil {
+		t.Fatalf("PostAccount: %v", err)
+	}
+	if _, err := svc.SuspendAccount(ctx, a.ID, "chargebacks"); err != nil {
+		t.Fatalf("SuspendAccount: %v", err)
+	}
+	// a failed change publishes nothing
+	svc.SuspendAccount(ctx, a.ID, "chargebacks")
+
+	var published []string
+	var failed bool
+	n, err := repo.DispatchEvents(ctx, 10, func(ctx context.Context, e events.Event) error {
+		// the bus fails once, on the second event
+		if len(published) == 1 && !failed {
+			failed = true
+			return errors.New("bus is down")
+		}
+		published = append(published, e.Type+" "+e.AggregateID)
+		return nil
+	})
+	if err == nil || n != 1 {
+		t.Fatalf("DispatchEvents with a failing bus = %d, %v; want 1 and an error", n, err)
+	}
+
+	if n, err := repo.DispatchEvents(ctx, 10, func(ctx context.Context, e events.Event) error {
+		published = append(published, e.Type+" "+e.AggregateID)
+		return nil
+	}); err != nil || n != 1 {
+		t.Fatalf("DispatchEvents = %d, %v; want the event left unpublished", n, err)
+	}
+
+	id := strconv.Itoa(int(a.ID))
+	want := []string{EventAccountCreated + " " + id, EventAccountStatusChanged + " " + id}
+	if !slices.Equal(published, want) {
+		t.Errorf("published %v, want %v once each", published, want)
+	}
+}
@@ -5,28 +5,121 @@ import (
 	"database/sql"
 	"errors"
 	"log"
+	"strconv"
+	"time"
 
-	_ "github.com/lib/pq"
+	"github.com/airlangga-hub/microservices/account/config"
+	"github.com/airlangga-hub/microservices/audit"
+	"github.com/airlangga-hub/microservices/events"
+	"github.com/lib/pq"
+)
+
+// Postgres error codes for a unique index conflict and a missing foreign
+// key.
+const (
+	uniqueViolation     = "23505"
+	foreignKeyViolation = "23503"
 )
 
 type Repository interface {
+	events.Outbox
 	Close() error
+	Ping(ctx context.Context) error
+	// CreateAccount returns ErrEmailTaken if a's email is in use. An empty
+	// email is stored as none, which any number of accounts may have.
 	CreateAccount(ctx context.Context, a Account) (Account, error)
+	// GetAccountByID and GetAccountByEmail return ErrAccountNotFound if there
+	// is no such account.
 	GetAccountByID(ctx context.Context, id int32) (Account, error)
+	// GetAccountByEmail ignores case.
+	GetAccountByEmail(ctx context.Context, email string) (Account, error)
 	ListAccounts(ctx context.Context, offset, limit int32) ([]Account, error)
+	// UpdateAccountStatus moves the account from status from to to, and
+	// returns ErrInvalidStatusTransition if it isn't in from anymore.
+	UpdateAccountStatus(ctx context.Context, id int32, from, to AccountStatus, reason string) (Account, error)
+	AddressRepository
+	TokenRepository
+	RoleRepository
+	APIKeyRepository
+	AuditRepository
+}
+
+// AuditRepository is the append-only audit log.
+type AuditRepository interface {
+	RecordAudit(ctx context.Context, e audit.Entry) error
+	// QueryAuditLog returns matching entries newest first.
+	QueryAuditLog(ctx context.Context, q audit.Query) ([]audit.Entry, error)
+}
+
+// APIKeyRepository stores API keys. GetAPIKeyByPrefix, RevokeAPIKey and
+// TouchAPIKey return ErrAPIKeyNotFound when there is no such key.
+type APIKeyRepository interface {
+	// CreateAPIKey sets k's ID and CreatedAt.
+	CreateAPIKey(ctx context.Context, k APIKey) (APIKey, error)
+	// ListAPIKeys returns the account's keys oldest first, revoked ones
+	// included.
+	ListAPIKeys(ctx context.Context, accountID int32) ([]APIKey, error)
+	// GetAPIKeyByPrefix returns the key with its Hash.
+	GetAPIKeyByPrefix(ctx context.Context, prefix string) (APIKey, error)
+	// RevokeAPIKey also returns ErrAPIKeyNotFound if the key belongs to
+	// another account or is already revoked.
+	RevokeAPIKey(ctx context.Context, accountID, id int32, at time.Time) error
+	TouchAPIKey(ctx context.Context, id int32, at time.Time) error
+}
+
+// RoleRepository stores roles, their permissions and who has them. Roles
+// are listed by name, with their permissions sorted.
+type RoleRepository interface {
+	ListRoles(ctx context.Context) ([]Role, error)
+	ListAccountRoles(ctx context.Context, accountID int32) ([]Role, error)
+	// AssignRole returns ErrRoleNotFound for an unknown role, and does
+	// nothing if the account already has it.
+	AssignRole(ctx context.Context, accountID int32, role string) error
+	// RevokeRole returns ErrRoleNotAssigned if the account doesn't have role.
+	RevokeRole(ctx context.Context, accountID int32, role string) error
+}
+
+// TokenRepository stores emailed tokens by their hash.
+type TokenRepository interface {
+	// CreateToken stores t in place of the account's unused tokens for the
+	// same purpose, so only the latest one mailed works.
+	CreateToken(ctx context.Context, t Token) error
+	// VerifyEmail uses the email verification token with hash and marks its
+	// account's email verified, returning ErrInvalidToken if the token is
+	// unknown, used or expired at now.
+	VerifyEmail(ctx context.Context, hash string, now time.Time) (Account, error)
+}
+
+// AddressRepository stores account address books. Every method but
+// CreateAddress and ListAddresses returns ErrAddressNotFound when the
+// address doesn't exist or belongs to another account.
+type AddressRepository interface {
+	// CreateAddress makes a the default if the account has no other address.
+	CreateAddress(ctx context.Context, a Address) (Address, error)
+	ListAddresses(ctx context.Context, accountID int32) ([]Address, error)
+	// UpdateAddress replaces every field but IsDefault.
+	UpdateAddress(ctx context.Context, a Address) (Address, error)
+	DeleteAddress(ctx context.Context, accountID, addressID int32) error
+	// SetDefaultAddress makes the address the default in place of the
+	// account's current one.
+	SetDefaultAddress(ctx context.Context, accountID, addressID int32) (Address, error)
 }
 
 type repository struct {
 	db *sql.DB
 }
 
-func NewRepository(dbUrl string) (Repository, error) {
+func NewRepository(dbUrl string, pool config.DB) (Repository, error) {
 	db, err := sql.Open("postgres", dbUrl)
 	if err != nil {
 		log.Println("ERROR: account repo NewRepository (sql.Open): ", err)
 		return nil, errors.New("error connecting to db")
 	}
 
+	db.SetMaxOpenConns(pool.MaxOpenConns)
+	db.SetMaxIdleConns(pool.MaxIdleConns)
+	db.SetConnMaxLifetime(pool.ConnMaxLifetime)
+
 	if err := db.Ping(); err != nil {
 		log.Println("ERROR: account repo NewRepository (db.Ping): ", err)
 		return nil, errors.New("error pinging db")
@@ -43,36 +136,83 @@ func (r *repository) Close() error {
 	return nil
 }
 
+func (r *repository) Ping(ctx context.Context) error {
+	if err := r.db.PingContext(ctx); err != nil {
+		log.Println("ERROR: account repo Ping: ", err)
+		return errors.New("error pinging db")
+	}
+	return nil
+}
+
 func (r *repository) CreateAccount(ctx context.Context, a Account) (Account, error) {
-	if err := r.db.QueryRowContext(
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		log.Println("ERROR: account repo CreateAccount (tx init): ", err)
+		return Account{}, errors.New("error creating account")
+	}
+	defer tx.Rollback()
+
+	if err := tx.QueryRowContext(
 		ctx,
-		`INSERT INTO accounts (name)
-		VALUES ($1)
-		RETURNING id;`,
-		a.Name,
-	).Scan(&a.ID); err != nil {
+		`INSERT INTO accounts (name, email)
+		VALUES ($1, NULLIF($2, ''))
+		RETURNING id, email_verified, status, status_reason;`,
+		a.Name, a.Email,
+	).Scan(&a.ID, &a.EmailVerified, &a.Status, &a.StatusReason); err != nil {
+		var pqErr *pq.Error
+		if errors.As(err, &pqErr) && pqErr.Code == uniqueViolation {
+			return Account{}, ErrEmailTaken
+		}
 		log.Println("ERROR: account repo CreateAccount: ", err)
 		return Account{}, errors.New("error creating account")
 	}
 
+	event, err := events.New(EventAccountCreated, strconv.Itoa(int(a.ID)), a)
+	if err != nil {
+		log.Println("ERROR: account repo CreateAccount (events.New): ", err)
+		return Account{}, errors.New("error creating account")
+	}
+
+	if err := insertEvent(ctx, tx, event); err != nil {
+		log.Println("ERROR: account repo CreateAccount (insertEvent): ", err)
+		return Account{}, errors.New("error creating account")
+	}
+
+	if err := tx.Commit(); err != nil {
+		log.Println("ERROR: account repo CreateAccount (tx commit): ", err)
+		return Account{}, errors.New("error creating account")
+	}
+
 	return a, nil
 }
 
 func (r *repository) GetAccountByID(ctx context.Context, id int32) (Account, error) {
 	account := Account{}
 
-	if err := r.db.QueryRowContext(
+	err := r.db.QueryRowContext(
 		ctx,
 		`SELECT
 			id,
-			name
+			name,
+			COALESCE(email, ''),
+			email_verified,
+			status,
+			status_reason
 		FROM accounts
 		WHERE id = $1;`,
 		id,
 	).Scan(
 		&account.ID,
 		&account.Name,
-	); err != nil {
+		&account.Email,
+		&account.EmailVerified,
+		&account.Status,
+		&account.StatusReason,
+	)
+	if errors.Is(err, sql.ErrNoRows) {
+		return Account{}, ErrAccountNotFound
+	}
+	if err != nil {
 		log.Println("ERROR: account repo GetAccountByID: ", err)
 		return Account{}, errors.New("error finding account")
 	}
@@ -80,12 +220,51 @@ func (r *repository) GetAccountByID(ctx context.Context, id int32) (Account, err
 	return account, nil
 }
 
+// GetAccountByEmail is served by the unique index on LOWER(email).
+func (r *repository) GetAccountByEmail(ctx context.Context, email string) (Account, error) {
+	account := Account{}
+
+	err := r.db.QueryRowContext(
+		ctx,
+		`SELECT
+			id,
+			name,
+			email,
+			email_verified,
+			status,
+			status_reason
+		FROM accounts
+		WHERE LOWER(email) = LOWER($1);`,
+		email,
+	).Scan(
+		&account.ID,
+		&account.Name,
+		&account.Email,
+		&account.EmailVerified,
+		&account.Status,
+		&account.StatusReason,
+	)
+	if errors.Is(err, sql.ErrNoRows) {
+		return Account{}, ErrAccountNotFound
+	}
+	if err != nil {
+		log.Println("ERROR: account repo GetAccountByEmail: ", err)
+		return Account{}, errors.New("error finding account")
+	}
+
+	return account, nil
+}
+
 func (r *repository) ListAccounts(ctx context.Context, offset, limit int32) ([]Account, error) {
 	rows, err := r.db.QueryContext(
 		ctx,
 		`SELECT
 			id,
-			name
+			name,
+			COALESCE(email, ''),
+			email_verified,
+			status,
+			status_reason
 		FROM accounts
 		ORDER BY id DESC
 		OFFSET $1
@@ -106,6 +285,10 @@ func (r *repository) ListAccounts(ctx context.Context, offset, limit int32) ([]A
 		if err := rows.Scan(
 			&a.ID,
 			&a.Name,
+			&a.Email,
+			&a.EmailVerified,
+			&a.Status,
+			&a.StatusReason,
 		); err != nil {
 			log.Println("ERROR: account repo ListAccounts (rows.Scan): ", err)
 			return nil, errors.New("error scanning current row")
@@ -120,3 +303,727 @@ func (r *repository) ListAccounts(ctx context.Context, offset, limit int32) ([]A
 
 	return accounts, nil
 }
+
+func (r *repository) CreateAddress(ctx context.Context, a Address) (Address, error) {
+	if err := r.db.QueryRowContext(
+		ctx,
+		`INSERT INTO addresses (account_id, name, line1, line2, city, region, postal_code, country, phone, is_default)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, NOT EXISTS (SELECT 1 FROM addresses WHERE account_id = $1))
+		RETURNING
+			id,
+			is_default;`,
+		a.AccountID, a.Name, a.Line1, a.Line2, a.City, a.Region, a.PostalCode, a.Country, a.Phone,
+	).Scan(
+		&a.ID,
+		&a.IsDefault,
+	); err != nil {
+		log.Println("ERROR: account repo CreateAddress: ", err)
+		return Address{}, errors.New("error creating address")
+	}
+
+	return a, nil
+}
+
+func (r *repository) ListAddresses(ctx context.Context, accountID int32) ([]Address, error) {
+	rows, err := r.db.QueryContext(
+		ctx,
+		`SELECT
+			id,
+			account_id,
+			name,
+			line1,
+			line2,
+			city,
+			region,
+			postal_code,
+			country,
+			phone,
+			is_default
+		FROM addresses
+		WHERE account_id = $1
+		ORDER BY id;`,
+		accountID,
+	)
+	if err != nil {
+		log.Println("ERROR: account repo ListAddresses (r.db.QueryContext): ", err)
+		return nil, errors.New("error listing addresses")
+	}
+
+	defer rows.Close()
+
+	addresses := []Address{}
+
+	for rows.Next() {
+		a, err := scanAddress(rows)
+		if err != nil {
+			log.Println("ERROR: account repo ListAddresses (rows.Scan): ", err)
+			return nil, errors.New("error listing addresses")
+		}
+		addresses = append(addresses, a)
+	}
+
+	if err := rows.Err(); err != nil {
+		log.Println("ERROR: account repo ListAddresses (rows.Err): ", err)
+		return nil, errors.New("error listing addresses")
+	}
+
+	return addresses, nil
+}
+
+func (r *repository) UpdateAddress(ctx context.Context, a Address) (Address, error) {
+	updated, err := scanAddress(r.db.QueryRowContext(
+		ctx,
+		`UPDATE addresses
+		SET name = $3, line1 = $4, line2 = $5, city = $6, region = $7, postal_code = $8, country = $9, phone = $10
+		WHERE id = $1 AND account_id = $2
+		RETURNING
+			id,
+			account_id,
+			name,
+			line1,
+			line2,
+			city,
+			region,
+			postal_code,
+			country,
+			phone,
+			is_default;`,
+		a.ID, a.AccountID, a.Name, a.Line1, a.Line2, a.City, a.Region, a.PostalCode, a.Country, a.Phone,
+	))
+	if errors.Is(err, sql.ErrNoRows) {
+		return Address{}, ErrAddressNotFound
+	}
+	if err != nil {
+		log.Println("ERROR: account repo UpdateAddress: ", err)
+		return Address{}, errors.New("error updating address")
+	}
+
+	return updated, nil
+}
+
+func (r *repository) DeleteAddress(ctx context.Context, accountID, addressID int32) error {
+	res, err := r.db.ExecContext(
+		ctx,
+		`DELETE FROM addresses WHERE id = $1 AND account_id = $2;`,
+		addressID, accountID,
+	)
+	if err != nil {
+		log.Println("ERROR: account repo DeleteAddress: ", err)
+		return errors.New("error deleting address")
+	}
+
+	n, err := res.RowsAffected()
+	if err != nil {
+		log.Println("ERROR: account repo DeleteAddress (RowsAffected): ", err)
+		return errors.New("error deleting address")
+	}
+
+	if n == 0 {
+		return ErrAddressNotFound
+	}
+
+	return nil
+}
+
+// SetDefaultAddress clears the old default first, in the same transaction,
+// so the one default per account index never sees two.
+func (r *repository) SetDefaultAddress(ctx context.Context, accountID, addressID int32) (Address, error) {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		log.Println("ERROR: account repo SetDefaultAddress (tx init): ", err)
+		return Address{}, errors.New("error setting default address")
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.ExecContext(
+		ctx,
+		`UPDATE addresses SET is_default = FALSE WHERE account_id = $1 AND is_default AND id <> $2;`,
+		accountID, addressID,
+	); err != nil {
+		log.Println("ERROR: account repo SetDefaultAddress (clear default): ", err)
+		return Address{}, errors.New("error setting default address")
+	}
+
+	a, err := scanAddress(tx.QueryRowContext(
+		ctx,
+		`UPDATE addresses
+		SET is_default = TRUE
+		WHERE id = $1 AND account_id = $2
+		RETURNING
+			id,
+			account_id,
+			name,
+			line1,
+			line2,
+			city,
+			region,
+			postal_code,
+			country,
+			phone,
+			is_default;`,
+		addressID, accountID,
+	))
+	if errors.Is(err, sql.ErrNoRows) {
+		return Address{}, ErrAddressNotFound
+	}
+	if err != nil {
+		log.Println("ERROR: account repo SetDefaultAddress (set default): ", err)
+		return Address{}, errors.New("error setting default address")
+	}
+
+	if err := tx.Commit(); err != nil {
+		log.Println("ERROR: account repo SetDefaultAddress (tx commit): ", err)
+		return Address{}, errors.New("error setting default address")
+	}
+
+	return a, nil
+}
+
+// scanAddress scans the address columns, in table order, from a row.
+func scanAddress(row interface{ Scan(dest ...any) error }) (Address, error) {
+	a := Address{}
+
+	err := row.Scan(
+		&a.ID,
+		&a.AccountID,
+		&a.Name,
+		&a.Line1,
+		&a.Line2,
+		&a.City,
+		&a.Region,
+		&a.PostalCode,
+		&a.Country,
+		&a.Phone,
+		&a.IsDefault,
+	)
+
+	return a, err
+}
+
+func (r *repository) CreateToken(ctx context.Context, t Token) error {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		log.Println("ERROR: account repo CreateToken (tx init): ", err)
+		return errors.New("error creating token")
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.ExecContext(
+		ctx,
+		`DELETE FROM account_tokens WHERE account_id = $1 AND purpose = $2 AND used_at IS NULL;`,
+		t.AccountID, t.Purpose,
+	); err != nil {
+		log.Println("ERROR: account repo CreateToken (delete unused): ", err)
+		return errors.New("error creating token")
+	}
+
+	if _, err := tx.ExecContext(
+		ctx,
+		`INSERT INTO account_tokens (token_hash, account_id, purpose, expires_at)
+		VALUES ($1, $2, $3, $4);`,
+		t.Hash, t.AccountID, t.Purpose, t.ExpiresAt,
+	); err != nil {
+		log.Println("ERROR: account repo CreateToken (insert): ", err)
+		return errors.New("error creating token")
+	}
+
+	if err := tx.Commit(); err != nil {
+		log.Println("ERROR: account repo CreateToken (tx commit): ", err)
+		return errors.New("error creating token")
+	}
+
+	return nil
+}
+
+// VerifyEmail marks the token used with a conditional update, so two
+// concurrent calls with the same token can't both succeed.
+func (r *repository) VerifyEmail(ctx context.Context, hash string, now time.Time) (Account, error) {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		log.Println("ERROR: account repo VerifyEmail (tx init): ", err)
+		return Account{}, errors.New("error verifying email")
+	}
+	defer tx.Rollback()
+
+	var accountID int32
+
+	err = tx.QueryRowContext(
+		ctx,
+		`UPDATE account_tokens
+		SET used_at = $3
+		WHERE token_hash = $1 AND purpose = $2 AND used_at IS NULL AND expires_at > $3
+		RETURNING account_id;`,
+		hash, TokenEmailVerification, now,
+	).Scan(&accountID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return Account{}, ErrInvalidToken
+	}
+	if err != nil {
+		log.Println("ERROR: account repo VerifyEmail (use token): ", err)
+		return Account{}, errors.New("error verifying email")
+	}
+
+	account := Account{}
+
+	if err := tx.QueryRowContext(
+		ctx,
+		`UPDATE accounts
+		SET email_verified = TRUE
+		WHERE id = $1
+		RETURNING id, name, COALESCE(email, ''), email_verified, status, status_reason;`,
+		accountID,
+	).Scan(
+		&account.ID,
+		&account.Name,
+		&account.Email,
+		&account.EmailVerified,
+		&account.Status,
+		&account.StatusReason,
+	); err != nil {
+		log.Println("ERROR: account repo VerifyEmail (verify account): ", err)
+		return Account{}, errors.New("error verifying email")
+	}
+
+	if err := tx.Commit(); err != nil {
+		log.Println("ERROR: account repo VerifyEmail (tx commit): ", err)
+		return Account{}, errors.New("error verifying email")
+	}
+
+	return account, nil
+}
+
+// rolesQuery selects roles with their permissions; callers add the WHERE
+// clause.
+const rolesQuery = `SELECT
+		r.name,
+		r.description,
+		COALESCE(ARRAY_AGG(p.permission ORDER BY p.permission) FILTER (WHERE p.permission IS NOT NULL), '{}')
+	FROM roles r
+	LEFT JOIN role_permissions p ON p.role = r.name`
+
+func (r *repository) ListRoles(ctx context.Context) ([]Role, error) {
+	rows, err := r.db.QueryContext(
+		ctx,
+		rolesQuery+`
+		GROUP BY r.name, r.description
+		ORDER BY r.name;`,
+	)
+	if err != nil {
+		log.Println("ERROR: account repo ListRoles (r.db.QueryContext): ", err)
+		return nil, errors.New("error listing roles")
+	}
+
+	defer rows.Close()
+
+	roles := []Role{}
+
+	for rows.Next() {
+		role, err := scanRole(rows)
+		if err != nil {
+			log.Println("ERROR: account repo ListRoles (rows.Scan): ", err)
+			return nil, errors.New("error listing roles")
+		}
+		roles = append(roles, role)
+	}
+
+	if err := rows.Err(); err != nil {
+		log.Println("ERROR: account repo ListRoles (rows.Err): ", err)
+		return nil, errors.New("error listing roles")
+	}
+
+	return roles, nil
+}
+
+func (r *repository) ListAccountRoles(ctx context.Context, accountID int32) ([]Role, error) {
+	rows, err := r.db.QueryContext(
+		ctx,
+		rolesQuery+`
+		WHERE r.name IN (SELECT role FROM account_roles WHERE account_id = $1)
+		GROUP BY r.name, r.description
+		ORDER BY r.name;`,
+		accountID,
+	)
+	if err != nil {
+		log.Println("ERROR: account repo ListAccountRoles (r.db.QueryContext): ", err)
+		return nil, errors.New("error listing roles")
+	}
+
+	defer rows.Close()
+
+	roles := []Role{}
+
+	for rows.Next() {
+		role, err := scanRole(rows)
+		if err != nil {
+			log.Println("ERROR: account repo ListAccountRoles (rows.Scan): ", err)
+			return nil, errors.New("error listing roles")
+		}
+		roles = append(roles, role)
+	}
+
+	if err := rows.Err(); err != nil {
+		log.Println("ERROR: account repo ListAccountRoles (rows.Err): ", err)
+		return nil, errors.New("error listing roles")
+	}
+
+	return roles, nil
+}
+
+func scanRole(row interface{ Scan(dest ...any) error }) (Role, error) {
+	role := Role{}
+	err := row.Scan(&role.Name, &role.Description, pq.Array(&role.Permissions))
+	return role, err
+}
+
+func (r *repository) AssignRole(ctx context.Context, accountID int32, role string) error {
+	_, err := r.db.ExecContext(
+		ctx,
+		`INSERT INTO account_roles (account_id, role)
+		VALUES ($1, $2)
+		ON CONFLICT (account_id, role) DO NOTHING;`,
+		accountID, role,
+	)
+
+	var pqErr *pq.Error
+	if errors.As(err, &pqErr) && pqErr.Code == foreignKeyViolation {
+		if pqErr.Constraint == "account_roles_account_id_fkey" {
+			return ErrAccountNotFound
+		}
+		return ErrRoleNotFound
+	}
+	if err != nil {
+		log.Println("ERROR: account repo AssignRole: ", err)
+		return errors.New("error assigning role")
+	}
+
+	return nil
+}
+
+func (r *repository) RevokeRole(ctx context.Context, accountID int32, role string) error {
+	res, err := r.db.ExecContext(
+		ctx,
+		`DELETE FROM account_roles WHERE account_id = $1 AND role = $2;`,
+		accountID, role,
+	)
+	if err != nil {
+		log.Println("ERROR: account repo RevokeRole: ", err)
+		return errors.New("error revoking role")
+	}
+
+	n, err := res.RowsAffected()
+	if err != nil {
+		log.Println("ERROR: account repo RevokeRole (RowsAffected): ", err)
+		return errors.New("error revoking role")
+	}
+
+	if n == 0 {
+		return ErrRoleNotAssigned
+	}
+
+	return nil
+}
+
+func (r *repository) CreateAPIKey(ctx context.Context, k APIKey) (APIKey, error) {
+	if err := r.db.QueryRowContext(
+		ctx,
+		`INSERT INTO api_keys (account_id, name, prefix, key_hash, scopes, expires_at)
+		VALUES ($1, $2, $3, $4, $5, $6)
+		RETURNING
+			id,
+			created_at;`,
+		k.AccountID, k.Name, k.Prefix, k.Hash, pq.Array(k.Scopes), nullTime(k.ExpiresAt),
+	).Scan(
+		&k.ID,
+		&k.CreatedAt,
+	); err != nil {
+		log.Println("ERROR: account repo CreateAPIKey: ", err)
+		return APIKey{}, errors.New("error creating api key")
+	}
+
+	return k, nil
+}
+
+const apiKeyColumns = `
+			id,
+			account_id,
+			name,
+			prefix,
+			key_hash,
+			scopes,
+			expires_at,
+			created_at,
+			last_used_at,
+			revoked_at`
+
+func (r *repository) ListAPIKeys(ctx context.Context, accountID int32) ([]APIKey, error) {
+	rows, err := r.db.QueryContext(
+		ctx,
+		`SELECT`+apiKeyColumns+`
+		FROM api_keys
+		WHERE account_id = $1
+		ORDER BY id;`,
+		accountID,
+	)
+	if err != nil {
+		log.Println("ERROR: account repo ListAPIKeys (r.db.QueryContext): ", err)
+		return nil, errors.New("error listing api keys")
+	}
+
+	defer rows.Close()
+
+	keys := []APIKey{}
+
+	for rows.Next() {
+		k, err := scanAPIKey(rows)
+		if err != nil {
+			log.Println("ERROR: account repo ListAPIKeys (rows.Scan): ", err)
+			return nil, errors.New("error listing api keys")
+		}
+		keys = append(keys, k)
+	}
+
+	if err := rows.Err(); err != nil {
+		log.Println("ERROR: account repo ListAPIKeys (rows.Err): ", err)
+		return nil, errors.New("error listing api keys")
+	}
+
+	return keys, nil
+}
+
+func (r *repository) GetAPIKeyByPrefix(ctx context.Context, prefix string) (APIKey, error) {
+	k, err := scanAPIKey(r.db.QueryRowContext(
+		ctx,
+		`SELECT`+apiKeyColumns+`
+		FROM api_keys
+		WHERE prefix = $1;`,
+		prefix,
+	))
+	if errors.Is(err, sql.ErrNoRows) {
+		return APIKey{}, ErrAPIKeyNotFound
+	}
+	if err != nil {
+		log.Println("ERROR: account repo GetAPIKeyByPrefix: ", err)
+		return APIKey{}, errors.New("error getting api key")
+	}
+
+	return k, nil
+}
+
+func (r *repository) RevokeAPIKey(ctx context.Context, accountID, id int32, at time.Time) error {
+	res, err := r.db.ExecContext(
+		ctx,
+		`UPDATE api_keys
+		SET revoked_at = $3
+		WHERE id = $1 AND account_id = $2 AND revoked_at IS NULL;`,
+		id, accountID, at,
+	)
+	if err != nil {
+		log.Println("ERROR: account repo RevokeAPIKey: ", err)
+		return errors.New("error revoking api key")
+	}
+
+	n, err := res.RowsAffected()
+	if err != nil {
+		log.Println("ERROR: account repo RevokeAPIKey (RowsAffected): ", err)
+		return errors.New("error revoking api key")
+	}
+
+	if n == 0 {
+		return ErrAPIKeyNotFound
+	}
+
+	return nil
+}
+
+func (r *repository) TouchAPIKey(ctx context.Context, id int32, at time.Time) error {
+	res, err := r.db.ExecContext(
+		ctx,
+		`UPDATE api_keys SET last_used_at = $2 WHERE id = $1;`,
+		id, at,
+	)
+	if err != nil {
+		log.Println("ERROR: account repo TouchAPIKey: ", err)
+		return errors.New("error updating api key")
+	}
+
+	n, err := res.RowsAffected()
+	if err != nil {
+		log.Println("ERROR: account repo TouchAPIKey (RowsAffected): ", err)
+		return errors.New("error updating api key")
+	}
+
+	if n == 0 {
+		return ErrAPIKeyNotFound
+	}
+
+	return nil
+}
+
+func scanAPIKey(row interface{ Scan(dest ...any) error }) (APIKey, error) {
+	k := APIKey{}
+	var expiresAt, lastUsedAt, revokedAt sql.NullTime
+
+	err := row.Scan(
+		&k.ID,
+		&k.AccountID,
+		&k.Name,
+		&k.Prefix,
+		&k.Hash,
+		pq.Array(&k.Scopes),
+		&expiresAt,
+		&k.CreatedAt,
+		&lastUsedAt,
+		&revokedAt,
+	)
+
+	k.ExpiresAt = expiresAt.Time
+	k.LastUsedAt = lastUsedAt.Time
+	k.RevokedAt = revokedAt.Time
+
+	return k, err
+}
+
+// nullTime stores a zero t as NULL.
+func nullTime(t time.Time) sql.NullTime {
+	return sql.NullTime{Time: t, Valid: !t.IsZero()}
+}
+
+func (r *repository) UpdateAccountStatus(ctx context.Context, id int32, from, to AccountStatus, reason string) (Account, error) {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		log.Println("ERROR: account repo UpdateAccountStatus (tx init): ", err)
+		return Account{}, errors.New("error updating account status")
+	}
+	defer tx.Rollback()
+
+	account := Account{}
+
+	err = tx.QueryRowContext(
+		ctx,
+		`UPDATE accounts
+		SET status = $3, status_reason = $4, status_changed_at = NOW()
+		WHERE id = $1 AND status = $2
+		RETURNING id, name, COALESCE(email, ''), email_verified, status, status_reason;`,
+		id, from, to, reason,
+	).Scan(
+		&account.ID,
+		&account.Name,
+		&account.Email,
+		&account.EmailVerified,
+		&account.Status,
+		&account.StatusReason,
+	)
+	if errors.Is(err, sql.ErrNoRows) {
+		return Account{}, ErrInvalidStatusTransition
+	}
+	if err != nil {
+		log.Println("ERROR: account repo UpdateAccountStatus: ", err)
+		return Account{}, errors.New("error updating account status")
+	}
+
+	event, err := events.New(EventAccountStatusChanged, strconv.Itoa(int(account.ID)), account)
+	if err != nil {
+		log.Println("ERROR: account repo UpdateAccountStatus (events.New): ", err)
+		return Account{}, errors.New("error updating account status")
+	}
+
+	if err := insertEvent(ctx, tx, event); err != nil {
+		log.Println("ERROR: account repo UpdateAccountStatus (insertEvent): ", err)
+		return Account{}, errors.New("error updating account status")
+	}
+
+	if err := tx.Commit(); err != nil {
+		log.Println("ERROR: account repo UpdateAccountStatus (tx commit): ", err)
+		return Account{}, errors.New("error updating account status")
+	}
+
+	return account, nil
+}
+
+func (r *repository) RecordAudit(ctx context.Context, e audit.Entry) error {
+	if _, err := r.db.ExecContext(
+		ctx,
+		`INSERT INTO audit_log (id, actor, action, entity_type, entity_id, before, after, request_id, occurred_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9);`,
+		e.ID, e.Actor, e.Action, e.EntityType, e.EntityID, nullJSON(e.Before), nullJSON(e.After), e.RequestID, e.OccurredAt,
+	); err != nil {
+		log.Println("ERROR: account repo RecordAudit: ", err)
+		return errors.New("error recording audit entry")
+	}
+
+	return nil
+}
+
+// QueryAuditLog treats each empty filter as matching everything.
+func (r *repository) QueryAuditLog(ctx context.Context, q audit.Query) ([]audit.Entry, error) {
+	rows, err := r.db.QueryContext(
+		ctx,
+		`SELECT
+			id,
+			actor,
+			action,
+			entity_type,
+			entity_id,
+			before,
+			after,
+			request_id,
+			occurred_at
+		FROM audit_log
+		WHERE ($1 = '' OR entity_type = $1)
+			AND ($2 = '' OR entity_id = $2)
+			AND ($3 = '' OR actor = $3)
+			AND ($4::TIMESTAMPTZ IS NULL OR occurred_at >= $4)
+			AND ($5::TIMESTAMPTZ IS NULL OR occurred_at < $5)
+		ORDER BY occurred_at DESC, id
+		OFFSET $6
+		LIMIT $7;`,
+		q.EntityType, q.EntityID, q.Actor, nullTime(q.From), nullTime(q.To), q.Offset, q.Limit,
+	)
+	if err != nil {
+		log.Println("ERROR: account repo QueryAuditLog (r.db.QueryContext): ", err)
+		return nil, errors.New("error querying audit log")
+	}
+
+	defer rows.Close()
+
+	entries := []audit.Entry{}
+
+	for rows.Next() {
+		e := audit.Entry{}
+		var before, after []byte
+
+		if err := rows.Scan(
+			&e.ID,
+			&e.Actor,
+			&e.Action,
+			&e.EntityType,
+			&e.EntityID,
+			&before,
+			&after,
+			&e.RequestID,
+			&e.OccurredAt,
+		); err != nil {
+			log.Println("ERROR: account repo QueryAuditLog (rows.Scan): ", err)
+			return nil, errors.New("error querying audit log")
+		}
+
+		e.Before, e.After = before, after
+		entries = append(entries, e)
+	}
+
+	if err := rows.Err(); err != nil {
+		log.Println("ERROR: account repo QueryAuditLog (rows.Err): ", err)
+		return nil, errors.New("error querying audit log")
+	}
+
+	return entries, nil
+}
+
+// nullJSON stores an empty document as NULL.
+func nullJSON(b []byte) any {
+	if len(b) == 0 {
+		return nil
+	}
+
+	return string(b)
+}
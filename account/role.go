@@ -0,0 +1,79 @@
+package main
+
+import (
+	"errors"
+	"slices"
+)
+
+// Permissions checked before privileged calls, here and in other services.
+const (
+	PermissionManageRoles        = "account:roles:manage"
+	PermissionManageAPIKeys      = "account:api_keys:manage"
+	PermissionReadAccountAudit   = "account:audit:read"
+	PermissionWriteProducts      = "catalog:products:write"
+	PermissionWriteExchangeRates = "catalog:exchange_rates:write"
+	PermissionReadCatalogAudit   = "catalog:audit:read"
+	PermissionReadAnyOrders      = "order:orders:read_any"
+	PermissionReadOrderAudit     = "order:audit:read"
+	PermissionManagePromotions   = "order:promotions:manage"
+	PermissionManageOrders       = "order:orders:manage"
+)
+
+var (
+	ErrRoleNotFound    = errors.New("role not found")
+	ErrRoleNotAssigned = errors.New("role is not assigned to the account")
+)
+
+// Role grants its Permissions to the accounts it is assigned to. Accounts
+// without a role are customers and can only act on their own data.
+type Role struct {
+	Name        string   `json:"name"`
+	Description string   `json:"description"`
+	Permissions []string `json:"permissions"`
+}
+
+// defaultRoles are the roles migration 0006 seeds, with the permissions
+// later migrations grant them; the memory repository starts with them too.
+var defaultRoles = []Role{
+	{
+		Name:        "admin",
+		Description: "Manages the catalog, roles and every account's orders",
+		Permissions: []string{
+			PermissionManageRoles,
+			PermissionManageAPIKeys,
+			PermissionReadAccountAudit,
+			PermissionWriteProducts,
+			PermissionWriteExchangeRates,
+			PermissionReadCatalogAudit,
+			PermissionReadAnyOrders,
+			PermissionReadOrderAudit,
+			PermissionManagePromotions,
+			PermissionManageOrders,
+		},
+	},
+	{
+		Name:        "support",
+		Description: "Reads every account's orders",
+		Permissions: []string{PermissionReadAnyOrders},
+	},
+}
+
+// rolePermissions returns the permissions roles grant, each once.
+func rolePermissions(roles []Role) []string {
+	permissions := []string{}
+
+	for _, r := range roles {
+		for _, p := range r.Permissions {
+			if !slices.Contains(permissions, p) {
+				permissions = append(permissions, p)
+			}
+		}
+	}
+
+	return permissions
+}
+
+// hasPermission reports whether any of roles grants permission.
+func hasPermission(roles []Role, permission string) bool {
+	return slices.ContainsFunc(roles, func(r Role) bool { return slices.Contains(r.Permissions, permission) })
+}
@@ -2,31 +2,54 @@ package main
 
 import (
 	"context"
+	"errors"
+	"log"
+	"net"
+	"strconv"
+	"time"
 
 	"github.com/airlangga-hub/microservices/account/pb"
+	"github.com/airlangga-hub/microservices/audit"
+	"github.com/airlangga-hub/microservices/auth"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
 )
 
+// Server implements AccountService. Limiter, if set, rate limits the token
+// endpoints: verification emails per account, and verification attempts per
+// client IP.
 type Server struct {
 	pb.UnimplementedAccountServiceServer
-	Svc Service
+	Svc     Service
+	Limiter *rateLimiter
 }
 
 func (s *Server) PostAccount(ctx context.Context, r *pb.PostAccountRequest) (*pb.PostAccountResponse, error) {
-	account, err := s.Svc.PostAccount(ctx, r.Name)
+	account, err := s.Svc.PostAccount(ctx, r.Name, r.Email)
 	if err != nil {
-		return nil, err
+		return nil, accountError(err)
 	}
 
-	return &pb.PostAccountResponse{Account: &pb.Account{Id: account.ID, Name: account.Name}}, nil
+	return &pb.PostAccountResponse{Account: pbAccount(account)}, nil
 }
 
 func (s *Server) GetAccount(ctx context.Context, r *pb.GetAccountRequest) (*pb.GetAccountResponse, error) {
 	account, err := s.Svc.GetAccount(ctx, r.Id)
 	if err != nil {
-		return nil, err
+		return nil, accountError(err)
+	}
+
+	return &pb.GetAccountResponse{Account: pbAccount(account)}, nil
+}
+
+func (s *Server) GetAccountByEmail(ctx context.Context, r *pb.GetAccountByEmailRequest) (*pb.GetAccountByEmailResponse, error) {
+	account, err := s.Svc.GetAccountByEmail(ctx, r.Email)
+	if err != nil {
+		return nil, accountError(err)
 	}
 
-	return &pb.GetAccountResponse{Account: &pb.Account{Id: account.ID, Name: account.Name}}, nil
+	return &pb.GetAccountByEmailResponse{Account: pbAccount(account)}, nil
 }
 
 func (s *Server) GetAccounts(ctx context.Context, r *pb.GetAccountsRequest) (*pb.GetAccountsResponse, error) {
@@ -38,9 +61,466 @@ func (s *Server) GetAccounts(ctx context.Context, r *pb.GetAccountsRequest) (*pb
 	a := []*pb.Account{}
 
 	for _, account := range accounts {
-		pbAccount := &pb.Account{Id: account.ID, Name: account.Name}
-		a = append(a, pbAccount)
+		a = append(a, pbAccount(account))
 	}
 
 	return &pb.GetAccountsResponse{Accounts: a}, nil
 }
+
+func (s *Server) AddAddress(ctx context.Context, r *pb.AddAddressRequest) (*pb.AddAddressResponse, error) {
+	address, err := s.Svc.AddAddress(ctx, r.AccountId, addressFromPB(r.Address))
+	if err != nil {
+		return nil, addressError(err)
+	}
+
+	return &pb.AddAddressResponse{Address: pbAddress(address)}, nil
+}
+
+func (s *Server) ListAddresses(ctx context.Context, r *pb.ListAddressesRequest) (*pb.ListAddressesResponse, error) {
+	addresses, err := s.Svc.ListAddresses(ctx, r.AccountId)
+	if err != nil {
+		return nil, err
+	}
+
+	pbAddresses := []*pb.Address{}
+
+	for _, a := range addresses {
+		pbAddresses = append(pbAddresses, pbAddress(a))
+	}
+
+	return &pb.ListAddressesResponse{Addresses: pbAddresses}, nil
+}
+
+func (s *Server) UpdateAddress(ctx context.Context, r *pb.UpdateAddressRequest) (*pb.UpdateAddressResponse, error) {
+	address, err := s.Svc.UpdateAddress(ctx, r.AccountId, addressFromPB(r.Address))
+	if err != nil {
+		return nil, addressError(err)
+	}
+
+	return &pb.UpdateAddressResponse{Address: pbAddress(address)}, nil
+}
+
+func (s *Server) DeleteAddress(ctx context.Context, r *pb.DeleteAddressRequest) (*pb.DeleteAddressResponse, error) {
+	if err := s.Svc.DeleteAddress(ctx, r.AccountId, r.AddressId); err != nil {
+		return nil, addressError(err)
+	}
+
+	return &pb.DeleteAddressResponse{}, nil
+}
+
+func (s *Server) SetDefaultAddress(ctx context.Context, r *pb.SetDefaultAddressRequest) (*pb.SetDefaultAddressResponse, error) {
+	address, err := s.Svc.SetDefaultAddress(ctx, r.AccountId, r.AddressId)
+	if err != nil {
+		return nil, addressError(err)
+	}
+
+	return &pb.SetDefaultAddressResponse{Address: pbAddress(address)}, nil
+}
+
+func (s *Server) RequestEmailVerification(ctx context.Context, r *pb.RequestEmailVerificationRequest) (*pb.RequestEmailVerificationResponse, error) {
+	if !s.allow("request_email_verification:" + strconv.Itoa(int(r.AccountId))) {
+		return nil, status.Error(codes.ResourceExhausted, "too many verification emails requested, try again later")
+	}
+
+	if err := s.Svc.RequestEmailVerification(ctx, r.AccountId); err != nil {
+		return nil, accountError(err)
+	}
+
+	return &pb.RequestEmailVerificationResponse{}, nil
+}
+
+func (s *Server) VerifyEmail(ctx context.Context, r *pb.VerifyEmailRequest) (*pb.VerifyEmailResponse, error) {
+	if !s.allow("verify_email:" + peerIP(ctx)) {
+		return nil, status.Error(codes.ResourceExhausted, "too many verification attempts, try again later")
+	}
+
+	account, err := s.Svc.VerifyEmail(ctx, r.Token)
+	if err != nil {
+		return nil, accountError(err)
+	}
+
+	return &pb.VerifyEmailResponse{Account: pbAccount(account)}, nil
+}
+
+// ListRoles lists every role, or an account's roles if account_id is set;
+// only the account itself or a role manager can see those.
+func (s *Server) ListRoles(ctx context.Context, r *pb.ListRolesRequest) (*pb.ListRolesResponse, error) {
+	if r.AccountId != 0 {
+		if err := auth.RequireAccount(ctx, r.AccountId, PermissionManageRoles); err != nil {
+			return nil, err
+		}
+	}
+
+	roles, err := s.Svc.ListRoles(ctx, r.AccountId)
+	if err != nil {
+		return nil, roleError(err)
+	}
+
+	return &pb.ListRolesResponse{Roles: pbRoles(roles)}, nil
+}
+
+// AssignRole and RevokeRole need the caller to manage roles.
+func (s *Server) AssignRole(ctx context.Context, r *pb.AssignRoleRequest) (*pb.AssignRoleResponse, error) {
+	if err := auth.Require(ctx, PermissionManageRoles); err != nil {
+		return nil, err
+	}
+
+	roles, err := s.Svc.AssignRole(ctx, r.AccountId, r.Role)
+	if err != nil {
+		return nil, roleError(err)
+	}
+
+	return &pb.AssignRoleResponse{Roles: pbRoles(roles)}, nil
+}
+
+func (s *Server) RevokeRole(ctx context.Context, r *pb.RevokeRoleRequest) (*pb.RevokeRoleResponse, error) {
+	if err := auth.Require(ctx, PermissionManageRoles); err != nil {
+		return nil, err
+	}
+
+	roles, err := s.Svc.RevokeRole(ctx, r.AccountId, r.Role)
+	if err != nil {
+		return nil, roleError(err)
+	}
+
+	return &pb.RevokeRoleResponse{Roles: pbRoles(roles)}, nil
+}
+
+// CheckPermission reports whether an account holds a permission, to the
+// account itself or a role manager. Services don't need it to authorize a
+// call: AuthenticateAPIKey already returns the caller's permissions.
+func (s *Server) CheckPermission(ctx context.Context, r *pb.CheckPermissionRequest) (*pb.CheckPermissionResponse, error) {
+	if err := auth.RequireAccount(ctx, r.AccountId, PermissionManageRoles); err != nil {
+		return nil, err
+	}
+
+	allowed, err := s.Svc.CheckPermission(ctx, r.AccountId, r.Permission)
+	if err != nil {
+		return nil, err
+	}
+
+	return &pb.CheckPermissionResponse{Allowed: allowed}, nil
+}
+
+// CreateAPIKey returns the key's secret in key; it is only ever sent here.
+// Only the account itself, or a caller that may manage every account's
+// keys, can create, list or revoke an account's keys.
+func (s *Server) CreateAPIKey(ctx context.Context, r *pb.CreateAPIKeyRequest) (*pb.CreateAPIKeyResponse, error) {
+	if err := auth.RequireAccount(ctx, r.AccountId, PermissionManageAPIKeys); err != nil {
+		return nil, err
+	}
+
+	var expiresAt time.Time
+	if len(r.ExpiresAt) > 0 {
+		if err := expiresAt.UnmarshalBinary(r.ExpiresAt); err != nil {
+			return nil, status.Error(codes.InvalidArgument, "invalid expires_at")
+		}
+	}
+
+	k, key, err := s.Svc.CreateAPIKey(ctx, r.AccountId, r.Name, r.Scopes, expiresAt)
+	if err != nil {
+		return nil, apiKeyError(err)
+	}
+
+	pbKey, err := pbAPIKey(k)
+	if err != nil {
+		return nil, err
+	}
+
+	return &pb.CreateAPIKeyResponse{ApiKey: pbKey, Key: key}, nil
+}
+
+func (s *Server) ListAPIKeys(ctx context.Context, r *pb.ListAPIKeysRequest) (*pb.ListAPIKeysResponse, error) {
+	if err := auth.RequireAccount(ctx, r.AccountId, PermissionManageAPIKeys); err != nil {
+		return nil, err
+	}
+
+	keys, err := s.Svc.ListAPIKeys(ctx, r.AccountId)
+	if err != nil {
+		return nil, err
+	}
+
+	pbKeys := []*pb.APIKey{}
+
+	for _, k := range keys {
+		pbKey, err := pbAPIKey(k)
+		if err != nil {
+			return nil, err
+		}
+		pbKeys = append(pbKeys, pbKey)
+	}
+
+	return &pb.ListAPIKeysResponse{ApiKeys: pbKeys}, nil
+}
+
+func (s *Server) RevokeAPIKey(ctx context.Context, r *pb.RevokeAPIKeyRequest) (*pb.RevokeAPIKeyResponse, error) {
+	if err := auth.RequireAccount(ctx, r.AccountId, PermissionManageAPIKeys); err != nil {
+		return nil, err
+	}
+
+	if err := s.Svc.RevokeAPIKey(ctx, r.AccountId, r.ApiKeyId); err != nil {
+		return nil, apiKeyError(err)
+	}
+
+	return &pb.RevokeAPIKeyResponse{}, nil
+}
+
+// AuthenticateAPIKey resolves a key a machine client sent, as x-api-key
+// metadata, to its account, scopes and permissions. Other services call it
+// from their auth interceptor.
+func (s *Server) AuthenticateAPIKey(ctx context.Context, r *pb.AuthenticateAPIKeyRequest) (*pb.AuthenticateAPIKeyResponse, error) {
+	k, permissions, err := s.Svc.AuthenticateAPIKey(ctx, r.Key)
+	if err != nil {
+		return nil, apiKeyError(err)
+	}
+
+	pbKey, err := pbAPIKey(k)
+	if err != nil {
+		return nil, err
+	}
+
+	return &pb.AuthenticateAPIKeyResponse{ApiKey: pbKey, Permissions: permissions}, nil
+}
+
+func (s *Server) SuspendAccount(ctx context.Context, r *pb.SuspendAccountRequest) (*pb.SuspendAccountResponse, error) {
+	account, err := s.Svc.SuspendAccount(ctx, r.Id, r.Reason)
+	if err != nil {
+		return nil, accountError(err)
+	}
+
+	return &pb.SuspendAccountResponse{Account: pbAccount(account)}, nil
+}
+
+func (s *Server) ReactivateAccount(ctx context.Context, r *pb.ReactivateAccountRequest) (*pb.ReactivateAccountResponse, error) {
+	account, err := s.Svc.ReactivateAccount(ctx, r.Id, r.Reason)
+	if err != nil {
+		return nil, accountError(err)
+	}
+
+	return &pb.ReactivateAccountResponse{Account: pbAccount(account)}, nil
+}
+
+func (s *Server) CloseAccount(ctx context.Context, r *pb.CloseAccountRequest) (*pb.CloseAccountResponse, error) {
+	account, err := s.Svc.CloseAccount(ctx, r.Id, r.Reason)
+	if err != nil {
+		return nil, accountError(err)
+	}
+
+	return &pb.CloseAccountResponse{Account: pbAccount(account)}, nil
+}
+
+// QueryAuditLog returns audit entries newest first, filtered by entity,
+// actor and an occurred_at range, to callers that may read the audit log.
+func (s *Server) QueryAuditLog(ctx context.Context, r *pb.QueryAccountAuditLogRequest) (*pb.QueryAccountAuditLogResponse, error) {
+	if err := auth.Require(ctx, PermissionReadAccountAudit); err != nil {
+		return nil, err
+	}
+
+	q := audit.Query{EntityType: r.EntityType, EntityID: r.EntityId, Actor: r.Actor, Offset: r.Offset, Limit: r.Limit}
+
+	if len(r.From) > 0 {
+		if err := q.From.UnmarshalBinary(r.From); err != nil {
+			return nil, status.Error(codes.InvalidArgument, "invalid from")
+		}
+	}
+	if len(r.To) > 0 {
+		if err := q.To.UnmarshalBinary(r.To); err != nil {
+			return nil, status.Error(codes.InvalidArgument, "invalid to")
+		}
+	}
+
+	entries, err := s.Svc.QueryAuditLog(ctx, q)
+	if err != nil {
+		return nil, err
+	}
+
+	pbEntries := []*pb.AccountAuditEntry{}
+
+	for _, e := range entries {
+		occurredAt, err := e.OccurredAt.MarshalBinary()
+		if err != nil {
+			log.Println("ERROR: account server QueryAuditLog (MarshalBinary): ", err)
+			return nil, errors.New("error encoding audit entry")
+		}
+
+		pbEntries = append(pbEntries, &pb.AccountAuditEntry{
+			Id:         e.ID,
+			Actor:      e.Actor,
+			Action:     e.Action,
+			EntityType: e.EntityType,
+			EntityId:   e.EntityID,
+			Before:     string(e.Before),
+			After:      string(e.After),
+			RequestId:  e.RequestID,
+			OccurredAt: occurredAt,
+		})
+	}
+
+	return &pb.QueryAccountAuditLogResponse{Entries: pbEntries}, nil
+}
+
+func (s *Server) allow(key string) bool {
+	return s.Limiter == nil || s.Limiter.Allow(key)
+}
+
+// peerIP is the caller's IP without the port, so reconnecting doesn't reset
+// its limit.
+func peerIP(ctx context.Context) string {
+	p, ok := peer.FromContext(ctx)
+	if !ok || p.Addr == nil {
+		return ""
+	}
+
+	host, _, err := net.SplitHostPort(p.Addr.String())
+	if err != nil {
+		return p.Addr.String()
+	}
+
+	return host
+}
+
+func accountError(err error) error {
+	switch {
+	case errors.Is(err, ErrInvalidEmail):
+		return status.Error(codes.InvalidArgument, err.Error())
+	case errors.Is(err, ErrEmailTaken):
+		return status.Error(codes.AlreadyExists, err.Error())
+	case errors.Is(err, ErrAccountNotFound):
+		return status.Error(codes.NotFound, err.Error())
+	case errors.Is(err, ErrStatusReasonRequired):
+		return status.Error(codes.InvalidArgument, err.Error())
+	case errors.Is(err, ErrNoEmail), errors.Is(err, ErrEmailVerified), errors.Is(err, ErrInvalidStatusTransition):
+		return status.Error(codes.FailedPrecondition, err.Error())
+	case errors.Is(err, ErrInvalidToken):
+		return status.Error(codes.InvalidArgument, err.Error())
+	default:
+		return err
+	}
+}
+
+func pbAccount(a Account) *pb.Account {
+	return &pb.Account{
+		Id:            a.ID,
+		Name:          a.Name,
+		Email:         a.Email,
+		EmailVerified: a.EmailVerified,
+		Status:        pbAccountStatus[a.Status],
+		StatusReason:  a.StatusReason,
+	}
+}
+
+var pbAccountStatus = map[AccountStatus]pb.AccountStatus{
+	AccountActive:    pb.AccountStatus_ACCOUNT_STATUS_ACTIVE,
+	AccountSuspended: pb.AccountStatus_ACCOUNT_STATUS_SUSPENDED,
+	AccountClosed:    pb.AccountStatus_ACCOUNT_STATUS_CLOSED,
+}
+
+func roleError(err error) error {
+	switch {
+	case errors.Is(err, ErrRoleNotFound), errors.Is(err, ErrRoleNotAssigned), errors.Is(err, ErrAccountNotFound):
+		return status.Error(codes.NotFound, err.Error())
+	default:
+		return err
+	}
+}
+
+func pbRoles(roles []Role) []*pb.Role {
+	pbRoles := []*pb.Role{}
+
+	for _, r := range roles {
+		pbRoles = append(pbRoles, &pb.Role{Name: r.Name, Description: r.Description, Permissions: r.Permissions})
+	}
+
+	return pbRoles
+}
+
+func apiKeyError(err error) error {
+	switch {
+	case errors.Is(err, ErrInvalidAPIKey):
+		return status.Error(codes.InvalidArgument, err.Error())
+	case errors.Is(err, ErrUnknownAPIKey):
+		return status.Error(codes.Unauthenticated, err.Error())
+	case errors.Is(err, ErrScopeNotPermitted):
+		return status.Error(codes.PermissionDenied, err.Error())
+	case errors.Is(err, ErrAPIKeyNotFound), errors.Is(err, ErrAccountNotFound):
+		return status.Error(codes.NotFound, err.Error())
+	default:
+		return err
+	}
+}
+
+// pbAPIKey leaves out the hash, and sends times that haven't happened, like
+// a never used key's last_used_at, as empty.
+func pbAPIKey(k APIKey) (*pb.APIKey, error) {
+	pbKey := &pb.APIKey{
+		Id:        k.ID,
+		AccountId: k.AccountID,
+		Name:      k.Name,
+		Prefix:    k.Prefix,
+		Scopes:    k.Scopes,
+	}
+
+	for _, t := range []struct {
+		at  time.Time
+		dst *[]byte
+	}{
+		{k.ExpiresAt, &pbKey.ExpiresAt},
+		{k.CreatedAt, &pbKey.CreatedAt},
+		{k.LastUsedAt, &pbKey.LastUsedAt},
+		{k.RevokedAt, &pbKey.RevokedAt},
+	} {
+		if t.at.IsZero() {
+			continue
+		}
+
+		b, err := t.at.MarshalBinary()
+		if err != nil {
+			log.Println("ERROR: account server pbAPIKey (MarshalBinary): ", err)
+			return nil, errors.New("error encoding api key")
+		}
+		*t.dst = b
+	}
+
+	return pbKey, nil
+}
+
+func addressError(err error) error {
+	switch {
+	case errors.Is(err, ErrInvalidAddress):
+		return status.Error(codes.InvalidArgument, err.Error())
+	case errors.Is(err, ErrAddressNotFound), errors.Is(err, ErrAccountNotFound):
+		return status.Error(codes.NotFound, err.Error())
+	default:
+		return err
+	}
+}
+
+func pbAddress(a Address) *pb.Address {
+	return &pb.Address{
+		Id:         a.ID,
+		AccountId:  a.AccountID,
+		Name:       a.Name,
+		Line1:      a.Line1,
+		Line2:      a.Line2,
+		City:       a.City,
+		Region:     a.Region,
+		PostalCode: a.PostalCode,
+		Country:    a.Country,
+		Phone:      a.Phone,
+		IsDefault:  a.IsDefault,
+	}
+}
+
+func addressFromPB(a *pb.Address) Address {
+	return Address{
+		ID:         a.GetId(),
+		Name:       a.GetName(),
+		Line1:      a.GetLine1(),
+		Line2:      a.GetLine2(),
+		City:       a.GetCity(),
+		Region:     a.GetRegion(),
+		PostalCode: a.GetPostalCode(),
+		Country:    a.GetCountry(),
+		Phone:      a.GetPhone(),
+	}
+}
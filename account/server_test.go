@@ -0,0 +1,855 @@
+package main
+
+import (
+	"context"
+	"net"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/airlangga-hub/microservices/account/pb"
+	"github.com/airlangga-hub/microservices/audit"
+	"github.com/airlangga-hub/microservices/auth"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+)
+
+// startServer serves the account service over an in-process bufconn listener
+// backed by an in-memory repository.
+func startServer(t *testing.T) pb.AccountServiceClient {
+	t.Helper()
+
+	client, _ := startService(t)
+	return client
+}
+
+// startService is startServer that also returns the service, to give
+// callers keys with.
+func startService(t *testing.T) (pb.AccountServiceClient, Service) {
+	t.Helper()
+
+	repo := NewMemoryRepository()
+	svc := NewService(repo, &recordingMailer{}, time.Hour)
+
+	return serve(t, repo, &Server{Svc: svc}), svc
+}
+
+// serve serves srv, authenticating callers and auditing their calls to repo
+// like main does.
+func serve(t *testing.T, repo Repository, srv *Server) pb.AccountServiceClient {
+	t.Helper()
+
+	lis := bufconn.Listen(1 << 20)
+
+	s := grpc.NewServer(grpc.ChainUnaryInterceptor(
+		auth.UnaryInterceptor(srv.authenticateAPIKey, methodScopes),
+		audit.Interceptor("account", repo, srv.auditSpecs()),
+	))
+	pb.RegisterAccountServiceServer(s, srv)
+
+	go s.Serve(lis)
+	t.Cleanup(s.Stop)
+
+	conn, err := grpc.NewClient(
+		"passthrough:///bufnet",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) { return lis.DialContext(ctx) }),
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+	)
+	if err != nil {
+		t.Fatalf("grpc.NewClient: %v", err)
+	}
+	t.Cleanup(func() { conn.Close() })
+
+	return pb.NewAccountServiceClient(conn)
+}
+
+// withAPIKey returns a context sending a new key of accountID with scopes.
+func withAPIKey(t *testing.T, svc Service, accountID int32, scopes ...string) context.Context {
+	t.Helper()
+
+	_, key, err := svc.CreateAPIKey(context.Background(), accountID, "test", scopes, time.Time{})
+	if err != nil {
+		t.Fatalf("CreateAPIKey(%v): %v", scopes, err)
+	}
+
+	return metadata.AppendToOutgoingContext(context.Background(), auth.APIKeyMetadataKey, key)
+}
+
+// asAdmin returns a context sending the key the admin-key command would
+// issue a new account.
+func asAdmin(t *testing.T, svc Service) context.Context {
+	t.Helper()
+
+	ctx := context.Background()
+
+	admin, err := svc.PostAccount(ctx, "admin", "")
+	if err != nil {
+		t.Fatalf("PostAccount: %v", err)
+	}
+
+	key, err := issueAdminKey(ctx, svc, admin.ID)
+	if err != nil {
+		t.Fatalf("issueAdminKey: %v", err)
+	}
+
+	return metadata.AppendToOutgoingContext(ctx, auth.APIKeyMetadataKey, key)
+}
+
+func TestPostAndGetAccount(t *testing.T) {
+	client := startServer(t)
+	ctx := context.Background()
+
+	posted, err := client.PostAccount(ctx, &pb.PostAccountRequest{Name: "angga", Email: "angga@example.com"})
+	if err != nil {
+		t.Fatalf("PostAccount: %v", err)
+	}
+
+	got, err := client.GetAccount(ctx, &pb.GetAccountRequest{Id: posted.Account.Id})
+	if err != nil {
+		t.Fatalf("GetAccount: %v", err)
+	}
+
+	if got.Account.Name != "angga" {
+		t.Errorf("GetAccount name = %q, want %q", got.Account.Name, "angga")
+	}
+}
+
+func TestGetAccountNotFound(t *testing.T) {
+	client := startServer(t)
+
+	if _, err := client.GetAccount(context.Background(), &pb.GetAccountRequest{Id: 42}); status.Code(err) != codes.NotFound {
+		t.Fatalf("GetAccount of a missing account error = %v, want NotFound", err)
+	}
+}
+
+func TestAccountEmail(t *testing.T) {
+	client := startServer(t)
+	ctx := context.Background()
+
+	posted, err := client.PostAccount(ctx, &pb.PostAccountRequest{Name: "angga", Email: " Angga@Example.com "})
+	if err != nil {
+		t.Fatalf("PostAccount: %v", err)
+	}
+	if posted.Account.Email != "Angga@Example.com" || posted.Account.EmailVerified {
+		t.Errorf("posted account = %v, want the trimmed, unverified email", posted.Account)
+	}
+
+	_, err = client.PostAccount(ctx, &pb.PostAccountRequest{Name: "impostor", Email: "angga@example.COM"})
+	if status.Code(err) != codes.AlreadyExists {
+		t.Errorf("PostAccount with a taken email error = %v, want AlreadyExists", err)
+	}
+
+	for _, email := range []string{"angga", "Angga <angga@example.com>"} {
+		if _, err := client.PostAccount(ctx, &pb.PostAccountRequest{Name: "angga", Email: email}); status.Code(err) != codes.InvalidArgument {
+			t.Errorf("PostAccount(email %q) error = %v, want InvalidArgument", email, err)
+		}
+	}
+
+	got, err := client.GetAccountByEmail(ctx, &pb.GetAccountByEmailRequest{Email: "ANGGA@example.com"})
+	if err != nil {
+		t.Fatalf("GetAccountByEmail: %v", err)
+	}
+	if got.Account.Id != posted.Account.Id {
+		t.Errorf("GetAccountByEmail = %v, want account %d", got.Account, posted.Account.Id)
+	}
+
+	if _, err := client.GetAccountByEmail(ctx, &pb.GetAccountByEmailRequest{Email: "nobody@example.com"}); status.Code(err) != codes.NotFound {
+		t.Errorf("GetAccountByEmail of an unknown email error = %v, want NotFound", err)
+	}
+}
+
+func TestPostAccountWithoutEmail(t *testing.T) {
+	client := startServer(t)
+	ctx := context.Background()
+
+	// any number of accounts may have no email
+	ids := []int32{}
+	for _, email := range []string{"", "  "} {
+		posted, err := client.PostAccount(ctx, &pb.PostAccountRequest{Name: "angga", Email: email})
+		if err != nil {
+			t.Fatalf("PostAccount(email %q): %v", email, err)
+		}
+		if posted.Account.Email != "" || posted.Account.EmailVerified {
+			t.Errorf("posted account = %v, want no email", posted.Account)
+		}
+		ids = append(ids, posted.Account.Id)
+	}
+
+	if _, err := client.GetAccountByEmail(ctx, &pb.GetAccountByEmailRequest{Email: ""}); status.Code(err) != codes.NotFound {
+		t.Errorf("GetAccountByEmail of no email error = %v, want NotFound", err)
+	}
+
+	if _, err := client.RequestEmailVerification(ctx, &pb.RequestEmailVerificationRequest{AccountId: ids[0]}); status.Code(err) != codes.FailedPrecondition {
+		t.Errorf("RequestEmailVerification without an email error = %v, want FailedPrecondition", err)
+	}
+}
+
+func TestGetAccountsNewestFirst(t *testing.T) {
+	client := startServer(t)
+	ctx := context.Background()
+
+	for _, name := range []string{"a", "b", "c"} {
+		if _, err := client.PostAccount(ctx, &pb.PostAccountRequest{Name: name, Email: name + "@example.com"}); err != nil {
+			t.Fatalf("PostAccount: %v", err)
+		}
+	}
+
+	res, err := client.GetAccounts(ctx, &pb.GetAccountsRequest{Offset: 1, Limit: 5})
+	if err != nil {
+		t.Fatalf("GetAccounts: %v", err)
+	}
+
+	names := []string{}
+	for _, a := range res.Accounts {
+		names = append(names, a.Name)
+	}
+
+	if len(names) != 2 || names[0] != "b" || names[1] != "a" {
+		t.Errorf("GetAccounts(offset 1) = %v, want [b a]", names)
+	}
+}
+
+func TestAddresses(t *testing.T) {
+	client := startServer(t)
+	ctx := context.Background()
+
+	posted, err := client.PostAccount(ctx, &pb.PostAccountRequest{Name: "angga", Email: "angga@example.com"})
+	if err != nil {
+		t.Fatalf("PostAccount: %v", err)
+	}
+	accountID := posted.Account.Id
+
+	home, err := client.AddAddress(ctx, &pb.AddAddressRequest{AccountId: accountID, Address: &pb.Address{Name: "Angga", Line1: "Jl. Sudirman 1", City: "Jakarta", Country: "id"}})
+	if err != nil {
+		t.Fatalf("AddAddress: %v", err)
+	}
+	if !home.Address.IsDefault || home.Address.Country != "ID" {
+		t.Errorf("first address = %v, want the default with country ID", home.Address)
+	}
+
+	work, err := client.AddAddress(ctx, &pb.AddAddressRequest{AccountId: accountID, Address: &pb.Address{Name: "Angga", Line1: "Jl. Thamrin 2", City: "Jakarta", Country: "ID"}})
+	if err != nil {
+		t.Fatalf("AddAddress: %v", err)
+	}
+	if work.Address.IsDefault {
+		t.Error("second address is the default, want only the first")
+	}
+
+	if _, err := client.SetDefaultAddress(ctx, &pb.SetDefaultAddressRequest{AccountId: accountID, AddressId: work.Address.Id}); err != nil {
+		t.Fatalf("SetDefaultAddress: %v", err)
+	}
+
+	updated, err := client.UpdateAddress(ctx, &pb.UpdateAddressRequest{AccountId: accountID, Address: &pb.Address{Id: home.Address.Id, Name: "Angga", Line1: "Jl. Sudirman 3", City: "Jakarta", Country: "ID"}})
+	if err != nil {
+		t.Fatalf("UpdateAddress: %v", err)
+	}
+	if updated.Address.Line1 != "Jl. Sudirman 3" || updated.Address.IsDefault {
+		t.Errorf("updated address = %v, want the new line1 and not the default", updated.Address)
+	}
+
+	list, err := client.ListAddresses(ctx, &pb.ListAddressesRequest{AccountId: accountID})
+	if err != nil {
+		t.Fatalf("ListAddresses: %v", err)
+	}
+	if len(list.Addresses) != 2 || list.Addresses[0].IsDefault || !list.Addresses[1].IsDefault {
+		t.Errorf("ListAddresses = %v, want both with only the second as default", list.Addresses)
+	}
+
+	if _, err := client.DeleteAddress(ctx, &pb.DeleteAddressRequest{AccountId: accountID, AddressId: home.Address.Id}); err != nil {
+		t.Fatalf("DeleteAddress: %v", err)
+	}
+	if _, err := client.DeleteAddress(ctx, &pb.DeleteAddressRequest{AccountId: accountID, AddressId: home.Address.Id}); status.Code(err) != codes.NotFound {
+		t.Errorf("DeleteAddress of a deleted address error = %v, want NotFound", err)
+	}
+}
+
+func TestAddressesAreScopedToTheirAccount(t *testing.T) {
+	client := startServer(t)
+	ctx := context.Background()
+
+	owner, _ := client.PostAccount(ctx, &pb.PostAccountRequest{Name: "owner", Email: "owner@example.com"})
+	other, _ := client.PostAccount(ctx, &pb.PostAccountRequest{Name: "other", Email: "other@example.com"})
+
+	added, err := client.AddAddress(ctx, &pb.AddAddressRequest{AccountId: owner.Account.Id, Address: &pb.Address{Name: "Owner", Line1: "1 Main St", City: "London", Country: "GB"}})
+	if err != nil {
+		t.Fatalf("AddAddress: %v", err)
+	}
+
+	if _, err := client.SetDefaultAddress(ctx, &pb.SetDefaultAddressRequest{AccountId: other.Account.Id, AddressId: added.Address.Id}); status.Code(err) != codes.NotFound {
+		t.Errorf("SetDefaultAddress from another account error = %v, want NotFound", err)
+	}
+	if _, err := client.DeleteAddress(ctx, &pb.DeleteAddressRequest{AccountId: other.Account.Id, AddressId: added.Address.Id}); status.Code(err) != codes.NotFound {
+		t.Errorf("DeleteAddress from another account error = %v, want NotFound", err)
+	}
+
+	for _, a := range []*pb.Address{
+		{Line1: "1 Main St", City: "London", Country: "GB"},
+		{Name: "Owner", Line1: "1 Main St", City: "London", Country: "GBR"},
+	} {
+		if _, err := client.AddAddress(ctx, &pb.AddAddressRequest{AccountId: owner.Account.Id, Address: a}); status.Code(err) != codes.InvalidArgument {
+			t.Errorf("AddAddress(%v) error = %v, want InvalidArgument", a, err)
+		}
+	}
+}
+
+// recordingMailer keeps the emails sent through it.
+type recordingMailer struct {
+	mu   sync.Mutex
+	sent []sentEmail
+}
+
+type sentEmail struct {
+	to, subject, body string
+}
+
+func (m *recordingMailer) Send(ctx context.Context, to, subject, body string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.sent = append(m.sent, sentEmail{to, subject, body})
+	return nil
+}
+
+// lastToken is the token in the last email sent, which is on a paragraph of
+// its own.
+func (m *recordingMailer) lastToken(t *testing.T) string {
+	t.Helper()
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if len(m.sent) == 0 {
+		t.Fatal("no email was sent")
+	}
+
+	paragraphs := strings.Split(m.sent[len(m.sent)-1].body, "\n\n")
+	if len(paragraphs) < 3 {
+		t.Fatalf("email body %q has no token", m.sent[len(m.sent)-1].body)
+	}
+
+	return paragraphs[2]
+}
+
+func TestVerifyEmail(t *testing.T) {
+	mailer := &recordingMailer{}
+	repo := NewMemoryRepository()
+	client := serve(t, repo, &Server{Svc: NewService(repo, mailer, time.Hour)})
+	ctx := context.Background()
+
+	posted, err := client.PostAccount(ctx, &pb.PostAccountRequest{Name: "angga", Email: "angga@example.com"})
+	if err != nil {
+		t.Fatalf("PostAccount: %v", err)
+	}
+
+	if _, err := client.RequestEmailVerification(ctx, &pb.RequestEmailVerificationRequest{AccountId: posted.Account.Id}); err != nil {
+		t.Fatalf("RequestEmailVerification: %v", err)
+	}
+	stale := mailer.lastToken(t)
+
+	if _, err := client.RequestEmailVerification(ctx, &pb.RequestEmailVerificationRequest{AccountId: posted.Account.Id}); err != nil {
+		t.Fatalf("RequestEmailVerification again: %v", err)
+	}
+	token := mailer.lastToken(t)
+
+	if mailer.sent[1].to != "angga@example.com" {
+		t.Errorf("verification email sent to %q, want %q", mailer.sent[1].to, "angga@example.com")
+	}
+
+	if _, err := client.VerifyEmail(ctx, &pb.VerifyEmailRequest{Token: stale}); status.Code(err) != codes.InvalidArgument {
+		t.Errorf("VerifyEmail with a replaced token error = %v, want InvalidArgument", err)
+	}
+
+	verified, err := client.VerifyEmail(ctx, &pb.VerifyEmailRequest{Token: token})
+	if err != nil {
+		t.Fatalf("VerifyEmail: %v", err)
+	}
+	if verified.Account.Id != posted.Account.Id || !verified.Account.EmailVerified {
+		t.Errorf("VerifyEmail account = %v, want account %d verified", verified.Account, posted.Account.Id)
+	}
+
+	if _, err := client.VerifyEmail(ctx, &pb.VerifyEmailRequest{Token: token}); status.Code(err) != codes.InvalidArgument {
+		t.Errorf("VerifyEmail with a used token error = %v, want InvalidArgument", err)
+	}
+
+	if _, err := client.RequestEmailVerification(ctx, &pb.RequestEmailVerificationRequest{AccountId: posted.Account.Id}); status.Code(err) != codes.FailedPrecondition {
+		t.Errorf("RequestEmailVerification of a verified email error = %v, want FailedPrecondition", err)
+	}
+}
+
+func TestVerifyEmailExpiredToken(t *testing.T) {
+	mailer := &recordingMailer{}
+	repo := NewMemoryRepository()
+	client := serve(t, repo, &Server{Svc: NewService(repo, mailer, time.Nanosecond)})
+	ctx := context.Background()
+
+	posted, _ := client.PostAccount(ctx, &pb.PostAccountRequest{Name: "angga", Email: "angga@example.com"})
+
+	if _, err := client.RequestEmailVerification(ctx, &pb.RequestEmailVerificationRequest{AccountId: posted.Account.Id}); err != nil {
+		t.Fatalf("RequestEmailVerification: %v", err)
+	}
+
+	if _, err := client.VerifyEmail(ctx, &pb.VerifyEmailRequest{Token: mailer.lastToken(t)}); status.Code(err) != codes.InvalidArgument {
+		t.Errorf("VerifyEmail with an expired token error = %v, want InvalidArgument", err)
+	}
+}
+
+func TestTokenEndpointsAreRateLimited(t *testing.T) {
+	repo := NewMemoryRepository()
+	client := serve(t, repo, &Server{
+		Svc:     NewService(repo, &recordingMailer{}, time.Hour),
+		Limiter: newRateLimiter(2, time.Hour),
+	})
+	ctx := context.Background()
+
+	posted, _ := client.PostAccount(ctx, &pb.PostAccountRequest{Name: "angga", Email: "angga@example.com"})
+
+	for i := range 3 {
+		_, err := client.RequestEmailVerification(ctx, &pb.RequestEmailVerificationRequest{AccountId: posted.Account.Id})
+		if want := i < 2; (err == nil) != want {
+			t.Errorf("RequestEmailVerification %d error = %v, want allowed %v", i+1, err, want)
+		}
+		if i == 2 && status.Code(err) != codes.ResourceExhausted {
+			t.Errorf("RequestEmailVerification over the limit error = %v, want ResourceExhausted", err)
+		}
+	}
+
+	for i := range 3 {
+		_, err := client.VerifyEmail(ctx, &pb.VerifyEmailRequest{Token: "guess"})
+		want := codes.InvalidArgument
+		if i == 2 {
+			want = codes.ResourceExhausted
+		}
+		if status.Code(err) != want {
+			t.Errorf("VerifyEmail %d error = %v, want %v", i+1, err, want)
+		}
+	}
+}
+
+func TestRoles(t *testing.T) {
+	client, svc := startService(t)
+	ctx := asAdmin(t, svc)
+
+	posted, _ := client.PostAccount(context.Background(), &pb.PostAccountRequest{Name: "angga", Email: "angga@example.com"})
+	accountID := posted.Account.Id
+	owner := withAPIKey(t, svc, accountID, "account:roles:*")
+
+	all, err := client.ListRoles(context.Background(), &pb.ListRolesRequest{})
+	if err != nil {
+		t.Fatalf("ListRoles: %v", err)
+	}
+	if len(all.Roles) != len(defaultRoles) {
+		t.Errorf("ListRoles = %v, want the %d default roles", all.Roles, len(defaultRoles))
+	}
+
+	check := func(permission string) bool {
+		t.Helper()
+
+		res, err := client.CheckPermission(owner, &pb.CheckPermissionRequest{AccountId: accountID, Permission: permission})
+		if err != nil {
+			t.Fatalf("CheckPermission(%s): %v", permission, err)
+		}
+		return res.Allowed
+	}
+
+	if check(PermissionWriteProducts) {
+		t.Errorf("CheckPermission(%s) of an account without roles = true, want false", PermissionWriteProducts)
+	}
+
+	assigned, err := client.AssignRole(ctx, &pb.AssignRoleRequest{AccountId: accountID, Role: "support"})
+	if err != nil {
+		t.Fatalf("AssignRole: %v", err)
+	}
+	if len(assigned.Roles) != 1 || assigned.Roles[0].Name != "support" {
+		t.Errorf("AssignRole roles = %v, want only support", assigned.Roles)
+	}
+
+	if !check(PermissionReadAnyOrders) || check(PermissionWriteProducts) {
+		t.Errorf("support may read any orders but not write products")
+	}
+
+	if _, err := client.AssignRole(ctx, &pb.AssignRoleRequest{AccountId: accountID, Role: "support"}); err != nil {
+		t.Errorf("AssignRole of a role the account has: %v", err)
+	}
+	if _, err := client.AssignRole(ctx, &pb.AssignRoleRequest{AccountId: accountID, Role: "owner"}); status.Code(err) != codes.NotFound {
+		t.Errorf("AssignRole of an unknown role error = %v, want NotFound", err)
+	}
+	if _, err := client.AssignRole(ctx, &pb.AssignRoleRequest{AccountId: 42, Role: "admin"}); status.Code(err) != codes.NotFound {
+		t.Errorf("AssignRole to a missing account error = %v, want NotFound", err)
+	}
+
+	revoked, err := client.RevokeRole(ctx, &pb.RevokeRoleRequest{AccountId: accountID, Role: "support"})
+	if err != nil {
+		t.Fatalf("RevokeRole: %v", err)
+	}
+	if len(revoked.Roles) != 0 || check(PermissionReadAnyOrders) {
+		t.Errorf("RevokeRole roles = %v, want none and no permissions left", revoked.Roles)
+	}
+
+	if _, err := client.RevokeRole(ctx, &pb.RevokeRoleRequest{AccountId: accountID, Role: "support"}); status.Code(err) != codes.NotFound {
+		t.Errorf("RevokeRole of a role the account doesn't have error = %v, want NotFound", err)
+	}
+}
+
+func TestRolesNeedARoleManager(t *testing.T) {
+	client, svc := startService(t)
+
+	posted, _ := client.PostAccount(context.Background(), &pb.PostAccountRequest{Name: "angga", Email: "angga@example.com"})
+	other, _ := client.PostAccount(context.Background(), &pb.PostAccountRequest{Name: "other", Email: "other@example.com"})
+	id := posted.Account.Id
+
+	// even a key scoped for everything has no permissions its account lacks
+	owner := withAPIKey(t, svc, id, "*:*:*")
+
+	for _, tt := range []struct {
+		name string
+		ctx  context.Context
+		code codes.Code
+	}{
+		{"anonymous", context.Background(), codes.Unauthenticated},
+		{"the account itself", owner, codes.PermissionDenied},
+	} {
+		if _, err := client.AssignRole(tt.ctx, &pb.AssignRoleRequest{AccountId: id, Role: "admin"}); status.Code(err) != tt.code {
+			t.Errorf("AssignRole as %s error = %v, want %v", tt.name, err, tt.code)
+		}
+		if _, err := client.RevokeRole(tt.ctx, &pb.RevokeRoleRequest{AccountId: id, Role: "admin"}); status.Code(err) != tt.code {
+			t.Errorf("RevokeRole as %s error = %v, want %v", tt.name, err, tt.code)
+		}
+	}
+
+	if _, err := client.CheckPermission(owner, &pb.CheckPermissionRequest{AccountId: id, Permission: PermissionManageRoles}); err != nil {
+		t.Errorf("CheckPermission of the caller's own account: %v", err)
+	}
+	if _, err := client.CheckPermission(owner, &pb.CheckPermissionRequest{AccountId: other.Account.Id, Permission: PermissionManageRoles}); status.Code(err) != codes.PermissionDenied {
+		t.Errorf("CheckPermission of another account error = %v, want PermissionDenied", err)
+	}
+	if _, err := client.ListRoles(owner, &pb.ListRolesRequest{AccountId: other.Account.Id}); status.Code(err) != codes.PermissionDenied {
+		t.Errorf("ListRoles of another account error = %v, want PermissionDenied", err)
+	}
+	if _, err := client.CheckPermission(asAdmin(t, svc), &pb.CheckPermissionRequest{AccountId: other.Account.Id, Permission: PermissionManageRoles}); err != nil {
+		t.Errorf("CheckPermission of another account by an admin: %v", err)
+	}
+}
+
+func TestAPIKeys(t *testing.T) {
+	client, svc := startService(t)
+
+	posted, _ := client.PostAccount(context.Background(), &pb.PostAccountRequest{Name: "partner", Email: "partner@example.com"})
+	accountID := posted.Account.Id
+
+	ctx := withAPIKey(t, svc, accountID, "account:api_keys:*")
+
+	created, err := client.CreateAPIKey(ctx, &pb.CreateAPIKeyRequest{AccountId: accountID, Name: "warehouse sync", Scopes: []string{"order:orders:read"}})
+	if err != nil {
+		t.Fatalf("CreateAPIKey: %v", err)
+	}
+	if !strings.HasPrefix(created.Key, "ak_"+created.ApiKey.Prefix+"_") {
+		t.Errorf("CreateAPIKey key = %q, want it to start with ak_%s_", created.Key, created.ApiKey.Prefix)
+	}
+
+	authenticated, err := client.AuthenticateAPIKey(ctx, &pb.AuthenticateAPIKeyRequest{Key: created.Key})
+	if err != nil {
+		t.Fatalf("AuthenticateAPIKey: %v", err)
+	}
+	if authenticated.ApiKey.AccountId != accountID || len(authenticated.ApiKey.LastUsedAt) == 0 || len(authenticated.Permissions) != 0 {
+		t.Errorf("AuthenticateAPIKey = %v, want account %d with last_used_at set and no permissions", authenticated, accountID)
+	}
+
+	for _, key := range []string{created.Key + "x", "ak_" + created.ApiKey.Prefix + "_guess", "not a key"} {
+		if _, err := client.AuthenticateAPIKey(ctx, &pb.AuthenticateAPIKeyRequest{Key: key}); status.Code(err) != codes.Unauthenticated {
+			t.Errorf("AuthenticateAPIKey(%q) error = %v, want Unauthenticated", key, err)
+		}
+	}
+
+	if _, err := client.RevokeAPIKey(asAdmin(t, svc), &pb.RevokeAPIKeyRequest{AccountId: accountID + 1, ApiKeyId: created.ApiKey.Id}); status.Code(err) != codes.NotFound {
+		t.Errorf("RevokeAPIKey from another account error = %v, want NotFound", err)
+	}
+	if _, err := client.RevokeAPIKey(ctx, &pb.RevokeAPIKeyRequest{AccountId: accountID, ApiKeyId: created.ApiKey.Id}); err != nil {
+		t.Fatalf("RevokeAPIKey: %v", err)
+	}
+	if _, err := client.AuthenticateAPIKey(ctx, &pb.AuthenticateAPIKeyRequest{Key: created.Key}); status.Code(err) != codes.Unauthenticated {
+		t.Errorf("AuthenticateAPIKey with a revoked key error = %v, want Unauthenticated", err)
+	}
+
+	list, err := client.ListAPIKeys(ctx, &pb.ListAPIKeysRequest{AccountId: accountID})
+	if err != nil {
+		t.Fatalf("ListAPIKeys: %v", err)
+	}
+	if len(list.ApiKeys) != 2 || len(list.ApiKeys[1].RevokedAt) == 0 {
+		t.Errorf("ListAPIKeys = %v, want the caller's key and the revoked one", list.ApiKeys)
+	}
+}
+
+func TestAPIKeyRPCsNeedTheOwnerOrAnAdmin(t *testing.T) {
+	client, svc := startService(t)
+
+	owner, _ := client.PostAccount(context.Background(), &pb.PostAccountRequest{Name: "partner", Email: "partner@example.com"})
+	other, _ := client.PostAccount(context.Background(), &pb.PostAccountRequest{Name: "other", Email: "other@example.com"})
+
+	create := &pb.CreateAPIKeyRequest{AccountId: owner.Account.Id, Name: "sync", Scopes: []string{"order:orders:read"}}
+
+	for _, tt := range []struct {
+		name string
+		ctx  context.Context
+		code codes.Code
+	}{
+		{"anonymous", context.Background(), codes.Unauthenticated},
+		{"another account", withAPIKey(t, svc, other.Account.Id, "account:api_keys:*"), codes.PermissionDenied},
+		{"owner", withAPIKey(t, svc, owner.Account.Id, "account:api_keys:*"), codes.OK},
+		{"admin", asAdmin(t, svc), codes.OK},
+	} {
+		if _, err := client.CreateAPIKey(tt.ctx, create); status.Code(err) != tt.code {
+			t.Errorf("CreateAPIKey as %s error = %v, want %v", tt.name, err, tt.code)
+		}
+		if _, err := client.ListAPIKeys(tt.ctx, &pb.ListAPIKeysRequest{AccountId: owner.Account.Id}); status.Code(err) != tt.code {
+			t.Errorf("ListAPIKeys as %s error = %v, want %v", tt.name, err, tt.code)
+		}
+	}
+
+	// an admin key only manages other accounts' keys if it is scoped for it
+	narrowAdmin, err := svc.PostAccount(context.Background(), "ops", "")
+	if err != nil {
+		t.Fatalf("PostAccount: %v", err)
+	}
+	if _, err := svc.AssignRole(context.Background(), narrowAdmin.ID, "admin"); err != nil {
+		t.Fatalf("AssignRole: %v", err)
+	}
+
+	ctx := withAPIKey(t, svc, narrowAdmin.ID, "account:api_keys:write")
+	if _, err := client.CreateAPIKey(ctx, create); status.Code(err) != codes.PermissionDenied {
+		t.Errorf("CreateAPIKey with an admin's key not scoped for %s error = %v, want PermissionDenied", PermissionManageAPIKeys, err)
+	}
+}
+
+func TestAPIKeyAuthentication(t *testing.T) {
+	client, svc := startService(t)
+
+	posted, _ := client.PostAccount(context.Background(), &pb.PostAccountRequest{Name: "partner", Email: "partner@example.com"})
+	id := posted.Account.Id
+
+	revoked, key, err := svc.CreateAPIKey(context.Background(), id, "old", []string{"account:accounts:read"}, time.Time{})
+	if err != nil {
+		t.Fatalf("CreateAPIKey: %v", err)
+	}
+	if err := svc.RevokeAPIKey(context.Background(), id, revoked.ID); err != nil {
+		t.Fatalf("RevokeAPIKey: %v", err)
+	}
+
+	for _, tt := range []struct {
+		name string
+		ctx  context.Context
+		code codes.Code
+	}{
+		{"no key", context.Background(), codes.OK},
+		{"scoped key", withAPIKey(t, svc, id, "account:accounts:read"), codes.OK},
+		{"wildcard key", withAPIKey(t, svc, id, "account:*:*"), codes.OK},
+		{"key for another resource", withAPIKey(t, svc, id, "account:addresses:read"), codes.PermissionDenied},
+		{"revoked key", metadata.AppendToOutgoingContext(context.Background(), auth.APIKeyMetadataKey, key), codes.Unauthenticated},
+		{"made-up key", metadata.AppendToOutgoingContext(context.Background(), auth.APIKeyMetadataKey, "ak_guess"), codes.Unauthenticated},
+	} {
+		if _, err := client.GetAccount(tt.ctx, &pb.GetAccountRequest{Id: id}); status.Code(err) != tt.code {
+			t.Errorf("GetAccount with %s error = %v, want %v", tt.name, err, tt.code)
+		}
+	}
+}
+
+func TestCreateAPIKeyChecksScopes(t *testing.T) {
+	client, svc := startService(t)
+
+	posted, _ := client.PostAccount(context.Background(), &pb.PostAccountRequest{Name: "partner", Email: "partner@example.com"})
+	accountID := posted.Account.Id
+
+	ctx := withAPIKey(t, svc, accountID, "account:api_keys:write")
+
+	past, _ := time.Now().Add(-time.Hour).MarshalBinary()
+
+	for _, r := range []*pb.CreateAPIKeyRequest{
+		{AccountId: accountID, Scopes: []string{"order:orders:read"}},
+		{AccountId: accountID, Name: "sync"},
+		{AccountId: accountID, Name: "sync", Scopes: []string{"orders"}},
+		{AccountId: accountID, Name: "sync", Scopes: []string{"order:orders:read"}, ExpiresAt: past},
+	} {
+		if _, err := client.CreateAPIKey(ctx, r); status.Code(err) != codes.InvalidArgument {
+			t.Errorf("CreateAPIKey(%v) error = %v, want InvalidArgument", r, err)
+		}
+	}
+
+	privileged := &pb.CreateAPIKeyRequest{AccountId: accountID, Name: "catalog import", Scopes: []string{PermissionWriteProducts}}
+
+	everything := &pb.CreateAPIKeyRequest{AccountId: accountID, Name: "everything", Scopes: []string{"*:*:*"}}
+
+	if _, err := client.CreateAPIKey(ctx, privileged); status.Code(err) != codes.PermissionDenied {
+		t.Errorf("CreateAPIKey with a scope the account lacks error = %v, want PermissionDenied", err)
+	}
+
+	customer, err := client.CreateAPIKey(ctx, everything)
+	if err != nil {
+		t.Fatalf("CreateAPIKey with a wildcard: %v", err)
+	}
+
+	authenticated, err := client.AuthenticateAPIKey(context.Background(), &pb.AuthenticateAPIKeyRequest{Key: customer.Key})
+	if err != nil {
+		t.Fatalf("AuthenticateAPIKey: %v", err)
+	}
+	if len(authenticated.Permissions) != 0 {
+		t.Errorf("AuthenticateAPIKey permissions of a customer's wildcard key = %v, want none", authenticated.Permissions)
+	}
+
+	if _, err := svc.AssignRole(context.Background(), accountID, "admin"); err != nil {
+		t.Fatalf("AssignRole: %v", err)
+	}
+	if _, err := client.CreateAPIKey(ctx, privileged); err != nil {
+		t.Errorf("CreateAPIKey with a scope the account has: %v", err)
+	}
+
+	authenticated, err = client.AuthenticateAPIKey(context.Background(), &pb.AuthenticateAPIKeyRequest{Key: customer.Key})
+	if err != nil {
+		t.Fatalf("AuthenticateAPIKey: %v", err)
+	}
+	if !slices.Contains(authenticated.Permissions, PermissionWriteProducts) || !slices.Contains(authenticated.Permissions, PermissionManageRoles) {
+		t.Errorf("AuthenticateAPIKey permissions = %v, want the admin role's", authenticated.Permissions)
+	}
+}
+
+func TestAccountStatusLifecycle(t *testing.T) {
+	client := startServer(t)
+	ctx := context.Background()
+
+	posted, _ := client.PostAccount(ctx, &pb.PostAccountRequest{Name: "angga", Email: "angga@example.com"})
+	id := posted.Account.Id
+
+	if posted.Account.Status != pb.AccountStatus_ACCOUNT_STATUS_ACTIVE {
+		t.Errorf("PostAccount status = %v, want active", posted.Account.Status)
+	}
+
+	if _, err := client.SuspendAccount(ctx, &pb.SuspendAccountRequest{Id: id}); status.Code(err) != codes.InvalidArgument {
+		t.Errorf("SuspendAccount without a reason error = %v, want InvalidArgument", err)
+	}
+	if _, err := client.ReactivateAccount(ctx, &pb.ReactivateAccountRequest{Id: id}); status.Code(err) != codes.FailedPrecondition {
+		t.Errorf("ReactivateAccount of an active account error = %v, want FailedPrecondition", err)
+	}
+
+	suspended, err := client.SuspendAccount(ctx, &pb.SuspendAccountRequest{Id: id, Reason: "chargeback"})
+	if err != nil {
+		t.Fatalf("SuspendAccount: %v", err)
+	}
+	if suspended.Account.Status != pb.AccountStatus_ACCOUNT_STATUS_SUSPENDED || suspended.Account.StatusReason != "chargeback" {
+		t.Errorf("SuspendAccount = %v, want suspended for chargeback", suspended.Account)
+	}
+
+	reactivated, err := client.ReactivateAccount(ctx, &pb.ReactivateAccountRequest{Id: id})
+	if err != nil {
+		t.Fatalf("ReactivateAccount: %v", err)
+	}
+	if reactivated.Account.Status != pb.AccountStatus_ACCOUNT_STATUS_ACTIVE {
+		t.Errorf("ReactivateAccount status = %v, want active", reactivated.Account.Status)
+	}
+
+	if _, err := client.CloseAccount(ctx, &pb.CloseAccountRequest{Id: id, Reason: "asked to"}); err != nil {
+		t.Fatalf("CloseAccount: %v", err)
+	}
+
+	got, _ := client.GetAccount(ctx, &pb.GetAccountRequest{Id: id})
+	if got.Account.Status != pb.AccountStatus_ACCOUNT_STATUS_CLOSED {
+		t.Errorf("GetAccount status after CloseAccount = %v, want closed", got.Account.Status)
+	}
+
+	if _, err := client.ReactivateAccount(ctx, &pb.ReactivateAccountRequest{Id: id}); status.Code(err) != codes.FailedPrecondition {
+		t.Errorf("ReactivateAccount of a closed account error = %v, want FailedPrecondition", err)
+	}
+	if _, err := client.SuspendAccount(ctx, &pb.SuspendAccountRequest{Id: 42, Reason: "fraud"}); status.Code(err) != codes.NotFound {
+		t.Errorf("SuspendAccount of a missing account error = %v, want NotFound", err)
+	}
+}
+
+func TestAuditLog(t *testing.T) {
+	client, svc := startService(t)
+
+	admin, err := svc.PostAccount(context.Background(), "support", "")
+	if err != nil {
+		t.Fatalf("PostAccount: %v", err)
+	}
+	key, err := issueAdminKey(context.Background(), svc, admin.ID)
+	if err != nil {
+		t.Fatalf("issueAdminKey: %v", err)
+	}
+
+	// x-actor is ignored: calls are attributed to the key's account
+	ctx := metadata.AppendToOutgoingContext(context.Background(), auth.APIKeyMetadataKey, key, "x-actor", "spoofed", "x-request-id", "req-1")
+
+	posted, err := client.PostAccount(context.Background(), &pb.PostAccountRequest{Name: "angga", Email: "angga@example.com"})
+	if err != nil {
+		t.Fatalf("PostAccount: %v", err)
+	}
+	id := strconv.Itoa(int(posted.Account.Id))
+
+	if _, err := client.SuspendAccount(ctx, &pb.SuspendAccountRequest{Id: posted.Account.Id, Reason: "chargeback"}); err != nil {
+		t.Fatalf("SuspendAccount: %v", err)
+	}
+
+	// failed calls change nothing, so they aren't audited
+	if _, err := client.SuspendAccount(ctx, &pb.SuspendAccountRequest{Id: posted.Account.Id, Reason: "again"}); err == nil {
+		t.Fatal("SuspendAccount of a suspended account succeeded")
+	}
+
+	created, err := client.CreateAPIKey(withAPIKey(t, svc, posted.Account.Id, "account:api_keys:write"), &pb.CreateAPIKeyRequest{AccountId: posted.Account.Id, Name: "sync", Scopes: []string{"order:orders:read"}})
+	if err != nil {
+		t.Fatalf("CreateAPIKey: %v", err)
+	}
+
+	res, err := client.QueryAuditLog(ctx, &pb.QueryAccountAuditLogRequest{EntityType: "account", EntityId: id})
+	if err != nil {
+		t.Fatalf("QueryAuditLog: %v", err)
+	}
+
+	if len(res.Entries) != 2 {
+		t.Fatalf("QueryAuditLog returned %d entries, want 2: %v", len(res.Entries), res.Entries)
+	}
+
+	suspended, postedEntry := res.Entries[0], res.Entries[1]
+
+	adminActor := "account:" + strconv.Itoa(int(admin.ID))
+
+	if suspended.Action != "SuspendAccount" || suspended.Actor != adminActor || suspended.RequestId != "req-1" {
+		t.Errorf("newest entry = %v, want SuspendAccount by %s in req-1", suspended, adminActor)
+	}
+	if !strings.Contains(suspended.Before, "ACCOUNT_STATUS_ACTIVE") || !strings.Contains(suspended.After, "ACCOUNT_STATUS_SUSPENDED") {
+		t.Errorf("SuspendAccount entry before = %s, after = %s, want active then suspended", suspended.Before, suspended.After)
+	}
+	if postedEntry.Action != "PostAccount" || postedEntry.Actor != audit.AnonymousActor || postedEntry.Before != "" || postedEntry.After == "" {
+		t.Errorf("oldest entry = %v, want PostAccount by anonymous with only an after", postedEntry)
+	}
+
+	byOwner, err := client.QueryAuditLog(ctx, &pb.QueryAccountAuditLogRequest{Actor: "account:" + id})
+	if err != nil {
+		t.Fatalf("QueryAuditLog by actor: %v", err)
+	}
+	if len(byOwner.Entries) != 1 || byOwner.Entries[0].Action != "CreateAPIKey" {
+		t.Fatalf("QueryAuditLog by account:%s = %v, want the CreateAPIKey entry", id, byOwner.Entries)
+	}
+	if strings.Contains(byOwner.Entries[0].After, created.Key) {
+		t.Errorf("CreateAPIKey entry %s holds the key's secret", byOwner.Entries[0].After)
+	}
+
+	future, _ := time.Now().Add(time.Hour).MarshalBinary()
+	later, err := client.QueryAuditLog(ctx, &pb.QueryAccountAuditLogRequest{From: future})
+	if err != nil {
+		t.Fatalf("QueryAuditLog from the future: %v", err)
+	}
+	if len(later.Entries) != 0 {
+		t.Errorf("QueryAuditLog from an hour ahead = %v, want none", later.Entries)
+	}
+
+	if _, err := client.QueryAuditLog(context.Background(), &pb.QueryAccountAuditLogRequest{}); status.Code(err) != codes.Unauthenticated {
+		t.Errorf("QueryAuditLog without a key error = %v, want Unauthenticated", err)
+	}
+	if _, err := client.QueryAuditLog(withAPIKey(t, svc, posted.Account.Id, "*:*:*"), &pb.QueryAccountAuditLogRequest{}); status.Code(err) != codes.PermissionDenied {
+		t.Errorf("QueryAuditLog by a customer error = %v, want PermissionDenied", err)
+	}
+}
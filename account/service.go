@@ -2,39 +2,415 @@ package main
 
 import (
 	"context"
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"net/mail"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/airlangga-hub/microservices/audit"
+	"github.com/airlangga-hub/microservices/auth"
+)
+
+var (
+	ErrAccountNotFound = errors.New("account not found")
+	ErrEmailTaken      = errors.New("email is already in use")
+	ErrInvalidEmail    = errors.New("invalid email")
+	ErrNoEmail         = errors.New("account has no email")
+	ErrEmailVerified   = errors.New("email is already verified")
+
+	ErrInvalidStatusTransition = errors.New("invalid account status change")
+	ErrStatusReasonRequired    = errors.New("a reason is required to suspend or close an account")
 )
 
+// AccountStatus is where an account is in its lifecycle. Active accounts
+// can be suspended and reactivated; either can be closed, which is final.
+type AccountStatus string
+
+const (
+	AccountActive    AccountStatus = "active"
+	AccountSuspended AccountStatus = "suspended"
+	AccountClosed    AccountStatus = "closed"
+)
+
+// Account's Email is unique, ignoring case. It is empty for accounts created
+// before emails were required. StatusReason is why the account got its
+// Status, empty for an account that was never suspended or closed.
 type Account struct {
-	ID   int32  `json:"id"`
-	Name string `json:"name"`
+	ID            int32         `json:"id"`
+	Name          string        `json:"name"`
+	Email         string        `json:"email"`
+	EmailVerified bool          `json:"email_verified"`
+	Status        AccountStatus `json:"status"`
+	StatusReason  string        `json:"status_reason"`
 }
 
 type Service interface {
-	PostAccount(ctx context.Context, name string) (Account, error)
+	PostAccount(ctx context.Context, name, email string) (Account, error)
 	GetAccount(ctx context.Context, id int32) (Account, error)
+	GetAccountByEmail(ctx context.Context, email string) (Account, error)
 	GetAccounts(ctx context.Context, offset, limit int32) ([]Account, error)
+	AddAddress(ctx context.Context, accountID int32, a Address) (Address, error)
+	ListAddresses(ctx context.Context, accountID int32) ([]Address, error)
+	UpdateAddress(ctx context.Context, accountID int32, a Address) (Address, error)
+	DeleteAddress(ctx context.Context, accountID, addressID int32) error
+	SetDefaultAddress(ctx context.Context, accountID, addressID int32) (Address, error)
+	RequestEmailVerification(ctx context.Context, accountID int32) error
+	VerifyEmail(ctx context.Context, token string) (Account, error)
+	ListRoles(ctx context.Context, accountID int32) ([]Role, error)
+	AssignRole(ctx context.Context, accountID int32, role string) ([]Role, error)
+	RevokeRole(ctx context.Context, accountID int32, role string) ([]Role, error)
+	CheckPermission(ctx context.Context, accountID int32, permission string) (bool, error)
+	CreateAPIKey(ctx context.Context, accountID int32, name string, scopes []string, expiresAt time.Time) (APIKey, string, error)
+	ListAPIKeys(ctx context.Context, accountID int32) ([]APIKey, error)
+	RevokeAPIKey(ctx context.Context, accountID, id int32) error
+	AuthenticateAPIKey(ctx context.Context, key string) (APIKey, []string, error)
+	SuspendAccount(ctx context.Context, id int32, reason string) (Account, error)
+	ReactivateAccount(ctx context.Context, id int32, reason string) (Account, error)
+	CloseAccount(ctx context.Context, id int32, reason string) (Account, error)
+	QueryAuditLog(ctx context.Context, q audit.Query) ([]audit.Entry, error)
 }
 
 type service struct {
-	repository Repository
+	repository      Repository
+	mailer          Mailer
+	verificationTTL time.Duration
 }
 
-func NewService(r Repository) Service {
-	return &service{r}
+// NewService mails verification tokens through mailer; they expire after
+// verificationTTL.
+func NewService(r Repository, mailer Mailer, verificationTTL time.Duration) Service {
+	return &service{repository: r, mailer: mailer, verificationTTL: verificationTTL}
 }
 
-func (s *service) PostAccount(ctx context.Context, name string) (Account, error) {
-	return s.repository.CreateAccount(ctx, Account{Name: name})
+// PostAccount returns ErrEmailTaken if another account has email in any
+// case. The email starts out unverified. An account may be created without
+// one; only a given email is validated.
+func (s *service) PostAccount(ctx context.Context, name, email string) (Account, error) {
+	email = strings.TrimSpace(email)
+
+	if email != "" {
+		var err error
+		if email, err = normalizeEmail(email); err != nil {
+			return Account{}, err
+		}
+	}
+
+	return s.repository.CreateAccount(ctx, Account{Name: name, Email: email, Status: AccountActive})
 }
 
 func (s *service) GetAccount(ctx context.Context, id int32) (Account, error) {
 	return s.repository.GetAccountByID(ctx, id)
 }
 
+func (s *service) GetAccountByEmail(ctx context.Context, email string) (Account, error) {
+	return s.repository.GetAccountByEmail(ctx, strings.TrimSpace(email))
+}
+
+// normalizeEmail accepts a bare address, like a@example.com, and trims it.
+// The case is kept as entered; comparisons ignore it.
+func normalizeEmail(email string) (string, error) {
+	email = strings.TrimSpace(email)
+
+	parsed, err := mail.ParseAddress(email)
+	if err != nil || parsed.Name != "" || parsed.Address != email {
+		return "", fmt.Errorf("%w: %q", ErrInvalidEmail, email)
+	}
+
+	return email, nil
+}
+
 func (s *service) GetAccounts(ctx context.Context, offset, limit int32) ([]Account, error) {
 	if limit > 100 || (offset == 0 && limit == 0) {
 		limit = 100
 	}
-	
+
 	return s.repository.ListAccounts(ctx, offset, limit)
 }
+
+// AddAddress adds a to the account's address book. An account's first
+// address becomes its default.
+func (s *service) AddAddress(ctx context.Context, accountID int32, a Address) (Address, error) {
+	a, err := a.normalize()
+	if err != nil {
+		return Address{}, err
+	}
+
+	if _, err := s.repository.GetAccountByID(ctx, accountID); err != nil {
+		return Address{}, err
+	}
+
+	a.AccountID = accountID
+
+	return s.repository.CreateAddress(ctx, a)
+}
+
+func (s *service) ListAddresses(ctx context.Context, accountID int32) ([]Address, error) {
+	return s.repository.ListAddresses(ctx, accountID)
+}
+
+func (s *service) UpdateAddress(ctx context.Context, accountID int32, a Address) (Address, error) {
+	a, err := a.normalize()
+	if err != nil {
+		return Address{}, err
+	}
+
+	a.AccountID = accountID
+
+	return s.repository.UpdateAddress(ctx, a)
+}
+
+// DeleteAddress leaves the account without a default if a was it.
+func (s *service) DeleteAddress(ctx context.Context, accountID, addressID int32) error {
+	return s.repository.DeleteAddress(ctx, accountID, addressID)
+}
+
+func (s *service) SetDefaultAddress(ctx context.Context, accountID, addressID int32) (Address, error) {
+	return s.repository.SetDefaultAddress(ctx, accountID, addressID)
+}
+
+// RequestEmailVerification mails the account a new verification token. Any
+// token mailed before it stops working.
+func (s *service) RequestEmailVerification(ctx context.Context, accountID int32) error {
+	account, err := s.repository.GetAccountByID(ctx, accountID)
+	if err != nil {
+		return err
+	}
+
+	switch {
+	case account.Email == "":
+		return ErrNoEmail
+	case account.EmailVerified:
+		return ErrEmailVerified
+	}
+
+	token, hash, err := newToken()
+	if err != nil {
+		return err
+	}
+
+	err = s.repository.CreateToken(ctx, Token{
+		AccountID: accountID,
+		Purpose:   TokenEmailVerification,
+		Hash:      hash,
+		ExpiresAt: time.Now().Add(s.verificationTTL),
+	})
+	if err != nil {
+		return err
+	}
+
+	body := fmt.Sprintf(
+		"Hi %s,\n\nUse this token to verify your email address:\n\n%s\n\nIt expires in %s. If you didn't ask for it, ignore this email.\n",
+		account.Name, token, s.verificationTTL,
+	)
+
+	return s.mailer.Send(ctx, account.Email, "Verify your email address", body)
+}
+
+// VerifyEmail uses up token and marks its account's email verified. A token
+// that is unknown, used or expired is ErrInvalidToken.
+func (s *service) VerifyEmail(ctx context.Context, token string) (Account, error) {
+	return s.repository.VerifyEmail(ctx, hashToken(strings.TrimSpace(token)), time.Now())
+}
+
+// ListRoles returns the account's roles, or every role there is when
+// accountID is 0.
+func (s *service) ListRoles(ctx context.Context, accountID int32) ([]Role, error) {
+	if accountID == 0 {
+		return s.repository.ListRoles(ctx)
+	}
+
+	if _, err := s.repository.GetAccountByID(ctx, accountID); err != nil {
+		return nil, err
+	}
+
+	return s.repository.ListAccountRoles(ctx, accountID)
+}
+
+// AssignRole and RevokeRole return the account's roles after the change.
+func (s *service) AssignRole(ctx context.Context, accountID int32, role string) ([]Role, error) {
+	if _, err := s.repository.GetAccountByID(ctx, accountID); err != nil {
+		return nil, err
+	}
+
+	if err := s.repository.AssignRole(ctx, accountID, strings.TrimSpace(role)); err != nil {
+		return nil, err
+	}
+
+	return s.repository.ListAccountRoles(ctx, accountID)
+}
+
+func (s *service) RevokeRole(ctx context.Context, accountID int32, role string) ([]Role, error) {
+	if err := s.repository.RevokeRole(ctx, accountID, strings.TrimSpace(role)); err != nil {
+		return nil, err
+	}
+
+	return s.repository.ListAccountRoles(ctx, accountID)
+}
+
+// CheckPermission reports whether one of the account's roles grants
+// permission. An unknown account has none.
+func (s *service) CheckPermission(ctx context.Context, accountID int32, permission string) (bool, error) {
+	roles, err := s.repository.ListAccountRoles(ctx, accountID)
+	if err != nil {
+		return false, err
+	}
+
+	return hasPermission(roles, permission), nil
+}
+
+// CreateAPIKey returns the key with the secret to hand out, which isn't
+// stored and can't be read back. A scope that is also a role permission,
+// like catalog:products:write, needs the account to hold that permission.
+// Wildcard scopes don't: a key only ever has the permissions its account
+// holds.
+func (s *service) CreateAPIKey(ctx context.Context, accountID int32, name string, scopes []string, expiresAt time.Time) (APIKey, string, error) {
+	name = strings.TrimSpace(name)
+	if name == "" {
+		return APIKey{}, "", fmt.Errorf("%w: name is required", ErrInvalidAPIKey)
+	}
+	if err := validateScopes(scopes); err != nil {
+		return APIKey{}, "", err
+	}
+	if !expiresAt.IsZero() && !expiresAt.After(time.Now()) {
+		return APIKey{}, "", fmt.Errorf("%w: expires_at is in the past", ErrInvalidAPIKey)
+	}
+
+	if _, err := s.repository.GetAccountByID(ctx, accountID); err != nil {
+		return APIKey{}, "", err
+	}
+
+	allRoles, err := s.repository.ListRoles(ctx)
+	if err != nil {
+		return APIKey{}, "", err
+	}
+
+	accountRoles, err := s.repository.ListAccountRoles(ctx, accountID)
+	if err != nil {
+		return APIKey{}, "", err
+	}
+
+	for _, scope := range scopes {
+		if hasPermission(allRoles, scope) && !hasPermission(accountRoles, scope) {
+			return APIKey{}, "", fmt.Errorf("%w: %s", ErrScopeNotPermitted, scope)
+		}
+	}
+
+	key, prefix, hash, err := newAPIKey()
+	if err != nil {
+		return APIKey{}, "", err
+	}
+
+	k, err := s.repository.CreateAPIKey(ctx, APIKey{
+		AccountID: accountID,
+		Name:      name,
+		Prefix:    prefix,
+		Hash:      hash,
+		Scopes:    scopes,
+		ExpiresAt: expiresAt,
+	})
+	if err != nil {
+		return APIKey{}, "", err
+	}
+
+	return k, key, nil
+}
+
+func (s *service) ListAPIKeys(ctx context.Context, accountID int32) ([]APIKey, error) {
+	return s.repository.ListAPIKeys(ctx, accountID)
+}
+
+func (s *service) RevokeAPIKey(ctx context.Context, accountID, id int32) error {
+	return s.repository.RevokeAPIKey(ctx, accountID, id, time.Now())
+}
+
+// AuthenticateAPIKey returns the active key matching key, and the
+// permissions of its account's roles that the key's scopes cover, and
+// records that it was used. Anything else is ErrUnknownAPIKey, so callers
+// can't tell a revoked key from a made-up one.
+func (s *service) AuthenticateAPIKey(ctx context.Context, key string) (APIKey, []string, error) {
+	prefix, err := parseAPIKeyPrefix(strings.TrimSpace(key))
+	if err != nil {
+		return APIKey{}, nil, err
+	}
+
+	k, err := s.repository.GetAPIKeyByPrefix(ctx, prefix)
+	if errors.Is(err, ErrAPIKeyNotFound) {
+		return APIKey{}, nil, ErrUnknownAPIKey
+	}
+	if err != nil {
+		return APIKey{}, nil, err
+	}
+
+	now := time.Now()
+
+	if subtle.ConstantTimeCompare([]byte(k.Hash), []byte(hashToken(strings.TrimSpace(key)))) != 1 || !k.Active(now) {
+		return APIKey{}, nil, ErrUnknownAPIKey
+	}
+
+	roles, err := s.repository.ListAccountRoles(ctx, k.AccountID)
+	if err != nil {
+		return APIKey{}, nil, err
+	}
+
+	permissions := []string{}
+
+	for _, permission := range rolePermissions(roles) {
+		if auth.HasScope(k.Scopes, permission) {
+			permissions = append(permissions, permission)
+		}
+	}
+
+	if err := s.repository.TouchAPIKey(ctx, k.ID, now); err != nil {
+		return APIKey{}, nil, err
+	}
+
+	k.LastUsedAt = now
+
+	return k, permissions, nil
+}
+
+// SuspendAccount stops an active account from placing orders until it is
+// reactivated. Suspending and closing need a reason.
+func (s *service) SuspendAccount(ctx context.Context, id int32, reason string) (Account, error) {
+	return s.changeStatus(ctx, id, AccountSuspended, reason, AccountActive)
+}
+
+func (s *service) ReactivateAccount(ctx context.Context, id int32, reason string) (Account, error) {
+	return s.changeStatus(ctx, id, AccountActive, reason, AccountSuspended)
+}
+
+// CloseAccount keeps the account and its history, but it can't be used or
+// reactivated again.
+func (s *service) CloseAccount(ctx context.Context, id int32, reason string) (Account, error) {
+	return s.changeStatus(ctx, id, AccountClosed, reason, AccountActive, AccountSuspended)
+}
+
+// changeStatus moves the account to status to if it is in one of from.
+func (s *service) changeStatus(ctx context.Context, id int32, to AccountStatus, reason string, from ...AccountStatus) (Account, error) {
+	reason = strings.TrimSpace(reason)
+	if reason == "" && to != AccountActive {
+		return Account{}, ErrStatusReasonRequired
+	}
+
+	account, err := s.repository.GetAccountByID(ctx, id)
+	if err != nil {
+		return Account{}, err
+	}
+
+	if !slices.Contains(from, account.Status) {
+		return Account{}, fmt.Errorf("%w: account %d is %s", ErrInvalidStatusTransition, id, account.Status)
+	}
+
+	return s.repository.UpdateAccountStatus(ctx, id, account.Status, to, reason)
+}
+
+func (s *service) QueryAuditLog(ctx context.Context, q audit.Query) ([]audit.Entry, error) {
+	if q.Limit > 100 || (q.Offset == 0 && q.Limit == 0) {
+		q.Limit = 100
+	}
+
+	return s.repository.QueryAuditLog(ctx, q)
+}
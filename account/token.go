@@ -0,0 +1,92 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"sync"
+	"time"
+)
+
+// TokenEmailVerification is the purpose of the tokens VerifyEmail takes.
+const TokenEmailVerification = "email_verification"
+
+var ErrInvalidToken = errors.New("token is invalid, used or expired")
+
+// Token is a single-use secret mailed to an account's owner. Only its hash
+// is stored, so a database leak doesn't hand out working tokens.
+type Token struct {
+	AccountID int32
+	Purpose   string
+	Hash      string
+	ExpiresAt time.Time
+}
+
+// newToken returns a random token to mail and the hash to store.
+func newToken() (token, hash string, err error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", "", err
+	}
+
+	token = base64.RawURLEncoding.EncodeToString(b)
+	return token, hashToken(token), nil
+}
+
+// hashToken doesn't need a salt or a slow hash: tokens are 256 random bits,
+// not passwords.
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// rateLimiter allows limit calls per key in each fixed window. It counts in
+// memory, so every replica enforces the limit on its own.
+type rateLimiter struct {
+	mu      sync.Mutex
+	limit   int
+	window  time.Duration
+	now     func() time.Time
+	windows map[string]rateWindow
+}
+
+type rateWindow struct {
+	start time.Time
+	count int
+}
+
+func newRateLimiter(limit int, window time.Duration) *rateLimiter {
+	return &rateLimiter{limit: limit, window: window, now: time.Now, windows: map[string]rateWindow{}}
+}
+
+func (l *rateLimiter) Allow(key string) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := l.now()
+
+	// forget finished windows now and then, so keys don't pile up
+	if len(l.windows) > 10_000 {
+		for k, w := range l.windows {
+			if now.Sub(w.start) >= l.window {
+				delete(l.windows, k)
+			}
+		}
+	}
+
+	w := l.windows[key]
+	if now.Sub(w.start) >= l.window {
+		w = rateWindow{start: now}
+	}
+
+	if w.count >= l.limit {
+		return false
+	}
+
+	w.count++
+	l.windows[key] = w
+
+	return true
+}
@@ -0,0 +1,234 @@
+// Package audit records successful mutating gRPC calls as an append-only
+// trail of who changed what. Each service lists its mutating RPCs as Specs
+// and stores entries in its own repository; the entry format and the
+// interceptor that writes them are shared.
+package audit
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"path"
+	"strconv"
+	"time"
+
+	"github.com/airlangga-hub/microservices/auth"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+)
+
+// Entry records one successful mutating call: who made it, what it changed,
+// and the entity as it was before and after, as JSON. Before is empty for a
+// created entity and After for a deleted one.
+type Entry struct {
+	ID         string          `json:"id"`
+	Actor      string          `json:"actor"`
+	Action     string          `json:"action"`
+	EntityType string          `json:"entity_type"`
+	EntityID   string          `json:"entity_id"`
+	Before     json.RawMessage `json:"before"`
+	After      json.RawMessage `json:"after"`
+	RequestID  string          `json:"request_id"`
+	OccurredAt time.Time       `json:"occurred_at"`
+}
+
+// Query filters the audit log. Empty fields and zero times match
+// everything; From is inclusive and To exclusive.
+type Query struct {
+	EntityType string
+	EntityID   string
+	Actor      string
+	From       time.Time
+	To         time.Time
+	Offset     int32
+	Limit      int32
+}
+
+// Matches reports whether e passes q's filters, ignoring Offset and Limit.
+func (q Query) Matches(e Entry) bool {
+	return (q.EntityType == "" || e.EntityType == q.EntityType) &&
+		(q.EntityID == "" || e.EntityID == q.EntityID) &&
+		(q.Actor == "" || e.Actor == q.Actor) &&
+		(q.From.IsZero() || !e.OccurredAt.Before(q.From)) &&
+		(q.To.IsZero() || e.OccurredAt.Before(q.To))
+}
+
+// Recorder appends entries to a service's audit log.
+type Recorder interface {
+	RecordAudit(ctx context.Context, e Entry) error
+}
+
+// RequestIDMetadataKey is the metadata a call's request ID is read from; a
+// call without one is logged under its entry's ID. Calls are attributed to
+// the account whose API key authenticated them, or to AnonymousActor.
+const (
+	RequestIDMetadataKey = "x-request-id"
+	AnonymousActor       = "anonymous"
+)
+
+// Spec says how to audit one RPC. before loads the entity the call is about
+// to change, returning nil if there is none; after picks it out of the
+// response.
+type Spec struct {
+	entity string
+	id     func(req, resp any) string
+	before func(ctx context.Context, req any) (proto.Message, error)
+	after  func(ctx context.Context, req, resp any) (proto.Message, error)
+}
+
+// Audited builds a Spec from typed functions; before and after may be nil.
+func Audited[Req, Resp any](
+	entity string,
+	id func(Req, Resp) string,
+	before func(context.Context, Req) (proto.Message, error),
+	after func(context.Context, Req, Resp) (proto.Message, error),
+) Spec {
+	spec := Spec{
+		entity: entity,
+		id:     func(req, resp any) string { return id(req.(Req), resp.(Resp)) },
+	}
+
+	if before != nil {
+		spec.before = func(ctx context.Context, req any) (proto.Message, error) { return before(ctx, req.(Req)) }
+	}
+	if after != nil {
+		spec.after = func(ctx context.Context, req, resp any) (proto.Message, error) {
+			return after(ctx, req.(Req), resp.(Resp))
+		}
+	}
+
+	return spec
+}
+
+// writeFailures counts calls that took effect without an audit entry. It
+// should always be zero; alert on any increase.
+var writeFailures = promauto.NewCounterVec(
+	prometheus.CounterOpts{
+		Name: "audit_write_failures_total",
+		Help: "Successful mutating calls whose audit entry couldn't be written, by service and method.",
+	},
+	[]string{"service", "method"},
+)
+
+// Interceptor writes an Entry to rec for every successful call to a method
+// in specs, keyed by full method name. service names the service in logs
+// and metrics.
+//
+// If the before state can't be read the call is refused, since nothing has
+// changed yet. The entry is written after the change commits, so if it
+// can't be written the change has already taken effect: the call still
+// returns its result, so the caller doesn't retry it, and the missing entry
+// is logged and counted in audit_write_failures_total to alert on.
+func Interceptor(service string, rec Recorder, specs map[string]Spec) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+		spec, exist := specs[info.FullMethod]
+		if !exist {
+			return handler(ctx, req)
+		}
+
+		var before proto.Message
+		if spec.before != nil {
+			var err error
+			if before, err = spec.before(ctx, req); err != nil {
+				return nil, err
+			}
+		}
+
+		resp, err := handler(ctx, req)
+		if err != nil {
+			return resp, err
+		}
+
+		unaudited := func(step string, err error) (any, error) {
+			log.Printf("ERROR: %s audit %s (%s): %v", service, info.FullMethod, step, err)
+			writeFailures.WithLabelValues(service, info.FullMethod).Inc()
+			return resp, nil
+		}
+
+		var after proto.Message
+		if spec.after != nil {
+			if after, err = spec.after(context.WithoutCancel(ctx), req, resp); err != nil {
+				return unaudited("after", err)
+			}
+		}
+
+		entry, err := NewEntry(ctx, path.Base(info.FullMethod), spec.entity, spec.id(req, resp), before, after)
+		if err != nil {
+			return unaudited("NewEntry", err)
+		}
+
+		if err := rec.RecordAudit(context.WithoutCancel(ctx), entry); err != nil {
+			return unaudited("RecordAudit", fmt.Errorf("%w, entry: %s", err, entryJSON(entry)))
+		}
+
+		return resp, nil
+	}
+}
+
+// entryJSON is e as logged when it can't be recorded, so it can be restored
+// from the logs.
+func entryJSON(e Entry) string {
+	b, err := json.Marshal(e)
+	if err != nil {
+		return e.ID
+	}
+	return string(b)
+}
+
+// NewEntry builds an entry for a call made with ctx, taking the actor from
+// its authenticated identity and the request ID from its incoming metadata.
+func NewEntry(ctx context.Context, action, entityType, entityID string, before, after proto.Message) (Entry, error) {
+	id := make([]byte, 16)
+	if _, err := rand.Read(id); err != nil {
+		return Entry{}, err
+	}
+
+	e := Entry{
+		ID:         hex.EncodeToString(id),
+		Actor:      AnonymousActor,
+		Action:     action,
+		EntityType: entityType,
+		EntityID:   entityID,
+		RequestID:  hex.EncodeToString(id),
+		OccurredAt: time.Now().UTC(),
+	}
+
+	if id, ok := auth.FromContext(ctx); ok {
+		e.Actor = id.Actor()
+	}
+
+	md, _ := metadata.FromIncomingContext(ctx)
+	if v := md.Get(RequestIDMetadataKey); len(v) > 0 && v[0] != "" {
+		e.RequestID = v[0]
+	}
+
+	var err error
+	if e.Before, err = marshalState(before); err != nil {
+		return Entry{}, err
+	}
+	if e.After, err = marshalState(after); err != nil {
+		return Entry{}, err
+	}
+
+	return e, nil
+}
+
+func marshalState(m proto.Message) (json.RawMessage, error) {
+	if m == nil {
+		return nil, nil
+	}
+
+	return protojson.Marshal(m)
+}
+
+// IntID formats a numeric entity ID.
+func IntID(id int32) string {
+	return strconv.Itoa(int(id))
+}
@@ -0,0 +1,145 @@
+package audit
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/airlangga-hub/microservices/auth"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/wrapperspb"
+)
+
+type recorder struct {
+	entries []Entry
+	err     error
+}
+
+func (r *recorder) RecordAudit(ctx context.Context, e Entry) error {
+	if r.err != nil {
+		return r.err
+	}
+	r.entries = append(r.entries, e)
+	return nil
+}
+
+const renameMethod = "/test.Service/Rename"
+
+func renameSpecs() map[string]Spec {
+	return map[string]Spec{
+		renameMethod: Audited(
+			"name",
+			func(req *wrapperspb.StringValue, _ *wrapperspb.StringValue) string { return req.Value },
+			func(_ context.Context, req *wrapperspb.StringValue) (proto.Message, error) {
+				return wrapperspb.String("old " + req.Value), nil
+			},
+			func(_ context.Context, _ *wrapperspb.StringValue, resp *wrapperspb.StringValue) (proto.Message, error) {
+				return resp, nil
+			},
+		),
+	}
+}
+
+func TestInterceptor(t *testing.T) {
+	rec := &recorder{}
+	intercept := Interceptor("test", rec, renameSpecs())
+
+	ctx := metadata.NewIncomingContext(context.Background(), metadata.Pairs("x-actor", "spoofed", RequestIDMetadataKey, "req-1"))
+	ctx = auth.NewContext(ctx, auth.Identity{AccountID: 7})
+	info := &grpc.UnaryServerInfo{FullMethod: renameMethod}
+
+	ok := func(ctx context.Context, req any) (any, error) { return wrapperspb.String("new"), nil }
+	failed := func(ctx context.Context, req any) (any, error) { return nil, errors.New("failed") }
+
+	if _, err := intercept(ctx, wrapperspb.String("a"), info, ok); err != nil {
+		t.Fatalf("intercept: %v", err)
+	}
+	if _, err := intercept(ctx, wrapperspb.String("b"), info, failed); err == nil {
+		t.Fatal("intercept of a failing call succeeded")
+	}
+	if _, err := intercept(ctx, wrapperspb.String("c"), &grpc.UnaryServerInfo{FullMethod: "/test.Service/Get"}, ok); err != nil {
+		t.Fatalf("intercept of an unaudited method: %v", err)
+	}
+
+	if len(rec.entries) != 1 {
+		t.Fatalf("recorded %d entries, want only the successful rename: %v", len(rec.entries), rec.entries)
+	}
+
+	e := rec.entries[0]
+	if e.Action != "Rename" || e.EntityType != "name" || e.EntityID != "a" || e.Actor != "account:7" || e.RequestID != "req-1" {
+		t.Errorf("entry = %+v, want Rename of name a by account:7 in req-1", e)
+	}
+	if string(e.Before) != `"old a"` || string(e.After) != `"new"` {
+		t.Errorf("entry before = %s, after = %s, want \"old a\" then \"new\"", e.Before, e.After)
+	}
+}
+
+func TestInterceptorReturnsUnauditedCalls(t *testing.T) {
+	rec := &recorder{err: errors.New("audit log unavailable")}
+	intercept := Interceptor("unaudited", rec, renameSpecs())
+
+	handler := func(ctx context.Context, req any) (any, error) { return wrapperspb.String("new"), nil }
+
+	// the rename took effect, so the caller must see it did
+	resp, err := intercept(context.Background(), wrapperspb.String("a"), &grpc.UnaryServerInfo{FullMethod: renameMethod}, handler)
+	if err != nil || resp.(*wrapperspb.StringValue).Value != "new" {
+		t.Errorf("intercept with a failing recorder = %v, %v, want the handler's result", resp, err)
+	}
+
+	if got := testutil.ToFloat64(writeFailures.WithLabelValues("unaudited", renameMethod)); got != 1 {
+		t.Errorf("audit_write_failures_total = %v, want 1", got)
+	}
+}
+
+func TestInterceptorRefusesCallsWithoutABeforeState(t *testing.T) {
+	specs := map[string]Spec{
+		renameMethod: Audited(
+			"name",
+			func(req *wrapperspb.StringValue, _ *wrapperspb.StringValue) string { return req.Value },
+			func(context.Context, *wrapperspb.StringValue) (proto.Message, error) {
+				return nil, status.Error(codes.Unavailable, "store unavailable")
+			},
+			nil,
+		),
+	}
+	intercept := Interceptor("test", &recorder{}, specs)
+
+	applied := false
+	handler := func(ctx context.Context, req any) (any, error) {
+		applied = true
+		return wrapperspb.String("new"), nil
+	}
+
+	_, err := intercept(context.Background(), wrapperspb.String("a"), &grpc.UnaryServerInfo{FullMethod: renameMethod}, handler)
+	if applied || status.Code(err) != codes.Unavailable {
+		t.Errorf("intercept without a before state: applied = %v, error = %v, want not applied and Unavailable", applied, err)
+	}
+}
+
+func TestQueryMatches(t *testing.T) {
+	now := time.Now()
+	e := Entry{Actor: "ops", EntityType: "product", EntityID: "p1", OccurredAt: now}
+
+	for _, tt := range []struct {
+		q    Query
+		want bool
+	}{
+		{Query{}, true},
+		{Query{EntityType: "product", EntityID: "p1", Actor: "ops"}, true},
+		{Query{EntityID: "p2"}, false},
+		{Query{Actor: "other"}, false},
+		{Query{From: now}, true},
+		{Query{To: now}, false},
+		{Query{From: now.Add(-time.Hour), To: now.Add(time.Hour)}, true},
+	} {
+		if got := tt.q.Matches(e); got != tt.want {
+			t.Errorf("%+v.Matches = %v, want %v", tt.q, got, tt.want)
+		}
+	}
+}
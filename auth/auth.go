@@ -0,0 +1,175 @@
+// Package auth identifies callers by the API key they send as x-api-key
+// metadata. The account service issues keys and resolves them; every
+// service runs the interceptors here, which refuses keys that are invalid or
+// not scoped for the method called, and handlers check the caller's
+// permissions with Require and RequireAccount.
+package auth
+
+import (
+	"context"
+	"slices"
+	"strconv"
+	"strings"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// APIKeyMetadataKey is the metadata a caller sends its API key in.
+const APIKeyMetadataKey = "x-api-key"
+
+// Identity is an authenticated caller: the account its API key belongs to,
+// the scopes the key was issued with, and the permissions the account's
+// roles grant that those scopes cover.
+type Identity struct {
+	AccountID   int32
+	Scopes      []string
+	Permissions []string
+}
+
+// Actor names the identity in logs and audit entries.
+func (id Identity) Actor() string {
+	return "account:" + strconv.Itoa(int(id.AccountID))
+}
+
+// Can reports whether id holds permission.
+func (id Identity) Can(permission string) bool {
+	return slices.Contains(id.Permissions, permission)
+}
+
+// Authenticator resolves an API key to its identity. It returns a gRPC
+// status error, Unauthenticated for a key that is unknown, revoked or
+// expired.
+type Authenticator func(ctx context.Context, apiKey string) (Identity, error)
+
+type identityKey struct{}
+
+// NewContext returns ctx carrying id.
+func NewContext(ctx context.Context, id Identity) context.Context {
+	return context.WithValue(ctx, identityKey{}, id)
+}
+
+// FromContext returns the identity the interceptor authenticated, if the
+// caller sent an API key.
+func FromContext(ctx context.Context) (Identity, bool) {
+	id, ok := ctx.Value(identityKey{}).(Identity)
+	return id, ok
+}
+
+// MethodScopes maps a service's full method names, like
+// "/pb.AccountService/GetAccount", to the scope a key needs to call them.
+type MethodScopes map[string]string
+
+// UnaryInterceptor authenticates calls that send an API key and puts the
+// caller's identity in their context. A call with a key that doesn't
+// authenticate, or isn't scoped for the method, is refused; it never falls
+// back to an anonymous call. A call without a key goes through anonymously,
+// and handlers decide what it may do.
+func UnaryInterceptor(authenticate Authenticator, scopes MethodScopes) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+		ctx, err := identify(ctx, authenticate, scopes, info.FullMethod)
+		if err != nil {
+			return nil, err
+		}
+
+		return handler(ctx, req)
+	}
+}
+
+// StreamInterceptor is UnaryInterceptor for streaming RPCs.
+func StreamInterceptor(authenticate Authenticator, scopes MethodScopes) grpc.StreamServerInterceptor {
+	return func(srv any, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		ctx, err := identify(ss.Context(), authenticate, scopes, info.FullMethod)
+		if err != nil {
+			return err
+		}
+
+		return handler(srv, &identifiedStream{ServerStream: ss, ctx: ctx})
+	}
+}
+
+// identifiedStream is a stream whose context carries the caller's identity.
+type identifiedStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (s *identifiedStream) Context() context.Context {
+	return s.ctx
+}
+
+func identify(ctx context.Context, authenticate Authenticator, scopes MethodScopes, method string) (context.Context, error) {
+	md, _ := metadata.FromIncomingContext(ctx)
+
+	keys := md.Get(APIKeyMetadataKey)
+	if len(keys) == 0 {
+		return ctx, nil
+	}
+	if len(keys) > 1 || strings.TrimSpace(keys[0]) == "" {
+		return nil, status.Error(codes.Unauthenticated, "send one non-empty api key")
+	}
+
+	id, err := authenticate(ctx, keys[0])
+	if err != nil {
+		return nil, err
+	}
+
+	scope, ok := scopes[method]
+	if !ok || !HasScope(id.Scopes, scope) {
+		return nil, status.Errorf(codes.PermissionDenied, "api key isn't scoped for %s", method)
+	}
+
+	return NewContext(ctx, id), nil
+}
+
+// Require returns nil if the caller holds permission. A caller without an
+// API key is Unauthenticated, and one without the permission is
+// PermissionDenied.
+func Require(ctx context.Context, permission string) error {
+	id, ok := FromContext(ctx)
+	if !ok {
+		return status.Error(codes.Unauthenticated, "an api key is required")
+	}
+	if !id.Can(permission) {
+		return status.Errorf(codes.PermissionDenied, "%s is required", permission)
+	}
+
+	return nil
+}
+
+// RequireAccount returns nil if the caller is accountID, or holds permission
+// to act on any account.
+func RequireAccount(ctx context.Context, accountID int32, permission string) error {
+	if id, ok := FromContext(ctx); ok && id.AccountID == accountID {
+		return nil
+	}
+
+	return Require(ctx, permission)
+}
+
+// ScopeCovers reports whether a granted scope allows what required names.
+// Both are service:resource:action; a "*" segment in granted matches
+// anything.
+func ScopeCovers(granted, required string) bool {
+	g := strings.Split(granted, ":")
+	r := strings.Split(required, ":")
+
+	if len(g) != len(r) {
+		return false
+	}
+
+	for i := range g {
+		if g[i] != "*" && g[i] != r[i] {
+			return false
+		}
+	}
+
+	return true
+}
+
+// HasScope reports whether any of granted covers required.
+func HasScope(granted []string, required string) bool {
+	return slices.ContainsFunc(granted, func(g string) bool { return ScopeCovers(g, required) })
+}
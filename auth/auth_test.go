@@ -0,0 +1,171 @@
+package auth
+
+import (
+	"context"
+	"testing"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	getMethod    = "/test.Service/Get"
+	deleteMethod = "/test.Service/Delete"
+	otherMethod  = "/test.Service/Other"
+)
+
+var testScopes = MethodScopes{
+	getMethod:    "test:things:read",
+	deleteMethod: "test:things:write",
+}
+
+func authenticator(keys map[string]Identity) Authenticator {
+	return func(ctx context.Context, apiKey string) (Identity, error) {
+		id, ok := keys[apiKey]
+		if !ok {
+			return Identity{}, status.Error(codes.Unauthenticated, "unknown api key")
+		}
+		return id, nil
+	}
+}
+
+func TestUnaryInterceptor(t *testing.T) {
+	intercept := UnaryInterceptor(authenticator(map[string]Identity{
+		"reader": {AccountID: 1, Scopes: []string{"test:things:read"}},
+		"admin":  {AccountID: 2, Scopes: []string{"*:*:*"}, Permissions: []string{"test:things:write"}},
+	}), testScopes)
+
+	var got Identity
+	var authenticated bool
+	handler := func(ctx context.Context, req any) (any, error) {
+		got, authenticated = FromContext(ctx)
+		return "ok", nil
+	}
+
+	tests := []struct {
+		name   string
+		md     metadata.MD
+		method string
+		code   codes.Code
+		want   int32
+	}{
+		{"no key is anonymous", metadata.MD{}, getMethod, codes.OK, 0},
+		{"scoped key", metadata.Pairs(APIKeyMetadataKey, "reader"), getMethod, codes.OK, 1},
+		{"wildcard key", metadata.Pairs(APIKeyMetadataKey, "admin"), deleteMethod, codes.OK, 2},
+		{"unknown key", metadata.Pairs(APIKeyMetadataKey, "made-up"), getMethod, codes.Unauthenticated, 0},
+		{"empty key", metadata.Pairs(APIKeyMetadataKey, ""), getMethod, codes.Unauthenticated, 0},
+		{"two keys", metadata.Pairs(APIKeyMetadataKey, "reader", APIKeyMetadataKey, "admin"), getMethod, codes.Unauthenticated, 0},
+		{"key not scoped for the method", metadata.Pairs(APIKeyMetadataKey, "reader"), deleteMethod, codes.PermissionDenied, 0},
+		{"method without a scope", metadata.Pairs(APIKeyMetadataKey, "admin"), otherMethod, codes.PermissionDenied, 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, authenticated = Identity{}, false
+
+			ctx := metadata.NewIncomingContext(context.Background(), tt.md)
+			_, err := intercept(ctx, nil, &grpc.UnaryServerInfo{FullMethod: tt.method}, handler)
+
+			if status.Code(err) != tt.code {
+				t.Fatalf("intercept error = %v, want %v", err, tt.code)
+			}
+			if tt.code == codes.OK && (authenticated != (tt.want != 0) || got.AccountID != tt.want) {
+				t.Errorf("handler saw identity %+v (authenticated %v), want account %d", got, authenticated, tt.want)
+			}
+		})
+	}
+}
+
+type fakeStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (s fakeStream) Context() context.Context {
+	return s.ctx
+}
+
+func TestStreamInterceptor(t *testing.T) {
+	intercept := StreamInterceptor(authenticator(map[string]Identity{
+		"reader": {AccountID: 1, Scopes: []string{"test:things:read"}},
+	}), testScopes)
+
+	var got Identity
+	handler := func(srv any, ss grpc.ServerStream) error {
+		got, _ = FromContext(ss.Context())
+		return nil
+	}
+
+	ctx := metadata.NewIncomingContext(context.Background(), metadata.Pairs(APIKeyMetadataKey, "reader"))
+	if err := intercept(nil, fakeStream{ctx: ctx}, &grpc.StreamServerInfo{FullMethod: getMethod}, handler); err != nil {
+		t.Fatalf("intercept: %v", err)
+	}
+	if got.AccountID != 1 {
+		t.Errorf("handler saw identity %+v, want account 1", got)
+	}
+
+	if err := intercept(nil, fakeStream{ctx: ctx}, &grpc.StreamServerInfo{FullMethod: deleteMethod}, handler); status.Code(err) != codes.PermissionDenied {
+		t.Errorf("intercept of a method the key isn't scoped for = %v, want PermissionDenied", err)
+	}
+
+	ctx = metadata.NewIncomingContext(context.Background(), metadata.Pairs(APIKeyMetadataKey, "made-up"))
+	if err := intercept(nil, fakeStream{ctx: ctx}, &grpc.StreamServerInfo{FullMethod: getMethod}, handler); status.Code(err) != codes.Unauthenticated {
+		t.Errorf("intercept with an unknown key = %v, want Unauthenticated", err)
+	}
+}
+
+func TestRequire(t *testing.T) {
+	owner := NewContext(context.Background(), Identity{AccountID: 1})
+	admin := NewContext(context.Background(), Identity{AccountID: 2, Permissions: []string{"test:things:manage"}})
+
+	tests := []struct {
+		name    string
+		ctx     context.Context
+		account int32
+		code    codes.Code
+	}{
+		{"anonymous", context.Background(), 1, codes.Unauthenticated},
+		{"owner", owner, 1, codes.OK},
+		{"another account", owner, 3, codes.PermissionDenied},
+		{"permitted", admin, 3, codes.OK},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := RequireAccount(tt.ctx, tt.account, "test:things:manage"); status.Code(err) != tt.code {
+				t.Errorf("RequireAccount(%d) = %v, want %v", tt.account, err, tt.code)
+			}
+		})
+	}
+
+	if err := Require(owner, "test:things:manage"); status.Code(err) != codes.PermissionDenied {
+		t.Errorf("Require without the permission = %v, want PermissionDenied", err)
+	}
+	if err := Require(admin, "test:things:manage"); err != nil {
+		t.Errorf("Require with the permission = %v", err)
+	}
+}
+
+func TestScopeCovers(t *testing.T) {
+	tests := []struct {
+		granted, required string
+		want              bool
+	}{
+		{"order:orders:read", "order:orders:read", true},
+		{"order:orders:read", "order:orders:write", false},
+		{"order:orders:*", "order:orders:write", true},
+		{"order:*:read", "order:carts:read", true},
+		{"order:*:read", "catalog:products:read", false},
+		{"*:*:*", "catalog:products:write", true},
+		{"order:orders", "order:orders:read", false},
+		{"*:*:*", "order", false},
+	}
+
+	for _, tt := range tests {
+		if got := ScopeCovers(tt.granted, tt.required); got != tt.want {
+			t.Errorf("ScopeCovers(%q, %q) = %v, want %v", tt.granted, tt.required, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"context"
+	"errors"
+
+	"github.com/airlangga-hub/microservices/audit"
+	"github.com/airlangga-hub/microservices/catalog/pb"
+	"google.golang.org/protobuf/proto"
+)
+
+// auditSpecs lists the catalog RPCs that change something.
+func (s *Server) auditSpecs() map[string]audit.Spec {
+	rate := func(ctx context.Context, from, to string) (proto.Message, error) {
+		rates, err := s.Svc.ListExchangeRates(ctx)
+		if err != nil {
+			return nil, err
+		}
+		for _, r := range rates {
+			if r.From == from && r.To == to {
+				return pbExchangeRate(r), nil
+			}
+		}
+		return nil, nil
+	}
+
+	return map[string]audit.Spec{
+		pb.CatalogService_PostProduct_FullMethodName: audit.Audited(
+			"product",
+			func(_ *pb.PostProductRequest, resp *pb.PostProductResponse) string { return resp.Product.Id },
+			nil,
+			func(_ context.Context, _ *pb.PostProductRequest, resp *pb.PostProductResponse) (proto.Message, error) {
+				return resp.Product, nil
+			},
+		),
+		pb.CatalogService_UpdateProduct_FullMethodName: audit.Audited(
+			"product",
+			func(req *pb.UpdateProductRequest, _ *pb.UpdateProductResponse) string { return req.Id },
+			func(ctx context.Context, req *pb.UpdateProductRequest) (proto.Message, error) {
+				p, err := s.Svc.GetProductByID(ctx, req.Id, "")
+				if errors.Is(err, ErrProductNotFound) {
+					return nil, nil
+				}
+				if err != nil {
+					return nil, err
+				}
+				return pbProduct(p), nil
+			},
+			func(_ context.Context, _ *pb.UpdateProductRequest, resp *pb.UpdateProductResponse) (proto.Message, error) {
+				return resp.Product, nil
+			},
+		),
+		pb.CatalogService_SetExchangeRate_FullMethodName: audit.Audited(
+			"exchange_rate",
+			func(req *pb.SetExchangeRateRequest, _ *pb.SetExchangeRateResponse) string {
+				return exchangeRateID(req.FromCurrency, req.ToCurrency)
+			},
+			func(ctx context.Context, req *pb.SetExchangeRateRequest) (proto.Message, error) {
+				return rate(ctx, req.FromCurrency, req.ToCurrency)
+			},
+			func(_ context.Context, _ *pb.SetExchangeRateRequest, resp *pb.SetExchangeRateResponse) (proto.Message, error) {
+				return resp.ExchangeRate, nil
+			},
+		),
+		pb.CatalogService_DeleteExchangeRate_FullMethodName: audit.Audited(
+			"exchange_rate",
+			func(req *pb.DeleteExchangeRateRequest, _ *pb.DeleteExchangeRateResponse) string {
+				return exchangeRateID(req.FromCurrency, req.ToCurrency)
+			},
+			func(ctx context.Context, req *pb.DeleteExchangeRateRequest) (proto.Message, error) {
+				return rate(ctx, req.FromCurrency, req.ToCurrency)
+			},
+			nil,
+		),
+	}
+}
@@ -0,0 +1,45 @@
+package main
+
+import (
+	"context"
+
+	"github.com/airlangga-hub/microservices/auth"
+	accpb "github.com/airlangga-hub/microservices/catalog/account_pb"
+	"github.com/airlangga-hub/microservices/catalog/pb"
+)
+
+// Permissions, granted by account roles, that privileged catalog calls
+// need.
+const (
+	permissionWriteProducts      = "catalog:products:write"
+	permissionWriteExchangeRates = "catalog:exchange_rates:write"
+	permissionReadAudit          = "catalog:audit:read"
+)
+
+// methodScopes is the scope an API key needs for each catalog RPC.
+var methodScopes = auth.MethodScopes{
+	pb.CatalogService_PostProduct_FullMethodName:        "catalog:products:write",
+	pb.CatalogService_UpdateProduct_FullMethodName:      "catalog:products:write",
+	pb.CatalogService_GetProduct_FullMethodName:         "catalog:products:read",
+	pb.CatalogService_GetProducts_FullMethodName:        "catalog:products:read",
+	pb.CatalogService_SetExchangeRate_FullMethodName:    "catalog:exchange_rates:write",
+	pb.CatalogService_ListExchangeRates_FullMethodName:  "catalog:exchange_rates:read",
+	pb.CatalogService_DeleteExchangeRate_FullMethodName: "catalog:exchange_rates:write",
+	pb.CatalogService_QueryAuditLog_FullMethodName:      "catalog:audit:read",
+}
+
+// apiKeyAuthenticator asks the account service whose API key a caller sent.
+func apiKeyAuthenticator(accounts accpb.AccountServiceClient) auth.Authenticator {
+	return func(ctx context.Context, apiKey string) (auth.Identity, error) {
+		res, err := accounts.AuthenticateAPIKey(ctx, &accpb.AuthenticateAPIKeyRequest{Key: apiKey})
+		if err != nil {
+			return auth.Identity{}, err
+		}
+
+		return auth.Identity{
+			AccountID:   res.GetApiKey().GetAccountId(),
+			Scopes:      res.GetApiKey().GetScopes(),
+			Permissions: res.GetPermissions(),
+		}, nil
+	}
+}
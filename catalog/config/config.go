@@ -0,0 +1,92 @@
+// Package config loads and validates the catalog service configuration.
+//
+// Every setting is read from its environment variable, falling back to the
+// JSON file named by CATALOG_CONFIG_FILE, then to a default. Load reports all
+// invalid or missing settings at once.
+package config
+
+import (
+	"time"
+
+	"github.com/airlangga-hub/microservices/settings"
+)
+
+const (
+	StorageElasticsearch = "elasticsearch"
+	StorageMemory        = "memory"
+
+	EventBusMemory = "memory"
+	EventBusNATS   = "nats"
+)
+
+// AccountServiceURL is where callers' API keys are checked.
+type Config struct {
+	Port              string
+	HTTPPort          string
+	Storage           string
+	ElasticsearchURL  string
+	AccountServiceURL string
+	Elasticsearch     Elasticsearch
+	Events            Events
+	ShutdownTimeout   time.Duration
+}
+
+// Elasticsearch holds the elasticsearch client transport settings.
+type Elasticsearch struct {
+	MaxRetries          int
+	MaxIdleConnsPerHost int
+	RequestTimeout      time.Duration
+}
+
+// Events configures the bus domain events are published to and how often the
+// outbox relay polls for them.
+type Events struct {
+	Bus          string
+	NATSURL      string
+	PollInterval time.Duration
+	BatchSize    int
+}
+
+func Load() (Config, error) {
+	l := settings.NewLoader("CATALOG_CONFIG_FILE")
+
+	cfg := Config{
+		Port:              l.String("CATALOG_PORT", ""),
+		HTTPPort:          l.String("CATALOG_HTTP_PORT", ":8080"),
+		Storage:           l.String("CATALOG_STORAGE", StorageElasticsearch),
+		ElasticsearchURL:  l.String("ELASTICSEARCH_URL", ""),
+		AccountServiceURL: l.String("ACCOUNT_SERVICE_URL", ""),
+		Elasticsearch: Elasticsearch{
+			MaxRetries:          l.Int("CATALOG_ES_MAX_RETRIES", 3),
+			MaxIdleConnsPerHost: l.Int("CATALOG_ES_MAX_IDLE_CONNS_PER_HOST", 10),
+			RequestTimeout:      l.Duration("CATALOG_ES_REQUEST_TIMEOUT", 5*time.Second),
+		},
+		Events: Events{
+			Bus:          l.String("CATALOG_EVENT_BUS", EventBusMemory),
+			NATSURL:      l.String("NATS_URL", ""),
+			PollInterval: l.Duration("CATALOG_OUTBOX_POLL_INTERVAL", time.Second),
+			BatchSize:    l.Int("CATALOG_OUTBOX_BATCH_SIZE", 100),
+		},
+		ShutdownTimeout: l.Duration("CATALOG_SHUTDOWN_TIMEOUT", 10*time.Second),
+	}
+
+	l.Address("CATALOG_PORT", cfg.Port)
+	l.Address("CATALOG_HTTP_PORT", cfg.HTTPPort)
+	l.Check(cfg.Storage == StorageElasticsearch || cfg.Storage == StorageMemory, "CATALOG_STORAGE must be %q or %q", StorageElasticsearch, StorageMemory)
+	if cfg.Storage == StorageElasticsearch {
+		l.Required("ELASTICSEARCH_URL", cfg.ElasticsearchURL)
+	}
+	l.Required("ACCOUNT_SERVICE_URL", cfg.AccountServiceURL)
+	l.Check(cfg.Elasticsearch.MaxRetries >= 0, "CATALOG_ES_MAX_RETRIES must not be negative")
+	l.Check(cfg.Elasticsearch.MaxIdleConnsPerHost > 0, "CATALOG_ES_MAX_IDLE_CONNS_PER_HOST must be positive")
+	l.Check(cfg.Elasticsearch.RequestTimeout > 0, "CATALOG_ES_REQUEST_TIMEOUT must be positive")
+	l.Check(cfg.Events.Bus == EventBusMemory || cfg.Events.Bus == EventBusNATS, "CATALOG_EVENT_BUS must be %q or %q", EventBusMemory, EventBusNATS)
+	if cfg.Events.Bus == EventBusNATS {
+		l.Required("NATS_URL", cfg.Events.NATSURL)
+	}
+	l.Check(cfg.Events.PollInterval > 0, "CATALOG_OUTBOX_POLL_INTERVAL must be positive")
+	l.Check(cfg.Events.BatchSize > 0, "CATALOG_OUTBOX_BATCH_SIZE must be positive")
+	l.Check(cfg.ShutdownTimeout > 0, "CATALOG_SHUTDOWN_TIMEOUT must be positive")
+
+	return cfg, l.Err("catalog")
+}
@@ -0,0 +1,91 @@
+package config
+
+import (
+	"maps"
+	"strings"
+	"testing"
+	"time"
+)
+
+// required is the least a elasticsearch-backed catalog service needs set.
+var required = map[string]string{
+	"CATALOG_PORT":        ":9091",
+	"ELASTICSEARCH_URL":   "http://elasticsearch:9200",
+	"ACCOUNT_SERVICE_URL": "account:9090",
+}
+
+// setEnv sets env, clearing the required settings it leaves out and the
+// config file.
+func setEnv(t *testing.T, env map[string]string) {
+	t.Helper()
+
+	t.Setenv("CATALOG_CONFIG_FILE", "")
+	for key := range required {
+		t.Setenv(key, "")
+	}
+	for key, value := range env {
+		t.Setenv(key, value)
+	}
+}
+
+func with(changes map[string]string) map[string]string {
+	env := maps.Clone(required)
+	maps.Copy(env, changes)
+	return env
+}
+
+func TestLoadReportsProblems(t *testing.T) {
+	tests := []struct {
+		name     string
+		env      map[string]string
+		problems []string
+	}{
+		{"valid", required, nil},
+		{"nothing set", nil, []string{"CATALOG_PORT is required", "ELASTICSEARCH_URL is required", "ACCOUNT_SERVICE_URL is required"}},
+		{"memory storage needs no elasticsearch", with(map[string]string{"CATALOG_STORAGE": "memory", "ELASTICSEARCH_URL": ""}), nil},
+		{"nats needs a url", with(map[string]string{"CATALOG_EVENT_BUS": "nats"}), []string{"NATS_URL is required"}},
+		{"bad values", with(map[string]string{"CATALOG_ES_MAX_RETRIES": "-1", "CATALOG_OUTBOX_BATCH_SIZE": "lots"}), []string{"CATALOG_ES_MAX_RETRIES must not be negative", `CATALOG_OUTBOX_BATCH_SIZE: "lots" is not an integer`}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setEnv(t, tt.env)
+
+			_, err := Load()
+			if len(tt.problems) == 0 {
+				if err != nil {
+					t.Errorf("Load = %v, want no problems", err)
+				}
+				return
+			}
+
+			if err == nil {
+				t.Fatalf("Load succeeded, want %v", tt.problems)
+			}
+			for _, problem := range tt.problems {
+				if !strings.Contains(err.Error(), problem) {
+					t.Errorf("Load = %v, want it to report %s", err, problem)
+				}
+			}
+		})
+	}
+}
+
+func TestLoadDefaults(t *testing.T) {
+	setEnv(t, required)
+
+	cfg, err := Load()
+	if err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+
+	if cfg.Storage != StorageElasticsearch || cfg.HTTPPort != ":8080" {
+		t.Errorf("Load = %+v, want elasticsearch storage and HTTP on :8080", cfg)
+	}
+	if cfg.Elasticsearch != (Elasticsearch{MaxRetries: 3, MaxIdleConnsPerHost: 10, RequestTimeout: 5 * time.Second}) {
+		t.Errorf("Elasticsearch = %+v, want the defaults", cfg.Elasticsearch)
+	}
+	if cfg.Events != (Events{Bus: EventBusMemory, PollInterval: time.Second, BatchSize: 100}) {
+		t.Errorf("Events = %+v, want the default memory bus", cfg.Events)
+	}
+}
@@ -1,29 +1,97 @@
 package main
 
 import (
+	"context"
 	"fmt"
 	"log"
 	"net"
+	"net/http"
 	"os"
 	"os/signal"
 	"syscall"
+	"time"
 
+	"github.com/airlangga-hub/microservices/audit"
+	"github.com/airlangga-hub/microservices/auth"
+	accpb "github.com/airlangga-hub/microservices/catalog/account_pb"
+	"github.com/airlangga-hub/microservices/catalog/config"
 	"github.com/airlangga-hub/microservices/catalog/pb"
+	"github.com/airlangga-hub/microservices/events"
+	"github.com/airlangga-hub/microservices/healthcheck"
+	"github.com/airlangga-hub/microservices/money"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/reflection"
 )
 
 func main() {
-	port := os.Getenv("CATALOG_PORT")
+	cfg, err := config.Load()
+	if err != nil {
+		log.Fatalf("ERROR: catalog main: %v", err)
+	}
 
-	repository, err := NewRepository()
+	repository, err := openRepository(cfg)
 	if err != nil {
 		log.Fatalf("ERROR: catalog main: couldn't create repository: %v", err)
 	}
 
+	backfillCurrency(repository, cfg.Elasticsearch.RequestTimeout)
+
+	bus, err := openBus(cfg)
+	if err != nil {
+		log.Fatalf("ERROR: catalog main: couldn't create event bus: %v", err)
+	}
+	defer bus.Close()
+
 	service := NewService(repository)
 
-	s := grpc.NewServer()
-	pb.RegisterCatalogServiceServer(s, &Server{Svc: service})
+	lis, err := net.Listen("tcp", cfg.Port)
+	if err != nil {
+		log.Fatalf("ERROR: catalog main: couldn't listen on %s: %v", cfg.Port, err)
+	}
+
+	accountConn, err := grpc.NewClient(cfg.AccountServiceURL, grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		log.Fatalf("ERROR: catalog main: couldn't create account client: %v", err)
+	}
+	defer accountConn.Close()
+
+	server := &Server{Svc: service}
+
+	s := grpc.NewServer(grpc.ChainUnaryInterceptor(
+		auth.UnaryInterceptor(apiKeyAuthenticator(accpb.NewAccountServiceClient(accountConn)), methodScopes),
+		audit.Interceptor("catalog", repository, server.auditSpecs()),
+	))
+	pb.RegisterCatalogServiceServer(s, server)
+
+	healthServer := health.NewServer()
+	healthServer.SetServingStatus("", healthpb.HealthCheckResponse_NOT_SERVING)
+	healthpb.RegisterHealthServer(s, healthServer)
+	reflection.Register(s)
+
+	checker := healthcheck.New(healthServer, pb.CatalogService_ServiceDesc.ServiceName, repository.Ping)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	go checker.Run(ctx)
+
+	relay := events.NewRelay(repository, bus, cfg.Events.PollInterval, cfg.Events.BatchSize)
+	relayDone := make(chan struct{})
+
+	go func() {
+		relay.Run(ctx)
+		close(relayDone)
+	}()
+
+	mux := http.NewServeMux()
+	mux.Handle("/", checker.HTTPHandler())
+	mux.Handle("/metrics", promhttp.Handler())
+
+	httpServer := &http.Server{Addr: cfg.HTTPPort, Handler: mux}
 
 	exitChan := make(chan error, 1)
 
@@ -35,15 +103,67 @@ func main() {
 	}()
 
 	go func() {
-		lis, _ := net.Listen("tcp", port)
 		err := s.Serve(lis)
 		if err != nil && err != grpc.ErrServerStopped {
 			exitChan <- fmt.Errorf("grpc serve error: %v", err)
 		}
 	}()
 
+	go func() {
+		err := httpServer.ListenAndServe()
+		if err != nil && err != http.ErrServerClosed {
+			exitChan <- fmt.Errorf("http serve error: %v", err)
+		}
+	}()
+
 	err = <-exitChan
 	log.Printf("Shutting down. Reason: %v", err)
 
-	s.GracefulStop()
+	cancel()
+	<-relayDone
+	healthServer.Shutdown()
+
+	shutdownCtx, shutdownCancel := context.WithTimeout(context.Background(), cfg.ShutdownTimeout)
+	defer shutdownCancel()
+	httpServer.Shutdown(shutdownCtx)
+
+	stopped := make(chan struct{})
+	go func() {
+		s.GracefulStop()
+		close(stopped)
+	}()
+
+	select {
+	case <-stopped:
+	case <-shutdownCtx.Done():
+		s.Stop()
+	}
+}
+
+func openRepository(cfg config.Config) (Repository, error) {
+	if cfg.Storage == config.StorageMemory {
+		log.Println("WARNING: catalog main: using in-memory storage, data is lost on restart")
+		return NewMemoryRepository(), nil
+	}
+
+	return NewRepository(cfg.ElasticsearchURL, cfg.Elasticsearch)
+}
+
+// backfillCurrency migrates products stored before prices had a currency.
+// Reads default the currency anyway, so a failure doesn't stop the service.
+func backfillCurrency(repository Repository, timeout time.Duration) {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	if err := repository.BackfillCurrency(ctx, money.DefaultCurrency); err != nil {
+		log.Printf("WARNING: catalog main: couldn't backfill product currency, will retry on next start: %v", err)
+	}
+}
+
+func openBus(cfg config.Config) (events.Bus, error) {
+	if cfg.Events.Bus == config.EventBusNATS {
+		return events.NewNATSBus(cfg.Events.NATSURL, "catalog")
+	}
+
+	return events.NewMemoryBus(), nil
 }
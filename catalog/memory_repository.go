@@ -0,0 +1,346 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"unicode"
+
+	"github.com/airlangga-hub/microservices/audit"
+	"github.com/airlangga-hub/microservices/events"
+	"github.com/airlangga-hub/microservices/money"
+)
+
+// memoryRepository is a concurrency-safe, in-memory Repository used for
+// running without elasticsearch and as a fake in tests. Listing keeps
+// insertion order. Search approximates the multi_match query: any query term
+// matching the name or description counts, and name matches weigh double.
+type memoryRepository struct {
+	mu       sync.RWMutex
+	products map[string]Product
+	order    []string
+	nextID   int
+	outbox   []events.Event
+	rates    map[string]ExchangeRate
+	auditLog []audit.Entry
+}
+
+func NewMemoryRepository() Repository {
+	return &memoryRepository{products: map[string]Product{}, rates: map[string]ExchangeRate{}}
+}
+
+func (r *memoryRepository) Close(ctx context.Context) error {
+	return nil
+}
+
+func (r *memoryRepository) Ping(ctx context.Context) error {
+	return nil
+}
+
+func (r *memoryRepository) CreateProduct(ctx context.Context, p productDocument) (Product, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	product := Product{
+		ID:          fmt.Sprintf("mem-%d", r.nextID+1),
+		Name:        p.Name,
+		Description: p.Description,
+		Price:       p.Price,
+		Currency:    p.Currency,
+		Prices:      p.Prices,
+		TaxCategory: p.TaxCategory,
+	}
+
+	if product.Prices == nil {
+		product.Prices = []money.Money{}
+	}
+
+	event, err := events.New(EventProductCreated, product.ID, product)
+	if err != nil {
+		return Product{}, errors.New("error creating product event")
+	}
+
+	r.nextID++
+	r.products[product.ID] = product
+	r.order = append(r.order, product.ID)
+	r.outbox = append(r.outbox, event)
+
+	return product, nil
+}
+
+func (r *memoryRepository) GetProductByID(ctx context.Context, id string) (Product, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	p, exist := r.products[id]
+	if !exist {
+		return Product{}, ErrProductNotFound
+	}
+
+	return p, nil
+}
+
+func (r *memoryRepository) UpdateProduct(ctx context.Context, id string, p productDocument) (Product, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if _, exist := r.products[id]; !exist {
+		return Product{}, ErrProductNotFound
+	}
+
+	product := Product{
+		ID:          id,
+		Name:        p.Name,
+		Description: p.Description,
+		Price:       p.Price,
+		Currency:    p.Currency,
+		Prices:      p.Prices,
+		TaxCategory: p.TaxCategory,
+	}
+
+	if product.Prices == nil {
+		product.Prices = []money.Money{}
+	}
+
+	event, err := events.New(EventProductUpdated, id, product)
+	if err != nil {
+		return Product{}, errors.New("error creating product event")
+	}
+
+	r.products[id] = product
+	r.outbox = append(r.outbox, event)
+
+	return product, nil
+}
+
+func (r *memoryRepository) ListProducts(ctx context.Context, offset, limit int32) ([]Product, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	products := []Product{}
+	for _, id := range r.order {
+		products = append(products, r.products[id])
+	}
+
+	return paginate(products, offset, limit), nil
+}
+
+func (r *memoryRepository) ListProductsWithIDs(ctx context.Context, ids []string) ([]Product, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	products := []Product{}
+	for _, id := range ids {
+		if p, exist := r.products[id]; exist {
+			products = append(products, p)
+		}
+	}
+
+	return products, nil
+}
+
+func (r *memoryRepository) SearchProducts(ctx context.Context, query string, offset, limit int32) ([]Product, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	terms := tokenize(query)
+
+	type hit struct {
+		product Product
+		score   int
+	}
+
+	hits := []hit{}
+	for _, id := range r.order {
+		p := r.products[id]
+		name := tokenize(p.Name)
+		description := tokenize(p.Description)
+
+		score := 0
+		for _, term := range terms {
+			score += 2*count(name, term) + count(description, term)
+		}
+
+		if score > 0 {
+			hits = append(hits, hit{p, score})
+		}
+	}
+
+	sort.SliceStable(hits, func(i, j int) bool { return hits[i].score > hits[j].score })
+
+	products := []Product{}
+	for _, h := range hits {
+		products = append(products, h.product)
+	}
+
+	return paginate(products, offset, limit), nil
+}
+
+func (r *memoryRepository) BackfillCurrency(ctx context.Context, currency string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for id, p := range r.products {
+		if p.Currency == "" {
+			p.Currency = currency
+			r.products[id] = p
+		}
+	}
+
+	return nil
+}
+
+func (r *memoryRepository) PutExchangeRate(ctx context.Context, rate ExchangeRate) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	id := exchangeRateID(rate.From, rate.To)
+
+	event, err := events.New(EventExchangeRateChanged, id, rate)
+	if err != nil {
+		return errors.New("error creating exchange rate event")
+	}
+
+	r.rates[id] = rate
+	r.outbox = append(r.outbox, event)
+
+	return nil
+}
+
+func (r *memoryRepository) ListExchangeRates(ctx context.Context) ([]ExchangeRate, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	rates := []ExchangeRate{}
+	for _, rate := range r.rates {
+		rates = append(rates, rate)
+	}
+
+	sort.Slice(rates, func(i, j int) bool {
+		return exchangeRateID(rates[i].From, rates[i].To) < exchangeRateID(rates[j].From, rates[j].To)
+	})
+
+	return rates, nil
+}
+
+func (r *memoryRepository) DeleteExchangeRate(ctx context.Context, from, to string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	id := exchangeRateID(from, to)
+	rate, exist := r.rates[id]
+	if !exist {
+		return ErrExchangeRateNotFound
+	}
+
+	event, err := events.New(EventExchangeRateDeleted, id, rate)
+	if err != nil {
+		return errors.New("error creating exchange rate event")
+	}
+
+	delete(r.rates, id)
+	r.outbox = append(r.outbox, event)
+
+	return nil
+}
+
+func (r *memoryRepository) RecordAudit(ctx context.Context, e audit.Entry) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.auditLog = append(r.auditLog, e)
+
+	return nil
+}
+
+func (r *memoryRepository) QueryAuditLog(ctx context.Context, q audit.Query) ([]audit.Entry, error) {
+	if q.Offset < 0 || q.Limit < 0 {
+		return nil, errors.New("error querying audit log")
+	}
+
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	entries := []audit.Entry{}
+
+	// newest first: entries are recorded in order
+	for _, e := range slices.Backward(r.auditLog) {
+		if q.Matches(e) {
+			entries = append(entries, e)
+		}
+	}
+
+	if int(q.Offset) >= len(entries) {
+		return []audit.Entry{}, nil
+	}
+	entries = entries[q.Offset:]
+
+	if int(q.Limit) < len(entries) {
+		entries = entries[:q.Limit]
+	}
+
+	return entries, nil
+}
+
+// tokenize lowercases s and splits it on anything that isn't a letter or
+// digit, roughly like the elasticsearch standard analyzer.
+func tokenize(s string) []string {
+	return strings.FieldsFunc(strings.ToLower(s), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+}
+
+func count(words []string, term string) int {
+	n := 0
+	for _, w := range words {
+		if w == term {
+			n++
+		}
+	}
+	return n
+}
+
+func paginate(products []Product, offset, limit int32) []Product {
+	if offset < 0 || limit < 0 || int(offset) >= len(products) {
+		return []Product{}
+	}
+	products = products[offset:]
+
+	if int(limit) < len(products) {
+		products = products[:limit]
+	}
+
+	return products
+}
+
+// DispatchEvents publishes outside the lock so bus subscribers may call back
+// into the repository.
+func (r *memoryRepository) DispatchEvents(ctx context.Context, limit int, publish func(ctx context.Context, e events.Event) error) (int, error) {
+	r.mu.RLock()
+	pending := slices.Clone(r.outbox[:min(limit, len(r.outbox))])
+	r.mu.RUnlock()
+
+	published := map[string]bool{}
+	var publishErr error
+
+	for _, e := range pending {
+		if publishErr = publish(ctx, e); publishErr != nil {
+			break
+		}
+		published[e.ID] = true
+	}
+
+	r.mu.Lock()
+	r.outbox = slices.DeleteFunc(r.outbox, func(e events.Event) bool { return published[e.ID] })
+	r.mu.Unlock()
+
+	if publishErr != nil {
+		return len(published), errors.New("error publishing events")
+	}
+
+	return len(published), nil
+}
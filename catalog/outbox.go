@@ -0,0 +1,189 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io"
+	"log"
+	"time"
+
+	"github.com/airlangga-hub/microservices/events"
+	"github.com/elastic/go-elasticsearch/v9/esapi"
+)
+
+const (
+	EventProductCreated      = "ProductCreated"
+	EventProductUpdated      = "ProductUpdated"
+	EventExchangeRateChanged = "ExchangeRateChanged"
+	EventExchangeRateDeleted = "ExchangeRateDeleted"
+)
+
+func newDocumentID() (string, error) {
+	id := make([]byte, 16)
+	if _, err := rand.Read(id); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(id), nil
+}
+
+// outboxDocument is a pending event in the outbox index. The payload is
+// stored as a string so the entities' fields don't end up in the index
+// mapping.
+type outboxDocument struct {
+	Type        string    `json:"type"`
+	AggregateID string    `json:"aggregate_id"`
+	Payload     string    `json:"payload"`
+	OccurredAt  time.Time `json:"occurred_at"`
+}
+
+// addToOutbox stores e in the outbox index under its event ID.
+func (r *repository) addToOutbox(ctx context.Context, e events.Event) error {
+	outboxDoc, err := json.Marshal(outboxDocument{
+		Type:        e.Type,
+		AggregateID: e.AggregateID,
+		Payload:     string(e.Payload),
+		OccurredAt:  e.OccurredAt,
+	})
+	if err != nil {
+		log.Println("ERROR: catalog repo addToOutbox: ", err)
+		return errors.New("error marshaling outbox event")
+	}
+
+	res, err := esapi.IndexRequest{
+		Index:      ESOutboxIndex,
+		DocumentID: e.ID,
+		OpType:     "create",
+		Body:       bytes.NewReader(outboxDoc),
+		Refresh:    "true",
+	}.Do(ctx, r.client)
+	if err != nil {
+		log.Println("ERROR: catalog repo addToOutbox: ", err)
+		return errors.New("error storing outbox event")
+	}
+	defer res.Body.Close()
+
+	if res.IsError() {
+		body, _ := io.ReadAll(res.Body)
+		log.Printf("ERROR: catalog repo addToOutbox: status=%d, body=%s", res.StatusCode, body)
+		return errors.New("error storing outbox event")
+	}
+
+	return nil
+}
+
+// DispatchEvents publishes the oldest pending events in the outbox index and
+// deletes the ones it published.
+func (r *repository) DispatchEvents(ctx context.Context, limit int, publish func(ctx context.Context, e events.Event) error) (int, error) {
+	query := map[string]any{
+		"size": limit,
+		"query": map[string]any{
+			"match_all": map[string]any{},
+		},
+		// the index has no mapping for occurred_at until the first event
+		"sort": []any{
+			map[string]any{"occurred_at": map[string]any{"order": "asc", "unmapped_type": "date"}},
+		},
+	}
+
+	esQuery, err := json.Marshal(query)
+	if err != nil {
+		log.Println("ERROR: catalog repo DispatchEvents: ", err)
+		return 0, errors.New("error marshaling outbox query")
+	}
+
+	res, err := esapi.SearchRequest{
+		Index: []string{ESOutboxIndex},
+		Body:  bytes.NewReader(esQuery),
+	}.Do(ctx, r.client)
+	if err != nil {
+		log.Println("ERROR: catalog repo DispatchEvents: ", err)
+		return 0, errors.New("error searching outbox")
+	}
+	defer res.Body.Close()
+
+	if res.IsError() {
+		body, _ := io.ReadAll(res.Body)
+		log.Printf("ERROR: catalog repo DispatchEvents: status=%d, body=%s", res.StatusCode, body)
+		return 0, errors.New("error searching outbox")
+	}
+
+	var response struct {
+		Hits struct {
+			Hits []struct {
+				ID     string         `json:"_id"`
+				Source outboxDocument `json:"_source"`
+			} `json:"hits"`
+		} `json:"hits"`
+	}
+
+	if err := json.NewDecoder(res.Body).Decode(&response); err != nil {
+		log.Println("ERROR: catalog repo DispatchEvents: ", err)
+		return 0, errors.New("error decoding outbox")
+	}
+
+	published := []string{}
+	var publishErr error
+
+	for _, hit := range response.Hits.Hits {
+		e := events.Event{
+			ID:          hit.ID,
+			Type:        hit.Source.Type,
+			AggregateID: hit.Source.AggregateID,
+			Payload:     json.RawMessage(hit.Source.Payload),
+			OccurredAt:  hit.Source.OccurredAt,
+		}
+
+		if publishErr = publish(ctx, e); publishErr != nil {
+			break
+		}
+		published = append(published, e.ID)
+	}
+
+	if len(published) > 0 {
+		if err := r.removeFromOutbox(ctx, published); err != nil {
+			return len(published), err
+		}
+	}
+
+	if publishErr != nil {
+		log.Println("ERROR: catalog repo DispatchEvents (publish): ", publishErr)
+		return len(published), errors.New("error publishing events")
+	}
+
+	return len(published), nil
+}
+
+func (r *repository) removeFromOutbox(ctx context.Context, ids []string) error {
+	body, err := json.Marshal(map[string]any{
+		"query": map[string]any{
+			"ids": map[string]any{"values": ids},
+		},
+	})
+	if err != nil {
+		log.Println("ERROR: catalog repo removeFromOutbox: ", err)
+		return errors.New("error marshaling outbox delete")
+	}
+
+	res, err := esapi.DeleteByQueryRequest{
+		Index:   []string{ESOutboxIndex},
+		Body:    bytes.NewReader(body),
+		Refresh: esapi.BoolPtr(true),
+	}.Do(ctx, r.client)
+	if err != nil {
+		log.Println("ERROR: catalog repo removeFromOutbox: ", err)
+		return errors.New("error deleting published events")
+	}
+	defer res.Body.Close()
+
+	if res.IsError() {
+		b, _ := io.ReadAll(res.Body)
+		log.Printf("ERROR: catalog repo removeFromOutbox: status=%d, body=%s", res.StatusCode, b)
+		return errors.New("error deleting published events")
+	}
+
+	return nil
+}
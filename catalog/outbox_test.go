@@ -0,0 +1,95 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"slices"
+	"testing"
+
+	"github.com/airlangga-hub/microservices/catalog/pb"
+	"github.com/airlangga-hub/microservices/events"
+)
+
+func TestChangesPublishEvents(t *testing.T) {
+	repo := NewMemoryRepository()
+	client := serveRepository(t, repo)
+	ctx := withKey(adminKey)
+
+	posted := postProducts(t, client, &pb.PostProductRequest{Name: "Keyboard", Price: &pb.Money{Currency: "USD", Units: 100}})
+	if _, err := client.UpdateProduct(ctx, &pb.UpdateProductRequest{Id: posted[0].Id, Name: "Keyboard", Price: &pb.Money{Currency: "USD", Units: 90}}); err != nil {
+		t.Fatalf("UpdateProduct: %v", err)
+	}
+	if _, err := client.SetExchangeRate(ctx, &pb.SetExchangeRateRequest{FromCurrency: "USD", ToCurrency: "EUR", Rate: "0.92"}); err != nil {
+		t.Fatalf("SetExchangeRate: %v", err)
+	}
+	if _, err := client.DeleteExchangeRate(ctx, &pb.DeleteExchangeRateRequest{FromCurrency: "USD", ToCurrency: "EUR"}); err != nil {
+		t.Fatalf("DeleteExchangeRate: %v", err)
+	}
+	// failed changes publish nothing
+	client.UpdateProduct(ctx, &pb.UpdateProductRequest{Id: "missing", Name: "Mouse"})
+	client.DeleteExchangeRate(ctx, &pb.DeleteExchangeRateRequest{FromCurrency: "USD", ToCurrency: "EUR"})
+
+	published := []events.Event{}
+	n, err := repo.DispatchEvents(context.Background(), 10, func(ctx context.Context, e events.Event) error {
+		published = append(published, e)
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("DispatchEvents: %v", err)
+	}
+
+	types := []string{}
+	for _, e := range published {
+		types = append(types, e.Type+" "+e.AggregateID)
+	}
+	want := []string{
+		EventProductCreated + " " + posted[0].Id,
+		EventProductUpdated + " " + posted[0].Id,
+		EventExchangeRateChanged + " USD-EUR",
+		EventExchangeRateDeleted + " USD-EUR",
+	}
+	if n != len(want) || !slices.Equal(types, want) {
+		t.Fatalf("published %v, want %v", types, want)
+	}
+
+	var updated Product
+	if err := json.Unmarshal(published[1].Payload, &updated); err != nil || updated.Price != 90 {
+		t.Errorf("ProductUpdated payload = %s, want the product at its new price", published[1].Payload)
+	}
+	var rate ExchangeRate
+	if err := json.Unmarshal(published[3].Payload, &rate); err != nil || rate.Rate != "0.92" {
+		t.Errorf("ExchangeRateDeleted payload = %s, want the deleted rate", published[3].Payload)
+	}
+}
+
+func TestDispatchEventsKeepsUnpublishedEvents(t *testing.T) {
+	repo := NewMemoryRepository()
+	client := serveRepository(t, repo)
+
+	posted := postProducts(t, client,
+		&pb.PostProductRequest{Name: "Keyboard", Price: &pb.Money{Currency: "USD", Units: 100}},
+		&pb.PostProductRequest{Name: "Mouse", Price: &pb.Money{Currency: "USD", Units: 50}},
+	)
+
+	n, err := repo.DispatchEvents(context.Background(), 10, func(ctx context.Context, e events.Event) error {
+		if e.AggregateID == posted[1].Id {
+			return errors.New("bus is down")
+		}
+		return nil
+	})
+	if err == nil || n != 1 {
+		t.Fatalf("DispatchEvents with a failing bus = %d, %v; want 1 and an error", n, err)
+	}
+
+	retried := []string{}
+	if _, err := repo.DispatchEvents(context.Background(), 10, func(ctx context.Context, e events.Event) error {
+		retried = append(retried, e.AggregateID)
+		return nil
+	}); err != nil {
+		t.Fatalf("DispatchEvents: %v", err)
+	}
+	if !slices.Equal(retried, []string{posted[1].Id}) {
+		t.Errorf("retried events of %v, want only the unpublished %s", retried, posted[1].Id)
+	}
+}
@@ -7,39 +7,93 @@ import (
 	"errors"
 	"io"
 	"log"
-	"os"
+	"net/http"
+	"time"
 
+	"github.com/airlangga-hub/microservices/audit"
+	"github.com/airlangga-hub/microservices/catalog/config"
+	"github.com/airlangga-hub/microservices/events"
+	"github.com/airlangga-hub/microservices/money"
 	"github.com/elastic/go-elasticsearch/v9"
 	"github.com/elastic/go-elasticsearch/v9/esapi"
 )
 
 type Repository interface {
+	events.Outbox
 	Close(ctx context.Context) error
+	Ping(ctx context.Context) error
 	CreateProduct(ctx context.Context, p productDocument) (Product, error)
+	// UpdateProduct replaces the product's fields. It returns
+	// ErrProductNotFound if there is no product id.
+	UpdateProduct(ctx context.Context, id string, p productDocument) (Product, error)
+	// GetProductByID returns ErrProductNotFound if there is no product id.
 	GetProductByID(ctx context.Context, id string) (Product, error)
 	ListProducts(ctx context.Context, offset, limit int32) ([]Product, error)
 	ListProductsWithIDs(ctx context.Context, ids []string) ([]Product, error)
 	SearchProducts(ctx context.Context, query string, offset, limit int32) ([]Product, error)
+	// BackfillCurrency sets currency on products stored before prices had
+	// one.
+	BackfillCurrency(ctx context.Context, currency string) error
+	// PutExchangeRate creates or replaces the rate for its currency pair.
+	// Changes to rates are published as events like changes to products.
+	PutExchangeRate(ctx context.Context, rate ExchangeRate) error
+	ListExchangeRates(ctx context.Context) ([]ExchangeRate, error)
+	// DeleteExchangeRate returns ErrExchangeRateNotFound if the pair has no
+	// rate.
+	DeleteExchangeRate(ctx context.Context, from, to string) error
+	// RecordAudit appends e to the audit log, which is never updated.
+	RecordAudit(ctx context.Context, e audit.Entry) error
+	// QueryAuditLog returns matching entries newest first.
+	QueryAuditLog(ctx context.Context, q audit.Query) ([]audit.Entry, error)
 }
 
 type repository struct {
 	client *elasticsearch.Client
 }
 
+// Product's Price and Currency are its base price. Prices is its price list
+// in other currencies. ExchangeRate is only set on a product repriced by
+// converting the base price.
 type Product struct {
-	ID          string `json:"id"`
-	Name        string `json:"name"`
-	Description string `json:"description"`
-	Price       int64  `json:"price"`
+	ID           string        `json:"id"`
+	Name         string        `json:"name"`
+	Description  string        `json:"description"`
+	Price        int64         `json:"price"`
+	Currency     string        `json:"currency"`
+	Prices       []money.Money `json:"prices"`
+	TaxCategory  string        `json:"tax_category"`
+	ExchangeRate *ExchangeRate `json:"-"`
 }
 
 type productDocument struct {
-	Name        string `json:"name"`
-	Description string `json:"description"`
-	Price       int64  `json:"price"`
+	Name        string        `json:"name"`
+	Description string        `json:"description"`
+	Price       int64         `json:"price"`
+	Currency    string        `json:"currency"`
+	Prices      []money.Money `json:"prices"`
+	TaxCategory string        `json:"tax_category"`
 }
 
-const ESIndex = "catalog"
+// ExchangeRate is what one unit of From buys in To, in major units, as a
+// decimal string so it stays exact.
+type ExchangeRate struct {
+	From      string    `json:"from_currency"`
+	To        string    `json:"to_currency"`
+	Rate      string    `json:"rate"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+const (
+	ESIndex       = "catalog"
+	ESRatesIndex  = "exchange_rates"
+	ESAuditIndex  = "audit_log"
+	ESOutboxIndex = "outbox"
+)
+
+// exchangeRateID is the document ID of a currency pair's rate.
+func exchangeRateID(from, to string) string {
+	return from + "-" + to
+}
 
 type ESresponse struct {
 	Hits struct {
@@ -50,27 +104,35 @@ type ESresponse struct {
 	} `json:"hits"`
 }
 
-func NewRepository() (Repository, error) {
-	esUrl := os.Getenv("ELASTICSEARCH_URL")
-	
+func NewRepository(esUrl string, opts config.Elasticsearch) (Repository, error) {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.MaxIdleConnsPerHost = opts.MaxIdleConnsPerHost
+	transport.ResponseHeaderTimeout = opts.RequestTimeout
+
 	cfg := elasticsearch.Config{
-		Addresses: []string{esUrl},
+		Addresses:  []string{esUrl},
+		MaxRetries: opts.MaxRetries,
+		Transport:  transport,
 	}
-	
+
 	client, err := elasticsearch.NewClient(cfg)
 	if err != nil {
 		log.Println("ERROR: catalog repo NewRepository: ", err)
 		return nil, errors.New("error creating elastic search client")
 	}
 
-	// create index if not exist
-	res, err := esapi.IndicesExistsRequest{
-		Index: []string{ESIndex},
-	}.Do(context.Background(), client)
-	if err != nil || res.StatusCode == 404 {
-		client.Indices.Create(ESIndex)
+	// create indices if not exist
+	for _, index := range []string{ESIndex, ESRatesIndex, ESAuditIndex, ESOutboxIndex} {
+		res, err := esapi.IndicesExistsRequest{
+			Index: []string{index},
+		}.Do(context.Background(), client)
+		if err != nil || res.StatusCode == 404 {
+			client.Indices.Create(index)
+		}
+		if err == nil {
+			res.Body.Close()
+		}
 	}
-	res.Body.Close()
 
 	return &repository{client}, nil
 }
@@ -80,11 +142,81 @@ func (r *repository) Close(ctx context.Context) error {
 		log.Println("ERROR: catalog repo Close: ", err)
 		return errors.New("error closing elastic search client")
 	}
-	
+
+	return nil
+}
+
+// Ping reports the cluster as unhealthy when it is red or when the catalog
+// index is missing.
+func (r *repository) Ping(ctx context.Context) error {
+	res, err := esapi.ClusterHealthRequest{}.Do(ctx, r.client)
+	if err != nil {
+		log.Println("ERROR: catalog repo Ping (cluster health): ", err)
+		return errors.New("error checking elastic search cluster health")
+	}
+	defer res.Body.Close()
+
+	if res.IsError() {
+		body, _ := io.ReadAll(res.Body)
+		log.Printf("ERROR: catalog repo Ping (cluster health): status=%d body=%s", res.StatusCode, body)
+		return errors.New("error checking elastic search cluster health")
+	}
+
+	var health struct {
+		Status string `json:"status"`
+	}
+
+	if err := json.NewDecoder(res.Body).Decode(&health); err != nil {
+		log.Println("ERROR: catalog repo Ping (decode cluster health): ", err)
+		return errors.New("error decoding elastic search cluster health")
+	}
+
+	if health.Status == "red" {
+		return errors.New("elastic search cluster health is red")
+	}
+
+	exists, err := esapi.IndicesExistsRequest{
+		Index: []string{ESIndex},
+	}.Do(ctx, r.client)
+	if err != nil {
+		log.Println("ERROR: catalog repo Ping (index exists): ", err)
+		return errors.New("error checking catalog index")
+	}
+	defer exists.Body.Close()
+
+	if exists.StatusCode != 200 {
+		return errors.New("catalog index does not exist")
+	}
+
 	return nil
 }
 
+// CreateProduct stores the product, then its ProductCreated event in the
+// outbox index. Elasticsearch can't write both atomically, so if the event
+// can't be stored the product is deleted again and the call fails.
 func (r *repository) CreateProduct(ctx context.Context, p productDocument) (Product, error) {
+	id, err := newDocumentID()
+	if err != nil {
+		log.Println("ERROR: catalog repo CreateProduct (newDocumentID): ", err)
+		return Product{}, errors.New("error generating product id")
+	}
+
+	product := Product{
+		ID:          id,
+		Name:        p.Name,
+		Description: p.Description,
+		Price:       p.Price,
+		Currency:    p.Currency,
+		Prices:      p.Prices,
+		TaxCategory: p.TaxCategory,
+	}
+
+	event, err := events.New(EventProductCreated, id, product)
+	if err != nil {
+		log.Println("ERROR: catalog repo CreateProduct (events.New): ", err)
+		return Product{}, errors.New("error creating product event")
+	}
+
 	productDoc, err := json.Marshal(p)
 	if err != nil {
 		log.Println("ERROR: catalog repo CreateProduct: ", err)
@@ -92,9 +224,11 @@ func (r *repository) CreateProduct(ctx context.Context, p productDocument) (Prod
 	}
 
 	req := esapi.IndexRequest{
-		Index:   ESIndex,
-		Body:    bytes.NewReader(productDoc),
-		Refresh: "true",
+		Index:      ESIndex,
+		DocumentID: id,
+		OpType:     "create",
+		Body:       bytes.NewReader(productDoc),
+		Refresh:    "true",
 	}
 
 	res, err := req.Do(ctx, r.client)
@@ -110,19 +244,105 @@ func (r *repository) CreateProduct(ctx context.Context, p productDocument) (Prod
 		return Product{}, errors.New("error creating product in elastic search")
 	}
 
-	var response struct {
-		ID     string  `json:"_id"`
-		Source Product `json:"_source"`
+	if err := r.addToOutbox(ctx, event); err != nil {
+		r.deleteProduct(context.WithoutCancel(ctx), id)
+		return Product{}, err
 	}
 
-	if err := json.NewDecoder(res.Body).Decode(&response); err != nil {
-		log.Println("ERROR: catalog repo CreateProduct: decode ID error", err)
-		return Product{}, errors.New("error decoding generated ID")
+	return product, nil
+}
+
+// deleteProduct undoes a CreateProduct whose event couldn't be stored. A
+// failure is only logged: the product then exists without its event.
+func (r *repository) deleteProduct(ctx context.Context, id string) {
+	res, err := esapi.DeleteRequest{
+		Index:      ESIndex,
+		DocumentID: id,
+		Refresh:    "true",
+	}.Do(ctx, r.client)
+	if err != nil {
+		log.Println("ERROR: catalog repo deleteProduct: ", err)
+		return
+	}
+	defer res.Body.Close()
+
+	if res.IsError() {
+		body, _ := io.ReadAll(res.Body)
+		log.Printf("ERROR: catalog repo deleteProduct: status=%d, body=%s", res.StatusCode, body)
+	}
+}
+
+// UpdateProduct replaces the product, then stores its ProductUpdated event
+// in the outbox index. As in CreateProduct, if the event can't be stored the
+// product is put back the way it was and the call fails.
+func (r *repository) UpdateProduct(ctx context.Context, id string, p productDocument) (Product, error) {
+	previous, err := r.GetProductByID(ctx, id)
+	if err != nil {
+		return Product{}, err
+	}
+
+	product := Product{
+		ID:          id,
+		Name:        p.Name,
+		Description: p.Description,
+		Price:       p.Price,
+		Currency:    p.Currency,
+		Prices:      p.Prices,
+		TaxCategory: p.TaxCategory,
+	}
+
+	event, err := events.New(EventProductUpdated, id, product)
+	if err != nil {
+		log.Println("ERROR: catalog repo UpdateProduct (events.New): ", err)
+		return Product{}, errors.New("error creating product event")
+	}
+
+	if err := r.indexProduct(ctx, id, p); err != nil {
+		return Product{}, err
+	}
+
+	if err := r.addToOutbox(ctx, event); err != nil {
+		r.indexProduct(context.WithoutCancel(ctx), id, productDocument{
+			Name:        previous.Name,
+			Description: previous.Description,
+			Price:       previous.Price,
+			Currency:    previous.Currency,
+			Prices:      previous.Prices,
+			TaxCategory: previous.TaxCategory,
+		})
+		return Product{}, err
+	}
+
+	return product, nil
+}
+
+// indexProduct creates or replaces the product document id.
+func (r *repository) indexProduct(ctx context.Context, id string, p productDocument) error {
+	productDoc, err := json.Marshal(p)
+	if err != nil {
+		log.Println("ERROR: catalog repo indexProduct: ", err)
+		return errors.New("error marshaling product")
+	}
+
+	res, err := esapi.IndexRequest{
+		Index:      ESIndex,
+		DocumentID: id,
+		Body:       bytes.NewReader(productDoc),
+		Refresh:    "true",
+	}.Do(ctx, r.client)
+	if err != nil {
+		log.Println("ERROR: catalog repo indexProduct: ", err)
+		return errors.New("error updating product in elastic search")
 	}
+	defer res.Body.Close()
 
-	response.Source.ID = response.ID
+	if res.IsError() {
+		body, _ := io.ReadAll(res.Body)
+		log.Printf("ERROR: catalog repo indexProduct: status=%d, body=%s", res.StatusCode, body)
+		return errors.New("error updating product in elastic search")
+	}
 
-	return response.Source, nil
+	return nil
 }
 
 func (r *repository) GetProductByID(ctx context.Context, id string) (Product, error) {
@@ -138,6 +358,10 @@ func (r *repository) GetProductByID(ctx context.Context, id string) (Product, er
 	}
 	defer res.Body.Close()
 
+	if res.StatusCode == 404 {
+		return Product{}, ErrProductNotFound
+	}
+
 	if res.IsError() {
 		body, _ := io.ReadAll(res.Body)
 		log.Printf("ERROR: catalog repo GetProductByID status=%d body=%s", res.StatusCode, body)
@@ -153,9 +377,7 @@ func (r *repository) GetProductByID(ctx context.Context, id string) (Product, er
 		return Product{}, errors.New("error decoding get product by id response")
 	}
 
-	response.Source.ID = id
-
-	return response.Source, nil
+	return fromSource(id, response.Source), nil
 }
 
 func (r *repository) ListProducts(ctx context.Context, offset, limit int32) ([]Product, error) {
@@ -201,8 +423,7 @@ func (r *repository) ListProducts(ctx context.Context, offset, limit int32) ([]P
 	products := []Product{}
 
 	for _, hit := range response.Hits.Hits {
-		hit.Source.ID = hit.ID
-		products = append(products, hit.Source)
+		products = append(products, fromSource(hit.ID, hit.Source))
 	}
 
 	return products, nil
@@ -255,8 +476,7 @@ func (r *repository) ListProductsWithIDs(ctx context.Context, ids []string) ([]P
 	products := []Product{}
 
 	for _, hit := range response.Hits.Hits {
-		hit.Source.ID = hit.ID
-		products = append(products, hit.Source)
+		products = append(products, fromSource(hit.ID, hit.Source))
 	}
 
 	return products, nil
@@ -308,9 +528,443 @@ func (r *repository) SearchProducts(ctx context.Context, query string, offset, l
 	products := []Product{}
 
 	for _, hit := range response.Hits.Hits {
-		hit.Source.ID = hit.ID
-		products = append(products, hit.Source)
+		products = append(products, fromSource(hit.ID, hit.Source))
 	}
 
 	return products, nil
 }
+
+// BackfillCurrency runs an update by query over the products without a
+// currency. Reads already fall back to money.DefaultCurrency, so a failed or
+// partial run only leaves documents for the next start.
+func (r *repository) BackfillCurrency(ctx context.Context, currency string) error {
+	query := map[string]any{
+		"query": map[string]any{
+			"bool": map[string]any{
+				"must_not": map[string]any{
+					"exists": map[string]any{"field": "currency"},
+				},
+			},
+		},
+		"script": map[string]any{
+			"source": "ctx._source.currency = params.currency",
+			"params": map[string]any{"currency": currency},
+		},
+	}
+
+	esQuery, err := json.Marshal(query)
+	if err != nil {
+		log.Println("ERROR: catalog repo BackfillCurrency: ", err)
+		return errors.New("error marshaling backfill currency query")
+	}
+
+	refresh := true
+	req := esapi.UpdateByQueryRequest{
+		Index:     []string{ESIndex},
+		Body:      bytes.NewReader(esQuery),
+		Conflicts: "proceed",
+		Refresh:   &refresh,
+	}
+
+	res, err := req.Do(ctx, r.client)
+	if err != nil {
+		log.Println("ERROR: catalog repo BackfillCurrency: ", err)
+		return errors.New("error backfilling product currency")
+	}
+	defer res.Body.Close()
+
+	if res.IsError() {
+		body, _ := io.ReadAll(res.Body)
+		log.Printf("ERROR: catalog repo BackfillCurrency: status=%d, body=%s", res.StatusCode, body)
+		return errors.New("error backfilling product currency")
+	}
+
+	return nil
+}
+
+// PutExchangeRate stores the rate, then its ExchangeRateChanged event in the
+// outbox index. If the event can't be stored, the pair's previous rate is
+// put back, or the new one removed if there was none.
+func (r *repository) PutExchangeRate(ctx context.Context, rate ExchangeRate) error {
+	previous, found, err := r.getExchangeRate(ctx, rate.From, rate.To)
+	if err != nil {
+		return err
+	}
+
+	event, err := events.New(EventExchangeRateChanged, exchangeRateID(rate.From, rate.To), rate)
+	if err != nil {
+		log.Println("ERROR: catalog repo PutExchangeRate (events.New): ", err)
+		return errors.New("error creating exchange rate event")
+	}
+
+	if err := r.indexExchangeRate(ctx, rate); err != nil {
+		return err
+	}
+
+	if err := r.addToOutbox(ctx, event); err != nil {
+		if found {
+			r.indexExchangeRate(context.WithoutCancel(ctx), previous)
+		} else {
+			r.removeExchangeRate(context.WithoutCancel(ctx), rate.From, rate.To)
+		}
+		return err
+	}
+
+	return nil
+}
+
+// getExchangeRate reports whether the pair has a rate, and returns it.
+func (r *repository) getExchangeRate(ctx context.Context, from, to string) (ExchangeRate, bool, error) {
+	res, err := esapi.GetRequest{
+		Index:      ESRatesIndex,
+		DocumentID: exchangeRateID(from, to),
+	}.Do(ctx, r.client)
+	if err != nil {
+		log.Println("ERROR: catalog repo getExchangeRate: ", err)
+		return ExchangeRate{}, false, errors.New("error getting exchange rate in elastic search")
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode == 404 {
+		return ExchangeRate{}, false, nil
+	}
+
+	if res.IsError() {
+		body, _ := io.ReadAll(res.Body)
+		log.Printf("ERROR: catalog repo getExchangeRate: status=%d, body=%s", res.StatusCode, body)
+		return ExchangeRate{}, false, errors.New("error getting exchange rate in elastic search")
+	}
+
+	var response struct {
+		Source ExchangeRate `json:"_source"`
+	}
+
+	if err := json.NewDecoder(res.Body).Decode(&response); err != nil {
+		log.Println("ERROR: catalog repo getExchangeRate: ", err)
+		return ExchangeRate{}, false, errors.New("error decoding exchange rate")
+	}
+
+	return response.Source, true, nil
+}
+
+func (r *repository) indexExchangeRate(ctx context.Context, rate ExchangeRate) error {
+	rateDoc, err := json.Marshal(rate)
+	if err != nil {
+		log.Println("ERROR: catalog repo indexExchangeRate: ", err)
+		return errors.New("error marshaling exchange rate")
+	}
+
+	req := esapi.IndexRequest{
+		Index:      ESRatesIndex,
+		DocumentID: exchangeRateID(rate.From, rate.To),
+		Body:       bytes.NewReader(rateDoc),
+		Refresh:    "true",
+	}
+
+	res, err := req.Do(ctx, r.client)
+	if err != nil {
+		log.Println("ERROR: catalog repo indexExchangeRate: ", err)
+		return errors.New("error storing exchange rate in elastic search")
+	}
+	defer res.Body.Close()
+
+	if res.IsError() {
+		body, _ := io.ReadAll(res.Body)
+		log.Printf("ERROR: catalog repo indexExchangeRate: status=%d, body=%s", res.StatusCode, body)
+		return errors.New("error storing exchange rate in elastic search")
+	}
+
+	return nil
+}
+
+// ListExchangeRates returns every rate; the table holds one per currency
+// pair, so it is small enough for a single page.
+func (r *repository) ListExchangeRates(ctx context.Context) ([]ExchangeRate, error) {
+	query := map[string]any{
+		"size": 1000,
+		"query": map[string]any{
+			"match_all": map[string]any{},
+		},
+		"sort": []any{
+			map[string]any{"from_currency.keyword": "asc"},
+			map[string]any{"to_currency.keyword": "asc"},
+		},
+	}
+
+	esQuery, err := json.Marshal(query)
+	if err != nil {
+		log.Println("ERROR: catalog repo ListExchangeRates: ", err)
+		return nil, errors.New("error marshaling query for ListExchangeRates")
+	}
+
+	req := esapi.SearchRequest{
+		Index: []string{ESRatesIndex},
+		Body:  bytes.NewReader(esQuery),
+	}
+
+	res, err := req.Do(ctx, r.client)
+	if err != nil {
+		log.Println("ERROR: catalog repo ListExchangeRates: ", err)
+		return nil, errors.New("error listing exchange rates")
+	}
+	defer res.Body.Close()
+
+	// no rate was ever set
+	if res.StatusCode == 404 {
+		return []ExchangeRate{}, nil
+	}
+
+	if res.IsError() {
+		body, _ := io.ReadAll(res.Body)
+		log.Printf("ERROR: catalog repo ListExchangeRates: status=%d, body=%s", res.StatusCode, body)
+		return nil, errors.New("error listing exchange rates")
+	}
+
+	var response struct {
+		Hits struct {
+			Hits []struct {
+				Source ExchangeRate `json:"_source"`
+			} `json:"hits"`
+		} `json:"hits"`
+	}
+
+	if err := json.NewDecoder(res.Body).Decode(&response); err != nil {
+		log.Println("ERROR: catalog repo ListExchangeRates: ", err)
+		return nil, errors.New("error decoding ListExchangeRates response")
+	}
+
+	rates := []ExchangeRate{}
+
+	for _, hit := range response.Hits.Hits {
+		rates = append(rates, hit.Source)
+	}
+
+	return rates, nil
+}
+
+// DeleteExchangeRate removes the rate, then stores its ExchangeRateDeleted
+// event in the outbox index, putting the rate back if the event can't be
+// stored.
+func (r *repository) DeleteExchangeRate(ctx context.Context, from, to string) error {
+	previous, found, err := r.getExchangeRate(ctx, from, to)
+	if err != nil {
+		return err
+	}
+	if !found {
+		return ErrExchangeRateNotFound
+	}
+
+	event, err := events.New(EventExchangeRateDeleted, exchangeRateID(from, to), previous)
+	if err != nil {
+		log.Println("ERROR: catalog repo DeleteExchangeRate (events.New): ", err)
+		return errors.New("error creating exchange rate event")
+	}
+
+	if err := r.removeExchangeRate(ctx, from, to); err != nil {
+		return err
+	}
+
+	if err := r.addToOutbox(ctx, event); err != nil {
+		r.indexExchangeRate(context.WithoutCancel(ctx), previous)
+		return err
+	}
+
+	return nil
+}
+
+func (r *repository) removeExchangeRate(ctx context.Context, from, to string) error {
+	req := esapi.DeleteRequest{
+		Index:      ESRatesIndex,
+		DocumentID: exchangeRateID(from, to),
+		Refresh:    "true",
+	}
+
+	res, err := req.Do(ctx, r.client)
+	if err != nil {
+		log.Println("ERROR: catalog repo removeExchangeRate: ", err)
+		return errors.New("error deleting exchange rate in elastic search")
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode == 404 {
+		return ErrExchangeRateNotFound
+	}
+
+	if res.IsError() {
+		body, _ := io.ReadAll(res.Body)
+		log.Printf("ERROR: catalog repo removeExchangeRate: status=%d, body=%s", res.StatusCode, body)
+		return errors.New("error deleting exchange rate in elastic search")
+	}
+
+	return nil
+}
+
+// fromSource fills in what documents written by older versions lack.
+func fromSource(id string, p Product) Product {
+	p.ID = id
+
+	if p.Currency == "" {
+		p.Currency = money.DefaultCurrency
+	}
+	if p.TaxCategory == "" {
+		p.TaxCategory = DefaultTaxCategory
+	}
+	if p.Prices == nil {
+		p.Prices = []money.Money{}
+	}
+
+	return p
+}
+
+// auditDocument stores an entry's before and after as strings, so the
+// entities' fields don't end up in the index mapping.
+type auditDocument struct {
+	Actor      string    `json:"actor"`
+	Action     string    `json:"action"`
+	EntityType string    `json:"entity_type"`
+	EntityID   string    `json:"entity_id"`
+	Before     string    `json:"before"`
+	After      string    `json:"after"`
+	RequestID  string    `json:"request_id"`
+	OccurredAt time.Time `json:"occurred_at"`
+}
+
+func (r *repository) RecordAudit(ctx context.Context, e audit.Entry) error {
+	auditDoc, err := json.Marshal(auditDocument{
+		Actor:      e.Actor,
+		Action:     e.Action,
+		EntityType: e.EntityType,
+		EntityID:   e.EntityID,
+		Before:     string(e.Before),
+		After:      string(e.After),
+		RequestID:  e.RequestID,
+		OccurredAt: e.OccurredAt,
+	})
+	if err != nil {
+		log.Println("ERROR: catalog repo RecordAudit: ", err)
+		return errors.New("error marshaling audit entry")
+	}
+
+	// create, not index, so an entry can't be overwritten
+	req := esapi.IndexRequest{
+		Index:      ESAuditIndex,
+		DocumentID: e.ID,
+		OpType:     "create",
+		Body:       bytes.NewReader(auditDoc),
+		Refresh:    "true",
+	}
+
+	res, err := req.Do(ctx, r.client)
+	if err != nil {
+		log.Println("ERROR: catalog repo RecordAudit: ", err)
+		return errors.New("error recording audit entry in elastic search")
+	}
+	defer res.Body.Close()
+
+	if res.IsError() {
+		body, _ := io.ReadAll(res.Body)
+		log.Printf("ERROR: catalog repo RecordAudit: status=%d, body=%s", res.StatusCode, body)
+		return errors.New("error recording audit entry in elastic search")
+	}
+
+	return nil
+}
+
+func (r *repository) QueryAuditLog(ctx context.Context, q audit.Query) ([]audit.Entry, error) {
+	filters := []any{}
+
+	for field, value := range map[string]string{
+		"entity_type.keyword": q.EntityType,
+		"entity_id.keyword":   q.EntityID,
+		"actor.keyword":       q.Actor,
+	} {
+		if value != "" {
+			filters = append(filters, map[string]any{"term": map[string]any{field: value}})
+		}
+	}
+
+	occurredAt := map[string]any{}
+	if !q.From.IsZero() {
+		occurredAt["gte"] = q.From
+	}
+	if !q.To.IsZero() {
+		occurredAt["lt"] = q.To
+	}
+	if len(occurredAt) > 0 {
+		filters = append(filters, map[string]any{"range": map[string]any{"occurred_at": occurredAt}})
+	}
+
+	query := map[string]any{
+		"query": map[string]any{
+			"bool": map[string]any{"filter": filters},
+		},
+		// the index has no mapping for occurred_at until the first entry
+		"sort": []any{
+			map[string]any{"occurred_at": map[string]any{"order": "desc", "unmapped_type": "date"}},
+		},
+		"from": q.Offset,
+		"size": q.Limit,
+	}
+
+	esQuery, err := json.Marshal(query)
+	if err != nil {
+		log.Println("ERROR: catalog repo QueryAuditLog: ", err)
+		return nil, errors.New("error marshaling query for QueryAuditLog")
+	}
+
+	req := esapi.SearchRequest{
+		Index: []string{ESAuditIndex},
+		Body:  bytes.NewReader(esQuery),
+	}
+
+	res, err := req.Do(ctx, r.client)
+	if err != nil {
+		log.Println("ERROR: catalog repo QueryAuditLog: ", err)
+		return nil, errors.New("error querying audit log")
+	}
+	defer res.Body.Close()
+
+	// nothing was ever audited
+	if res.StatusCode == 404 {
+		return []audit.Entry{}, nil
+	}
+
+	if res.IsError() {
+		body, _ := io.ReadAll(res.Body)
+		log.Printf("ERROR: catalog repo QueryAuditLog: status=%d, body=%s", res.StatusCode, body)
+		return nil, errors.New("error querying audit log")
+	}
+
+	var response struct {
+		Hits struct {
+			Hits []struct {
+				ID     string        `json:"_id"`
+				Source auditDocument `json:"_source"`
+			} `json:"hits"`
+		} `json:"hits"`
+	}
+
+	if err := json.NewDecoder(res.Body).Decode(&response); err != nil {
+		log.Println("ERROR: catalog repo QueryAuditLog: ", err)
+		return nil, errors.New("error decoding QueryAuditLog response")
+	}
+
+	entries := []audit.Entry{}
+
+	for _, hit := range response.Hits.Hits {
+		d := hit.Source
+		entries = append(entries, audit.Entry{
+			ID:         hit.ID,
+			Actor:      d.Actor,
+			Action:     d.Action,
+			EntityType: d.EntityType,
+			EntityID:   d.EntityID,
+			Before:     json.RawMessage(d.Before),
+			After:      json.RawMessage(d.After),
+			RequestID:  d.RequestID,
+			OccurredAt: d.OccurredAt,
+		})
+	}
+
+	return entries, nil
+}
@@ -2,8 +2,15 @@ package main
 
 import (
 	"context"
+	"errors"
+	"log"
 
+	"github.com/airlangga-hub/microservices/audit"
+	"github.com/airlangga-hub/microservices/auth"
 	"github.com/airlangga-hub/microservices/catalog/pb"
+	"github.com/airlangga-hub/microservices/money"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
 )
 
 type Server struct {
@@ -12,34 +19,52 @@ type Server struct {
 }
 
 func (s *Server) PostProduct(ctx context.Context, r *pb.PostProductRequest) (*pb.PostProductResponse, error) {
-	product, err := s.Svc.CreateProduct(ctx, r.Name, r.Description, r.Price)
+	if err := auth.Require(ctx, permissionWriteProducts); err != nil {
+		return nil, err
+	}
+
+	product, err := s.Svc.CreateProduct(ctx, r.Name, r.Description, money.New(r.Price.GetCurrency(), r.Price.GetUnits()), priceList(r.Prices), r.TaxCategory)
+	if errors.Is(err, ErrInvalidPrice) {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
 	if err != nil {
 		return nil, err
 	}
 
 	return &pb.PostProductResponse{
-		Product: &pb.Product{
-			Id:          product.ID,
-			Name:        product.Name,
-			Description: product.Description,
-			Price:       product.Price,
-		},
+		Product: pbProduct(product),
 	}, nil
 }
 
-func (s *Server) GetProduct(ctx context.Context, r *pb.GetProductRequest) (*pb.GetProductResponse, error) {
-	product, err := s.Svc.GetProductByID(ctx, r.Id)
+func (s *Server) UpdateProduct(ctx context.Context, r *pb.UpdateProductRequest) (*pb.UpdateProductResponse, error) {
+	if err := auth.Require(ctx, permissionWriteProducts); err != nil {
+		return nil, err
+	}
+
+	product, err := s.Svc.UpdateProduct(ctx, r.Id, r.Name, r.Description, money.New(r.Price.GetCurrency(), r.Price.GetUnits()), priceList(r.Prices), r.TaxCategory)
+	if errors.Is(err, ErrInvalidPrice) {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	if errors.Is(err, ErrProductNotFound) {
+		return nil, status.Errorf(codes.NotFound, "no product %s", r.Id)
+	}
 	if err != nil {
 		return nil, err
 	}
 
+	return &pb.UpdateProductResponse{
+		Product: pbProduct(product),
+	}, nil
+}
+
+func (s *Server) GetProduct(ctx context.Context, r *pb.GetProductRequest) (*pb.GetProductResponse, error) {
+	product, err := s.Svc.GetProductByID(ctx, r.Id, r.Currency)
+	if err != nil {
+		return nil, pricingError(err)
+	}
+
 	return &pb.GetProductResponse{
-		Product: &pb.Product{
-			Id:          product.ID,
-			Name:        product.Name,
-			Description: product.Description,
-			Price:       product.Price,
-		},
+		Product: pbProduct(product),
 	}, nil
 }
 
@@ -48,32 +73,184 @@ func (s *Server) GetProducts(ctx context.Context, r *pb.GetProductsRequest) (*pb
 	var err error
 
 	if r.Query != "" {
-		products, err = s.Svc.SearchProducts(ctx, r.Query, r.Offset, r.Limit)
+		products, err = s.Svc.SearchProducts(ctx, r.Query, r.Offset, r.Limit, r.Currency)
 	} else if len(r.Ids) > 0 {
-		products, err = s.Svc.GetProductsByIDs(ctx, r.Ids)
+		products, err = s.Svc.GetProductsByIDs(ctx, r.Ids, r.Currency)
 	} else {
-		products, err = s.Svc.GetProducts(ctx, r.Offset, r.Limit)
+		products, err = s.Svc.GetProducts(ctx, r.Offset, r.Limit, r.Currency)
 	}
 
 	if err != nil {
-		return nil, err
+		return nil, pricingError(err)
 	}
 
 	pbProducts := []*pb.Product{}
 
 	for _, p := range products {
-		pbProducts = append(
-			pbProducts,
-			&pb.Product{
-				Id:          p.ID,
-				Name:        p.Name,
-				Description: p.Description,
-				Price:       p.Price,
-			},
-		)
+		pbProducts = append(pbProducts, pbProduct(p))
 	}
 
 	return &pb.GetProductsResponse{
 		Products: pbProducts,
 	}, nil
 }
+
+func (s *Server) SetExchangeRate(ctx context.Context, r *pb.SetExchangeRateRequest) (*pb.SetExchangeRateResponse, error) {
+	if err := auth.Require(ctx, permissionWriteExchangeRates); err != nil {
+		return nil, err
+	}
+
+	rate, err := s.Svc.SetExchangeRate(ctx, r.FromCurrency, r.ToCurrency, r.Rate)
+	if errors.Is(err, ErrInvalidExchangeRate) {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return &pb.SetExchangeRateResponse{ExchangeRate: pbExchangeRate(rate)}, nil
+}
+
+func (s *Server) ListExchangeRates(ctx context.Context, r *pb.ListExchangeRatesRequest) (*pb.ListExchangeRatesResponse, error) {
+	rates, err := s.Svc.ListExchangeRates(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	pbRates := []*pb.ExchangeRate{}
+
+	for _, rate := range rates {
+		pbRates = append(pbRates, pbExchangeRate(rate))
+	}
+
+	return &pb.ListExchangeRatesResponse{ExchangeRates: pbRates}, nil
+}
+
+func (s *Server) DeleteExchangeRate(ctx context.Context, r *pb.DeleteExchangeRateRequest) (*pb.DeleteExchangeRateResponse, error) {
+	if err := auth.Require(ctx, permissionWriteExchangeRates); err != nil {
+		return nil, err
+	}
+
+	err := s.Svc.DeleteExchangeRate(ctx, r.FromCurrency, r.ToCurrency)
+	if errors.Is(err, ErrExchangeRateNotFound) {
+		return nil, status.Errorf(codes.NotFound, "no %s to %s exchange rate", r.FromCurrency, r.ToCurrency)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return &pb.DeleteExchangeRateResponse{}, nil
+}
+
+// QueryAuditLog returns audit entries newest first, filtered by entity,
+// actor and an occurred_at range, to callers that may read the audit log.
+func (s *Server) QueryAuditLog(ctx context.Context, r *pb.QueryCatalogAuditLogRequest) (*pb.QueryCatalogAuditLogResponse, error) {
+	if err := auth.Require(ctx, permissionReadAudit); err != nil {
+		return nil, err
+	}
+
+	q := audit.Query{EntityType: r.EntityType, EntityID: r.EntityId, Actor: r.Actor, Offset: r.Offset, Limit: r.Limit}
+
+	if len(r.From) > 0 {
+		if err := q.From.UnmarshalBinary(r.From); err != nil {
+			return nil, status.Error(codes.InvalidArgument, "invalid from")
+		}
+	}
+	if len(r.To) > 0 {
+		if err := q.To.UnmarshalBinary(r.To); err != nil {
+			return nil, status.Error(codes.InvalidArgument, "invalid to")
+		}
+	}
+
+	entries, err := s.Svc.QueryAuditLog(ctx, q)
+	if err != nil {
+		return nil, err
+	}
+
+	pbEntries := []*pb.CatalogAuditEntry{}
+
+	for _, e := range entries {
+		occurredAt, err := e.OccurredAt.MarshalBinary()
+		if err != nil {
+			log.Println("ERROR: catalog server QueryAuditLog (MarshalBinary): ", err)
+			return nil, errors.New("error encoding audit entry")
+		}
+
+		pbEntries = append(pbEntries, &pb.CatalogAuditEntry{
+			Id:         e.ID,
+			Actor:      e.Actor,
+			Action:     e.Action,
+			EntityType: e.EntityType,
+			EntityId:   e.EntityID,
+			Before:     string(e.Before),
+			After:      string(e.After),
+			RequestId:  e.RequestID,
+			OccurredAt: occurredAt,
+		})
+	}
+
+	return &pb.QueryCatalogAuditLogResponse{Entries: pbEntries}, nil
+}
+
+// pricingError maps the errors of pricing products in a currency to a
+// status.
+func pricingError(err error) error {
+	switch {
+	case errors.Is(err, money.ErrInvalidCurrency):
+		return status.Error(codes.InvalidArgument, err.Error())
+	case errors.Is(err, ErrNoExchangeRate):
+		return status.Error(codes.FailedPrecondition, err.Error())
+	case errors.Is(err, ErrProductNotFound):
+		return status.Error(codes.NotFound, err.Error())
+	default:
+		return err
+	}
+}
+
+// priceList reads a price list.
+func priceList(pbPrices []*pb.Money) []money.Money {
+	prices := []money.Money{}
+	for _, p := range pbPrices {
+		prices = append(prices, money.New(p.GetCurrency(), p.GetUnits()))
+	}
+
+	return prices
+}
+
+func pbProduct(p Product) *pb.Product {
+	prices := []*pb.Money{}
+	for _, m := range p.Prices {
+		prices = append(prices, &pb.Money{Currency: m.Currency, Units: m.Units})
+	}
+
+	product := &pb.Product{
+		Id:          p.ID,
+		Name:        p.Name,
+		Description: p.Description,
+		Price:       pbPrice(p),
+		TaxCategory: p.TaxCategory,
+		Prices:      prices,
+	}
+
+	if p.ExchangeRate != nil {
+		product.ExchangeRate = pbExchangeRate(*p.ExchangeRate)
+	}
+
+	return product
+}
+
+func pbPrice(p Product) *pb.Money {
+	return &pb.Money{Currency: p.Currency, Units: p.Price}
+}
+
+func pbExchangeRate(r ExchangeRate) *pb.ExchangeRate {
+	// rates are stored in UTC, which always marshals
+	updatedAt, _ := r.UpdatedAt.MarshalBinary()
+
+	return &pb.ExchangeRate{
+		FromCurrency: r.From,
+		ToCurrency:   r.To,
+		Rate:         r.Rate,
+		UpdatedAt:    updatedAt,
+	}
+}
@@ -0,0 +1,424 @@
+package main
+
+import (
+	"context"
+	"net"
+	"strings"
+	"testing"
+
+	"github.com/airlangga-hub/microservices/audit"
+	"github.com/airlangga-hub/microservices/auth"
+	accpb "github.com/airlangga-hub/microservices/catalog/account_pb"
+	"github.com/airlangga-hub/microservices/catalog/pb"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+)
+
+// startServer serves the catalog service over an in-process bufconn listener
+// backed by an in-memory repository.
+func startServer(t *testing.T) pb.CatalogServiceClient {
+	t.Helper()
+
+	return serveRepository(t, NewMemoryRepository())
+}
+
+// serveRepository is startServer backed by repo.
+func serveRepository(t *testing.T, repo Repository) pb.CatalogServiceClient {
+	t.Helper()
+
+	lis := bufconn.Listen(1 << 20)
+
+	server := &Server{Svc: NewService(repo)}
+
+	s := grpc.NewServer(grpc.ChainUnaryInterceptor(
+		auth.UnaryInterceptor(apiKeyAuthenticator(fakeAccounts{}), methodScopes),
+		audit.Interceptor("catalog", repo, server.auditSpecs()),
+	))
+	pb.RegisterCatalogServiceServer(s, server)
+
+	go s.Serve(lis)
+	t.Cleanup(s.Stop)
+
+	conn, err := grpc.NewClient(
+		"passthrough:///bufnet",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) { return lis.DialContext(ctx) }),
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+	)
+	if err != nil {
+		t.Fatalf("grpc.NewClient: %v", err)
+	}
+	t.Cleanup(func() { conn.Close() })
+
+	return pb.NewCatalogServiceClient(conn)
+}
+
+// Keys fakeAccounts authenticates: an admin's key for everything, a
+// customer's for reading products, and a customer's for everything, which
+// has no permissions since the customer has no role.
+const (
+	adminKey    = "admin-key"
+	readerKey   = "reader-key"
+	customerKey = "customer-key"
+)
+
+// fakeAccounts stands in for the account service's AuthenticateAPIKey.
+type fakeAccounts struct {
+	accpb.AccountServiceClient
+}
+
+func (fakeAccounts) AuthenticateAPIKey(ctx context.Context, r *accpb.AuthenticateAPIKeyRequest, _ ...grpc.CallOption) (*accpb.AuthenticateAPIKeyResponse, error) {
+	switch r.Key {
+	case adminKey:
+		return &accpb.AuthenticateAPIKeyResponse{
+			ApiKey:      &accpb.APIKey{AccountId: 1, Scopes: []string{"*:*:*"}},
+			Permissions: []string{permissionWriteProducts, permissionWriteExchangeRates, permissionReadAudit},
+		}, nil
+	case readerKey:
+		return &accpb.AuthenticateAPIKeyResponse{ApiKey: &accpb.APIKey{AccountId: 2, Scopes: []string{"catalog:products:read"}}}, nil
+	case customerKey:
+		return &accpb.AuthenticateAPIKeyResponse{ApiKey: &accpb.APIKey{AccountId: 3, Scopes: []string{"*:*:*"}}}, nil
+	default:
+		return nil, status.Error(codes.Unauthenticated, "api key is unknown, revoked or expired")
+	}
+}
+
+// withKey returns a context sending key.
+func withKey(key string) context.Context {
+	return metadata.AppendToOutgoingContext(context.Background(), auth.APIKeyMetadataKey, key)
+}
+
+func postProducts(t *testing.T, client pb.CatalogServiceClient, products ...*pb.PostProductRequest) []*pb.Product {
+	t.Helper()
+
+	posted := []*pb.Product{}
+	for _, p := range products {
+		res, err := client.PostProduct(withKey(adminKey), p)
+		if err != nil {
+			t.Fatalf("PostProduct: %v", err)
+		}
+		posted = append(posted, res.Product)
+	}
+
+	return posted
+}
+
+func TestGetProductsByIDs(t *testing.T) {
+	client := startServer(t)
+
+	posted := postProducts(t, client,
+		&pb.PostProductRequest{Name: "Keyboard", Description: "mechanical", Price: &pb.Money{Currency: "USD", Units: 100}},
+		&pb.PostProductRequest{Name: "Mouse", Description: "wireless", Price: &pb.Money{Currency: "USD", Units: 50}},
+	)
+
+	res, err := client.GetProducts(context.Background(), &pb.GetProductsRequest{Ids: []string{posted[1].Id, "missing"}})
+	if err != nil {
+		t.Fatalf("GetProducts: %v", err)
+	}
+
+	if len(res.Products) != 1 || res.Products[0].Name != "Mouse" {
+		t.Errorf("GetProducts(ids) = %v, want only Mouse", res.Products)
+	}
+}
+
+func TestSearchProductsPrefersNameMatches(t *testing.T) {
+	client := startServer(t)
+
+	postProducts(t, client,
+		&pb.PostProductRequest{Name: "Cable", Description: "fits any keyboard", Price: &pb.Money{Currency: "USD", Units: 5}},
+		&pb.PostProductRequest{Name: "Keyboard", Description: "mechanical", Price: &pb.Money{Currency: "USD", Units: 100}},
+		&pb.PostProductRequest{Name: "Mouse", Description: "wireless", Price: &pb.Money{Currency: "USD", Units: 50}},
+	)
+
+	res, err := client.GetProducts(context.Background(), &pb.GetProductsRequest{Query: "keyboard"})
+	if err != nil {
+		t.Fatalf("GetProducts: %v", err)
+	}
+
+	if len(res.Products) != 2 || res.Products[0].Name != "Keyboard" || res.Products[1].Name != "Cable" {
+		t.Errorf("GetProducts(query) = %v, want [Keyboard Cable]", res.Products)
+	}
+}
+
+func TestGetProductNotFound(t *testing.T) {
+	client := startServer(t)
+
+	if _, err := client.GetProduct(context.Background(), &pb.GetProductRequest{Id: "missing"}); status.Code(err) != codes.NotFound {
+		t.Fatalf("GetProduct of a missing product error = %v, want NotFound", err)
+	}
+}
+
+func TestUpdateProduct(t *testing.T) {
+	client := startServer(t)
+	ctx := withKey(adminKey)
+
+	posted := postProducts(t, client, &pb.PostProductRequest{Name: "Keyboard", Description: "Loud", Price: &pb.Money{Currency: "USD", Units: 100}, TaxCategory: "reduced"})
+
+	res, err := client.UpdateProduct(ctx, &pb.UpdateProductRequest{
+		Id:          posted[0].Id,
+		Name:        "Quiet keyboard",
+		Description: "Quiet",
+		Price:       &pb.Money{Units: 120},
+		Prices:      []*pb.Money{{Currency: "EUR", Units: 110}},
+	})
+	if err != nil {
+		t.Fatalf("UpdateProduct: %v", err)
+	}
+
+	got, err := client.GetProduct(ctx, &pb.GetProductRequest{Id: posted[0].Id})
+	if err != nil {
+		t.Fatalf("GetProduct: %v", err)
+	}
+	for _, p := range []*pb.Product{res.Product, got.Product} {
+		if p.Name != "Quiet keyboard" || p.Description != "Quiet" || p.Price.Units != 120 || p.Price.Currency != "USD" || p.TaxCategory != "standard" || len(p.Prices) != 1 {
+			t.Errorf("updated product = %v, want every field replaced, with the default currency and tax category", p)
+		}
+	}
+
+	for _, tt := range []struct {
+		name string
+		ctx  context.Context
+		req  *pb.UpdateProductRequest
+		code codes.Code
+	}{
+		{"missing product", ctx, &pb.UpdateProductRequest{Id: "missing", Name: "Mouse"}, codes.NotFound},
+		{"negative price", ctx, &pb.UpdateProductRequest{Id: posted[0].Id, Price: &pb.Money{Units: -1}}, codes.InvalidArgument},
+		{"anonymous", context.Background(), &pb.UpdateProductRequest{Id: posted[0].Id}, codes.Unauthenticated},
+		{"customer", withKey(customerKey), &pb.UpdateProductRequest{Id: posted[0].Id}, codes.PermissionDenied},
+	} {
+		if _, err := client.UpdateProduct(tt.ctx, tt.req); status.Code(err) != tt.code {
+			t.Errorf("UpdateProduct of a %s error = %v, want %v", tt.name, err, tt.code)
+		}
+	}
+
+	audited, err := client.QueryAuditLog(ctx, &pb.QueryCatalogAuditLogRequest{EntityType: "product", EntityId: posted[0].Id})
+	if err != nil {
+		t.Fatalf("QueryAuditLog: %v", err)
+	}
+	if len(audited.Entries) != 2 || audited.Entries[0].Action != "UpdateProduct" || !strings.Contains(audited.Entries[0].Before, "Loud") || !strings.Contains(audited.Entries[0].After, "Quiet keyboard") {
+		t.Errorf("product entries = %v, want the UpdateProduct with the product before and after", audited.Entries)
+	}
+}
+
+func TestPostProductPrice(t *testing.T) {
+	client := startServer(t)
+
+	posted := postProducts(t, client, &pb.PostProductRequest{Name: "Keyboard", Price: &pb.Money{Units: 100}})
+	if posted[0].Price.Currency != "USD" {
+		t.Errorf("price currency = %q, want the default USD", posted[0].Price.Currency)
+	}
+
+	for _, price := range []*pb.Money{{Currency: "usd", Units: 100}, {Currency: "EUR", Units: -1}} {
+		_, err := client.PostProduct(withKey(adminKey), &pb.PostProductRequest{Name: "Mouse", Price: price})
+		if status.Code(err) != codes.InvalidArgument {
+			t.Errorf("PostProduct(%v) error = %v, want InvalidArgument", price, err)
+		}
+	}
+}
+
+func TestGetProductsInCurrency(t *testing.T) {
+	client := startServer(t)
+	ctx := withKey(adminKey)
+
+	posted := postProducts(t, client,
+		&pb.PostProductRequest{Name: "Keyboard", Price: &pb.Money{Currency: "USD", Units: 1000}, Prices: []*pb.Money{{Currency: "EUR", Units: 899}}},
+		&pb.PostProductRequest{Name: "Mouse", Price: &pb.Money{Currency: "USD", Units: 500}},
+	)
+	ids := []string{posted[0].Id, posted[1].Id}
+
+	_, err := client.GetProducts(ctx, &pb.GetProductsRequest{Ids: ids, Currency: "EUR"})
+	if status.Code(err) != codes.FailedPrecondition {
+		t.Fatalf("GetProducts(EUR) without a rate error = %v, want FailedPrecondition", err)
+	}
+
+	if _, err := client.SetExchangeRate(ctx, &pb.SetExchangeRateRequest{FromCurrency: "USD", ToCurrency: "EUR", Rate: "0.92"}); err != nil {
+		t.Fatalf("SetExchangeRate: %v", err)
+	}
+
+	res, err := client.GetProducts(ctx, &pb.GetProductsRequest{Ids: ids, Currency: "EUR"})
+	if err != nil {
+		t.Fatalf("GetProducts(EUR): %v", err)
+	}
+
+	keyboard, mouse := res.Products[0], res.Products[1]
+	if keyboard.Price.Currency != "EUR" || keyboard.Price.Units != 899 || keyboard.ExchangeRate != nil {
+		t.Errorf("keyboard = %v, want its EUR list price", keyboard)
+	}
+	if mouse.Price.Currency != "EUR" || mouse.Price.Units != 460 || mouse.ExchangeRate.GetRate() != "0.92" {
+		t.Errorf("mouse = %v, want EUR 460 converted at 0.92", mouse)
+	}
+
+	got, err := client.GetProduct(ctx, &pb.GetProductRequest{Id: posted[1].Id})
+	if err != nil {
+		t.Fatalf("GetProduct: %v", err)
+	}
+	if got.Product.Price.Currency != "USD" || got.Product.Price.Units != 500 {
+		t.Errorf("GetProduct without a currency price = %v, want the base USD 500", got.Product.Price)
+	}
+
+	if _, err := client.GetProducts(ctx, &pb.GetProductsRequest{Ids: ids, Currency: "eur"}); status.Code(err) != codes.InvalidArgument {
+		t.Errorf("GetProducts(eur) error = %v, want InvalidArgument", err)
+	}
+}
+
+func TestPostProductPriceList(t *testing.T) {
+	client := startServer(t)
+
+	for _, prices := range [][]*pb.Money{
+		{{Currency: "USD", Units: 100}},
+		{{Currency: "EUR", Units: 90}, {Currency: "EUR", Units: 95}},
+		{{Currency: "EUR", Units: -1}},
+	} {
+		_, err := client.PostProduct(withKey(adminKey), &pb.PostProductRequest{Name: "Keyboard", Price: &pb.Money{Currency: "USD", Units: 100}, Prices: prices})
+		if status.Code(err) != codes.InvalidArgument {
+			t.Errorf("PostProduct(prices %v) error = %v, want InvalidArgument", prices, err)
+		}
+	}
+}
+
+func TestAPIKeysAreChecked(t *testing.T) {
+	client := startServer(t)
+
+	for _, tt := range []struct {
+		name string
+		ctx  context.Context
+		code codes.Code
+	}{
+		{"no key", context.Background(), codes.OK},
+		{"scoped key", withKey(readerKey), codes.OK},
+		{"wildcard key", withKey(adminKey), codes.OK},
+		{"unknown key", withKey("made-up"), codes.Unauthenticated},
+	} {
+		if _, err := client.GetProducts(tt.ctx, &pb.GetProductsRequest{}); status.Code(err) != tt.code {
+			t.Errorf("GetProducts with %s error = %v, want %v", tt.name, err, tt.code)
+		}
+	}
+
+	_, err := client.PostProduct(withKey(readerKey), &pb.PostProductRequest{Name: "Keyboard", Price: &pb.Money{Currency: "USD", Units: 100}})
+	if status.Code(err) != codes.PermissionDenied {
+		t.Errorf("PostProduct with a key only scoped to read error = %v, want PermissionDenied", err)
+	}
+}
+
+func TestWritesNeedPermissions(t *testing.T) {
+	client := startServer(t)
+
+	for _, tt := range []struct {
+		name string
+		ctx  context.Context
+		code codes.Code
+	}{
+		{"anonymous", context.Background(), codes.Unauthenticated},
+		{"customer", withKey(customerKey), codes.PermissionDenied},
+		{"admin", withKey(adminKey), codes.OK},
+	} {
+		_, err := client.PostProduct(tt.ctx, &pb.PostProductRequest{Name: "Keyboard", Price: &pb.Money{Currency: "USD", Units: 100}})
+		if status.Code(err) != tt.code {
+			t.Errorf("PostProduct as %s error = %v, want %v", tt.name, err, tt.code)
+		}
+
+		_, err = client.SetExchangeRate(tt.ctx, &pb.SetExchangeRateRequest{FromCurrency: "USD", ToCurrency: "EUR", Rate: "0.92"})
+		if status.Code(err) != tt.code {
+			t.Errorf("SetExchangeRate as %s error = %v, want %v", tt.name, err, tt.code)
+		}
+
+		_, err = client.DeleteExchangeRate(tt.ctx, &pb.DeleteExchangeRateRequest{FromCurrency: "USD", ToCurrency: "EUR"})
+		if status.Code(err) != tt.code {
+			t.Errorf("DeleteExchangeRate as %s error = %v, want %v", tt.name, err, tt.code)
+		}
+	}
+}
+
+func TestExchangeRates(t *testing.T) {
+	client := startServer(t)
+	ctx := withKey(adminKey)
+
+	for _, r := range []*pb.SetExchangeRateRequest{
+		{FromCurrency: "USD", ToCurrency: "USD", Rate: "1"},
+		{FromCurrency: "USD", ToCurrency: "EUR", Rate: "0"},
+		{FromCurrency: "USD", ToCurrency: "EUR", Rate: "-0.9"},
+		{FromCurrency: "USD", ToCurrency: "EUR", Rate: "9e-1"},
+	} {
+		if _, err := client.SetExchangeRate(ctx, r); status.Code(err) != codes.InvalidArgument {
+			t.Errorf("SetExchangeRate(%v) error = %v, want InvalidArgument", r, err)
+		}
+	}
+
+	for _, rate := range []string{"0.90", "0.92"} {
+		if _, err := client.SetExchangeRate(ctx, &pb.SetExchangeRateRequest{FromCurrency: "USD", ToCurrency: "EUR", Rate: rate}); err != nil {
+			t.Fatalf("SetExchangeRate: %v", err)
+		}
+	}
+
+	res, err := client.ListExchangeRates(ctx, &pb.ListExchangeRatesRequest{})
+	if err != nil {
+		t.Fatalf("ListExchangeRates: %v", err)
+	}
+	if len(res.ExchangeRates) != 1 || res.ExchangeRates[0].Rate != "0.92" {
+		t.Errorf("ListExchangeRates = %v, want only the latest USD to EUR rate", res.ExchangeRates)
+	}
+
+	if _, err := client.DeleteExchangeRate(ctx, &pb.DeleteExchangeRateRequest{FromCurrency: "USD", ToCurrency: "EUR"}); err != nil {
+		t.Fatalf("DeleteExchangeRate: %v", err)
+	}
+	if _, err := client.DeleteExchangeRate(ctx, &pb.DeleteExchangeRateRequest{FromCurrency: "USD", ToCurrency: "EUR"}); status.Code(err) != codes.NotFound {
+		t.Errorf("DeleteExchangeRate of a deleted rate error = %v, want NotFound", err)
+	}
+}
+
+func TestAuditLog(t *testing.T) {
+	client := startServer(t)
+	// x-actor is ignored: calls are attributed to the key's account
+	ctx := metadata.AppendToOutgoingContext(withKey(adminKey), "x-actor", "spoofed")
+
+	posted := postProducts(t, client, &pb.PostProductRequest{Name: "Keyboard", Price: &pb.Money{Currency: "USD", Units: 100}})
+
+	for _, rate := range []string{"0.90", "0.92"} {
+		if _, err := client.SetExchangeRate(ctx, &pb.SetExchangeRateRequest{FromCurrency: "USD", ToCurrency: "EUR", Rate: rate}); err != nil {
+			t.Fatalf("SetExchangeRate(%s): %v", rate, err)
+		}
+	}
+
+	if _, err := client.DeleteExchangeRate(ctx, &pb.DeleteExchangeRateRequest{FromCurrency: "USD", ToCurrency: "EUR"}); err != nil {
+		t.Fatalf("DeleteExchangeRate: %v", err)
+	}
+
+	res, err := client.QueryAuditLog(ctx, &pb.QueryCatalogAuditLogRequest{EntityType: "exchange_rate", Actor: "account:1"})
+	if err != nil {
+		t.Fatalf("QueryAuditLog: %v", err)
+	}
+
+	if len(res.Entries) != 3 {
+		t.Fatalf("QueryAuditLog returned %d entries, want 3: %v", len(res.Entries), res.Entries)
+	}
+
+	deleted, changed, created := res.Entries[0], res.Entries[1], res.Entries[2]
+
+	if deleted.Action != "DeleteExchangeRate" || !strings.Contains(deleted.Before, "0.92") || deleted.After != "" {
+		t.Errorf("DeleteExchangeRate entry = %v, want the 0.92 rate before and nothing after", deleted)
+	}
+	if changed.Action != "SetExchangeRate" || !strings.Contains(changed.Before, "0.90") || !strings.Contains(changed.After, "0.92") {
+		t.Errorf("second SetExchangeRate entry = %v, want 0.90 before and 0.92 after", changed)
+	}
+	if created.EntityId != "USD-EUR" || created.Before != "" {
+		t.Errorf("first SetExchangeRate entry = %v, want USD-EUR with nothing before", created)
+	}
+
+	products, err := client.QueryAuditLog(ctx, &pb.QueryCatalogAuditLogRequest{EntityType: "product", EntityId: posted[0].Id})
+	if err != nil {
+		t.Fatalf("QueryAuditLog of the product: %v", err)
+	}
+	if len(products.Entries) != 1 || products.Entries[0].Action != "PostProduct" || products.Entries[0].Actor != "account:1" {
+		t.Errorf("product entries = %v, want its PostProduct by account:1", products.Entries)
+	}
+
+	if _, err := client.QueryAuditLog(context.Background(), &pb.QueryCatalogAuditLogRequest{}); status.Code(err) != codes.Unauthenticated {
+		t.Errorf("QueryAuditLog without a key error = %v, want Unauthenticated", err)
+	}
+	if _, err := client.QueryAuditLog(withKey(customerKey), &pb.QueryCatalogAuditLogRequest{}); status.Code(err) != codes.PermissionDenied {
+		t.Errorf("QueryAuditLog by a customer error = %v, want PermissionDenied", err)
+	}
+}
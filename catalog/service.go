@@ -1,13 +1,37 @@
 package main
 
-import "context"
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"time"
 
+	"github.com/airlangga-hub/microservices/audit"
+	"github.com/airlangga-hub/microservices/money"
+)
+
+var (
+	ErrProductNotFound      = errors.New("product not found")
+	ErrInvalidPrice         = errors.New("invalid price")
+	ErrInvalidExchangeRate  = errors.New("invalid exchange rate")
+	ErrExchangeRateNotFound = errors.New("exchange rate not found")
+	ErrNoExchangeRate       = errors.New("no price or exchange rate for currency")
+)
+
+// Service reads products priced in the currency asked for, or at their base
+// price when no currency is given.
 type Service interface {
-	CreateProduct(ctx context.Context, name, description string, price int64) (Product, error)
-	GetProductByID(ctx context.Context, id string) (Product, error)
-	GetProducts(ctx context.Context, offset, limit int32) ([]Product, error)
-	GetProductsByIDs(ctx context.Context, ids []string) ([]Product, error)
-	SearchProducts(ctx context.Context, query string, offset, limit int32) ([]Product, error)
+	CreateProduct(ctx context.Context, name, description string, price money.Money, prices []money.Money, taxCategory string) (Product, error)
+	UpdateProduct(ctx context.Context, id, name, description string, price money.Money, prices []money.Money, taxCategory string) (Product, error)
+	GetProductByID(ctx context.Context, id, currency string) (Product, error)
+	GetProducts(ctx context.Context, offset, limit int32, currency string) ([]Product, error)
+	GetProductsByIDs(ctx context.Context, ids []string, currency string) ([]Product, error)
+	SearchProducts(ctx context.Context, query string, offset, limit int32, currency string) ([]Product, error)
+	SetExchangeRate(ctx context.Context, from, to, rate string) (ExchangeRate, error)
+	ListExchangeRates(ctx context.Context) ([]ExchangeRate, error)
+	DeleteExchangeRate(ctx context.Context, from, to string) error
+	QueryAuditLog(ctx context.Context, q audit.Query) ([]audit.Entry, error)
 }
 
 type service struct {
@@ -18,30 +42,211 @@ func NewService(r Repository) Service {
 	return &service{r}
 }
 
-func (s *service) CreateProduct(ctx context.Context, name, description string, price int64) (Product, error) {
-	return s.repository.CreateProduct(ctx, productDocument{Name: name, Description: description, Price: price})
+// DefaultTaxCategory is used for products created without a tax category.
+const DefaultTaxCategory = "standard"
+
+// CreateProduct prices the product in money.DefaultCurrency if price has no
+// currency. prices is its price list in other currencies, one price each.
+func (s *service) CreateProduct(ctx context.Context, name, description string, price money.Money, prices []money.Money, taxCategory string) (Product, error) {
+	p, err := newProductDocument(name, description, price, prices, taxCategory)
+	if err != nil {
+		return Product{}, err
+	}
+
+	return s.repository.CreateProduct(ctx, p)
+}
+
+// UpdateProduct replaces every field of product id, with the same defaults
+// and checks as CreateProduct.
+func (s *service) UpdateProduct(ctx context.Context, id, name, description string, price money.Money, prices []money.Money, taxCategory string) (Product, error) {
+	p, err := newProductDocument(name, description, price, prices, taxCategory)
+	if err != nil {
+		return Product{}, err
+	}
+
+	return s.repository.UpdateProduct(ctx, id, p)
+}
+
+func newProductDocument(name, description string, price money.Money, prices []money.Money, taxCategory string) (productDocument, error) {
+	if price.Currency == "" {
+		price.Currency = money.DefaultCurrency
+	}
+
+	listed := map[string]bool{}
+
+	for _, p := range append([]money.Money{price}, prices...) {
+		if err := money.ValidateCurrency(p.Currency); err != nil {
+			return productDocument{}, fmt.Errorf("%w: %w", ErrInvalidPrice, err)
+		}
+		if p.Units < 0 {
+			return productDocument{}, fmt.Errorf("%w: price can't be negative", ErrInvalidPrice)
+		}
+		if listed[p.Currency] {
+			return productDocument{}, fmt.Errorf("%w: more than one price in %s", ErrInvalidPrice, p.Currency)
+		}
+		listed[p.Currency] = true
+	}
+
+	if taxCategory == "" {
+		taxCategory = DefaultTaxCategory
+	}
+
+	return productDocument{
+		Name:        name,
+		Description: description,
+		Price:       price.Units,
+		Currency:    price.Currency,
+		Prices:      slices.Clone(prices),
+		TaxCategory: taxCategory,
+	}, nil
 }
 
-func (s *service) GetProductByID(ctx context.Context, id string) (Product, error) {
-	return s.repository.GetProductByID(ctx, id)
+func (s *service) GetProductByID(ctx context.Context, id, currency string) (Product, error) {
+	product, err := s.repository.GetProductByID(ctx, id)
+	if err != nil {
+		return Product{}, err
+	}
+
+	products, err := s.priceIn(ctx, []Product{product}, currency)
+	if err != nil {
+		return Product{}, err
+	}
+
+	return products[0], nil
 }
 
-func (s *service) GetProducts(ctx context.Context, offset, limit int32) ([]Product, error) {
+func (s *service) GetProducts(ctx context.Context, offset, limit int32, currency string) ([]Product, error) {
 	if limit > 100 || (offset == 0 && limit == 0) {
 		limit = 100
 	}
 
-	return s.repository.ListProducts(ctx, offset, limit)
+	products, err := s.repository.ListProducts(ctx, offset, limit)
+	if err != nil {
+		return nil, err
+	}
+
+	return s.priceIn(ctx, products, currency)
 }
 
-func (s *service) GetProductsByIDs(ctx context.Context, ids []string) ([]Product, error) {
-	return s.repository.ListProductsWithIDs(ctx, ids)
+func (s *service) GetProductsByIDs(ctx context.Context, ids []string, currency string) ([]Product, error) {
+	products, err := s.repository.ListProductsWithIDs(ctx, ids)
+	if err != nil {
+		return nil, err
+	}
+
+	return s.priceIn(ctx, products, currency)
 }
 
-func (s *service) SearchProducts(ctx context.Context, query string, offset, limit int32) ([]Product, error) {
+func (s *service) SearchProducts(ctx context.Context, query string, offset, limit int32, currency string) ([]Product, error) {
 	if limit > 100 || (offset == 0 && limit == 0) {
 		limit = 100
 	}
 
-	return s.repository.SearchProducts(ctx, query, offset, limit)
+	products, err := s.repository.SearchProducts(ctx, query, offset, limit)
+	if err != nil {
+		return nil, err
+	}
+
+	return s.priceIn(ctx, products, currency)
+}
+
+// SetExchangeRate sets how many units of to one unit of from buys. Rates go
+// one way: a USD to EUR rate doesn't convert EUR prices to USD.
+func (s *service) SetExchangeRate(ctx context.Context, from, to, rate string) (ExchangeRate, error) {
+	for _, currency := range []string{from, to} {
+		if err := money.ValidateCurrency(currency); err != nil {
+			return ExchangeRate{}, fmt.Errorf("%w: %w", ErrInvalidExchangeRate, err)
+		}
+	}
+	if from == to {
+		return ExchangeRate{}, fmt.Errorf("%w: %s to itself", ErrInvalidExchangeRate, from)
+	}
+	if _, err := money.ParseRate(rate); err != nil {
+		return ExchangeRate{}, fmt.Errorf("%w: %w", ErrInvalidExchangeRate, err)
+	}
+
+	r := ExchangeRate{From: from, To: to, Rate: rate, UpdatedAt: time.Now().UTC()}
+
+	if err := s.repository.PutExchangeRate(ctx, r); err != nil {
+		return ExchangeRate{}, err
+	}
+
+	return r, nil
+}
+
+func (s *service) ListExchangeRates(ctx context.Context) ([]ExchangeRate, error) {
+	return s.repository.ListExchangeRates(ctx)
+}
+
+func (s *service) DeleteExchangeRate(ctx context.Context, from, to string) error {
+	return s.repository.DeleteExchangeRate(ctx, from, to)
+}
+
+func (s *service) QueryAuditLog(ctx context.Context, q audit.Query) ([]audit.Entry, error) {
+	if q.Limit > 100 || (q.Offset == 0 && q.Limit == 0) {
+		q.Limit = 100
+	}
+
+	return s.repository.QueryAuditLog(ctx, q)
+}
+
+// priceIn reprices products in currency. A product's price list wins;
+// otherwise its base price is converted at the rate from its base currency,
+// and a product with neither fails the whole read with ErrNoExchangeRate so
+// callers never get prices in mixed currencies. Rates are only read if some
+// product needs converting.
+func (s *service) priceIn(ctx context.Context, products []Product, currency string) ([]Product, error) {
+	if currency == "" {
+		return products, nil
+	}
+	if err := money.ValidateCurrency(currency); err != nil {
+		return nil, err
+	}
+
+	var rates map[string]ExchangeRate
+
+	for i, p := range products {
+		if p.Currency == currency {
+			continue
+		}
+
+		if listed := slices.IndexFunc(p.Prices, func(m money.Money) bool { return m.Currency == currency }); listed >= 0 {
+			products[i].Price = p.Prices[listed].Units
+			products[i].Currency = currency
+			continue
+		}
+
+		if rates == nil {
+			all, err := s.repository.ListExchangeRates(ctx)
+			if err != nil {
+				return nil, err
+			}
+
+			rates = map[string]ExchangeRate{}
+			for _, r := range all {
+				rates[exchangeRateID(r.From, r.To)] = r
+			}
+		}
+
+		rate, exist := rates[exchangeRateID(p.Currency, currency)]
+		if !exist {
+			return nil, fmt.Errorf("%w: product %s has no %s price or %s to %s rate", ErrNoExchangeRate, p.ID, currency, p.Currency, currency)
+		}
+
+		r, err := money.ParseRate(rate.Rate)
+		if err != nil {
+			return nil, err
+		}
+
+		converted, err := money.New(p.Currency, p.Price).Convert(currency, r)
+		if err != nil {
+			return nil, err
+		}
+
+		products[i].Price = converted.Units
+		products[i].Currency = currency
+		products[i].ExchangeRate = &rate
+	}
+
+	return products, nil
 }
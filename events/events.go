@@ -0,0 +1,62 @@
+// Package events defines the domain events services publish, the Bus they
+// are published to, and the Relay that moves them from a repository's
+// transactional outbox onto the bus at least once.
+package events
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+type Event struct {
+	ID          string          `json:"id"`
+	Type        string          `json:"type"`
+	AggregateID string          `json:"aggregate_id"`
+	Payload     json.RawMessage `json:"payload"`
+	OccurredAt  time.Time       `json:"occurred_at"`
+}
+
+// New builds an event with a random ID, which consumers can use to drop the
+// duplicates at-least-once delivery may produce.
+func New(eventType, aggregateID string, payload any) (Event, error) {
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return Event{}, fmt.Errorf("marshaling %s payload: %w", eventType, err)
+	}
+
+	id := make([]byte, 16)
+	if _, err := rand.Read(id); err != nil {
+		return Event{}, fmt.Errorf("generating event id: %w", err)
+	}
+
+	return Event{
+		ID:          hex.EncodeToString(id),
+		Type:        eventType,
+		AggregateID: aggregateID,
+		Payload:     data,
+		OccurredAt:  time.Now().UTC(),
+	}, nil
+}
+
+type Bus interface {
+	Publish(ctx context.Context, e Event) error
+	Close() error
+}
+
+// Subscriber is implemented by buses that can deliver published events back
+// to handlers in this process. The returned function removes the handler.
+type Subscriber interface {
+	Subscribe(handler func(Event)) (func(), error)
+}
+
+// Outbox is implemented by repositories that store events in the same
+// transaction as the change they describe. DispatchEvents hands up to limit
+// pending events to publish, oldest first, and only marks those it published
+// successfully. It returns how many were published.
+type Outbox interface {
+	DispatchEvents(ctx context.Context, limit int, publish func(ctx context.Context, e Event) error) (int, error)
+}
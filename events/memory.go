@@ -0,0 +1,50 @@
+package events
+
+import (
+	"context"
+	"sync"
+)
+
+// MemoryBus delivers events synchronously to in-process subscribers. It is
+// meant for local runs and tests.
+type MemoryBus struct {
+	mu          sync.RWMutex
+	subscribers map[int]func(Event)
+	nextID      int
+}
+
+func NewMemoryBus() *MemoryBus {
+	return &MemoryBus{subscribers: map[int]func(Event){}}
+}
+
+// Subscribe registers handler for every published event and returns a
+// function that removes it.
+func (b *MemoryBus) Subscribe(handler func(Event)) (func(), error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	id := b.nextID
+	b.nextID++
+	b.subscribers[id] = handler
+
+	return func() {
+		b.mu.Lock()
+		defer b.mu.Unlock()
+		delete(b.subscribers, id)
+	}, nil
+}
+
+func (b *MemoryBus) Publish(ctx context.Context, e Event) error {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+
+	for _, handler := range b.subscribers {
+		handler(e)
+	}
+
+	return nil
+}
+
+func (b *MemoryBus) Close() error {
+	return nil
+}
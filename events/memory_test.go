@@ -0,0 +1,27 @@
+package events
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestMemoryBus(t *testing.T) {
+	bus := NewMemoryBus()
+
+	var got []string
+	unsubscribe, err := bus.Subscribe(func(e Event) { got = append(got, e.ID) })
+	if err != nil {
+		t.Fatalf("Subscribe: %v", err)
+	}
+
+	first, _ := New("ThingHappened", "1", nil)
+	second, _ := New("ThingHappened", "2", nil)
+
+	bus.Publish(t.Context(), first)
+	unsubscribe()
+	bus.Publish(t.Context(), second)
+
+	if !slices.Equal(got, []string{first.ID}) {
+		t.Errorf("handler saw %v, want only the event published before unsubscribing", got)
+	}
+}
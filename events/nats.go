@@ -0,0 +1,113 @@
+package events
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/nats-io/nats.go"
+	"github.com/nats-io/nats.go/jetstream"
+)
+
+// streamMaxAge is how long the stream keeps events for consumers that are
+// catching up.
+const streamMaxAge = 7 * 24 * time.Hour
+
+// NATSBus publishes each event as JSON on <subjectPrefix>.<type> to a
+// JetStream stream capturing those subjects. Publish returns once the stream
+// has stored the event, and the event ID is sent as the Nats-Msg-Id header
+// so the stream drops the duplicates a relay retry produces.
+type NATSBus struct {
+	conn          *nats.Conn
+	js            jetstream.JetStream
+	subjectPrefix string
+}
+
+// NewNATSBus connects to url and creates the <SUBJECTPREFIX>_EVENTS stream,
+// or updates it if it already exists.
+func NewNATSBus(url, subjectPrefix string) (*NATSBus, error) {
+	conn, err := nats.Connect(url, nats.Name(subjectPrefix), nats.MaxReconnects(-1))
+	if err != nil {
+		return nil, fmt.Errorf("connecting to nats: %w", err)
+	}
+
+	js, err := jetstream.New(conn)
+	if err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("opening jetstream: %w", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	if _, err := js.CreateOrUpdateStream(ctx, streamConfig(subjectPrefix)); err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("creating %s event stream: %w", subjectPrefix, err)
+	}
+
+	return &NATSBus{conn: conn, js: js, subjectPrefix: subjectPrefix}, nil
+}
+
+func streamConfig(subjectPrefix string) jetstream.StreamConfig {
+	return jetstream.StreamConfig{
+		Name:       strings.ToUpper(subjectPrefix) + "_EVENTS",
+		Subjects:   []string{subjectPrefix + ".>"},
+		Storage:    jetstream.FileStorage,
+		MaxAge:     streamMaxAge,
+		Duplicates: 2 * time.Minute,
+	}
+}
+
+// newMsg builds the message e is published as.
+func newMsg(subjectPrefix string, e Event) (*nats.Msg, error) {
+	data, err := json.Marshal(e)
+	if err != nil {
+		return nil, fmt.Errorf("marshaling event %s: %w", e.ID, err)
+	}
+
+	msg := nats.NewMsg(subjectPrefix + "." + e.Type)
+	msg.Header.Set(jetstream.MsgIDHeader, e.ID)
+	msg.Data = data
+
+	return msg, nil
+}
+
+func (b *NATSBus) Publish(ctx context.Context, e Event) error {
+	msg, err := newMsg(b.subjectPrefix, e)
+	if err != nil {
+		return err
+	}
+
+	// the ack means the stream stored the event, or already had it
+	if _, err := b.js.PublishMsg(ctx, msg); err != nil {
+		return fmt.Errorf("publishing event %s: %w", e.ID, err)
+	}
+
+	return nil
+}
+
+// Subscribe delivers every event published under the bus's subject prefix
+// from now on, including those published by other instances. Messages that
+// don't decode are logged and skipped.
+func (b *NATSBus) Subscribe(handler func(Event)) (func(), error) {
+	sub, err := b.conn.Subscribe(b.subjectPrefix+".>", func(msg *nats.Msg) {
+		var e Event
+		if err := json.Unmarshal(msg.Data, &e); err != nil {
+			log.Println("ERROR: events nats Subscribe (unmarshal): ", err)
+			return
+		}
+		handler(e)
+	})
+	if err != nil {
+		return nil, fmt.Errorf("subscribing to %s.>: %w", b.subjectPrefix, err)
+	}
+
+	return func() { sub.Unsubscribe() }, nil
+}
+
+func (b *NATSBus) Close() error {
+	return b.conn.Drain()
+}
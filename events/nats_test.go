@@ -0,0 +1,51 @@
+package events
+
+import (
+	"encoding/json"
+	"slices"
+	"testing"
+
+	"github.com/nats-io/nats.go/jetstream"
+)
+
+func TestNewMsg(t *testing.T) {
+	e, err := New("OrderPlaced", "7", map[string]int{"id": 7})
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+
+	msg, err := newMsg("order", e)
+	if err != nil {
+		t.Fatalf("newMsg: %v", err)
+	}
+
+	if msg.Subject != "order.OrderPlaced" {
+		t.Errorf("subject = %q, want order.OrderPlaced", msg.Subject)
+	}
+	// the stream drops a redelivered event by this header
+	if got := msg.Header.Get(jetstream.MsgIDHeader); got != e.ID {
+		t.Errorf("%s header = %q, want the event ID %q", jetstream.MsgIDHeader, got, e.ID)
+	}
+
+	var decoded Event
+	if err := json.Unmarshal(msg.Data, &decoded); err != nil {
+		t.Fatalf("unmarshal: %v", err)
+	}
+	if decoded.ID != e.ID || decoded.Type != e.Type || string(decoded.Payload) != string(e.Payload) {
+		t.Errorf("data decodes to %+v, want %+v", decoded, e)
+	}
+}
+
+func TestStreamConfig(t *testing.T) {
+	cfg := streamConfig("catalog")
+
+	if cfg.Name != "CATALOG_EVENTS" {
+		t.Errorf("stream name = %q, want CATALOG_EVENTS", cfg.Name)
+	}
+	if !slices.Equal(cfg.Subjects, []string{"catalog.>"}) {
+		t.Errorf("stream subjects = %v, want [catalog.>]", cfg.Subjects)
+	}
+	if cfg.Duplicates <= 0 {
+		t.Error("stream has no duplicate window")
+	}
+}
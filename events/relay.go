@@ -0,0 +1,57 @@
+package events
+
+import (
+	"context"
+	"log"
+	"time"
+)
+
+// Relay polls an Outbox and publishes pending events to a Bus. An event is
+// only removed from the outbox after Publish succeeds, so delivery is at
+// least once.
+type Relay struct {
+	outbox    Outbox
+	bus       Bus
+	interval  time.Duration
+	batchSize int
+}
+
+func NewRelay(outbox Outbox, bus Bus, interval time.Duration, batchSize int) *Relay {
+	return &Relay{
+		outbox:    outbox,
+		bus:       bus,
+		interval:  interval,
+		batchSize: batchSize,
+	}
+}
+
+func (r *Relay) Run(ctx context.Context) {
+	ticker := time.NewTicker(r.interval)
+	defer ticker.Stop()
+
+	for {
+		r.drain(ctx)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// drain keeps dispatching full batches until the outbox is empty or
+// publishing fails.
+func (r *Relay) drain(ctx context.Context) {
+	for ctx.Err() == nil {
+		n, err := r.outbox.DispatchEvents(ctx, r.batchSize, r.bus.Publish)
+		if err != nil {
+			log.Println("ERROR: events relay: ", err)
+			return
+		}
+
+		if n < r.batchSize {
+			return
+		}
+	}
+}
@@ -0,0 +1,195 @@
+package events
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeOutbox keeps pending events in order and, like the repositories,
+// only removes those published successfully.
+type fakeOutbox struct {
+	mu      sync.Mutex
+	pending []Event
+}
+
+func (o *fakeOutbox) add(t *testing.T, n int) []Event {
+	t.Helper()
+
+	added := []Event{}
+	for range n {
+		e, err := New("ThingHappened", "1", nil)
+		if err != nil {
+			t.Fatalf("New: %v", err)
+		}
+		added = append(added, e)
+	}
+
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.pending = append(o.pending, added...)
+
+	return added
+}
+
+func (o *fakeOutbox) len() int {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	return len(o.pending)
+}
+
+func (o *fakeOutbox) DispatchEvents(ctx context.Context, limit int, publish func(ctx context.Context, e Event) error) (int, error) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	n := 0
+	for _, e := range o.pending[:min(limit, len(o.pending))] {
+		if err := publish(ctx, e); err != nil {
+			o.pending = o.pending[n:]
+			return n, err
+		}
+		n++
+	}
+	o.pending = o.pending[n:]
+
+	return n, nil
+}
+
+// flakyBus fails the publishes numbered in failures, counting from 1, and
+// records the IDs of the events it was handed.
+type flakyBus struct {
+	mu        sync.Mutex
+	failures  map[int]bool
+	calls     int
+	published []string
+}
+
+func (b *flakyBus) Publish(ctx context.Context, e Event) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.calls++
+	b.published = append(b.published, e.ID)
+	if b.failures[b.calls] {
+		return errors.New("nats: no responders available for request")
+	}
+
+	return nil
+}
+
+func (b *flakyBus) Close() error {
+	return nil
+}
+
+func (b *flakyBus) publishedIDs() []string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return slices.Clone(b.published)
+}
+
+func ids(events []Event) []string {
+	ids := []string{}
+	for _, e := range events {
+		ids = append(ids, e.ID)
+	}
+	return ids
+}
+
+// runRelay runs a relay until the outbox is empty, failing the test if that
+// takes too long.
+func runRelay(t *testing.T, outbox *fakeOutbox, bus Bus, batchSize int) {
+	t.Helper()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		NewRelay(outbox, bus, time.Millisecond, batchSize).Run(ctx)
+		close(done)
+	}()
+	defer func() {
+		cancel()
+		<-done
+	}()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for outbox.len() > 0 {
+		if time.Now().After(deadline) {
+			t.Fatalf("relay left %d events in the outbox", outbox.len())
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestRelayDrainsFullBatches(t *testing.T) {
+	outbox := &fakeOutbox{}
+	added := outbox.add(t, 7)
+	bus := &flakyBus{}
+
+	runRelay(t, outbox, bus, 2)
+
+	if got := bus.publishedIDs(); !slices.Equal(got, ids(added)) {
+		t.Errorf("published %v, want every event once in order %v", got, ids(added))
+	}
+}
+
+func TestRelayRetriesFailedPublishes(t *testing.T) {
+	outbox := &fakeOutbox{}
+	added := outbox.add(t, 3)
+	// the second event fails twice before the bus takes it
+	bus := &flakyBus{failures: map[int]bool{2: true, 3: true}}
+
+	runRelay(t, outbox, bus, 10)
+
+	want := []string{added[0].ID, added[1].ID, added[1].ID, added[1].ID, added[2].ID}
+	if got := bus.publishedIDs(); !slices.Equal(got, want) {
+		t.Errorf("published %v, want %v", got, want)
+	}
+}
+
+// A publish the bus stored but failed to acknowledge is retried with the
+// same event ID, which the NATS stream and the consumers dedupe on.
+func TestRelayRetriesWithTheSameEventID(t *testing.T) {
+	outbox := &fakeOutbox{}
+	added := outbox.add(t, 1)
+	bus := &flakyBus{failures: map[int]bool{1: true}}
+
+	runRelay(t, outbox, bus, 10)
+
+	got := bus.publishedIDs()
+	if len(got) != 2 || got[0] != added[0].ID || got[1] != added[0].ID {
+		t.Errorf("published %v, want %s twice", got, added[0].ID)
+	}
+}
+
+func TestRelayStopsWhenCanceled(t *testing.T) {
+	outbox := &fakeOutbox{}
+	outbox.add(t, 1)
+	// the bus is down for good
+	bus := &flakyBus{failures: map[int]bool{}}
+	for i := range 1000 {
+		bus.failures[i+1] = true
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		NewRelay(outbox, bus, time.Millisecond, 10).Run(ctx)
+		close(done)
+	}()
+
+	time.Sleep(10 * time.Millisecond)
+	cancel()
+
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("relay kept running after its context was canceled")
+	}
+
+	if outbox.len() != 1 {
+		t.Errorf("outbox has %d events, want the unpublished event kept", outbox.len())
+	}
+}
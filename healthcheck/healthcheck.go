@@ -0,0 +1,122 @@
+// Package healthcheck keeps a service's grpc health status in step with its
+// dependencies and serves the same status as HTTP liveness and readiness
+// probes.
+package healthcheck
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+)
+
+const checkInterval = 10 * time.Second
+
+// CheckTimeout bounds each run of a check.
+const CheckTimeout = 3 * time.Second
+
+// Checker periodically runs a dependency check and publishes the result
+// through the grpc health server, for both the overall server ("") and the
+// named service.
+type Checker struct {
+	server  *health.Server
+	service string
+	check   func(ctx context.Context) error
+}
+
+func New(server *health.Server, service string, check func(ctx context.Context) error) *Checker {
+	return &Checker{
+		server:  server,
+		service: service,
+		check:   check,
+	}
+}
+
+// Run checks right away and then every checkInterval until ctx is canceled.
+func (c *Checker) Run(ctx context.Context) {
+	ticker := time.NewTicker(checkInterval)
+	defer ticker.Stop()
+
+	for {
+		c.probe(ctx)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (c *Checker) probe(ctx context.Context) {
+	ctx, cancel := context.WithTimeout(ctx, CheckTimeout)
+	defer cancel()
+
+	status := healthpb.HealthCheckResponse_SERVING
+
+	if err := c.check(ctx); err != nil {
+		log.Printf("ERROR: %s health check: %v", c.service, err)
+		status = healthpb.HealthCheckResponse_NOT_SERVING
+	}
+
+	c.server.SetServingStatus("", status)
+	c.server.SetServingStatus(c.service, status)
+}
+
+// HTTPHandler exposes liveness (/livez) and readiness (/readyz) for
+// orchestrators that can't speak grpc health.
+func (c *Checker) HTTPHandler() http.Handler {
+	mux := http.NewServeMux()
+
+	mux.HandleFunc("/livez", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+		w.Write([]byte("ok"))
+	})
+
+	mux.HandleFunc("/readyz", func(w http.ResponseWriter, r *http.Request) {
+		res, err := c.server.Check(r.Context(), &healthpb.HealthCheckRequest{Service: c.service})
+		if err != nil || res.Status != healthpb.HealthCheckResponse_SERVING {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			w.Write([]byte("not ready"))
+			return
+		}
+
+		w.WriteHeader(http.StatusOK)
+		w.Write([]byte("ready"))
+	})
+
+	return mux
+}
+
+// Downstream asks a dependency's own health service whether the given
+// service is serving.
+func Downstream(client healthpb.HealthClient, service string) func(ctx context.Context) error {
+	return func(ctx context.Context) error {
+		res, err := client.Check(ctx, &healthpb.HealthCheckRequest{Service: service})
+		if err != nil {
+			return fmt.Errorf("%s health check: %w", service, err)
+		}
+
+		if res.Status != healthpb.HealthCheckResponse_SERVING {
+			return fmt.Errorf("%s is %s", service, res.Status)
+		}
+
+		return nil
+	}
+}
+
+// All combines checks into one that fails on the first failing check.
+func All(checks ...func(ctx context.Context) error) func(ctx context.Context) error {
+	return func(ctx context.Context) error {
+		for _, check := range checks {
+			if err := check(ctx); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+}
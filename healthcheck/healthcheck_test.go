@@ -0,0 +1,221 @@
+package healthcheck
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/test/bufconn"
+)
+
+const service = "pb.ThingService"
+
+// toggle is a check that fails while down is set.
+type toggle struct {
+	down  atomic.Bool
+	calls atomic.Int32
+}
+
+func (c *toggle) check(ctx context.Context) error {
+	c.calls.Add(1)
+	if c.down.Load() {
+		return errors.New("database is down")
+	}
+	return nil
+}
+
+func status(t *testing.T, server *health.Server, service string) healthpb.HealthCheckResponse_ServingStatus {
+	t.Helper()
+
+	res, err := server.Check(context.Background(), &healthpb.HealthCheckRequest{Service: service})
+	if err != nil {
+		t.Fatalf("Check(%q): %v", service, err)
+	}
+	return res.Status
+}
+
+func get(t *testing.T, h http.Handler, path string) (int, string) {
+	t.Helper()
+
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, path, nil))
+	return rec.Code, strings.TrimSpace(rec.Body.String())
+}
+
+func TestProbeFollowsTheCheck(t *testing.T) {
+	server := health.NewServer()
+	check := &toggle{}
+	c := New(server, service, check.check)
+
+	steps := []struct {
+		down bool
+		want healthpb.HealthCheckResponse_ServingStatus
+	}{
+		{false, healthpb.HealthCheckResponse_SERVING},
+		{true, healthpb.HealthCheckResponse_NOT_SERVING},
+		{false, healthpb.HealthCheckResponse_SERVING},
+	}
+
+	for i, step := range steps {
+		check.down.Store(step.down)
+		c.probe(context.Background())
+
+		for _, name := range []string{"", service} {
+			if got := status(t, server, name); got != step.want {
+				t.Errorf("step %d: status of %q = %v, want %v", i, name, got, step.want)
+			}
+		}
+	}
+}
+
+func TestProbeTimesOutTheCheck(t *testing.T) {
+	server := health.NewServer()
+	c := New(server, service, func(ctx context.Context) error {
+		deadline, ok := ctx.Deadline()
+		if !ok || time.Until(deadline) > CheckTimeout {
+			t.Errorf("check ran without the %v timeout", CheckTimeout)
+		}
+		return nil
+	})
+
+	c.probe(context.Background())
+}
+
+func TestHTTPHandler(t *testing.T) {
+	server := health.NewServer()
+	server.SetServingStatus(service, healthpb.HealthCheckResponse_NOT_SERVING)
+	check := &toggle{}
+	c := New(server, service, check.check)
+	h := c.HTTPHandler()
+
+	if code, body := get(t, h, "/readyz"); code != http.StatusServiceUnavailable || body != "not ready" {
+		t.Errorf("/readyz before the first check = %d %q, want 503 not ready", code, body)
+	}
+
+	c.probe(context.Background())
+	if code, body := get(t, h, "/readyz"); code != http.StatusOK || body != "ready" {
+		t.Errorf("/readyz after a passing check = %d %q, want 200 ready", code, body)
+	}
+
+	check.down.Store(true)
+	c.probe(context.Background())
+	if code, _ := get(t, h, "/readyz"); code != http.StatusServiceUnavailable {
+		t.Errorf("/readyz after a failing check = %d, want 503", code)
+	}
+
+	// a failing dependency doesn't make the process a restart candidate
+	if code, body := get(t, h, "/livez"); code != http.StatusOK || body != "ok" {
+		t.Errorf("/livez = %d %q, want 200 ok", code, body)
+	}
+}
+
+func TestReadyzUnknownService(t *testing.T) {
+	c := New(health.NewServer(), service, (&toggle{}).check)
+
+	if code, _ := get(t, c.HTTPHandler(), "/readyz"); code != http.StatusServiceUnavailable {
+		t.Errorf("/readyz for a service never checked = %d, want 503", code)
+	}
+}
+
+func TestRunChecksRightAwayAndStops(t *testing.T) {
+	server := health.NewServer()
+	check := &toggle{}
+	c := New(server, service, check.check)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		c.Run(ctx)
+		close(done)
+	}()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for check.calls.Load() == 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("Run didn't check before the first interval")
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	cancel()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Run kept running after its context was canceled")
+	}
+}
+
+func serveHealth(t *testing.T, server *health.Server) healthpb.HealthClient {
+	t.Helper()
+
+	lis := bufconn.Listen(1 << 20)
+	s := grpc.NewServer()
+	healthpb.RegisterHealthServer(s, server)
+	go s.Serve(lis)
+	t.Cleanup(s.Stop)
+
+	conn, err := grpc.NewClient("passthrough:///downstream",
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) {
+			return lis.DialContext(ctx)
+		}),
+	)
+	if err != nil {
+		t.Fatalf("NewClient: %v", err)
+	}
+	t.Cleanup(func() { conn.Close() })
+
+	return healthpb.NewHealthClient(conn)
+}
+
+func TestDownstream(t *testing.T) {
+	server := health.NewServer()
+	client := serveHealth(t, server)
+	check := Downstream(client, service)
+
+	// a service the downstream has never reported on
+	if err := check(context.Background()); err == nil {
+		t.Error("check of an unknown service passed")
+	}
+
+	server.SetServingStatus(service, healthpb.HealthCheckResponse_NOT_SERVING)
+	if err := check(context.Background()); err == nil || !strings.Contains(err.Error(), "NOT_SERVING") {
+		t.Errorf("check of a not serving service = %v, want it to say NOT_SERVING", err)
+	}
+
+	server.SetServingStatus(service, healthpb.HealthCheckResponse_SERVING)
+	if err := check(context.Background()); err != nil {
+		t.Errorf("check of a serving service = %v", err)
+	}
+}
+
+func TestAll(t *testing.T) {
+	first, second, third := &toggle{}, &toggle{}, &toggle{}
+	check := All(first.check, second.check, third.check)
+
+	if err := check(context.Background()); err != nil {
+		t.Fatalf("All of passing checks = %v", err)
+	}
+
+	second.down.Store(true)
+	if err := check(context.Background()); err == nil {
+		t.Fatal("All passed with a failing check")
+	}
+	if first.calls.Load() != 2 || third.calls.Load() != 1 {
+		t.Errorf("calls = %d, %d; want the first check run again and the third skipped", first.calls.Load(), third.calls.Load())
+	}
+
+	if err := All()(context.Background()); err != nil {
+		t.Errorf("All of no checks = %v", err)
+	}
+}
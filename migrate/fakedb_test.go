@@ -0,0 +1,328 @@
+package migrate
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"io"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeDB understands just enough Postgres for the runner: session advisory
+// locks, the schema_migrations statements, and migration scripts made of
+// "CREATE TABLE <name>", "DROP TABLE <name>" and "FAIL" statements.
+// Everything is applied straight to the shared state, so runners that
+// aren't serialized by the lock trip over each other's tables.
+type fakeDB struct {
+	mu       sync.Mutex
+	locks    map[int64]*fakeConn
+	released chan struct{}
+	tables   map[string]bool
+	versions map[int64]string
+	// scripts counts the statements that ran, like "CREATE TABLE things".
+	scripts []string
+}
+
+func newFakeDB(t *testing.T) (*fakeDB, *sql.DB) {
+	t.Helper()
+
+	f := &fakeDB{
+		locks:    map[int64]*fakeConn{},
+		released: make(chan struct{}),
+		tables:   map[string]bool{},
+		versions: map[int64]string{},
+	}
+
+	db := sql.OpenDB(f)
+	t.Cleanup(func() { db.Close() })
+
+	return f, db
+}
+
+func (f *fakeDB) Connect(ctx context.Context) (driver.Conn, error) {
+	return &fakeConn{db: f}, nil
+}
+
+func (f *fakeDB) Driver() driver.Driver {
+	return nil
+}
+
+// lock blocks until c holds the advisory lock id, like pg_advisory_lock.
+func (f *fakeDB) lock(ctx context.Context, c *fakeConn, id int64) error {
+	for {
+		f.mu.Lock()
+		if holder, held := f.locks[id]; !held || holder == c {
+			f.locks[id] = c
+			f.mu.Unlock()
+			return nil
+		}
+		released := f.released
+		f.mu.Unlock()
+
+		select {
+		case <-released:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+func (f *fakeDB) unlock(c *fakeConn, id int64) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.locks[id] == c {
+		delete(f.locks, id)
+		close(f.released)
+		f.released = make(chan struct{})
+	}
+}
+
+func (f *fakeDB) tableNames() []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	names := []string{}
+	for name := range f.tables {
+		if name != "schema_migrations" {
+			names = append(names, name)
+		}
+	}
+	slices.Sort(names)
+
+	return names
+}
+
+func (f *fakeDB) applied() []int64 {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	versions := []int64{}
+	for v := range f.versions {
+		versions = append(versions, v)
+	}
+	slices.Sort(versions)
+
+	return versions
+}
+
+type fakeConn struct {
+	db *fakeDB
+	// undo reverts the open transaction's changes, newest last.
+	undo []func()
+	inTx bool
+}
+
+func (c *fakeConn) Prepare(query string) (driver.Stmt, error) {
+	return nil, errors.New("fakedb: prepared statements aren't supported")
+}
+
+func (c *fakeConn) Close() error {
+	c.db.mu.Lock()
+	held := []int64{}
+	for id, holder := range c.db.locks {
+		if holder == c {
+			held = append(held, id)
+		}
+	}
+	c.db.mu.Unlock()
+
+	// Postgres releases session locks when the connection goes away
+	for _, id := range held {
+		c.db.unlock(c, id)
+	}
+
+	return nil
+}
+
+func (c *fakeConn) Begin() (driver.Tx, error) {
+	return c.BeginTx(context.Background(), driver.TxOptions{})
+}
+
+func (c *fakeConn) BeginTx(ctx context.Context, opts driver.TxOptions) (driver.Tx, error) {
+	if c.inTx {
+		return nil, errors.New("fakedb: transaction already open")
+	}
+	c.inTx = true
+	c.undo = nil
+
+	return c, nil
+}
+
+func (c *fakeConn) Commit() error {
+	c.inTx = false
+	c.undo = nil
+	return nil
+}
+
+func (c *fakeConn) Rollback() error {
+	c.db.mu.Lock()
+	defer c.db.mu.Unlock()
+
+	for i := len(c.undo) - 1; i >= 0; i-- {
+		c.undo[i]()
+	}
+	c.inTx = false
+	c.undo = nil
+
+	return nil
+}
+
+func (c *fakeConn) ExecContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Result, error) {
+	query = strings.Join(strings.Fields(query), " ")
+
+	switch {
+	case strings.HasPrefix(query, "SELECT pg_advisory_lock("):
+		return driver.RowsAffected(0), c.db.lock(ctx, c, args[0].Value.(int64))
+
+	case strings.HasPrefix(query, "SELECT pg_advisory_unlock("):
+		c.db.unlock(c, args[0].Value.(int64))
+		return driver.RowsAffected(0), nil
+
+	case strings.HasPrefix(query, "CREATE TABLE IF NOT EXISTS schema_migrations"):
+		c.db.mu.Lock()
+		defer c.db.mu.Unlock()
+		c.db.tables["schema_migrations"] = true
+		return driver.RowsAffected(0), nil
+
+	case strings.HasPrefix(query, "INSERT INTO schema_migrations"):
+		c.db.mu.Lock()
+		defer c.db.mu.Unlock()
+
+		version := args[0].Value.(int64)
+		if _, exist := c.db.versions[version]; exist {
+			return nil, fmt.Errorf("fakedb: duplicate key value %d violates schema_migrations_pkey", version)
+		}
+		c.db.versions[version] = args[1].Value.(string)
+		c.onRollback(func() { delete(c.db.versions, version) })
+
+		return driver.RowsAffected(1), nil
+
+	case strings.HasPrefix(query, "DELETE FROM schema_migrations"):
+		c.db.mu.Lock()
+		defer c.db.mu.Unlock()
+
+		version := args[0].Value.(int64)
+		name, exist := c.db.versions[version]
+		if !exist {
+			return driver.RowsAffected(0), nil
+		}
+		delete(c.db.versions, version)
+		c.onRollback(func() { c.db.versions[version] = name })
+
+		return driver.RowsAffected(1), nil
+	}
+
+	for _, stmt := range strings.Split(query, ";") {
+		if err := c.execScript(strings.TrimSpace(stmt)); err != nil {
+			return nil, err
+		}
+	}
+
+	return driver.RowsAffected(0), nil
+}
+
+func (c *fakeConn) execScript(stmt string) error {
+	if stmt == "" {
+		return nil
+	}
+
+	// give a runner that doesn't hold the lock a chance to interleave
+	time.Sleep(time.Millisecond)
+
+	c.db.mu.Lock()
+	defer c.db.mu.Unlock()
+
+	switch {
+	case stmt == "FAIL":
+		return errors.New("fakedb: syntax error")
+
+	case strings.HasPrefix(stmt, "CREATE TABLE "):
+		name := strings.TrimPrefix(stmt, "CREATE TABLE ")
+		if c.db.tables[name] {
+			return fmt.Errorf("fakedb: relation %q already exists", name)
+		}
+		c.db.tables[name] = true
+		c.onRollback(func() { delete(c.db.tables, name) })
+
+	case strings.HasPrefix(stmt, "DROP TABLE "):
+		name := strings.TrimPrefix(stmt, "DROP TABLE ")
+		if !c.db.tables[name] {
+			return fmt.Errorf("fakedb: table %q does not exist", name)
+		}
+		delete(c.db.tables, name)
+		c.onRollback(func() { c.db.tables[name] = true })
+
+	default:
+		return fmt.Errorf("fakedb: unsupported statement %q", stmt)
+	}
+
+	c.db.scripts = append(c.db.scripts, stmt)
+
+	return nil
+}
+
+// onRollback records how to revert a change made in the open transaction.
+// Outside one the change is autocommitted. The caller holds db.mu.
+func (c *fakeConn) onRollback(undo func()) {
+	if c.inTx {
+		c.undo = append(c.undo, undo)
+	}
+}
+
+func (c *fakeConn) QueryContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
+	query = strings.Join(strings.Fields(query), " ")
+
+	c.db.mu.Lock()
+	defer c.db.mu.Unlock()
+
+	if !c.db.tables["schema_migrations"] {
+		return nil, errors.New(`fakedb: relation "schema_migrations" does not exist`)
+	}
+
+	switch {
+	case query == "SELECT version FROM schema_migrations;":
+		rows := &fakeRows{columns: []string{"version"}}
+		for v := range c.db.versions {
+			rows.values = append(rows.values, []driver.Value{v})
+		}
+		return rows, nil
+
+	case strings.HasPrefix(query, "SELECT COALESCE(MAX(version), 0) FROM schema_migrations"):
+		var latest int64
+		for v := range c.db.versions {
+			latest = max(latest, v)
+		}
+		return &fakeRows{columns: []string{"coalesce"}, values: [][]driver.Value{{latest}}}, nil
+	}
+
+	return nil, fmt.Errorf("fakedb: unsupported query %q", query)
+}
+
+type fakeRows struct {
+	columns []string
+	values  [][]driver.Value
+}
+
+func (r *fakeRows) Columns() []string {
+	return r.columns
+}
+
+func (r *fakeRows) Close() error {
+	return nil
+}
+
+func (r *fakeRows) Next(dest []driver.Value) error {
+	if len(r.values) == 0 {
+		return io.EOF
+	}
+	copy(dest, r.values[0])
+	r.values = r.values[1:]
+	return nil
+}
@@ -0,0 +1,3 @@
+module github.com/airlangga-hub/microservices/migrate
+
+go 1.25.3
@@ -0,0 +1,247 @@
+// Package migrate applies a service's versioned SQL migrations to Postgres.
+//
+// Files are named <version>_<name>.up.sql and <version>_<name>.down.sql.
+// Applied versions are recorded in schema_migrations, and every run holds a
+// Postgres advisory lock so concurrent replicas can't migrate at once.
+package migrate
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"io/fs"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Runner applies the migrations in files, holding the advisory lock lockID.
+// Every replica of a service must use the same lockID, and different services
+// sharing a database different ones.
+type Runner struct {
+	files  fs.FS
+	lockID int64
+}
+
+func New(files fs.FS, lockID int64) *Runner {
+	return &Runner{
+		files:  files,
+		lockID: lockID,
+	}
+}
+
+type migration struct {
+	version int64
+	name    string
+	up      string
+	down    string
+}
+
+// Up applies every migration that hasn't been applied yet, in version order.
+func (r *Runner) Up(ctx context.Context, db *sql.DB) error {
+	return r.withLock(ctx, db, func(conn *sql.Conn) error {
+		all, err := r.load()
+		if err != nil {
+			return err
+		}
+
+		applied, err := appliedVersions(ctx, conn)
+		if err != nil {
+			return err
+		}
+
+		for _, m := range all {
+			if applied[m.version] {
+				continue
+			}
+
+			if err := apply(ctx, conn, m.up, func(tx *sql.Tx) error {
+				_, err := tx.ExecContext(
+					ctx,
+					`INSERT INTO schema_migrations (version, name) VALUES ($1, $2);`,
+					m.version, m.name,
+				)
+				return err
+			}); err != nil {
+				return fmt.Errorf("migration %d_%s up: %w", m.version, m.name, err)
+			}
+		}
+
+		return nil
+	})
+}
+
+// Down reverts the latest steps applied migrations, newest first.
+func (r *Runner) Down(ctx context.Context, db *sql.DB, steps int) error {
+	return r.withLock(ctx, db, func(conn *sql.Conn) error {
+		all, err := r.load()
+		if err != nil {
+			return err
+		}
+
+		applied, err := appliedVersions(ctx, conn)
+		if err != nil {
+			return err
+		}
+
+		for i := len(all) - 1; i >= 0 && steps > 0; i-- {
+			m := all[i]
+			if !applied[m.version] {
+				continue
+			}
+
+			if err := apply(ctx, conn, m.down, func(tx *sql.Tx) error {
+				_, err := tx.ExecContext(ctx, `DELETE FROM schema_migrations WHERE version = $1;`, m.version)
+				return err
+			}); err != nil {
+				return fmt.Errorf("migration %d_%s down: %w", m.version, m.name, err)
+			}
+
+			steps--
+		}
+
+		return nil
+	})
+}
+
+// Version returns the highest applied migration version, or 0 when none are.
+func (r *Runner) Version(ctx context.Context, db *sql.DB) (int64, error) {
+	var version int64
+
+	err := r.withLock(ctx, db, func(conn *sql.Conn) error {
+		return conn.QueryRowContext(
+			ctx,
+			`SELECT COALESCE(MAX(version), 0) FROM schema_migrations;`,
+		).Scan(&version)
+	})
+
+	return version, err
+}
+
+func (r *Runner) withLock(ctx context.Context, db *sql.DB, fn func(conn *sql.Conn) error) error {
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		return fmt.Errorf("acquiring connection: %w", err)
+	}
+	defer conn.Close()
+
+	if _, err := conn.ExecContext(ctx, `SELECT pg_advisory_lock($1);`, r.lockID); err != nil {
+		return fmt.Errorf("acquiring advisory lock: %w", err)
+	}
+	defer conn.ExecContext(context.Background(), `SELECT pg_advisory_unlock($1);`, r.lockID)
+
+	if _, err := conn.ExecContext(
+		ctx,
+		`CREATE TABLE IF NOT EXISTS schema_migrations (
+			version BIGINT PRIMARY KEY,
+			name TEXT NOT NULL,
+			applied_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW()
+		);`,
+	); err != nil {
+		return fmt.Errorf("creating schema_migrations: %w", err)
+	}
+
+	return fn(conn)
+}
+
+func apply(ctx context.Context, conn *sql.Conn, script string, record func(tx *sql.Tx) error) error {
+	tx, err := conn.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.ExecContext(ctx, script); err != nil {
+		return err
+	}
+
+	if err := record(tx); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+func appliedVersions(ctx context.Context, conn *sql.Conn) (map[int64]bool, error) {
+	rows, err := conn.QueryContext(ctx, `SELECT version FROM schema_migrations;`)
+	if err != nil {
+		return nil, fmt.Errorf("reading schema_migrations: %w", err)
+	}
+	defer rows.Close()
+
+	applied := map[int64]bool{}
+
+	for rows.Next() {
+		var version int64
+		if err := rows.Scan(&version); err != nil {
+			return nil, fmt.Errorf("reading schema_migrations: %w", err)
+		}
+		applied[version] = true
+	}
+
+	return applied, rows.Err()
+}
+
+func (r *Runner) load() ([]migration, error) {
+	entries, err := fs.ReadDir(r.files, ".")
+	if err != nil {
+		return nil, err
+	}
+
+	byVersion := map[int64]*migration{}
+
+	for _, e := range entries {
+		name := e.Name()
+
+		var direction string
+		switch {
+		case strings.HasSuffix(name, ".up.sql"):
+			direction = "up"
+		case strings.HasSuffix(name, ".down.sql"):
+			direction = "down"
+		default:
+			continue
+		}
+
+		base := strings.TrimSuffix(name, "."+direction+".sql")
+		versionPart, migrationName, ok := strings.Cut(base, "_")
+		if !ok {
+			return nil, fmt.Errorf("migration file %q: expected <version>_<name>", name)
+		}
+
+		version, err := strconv.ParseInt(versionPart, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("migration file %q: invalid version: %w", name, err)
+		}
+
+		content, err := fs.ReadFile(r.files, name)
+		if err != nil {
+			return nil, err
+		}
+
+		m, exist := byVersion[version]
+		if !exist {
+			m = &migration{version: version, name: migrationName}
+			byVersion[version] = m
+		}
+
+		if direction == "up" {
+			m.up = string(content)
+		} else {
+			m.down = string(content)
+		}
+	}
+
+	all := []migration{}
+
+	for _, m := range byVersion {
+		if m.up == "" || m.down == "" {
+			return nil, fmt.Errorf("migration %d_%s: missing up or down file", m.version, m.name)
+		}
+		all = append(all, *m)
+	}
+
+	sort.Slice(all, func(i, j int) bool { return all[i].version < all[j].version })
+
+	return all, nil
+}
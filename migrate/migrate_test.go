@@ -0,0 +1,250 @@
+package migrate
+
+import (
+	"context"
+	"database/sql"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"testing/fstest"
+	"time"
+)
+
+const lockID = 42
+
+func files(scripts map[string]string) fstest.MapFS {
+	fsys := fstest.MapFS{}
+	for name, script := range scripts {
+		fsys[name] = &fstest.MapFile{Data: []byte(script)}
+	}
+	return fsys
+}
+
+// three migrations, each creating one table
+var schema = files(map[string]string{
+	"0001_create_accounts.up.sql":   "CREATE TABLE accounts;",
+	"0001_create_accounts.down.sql": "DROP TABLE accounts;",
+	"0002_create_orders.up.sql":     "CREATE TABLE orders;",
+	"0002_create_orders.down.sql":   "DROP TABLE orders;",
+	"0010_create_carts.up.sql":      "CREATE TABLE carts;",
+	"0010_create_carts.down.sql":    "DROP TABLE carts;",
+	"README.md":                     "not a migration",
+})
+
+func checkState(t *testing.T, f *fakeDB, db *sql.DB, wantVersion int64, wantTables ...string) {
+	t.Helper()
+
+	version, err := New(schema, lockID).Version(context.Background(), db)
+	if err != nil {
+		t.Fatalf("Version: %v", err)
+	}
+	if version != wantVersion {
+		t.Errorf("version = %d, want %d", version, wantVersion)
+	}
+
+	slices.Sort(wantTables)
+	if got := f.tableNames(); !slices.Equal(got, wantTables) {
+		t.Errorf("tables = %v, want %v", got, wantTables)
+	}
+}
+
+func TestUpDownUp(t *testing.T) {
+	f, db := newFakeDB(t)
+	r := New(schema, lockID)
+	ctx := context.Background()
+
+	if err := r.Up(ctx, db); err != nil {
+		t.Fatalf("Up: %v", err)
+	}
+	checkState(t, f, db, 10, "accounts", "orders", "carts")
+	if got := f.applied(); !slices.Equal(got, []int64{1, 2, 10}) {
+		t.Errorf("schema_migrations = %v, want [1 2 10]", got)
+	}
+
+	// a second Up has nothing left to apply
+	if err := r.Up(ctx, db); err != nil {
+		t.Fatalf("second Up: %v", err)
+	}
+	checkState(t, f, db, 10, "accounts", "orders", "carts")
+
+	if err := r.Down(ctx, db, 1); err != nil {
+		t.Fatalf("Down(1): %v", err)
+	}
+	checkState(t, f, db, 2, "accounts", "orders")
+
+	if err := r.Down(ctx, db, 5); err != nil {
+		t.Fatalf("Down(5): %v", err)
+	}
+	checkState(t, f, db, 0)
+
+	if err := r.Up(ctx, db); err != nil {
+		t.Fatalf("Up after Down: %v", err)
+	}
+	checkState(t, f, db, 10, "accounts", "orders", "carts")
+}
+
+func TestUpAppliesNewMigrations(t *testing.T) {
+	f, db := newFakeDB(t)
+	ctx := context.Background()
+
+	older := files(map[string]string{
+		"0001_create_accounts.up.sql":   "CREATE TABLE accounts;",
+		"0001_create_accounts.down.sql": "DROP TABLE accounts;",
+	})
+	if err := New(older, lockID).Up(ctx, db); err != nil {
+		t.Fatalf("Up of the older release: %v", err)
+	}
+
+	if err := New(schema, lockID).Up(ctx, db); err != nil {
+		t.Fatalf("Up of the newer release: %v", err)
+	}
+	checkState(t, f, db, 10, "accounts", "orders", "carts")
+}
+
+func TestFailedMigrationRollsBack(t *testing.T) {
+	f, db := newFakeDB(t)
+	ctx := context.Background()
+
+	broken := files(map[string]string{
+		"0001_create_accounts.up.sql":   "CREATE TABLE accounts;",
+		"0001_create_accounts.down.sql": "DROP TABLE accounts;",
+		"0002_create_orders.up.sql":     "CREATE TABLE orders; CREATE TABLE order_items; FAIL;",
+		"0002_create_orders.down.sql":   "DROP TABLE order_items; DROP TABLE orders;",
+		"0003_create_carts.up.sql":      "CREATE TABLE carts;",
+		"0003_create_carts.down.sql":    "DROP TABLE carts;",
+	})
+
+	err := New(broken, lockID).Up(ctx, db)
+	if err == nil || !strings.Contains(err.Error(), "migration 2_create_orders up") {
+		t.Fatalf("Up = %v, want migration 2 to fail", err)
+	}
+
+	// the first migration stays applied, the failed one left nothing behind
+	// and the later one never ran
+	if got := f.tableNames(); !slices.Equal(got, []string{"accounts"}) {
+		t.Errorf("tables = %v, want [accounts]", got)
+	}
+	if got := f.applied(); !slices.Equal(got, []int64{1}) {
+		t.Errorf("schema_migrations = %v, want [1]", got)
+	}
+}
+
+func TestConcurrentRunners(t *testing.T) {
+	f, db := newFakeDB(t)
+	ctx := context.Background()
+
+	const replicas = 8
+
+	var wg sync.WaitGroup
+	errs := make([]error, replicas)
+	for i := range replicas {
+		wg.Go(func() {
+			errs[i] = New(schema, lockID).Up(ctx, db)
+		})
+	}
+	wg.Wait()
+
+	for i, err := range errs {
+		if err != nil {
+			t.Errorf("replica %d: Up: %v", i, err)
+		}
+	}
+
+	// every migration ran exactly once
+	slices.Sort(f.scripts)
+	want := []string{"CREATE TABLE accounts", "CREATE TABLE carts", "CREATE TABLE orders"}
+	if !slices.Equal(f.scripts, want) {
+		t.Errorf("statements run = %v, want %v", f.scripts, want)
+	}
+	checkState(t, f, db, 10, "accounts", "orders", "carts")
+}
+
+func TestRunnerWaitsForTheLock(t *testing.T) {
+	f, db := newFakeDB(t)
+	ctx := context.Background()
+
+	// another replica is migrating
+	holder := &fakeConn{db: f}
+	if err := f.lock(ctx, holder, lockID); err != nil {
+		t.Fatalf("lock: %v", err)
+	}
+
+	timeout, cancel := context.WithTimeout(ctx, 20*time.Millisecond)
+	defer cancel()
+	if err := New(schema, lockID).Up(timeout, db); err == nil {
+		t.Fatal("Up succeeded while another runner held the lock")
+	}
+	if got := f.tableNames(); len(got) != 0 {
+		t.Errorf("tables = %v, want none while locked", got)
+	}
+
+	// a different service's lock doesn't get in the way
+	if _, err := New(schema, lockID+1).Version(ctx, db); err != nil {
+		t.Errorf("Version with another lock ID: %v", err)
+	}
+
+	done := make(chan error)
+	go func() { done <- New(schema, lockID).Up(ctx, db) }()
+
+	select {
+	case err := <-done:
+		t.Fatalf("Up = %v before the lock was released", err)
+	case <-time.After(20 * time.Millisecond):
+	}
+
+	f.unlock(holder, lockID)
+	if err := <-done; err != nil {
+		t.Fatalf("Up after the lock was released: %v", err)
+	}
+	checkState(t, f, db, 10, "accounts", "orders", "carts")
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if len(f.locks) != 0 {
+		t.Errorf("locks %v still held after the runs", f.locks)
+	}
+}
+
+func TestBadFiles(t *testing.T) {
+	tests := []struct {
+		name  string
+		files map[string]string
+		want  string
+	}{
+		{
+			"missing down",
+			map[string]string{"0001_create_accounts.up.sql": "CREATE TABLE accounts;"},
+			"missing up or down file",
+		},
+		{
+			"missing up",
+			map[string]string{"0001_create_accounts.down.sql": "DROP TABLE accounts;"},
+			"missing up or down file",
+		},
+		{
+			"no name",
+			map[string]string{"0001.up.sql": "CREATE TABLE accounts;", "0001.down.sql": "DROP TABLE accounts;"},
+			"expected <version>_<name>",
+		},
+		{
+			"bad version",
+			map[string]string{"v1_create_accounts.up.sql": "CREATE TABLE accounts;", "v1_create_accounts.down.sql": "DROP TABLE accounts;"},
+			"invalid version",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f, db := newFakeDB(t)
+
+			err := New(files(tt.files), lockID).Up(context.Background(), db)
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Fatalf("Up = %v, want an error containing %q", err, tt.want)
+			}
+			if got := f.tableNames(); len(got) != 0 {
+				t.Errorf("tables = %v, want nothing applied", got)
+			}
+		})
+	}
+}
@@ -0,0 +1,3 @@
+module github.com/airlangga-hub/microservices/money
+
+go 1.25.3
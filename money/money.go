@@ -0,0 +1,209 @@
+// Package money represents amounts as a currency and a count of the
+// currency's minor units, and does overflow-checked arithmetic on them.
+//
+// Amounts in different currencies never mix: adding or comparing them is
+// ErrCurrencyMismatch rather than a silently wrong number.
+package money
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"math/big"
+	"regexp"
+)
+
+// DefaultCurrency is assumed for prices stored before amounts carried a
+// currency, and for requests that don't name one.
+const DefaultCurrency = "USD"
+
+var (
+	ErrCurrencyMismatch = errors.New("amounts are in different currencies")
+	ErrOverflow         = errors.New("amount is too large")
+	ErrInvalidCurrency  = errors.New("currency must be a three letter ISO 4217 code")
+	ErrInvalidRate      = errors.New("exchange rate must be a positive decimal number")
+)
+
+// minorUnits lists the ISO 4217 currencies whose minor unit isn't a
+// hundredth. The rest have two decimals.
+var minorUnits = map[string]int{
+	"BHD": 3, "IQD": 3, "JOD": 3, "KWD": 3, "LYD": 3, "OMR": 3, "TND": 3,
+	"BIF": 0, "CLP": 0, "DJF": 0, "GNF": 0, "ISK": 0, "JPY": 0, "KMF": 0,
+	"KRW": 0, "PYG": 0, "RWF": 0, "UGX": 0, "VND": 0, "VUV": 0, "XAF": 0,
+	"XOF": 0, "XPF": 0,
+}
+
+// Exponent is the number of decimals in currency's minor unit, 2 for cents.
+func Exponent(currency string) int {
+	if e, exist := minorUnits[currency]; exist {
+		return e
+	}
+	return 2
+}
+
+var decimal = regexp.MustCompile(`^[0-9]+(\.[0-9]+)?$`)
+
+// ParseRate parses an exchange rate written as a plain decimal, like
+// "15500" or "0.92". It is how many units of one currency a single unit of
+// another buys, in major units.
+func ParseRate(s string) (*big.Rat, error) {
+	r, ok := new(big.Rat).SetString(s)
+	if !decimal.MatchString(s) || !ok || r.Sign() <= 0 {
+		return nil, fmt.Errorf("%w: %q", ErrInvalidRate, s)
+	}
+	return r, nil
+}
+
+type Money struct {
+	Currency string `json:"currency"`
+	Units    int64  `json:"units"`
+}
+
+func New(currency string, units int64) Money {
+	return Money{Currency: currency, Units: units}
+}
+
+// ValidateCurrency checks code looks like an ISO 4217 code. It doesn't check
+// the code is assigned.
+func ValidateCurrency(code string) error {
+	if len(code) != 3 {
+		return fmt.Errorf("%w: %q", ErrInvalidCurrency, code)
+	}
+
+	for _, c := range code {
+		if c < 'A' || c > 'Z' {
+			return fmt.Errorf("%w: %q", ErrInvalidCurrency, code)
+		}
+	}
+
+	return nil
+}
+
+func (m Money) IsZero() bool {
+	return m.Units == 0
+}
+
+func (m Money) String() string {
+	return fmt.Sprintf("%d %s", m.Units, m.Currency)
+}
+
+func (m Money) Add(o Money) (Money, error) {
+	if err := m.same(o); err != nil {
+		return Money{}, err
+	}
+
+	sum := m.Units + o.Units
+	if (o.Units > 0 && sum < m.Units) || (o.Units < 0 && sum > m.Units) {
+		return Money{}, fmt.Errorf("%w: %s + %s", ErrOverflow, m, o)
+	}
+
+	return Money{Currency: m.Currency, Units: sum}, nil
+}
+
+func (m Money) Sub(o Money) (Money, error) {
+	if o.Units == math.MinInt64 {
+		return Money{}, fmt.Errorf("%w: %s - %s", ErrOverflow, m, o)
+	}
+
+	return m.Add(Money{Currency: o.Currency, Units: -o.Units})
+}
+
+func (m Money) Mul(n int64) (Money, error) {
+	product := m.Units * n
+	if m.Units != 0 && (product/m.Units != n || (m.Units == -1 && n == math.MinInt64)) {
+		return Money{}, fmt.Errorf("%w: %s * %d", ErrOverflow, m, n)
+	}
+
+	return Money{Currency: m.Currency, Units: product}, nil
+}
+
+// MulRatio returns m * num / den, rounded half away from zero. The product
+// is worked out exactly, so only a result that doesn't fit overflows.
+func (m Money) MulRatio(num, den int64) (Money, error) {
+	if den == 0 {
+		return Money{}, errors.New("money: zero denominator")
+	}
+
+	q := new(big.Int).Mul(big.NewInt(m.Units), big.NewInt(num))
+	d := big.NewInt(den)
+	r := new(big.Int)
+	q.QuoRem(q, d, r)
+
+	// round half away from zero
+	if r.Sign() != 0 && new(big.Int).Abs(new(big.Int).Lsh(r, 1)).Cmp(new(big.Int).Abs(d)) >= 0 {
+		if (r.Sign() < 0) != (d.Sign() < 0) {
+			q.Sub(q, big.NewInt(1))
+		} else {
+			q.Add(q, big.NewInt(1))
+		}
+	}
+
+	if !q.IsInt64() {
+		return Money{}, fmt.Errorf("%w: %s * %d / %d", ErrOverflow, m, num, den)
+	}
+
+	return Money{Currency: m.Currency, Units: q.Int64()}, nil
+}
+
+// Convert prices m in currency at rate, rounding half away from zero to the
+// target's minor unit. rate is in major units, so converting 1000 USD cents
+// to JPY at 150 gives 1500 yen.
+func (m Money) Convert(currency string, rate *big.Rat) (Money, error) {
+	r := new(big.Rat).Mul(new(big.Rat).SetInt64(m.Units), rate)
+
+	for e := Exponent(m.Currency); e < Exponent(currency); e++ {
+		r.Mul(r, big.NewRat(10, 1))
+	}
+	for e := Exponent(m.Currency); e > Exponent(currency); e-- {
+		r.Quo(r, big.NewRat(10, 1))
+	}
+
+	// round half away from zero; the denominator is always positive
+	q, rem := new(big.Int).QuoRem(r.Num(), r.Denom(), new(big.Int))
+	if new(big.Int).Lsh(new(big.Int).Abs(rem), 1).Cmp(r.Denom()) >= 0 {
+		q.Add(q, big.NewInt(int64(r.Sign())))
+	}
+
+	if !q.IsInt64() {
+		return Money{}, fmt.Errorf("%w: %s at %s", ErrOverflow, m, rate.FloatString(8))
+	}
+
+	return Money{Currency: currency, Units: q.Int64()}, nil
+}
+
+// Cmp compares m and o, which must be in the same currency.
+func (m Money) Cmp(o Money) (int, error) {
+	if err := m.same(o); err != nil {
+		return 0, err
+	}
+
+	switch {
+	case m.Units < o.Units:
+		return -1, nil
+	case m.Units > o.Units:
+		return 1, nil
+	default:
+		return 0, nil
+	}
+}
+
+// Min returns the smaller of m and o, which must be in the same currency.
+func (m Money) Min(o Money) (Money, error) {
+	c, err := m.Cmp(o)
+	if err != nil {
+		return Money{}, err
+	}
+
+	if c > 0 {
+		return o, nil
+	}
+
+	return m, nil
+}
+
+func (m Money) same(o Money) error {
+	if m.Currency != o.Currency {
+		return fmt.Errorf("%w: %s and %s", ErrCurrencyMismatch, m.Currency, o.Currency)
+	}
+	return nil
+}
@@ -0,0 +1,139 @@
+package money
+
+import (
+	"errors"
+	"math"
+	"testing"
+)
+
+func TestArithmetic(t *testing.T) {
+	usd := func(units int64) Money { return New("USD", units) }
+
+	tests := []struct {
+		name    string
+		op      func() (Money, error)
+		want    Money
+		wantErr error
+	}{
+		{"add", func() (Money, error) { return usd(150).Add(usd(50)) }, usd(200), nil},
+		{"sub", func() (Money, error) { return usd(150).Sub(usd(200)) }, usd(-50), nil},
+		{"mul", func() (Money, error) { return usd(150).Mul(3) }, usd(450), nil},
+		{"mul ratio rounds half up", func() (Money, error) { return usd(10).MulRatio(500, 10000) }, usd(1), nil},
+		{"mul ratio rounds negative away from zero", func() (Money, error) { return usd(-10).MulRatio(500, 10000) }, usd(-1), nil},
+		{"mul ratio exact past int64", func() (Money, error) { return usd(math.MaxInt64).MulRatio(3, 4) }, usd(6917529027641081855), nil},
+		{"add overflow", func() (Money, error) { return usd(math.MaxInt64).Add(usd(1)) }, Money{}, ErrOverflow},
+		{"sub overflow", func() (Money, error) { return usd(math.MinInt64).Sub(usd(1)) }, Money{}, ErrOverflow},
+		{"sub min int64", func() (Money, error) { return usd(0).Sub(usd(math.MinInt64)) }, Money{}, ErrOverflow},
+		{"mul overflow", func() (Money, error) { return usd(math.MaxInt64 / 2).Mul(3) }, Money{}, ErrOverflow},
+		{"mul min int64 by -1", func() (Money, error) { return usd(-1).Mul(math.MinInt64) }, Money{}, ErrOverflow},
+		{"mul ratio overflow", func() (Money, error) { return usd(math.MaxInt64).MulRatio(2, 1) }, Money{}, ErrOverflow},
+		{"mixed currencies", func() (Money, error) { return usd(1).Add(New("EUR", 1)) }, Money{}, ErrCurrencyMismatch},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := tt.op()
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("err = %v, want %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestConvert(t *testing.T) {
+	tests := []struct {
+		name     string
+		from     Money
+		currency string
+		rate     string
+		want     Money
+	}{
+		{"same exponent", New("USD", 1000), "EUR", "0.92", New("EUR", 920)},
+		{"to zero decimals", New("USD", 1000), "JPY", "150", New("JPY", 1500)},
+		{"from zero decimals", New("JPY", 1500), "USD", "0.0066667", New("USD", 1000)},
+		{"to three decimals", New("USD", 1000), "KWD", "0.307", New("KWD", 3070)},
+		{"rounds half up", New("USD", 1), "EUR", "0.5", New("EUR", 1)},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rate, err := ParseRate(tt.rate)
+			if err != nil {
+				t.Fatalf("ParseRate: %v", err)
+			}
+
+			got, err := tt.from.Convert(tt.currency, rate)
+			if err != nil {
+				t.Fatalf("Convert: %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+
+	for _, bad := range []string{"", "0", "-1", "1/3", "1e3", ".5"} {
+		if _, err := ParseRate(bad); !errors.Is(err, ErrInvalidRate) {
+			t.Errorf("ParseRate(%q) err = %v, want ErrInvalidRate", bad, err)
+		}
+	}
+}
+
+func TestCompare(t *testing.T) {
+	small, large := New("USD", 100), New("USD", 250)
+
+	for _, tt := range []struct {
+		a, b Money
+		want int
+	}{
+		{small, large, -1},
+		{large, small, 1},
+		{small, small, 0},
+	} {
+		if got, err := tt.a.Cmp(tt.b); err != nil || got != tt.want {
+			t.Errorf("%v.Cmp(%v) = %d, %v; want %d", tt.a, tt.b, got, err, tt.want)
+		}
+	}
+
+	if got, err := large.Min(small); err != nil || got != small {
+		t.Errorf("%v.Min(%v) = %v, %v; want %v", large, small, got, err, small)
+	}
+	if got, err := small.Min(large); err != nil || got != small {
+		t.Errorf("%v.Min(%v) = %v, %v; want %v", small, large, got, err, small)
+	}
+
+	if _, err := small.Cmp(New("EUR", 100)); !errors.Is(err, ErrCurrencyMismatch) {
+		t.Errorf("Cmp across currencies err = %v, want ErrCurrencyMismatch", err)
+	}
+	if _, err := small.Min(New("EUR", 100)); !errors.Is(err, ErrCurrencyMismatch) {
+		t.Errorf("Min across currencies err = %v, want ErrCurrencyMismatch", err)
+	}
+}
+
+func TestCurrencies(t *testing.T) {
+	for _, code := range []string{"USD", "JPY", "KWD", "XYZ"} {
+		if err := ValidateCurrency(code); err != nil {
+			t.Errorf("ValidateCurrency(%q) = %v", code, err)
+		}
+	}
+	for _, code := range []string{"", "usd", "US", "USDT", "U$D"} {
+		if err := ValidateCurrency(code); !errors.Is(err, ErrInvalidCurrency) {
+			t.Errorf("ValidateCurrency(%q) err = %v, want ErrInvalidCurrency", code, err)
+		}
+	}
+
+	for code, want := range map[string]int{"USD": 2, "EUR": 2, "JPY": 0, "KWD": 3, "XYZ": 2} {
+		if got := Exponent(code); got != want {
+			t.Errorf("Exponent(%q) = %d, want %d", code, got, want)
+		}
+	}
+}
+
+func TestMulRatioZeroDenominator(t *testing.T) {
+	if _, err := New("USD", 100).MulRatio(1, 0); err == nil {
+		t.Error("MulRatio by a zero denominator succeeded")
+	}
+}
@@ -0,0 +1,327 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"slices"
+	"time"
+
+	"github.com/airlangga-hub/microservices/audit"
+	"github.com/airlangga-hub/microservices/auth"
+	"github.com/airlangga-hub/microservices/events"
+	"github.com/airlangga-hub/microservices/healthcheck"
+	accpb "github.com/airlangga-hub/microservices/order/account_pb"
+	catpb "github.com/airlangga-hub/microservices/order/catalog_pb"
+	"github.com/airlangga-hub/microservices/order/config"
+	paypb "github.com/airlangga-hub/microservices/order/payment_pb"
+	"github.com/airlangga-hub/microservices/order/pb"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/reflection"
+)
+
+// app is the order service composition root. It owns the repository, the
+// downstream client connections and the grpc and http servers, and releases
+// them in reverse order on Shutdown.
+type app struct {
+	cfg          config.Config
+	repository   Repository
+	bus          events.Bus
+	relayDone    chan struct{}
+	capturesDone chan struct{}
+	feed         *orderFeed
+	unsubscribe  func()
+	accountConn  *grpc.ClientConn
+	catalogConn  *grpc.ClientConn
+	paymentConn  *grpc.ClientConn
+	server       *Server
+	grpcServer   *grpc.Server
+	healthServer *health.Server
+	checker      *healthcheck.Checker
+	httpServer   *http.Server
+}
+
+func newApp(cfg config.Config) (*app, error) {
+	a := &app{cfg: cfg}
+
+	tax, err := openTaxCalculator(cfg)
+	if err != nil {
+		return nil, fmt.Errorf("couldn't load tax rules: %w", err)
+	}
+
+	repository, err := openRepository(cfg)
+	if err != nil {
+		return nil, fmt.Errorf("couldn't create repository: %w", err)
+	}
+	a.repository = repository
+
+	a.bus, err = openBus(cfg)
+	if err != nil {
+		a.close()
+		return nil, fmt.Errorf("couldn't create event bus: %w", err)
+	}
+
+	a.feed = newOrderFeed(cfg.Watch.History, cfg.Watch.Buffer)
+
+	if subscriber, ok := a.bus.(events.Subscriber); ok {
+		a.unsubscribe, err = subscriber.Subscribe(a.feed.Publish)
+		if err != nil {
+			a.close()
+			return nil, fmt.Errorf("couldn't subscribe to order events: %w", err)
+		}
+	}
+
+	a.accountConn, err = dialService("account", cfg.AccountServiceURL, cfg.ClientCallTimeout)
+	if err != nil {
+		a.close()
+		return nil, fmt.Errorf("couldn't create account client: %w", err)
+	}
+
+	a.catalogConn, err = dialService("catalog", cfg.CatalogServiceURL, cfg.ClientCallTimeout)
+	if err != nil {
+		a.close()
+		return nil, fmt.Errorf("couldn't create catalog client: %w", err)
+	}
+
+	a.paymentConn, err = dialService("payment", cfg.PaymentServiceURL, cfg.ClientCallTimeout)
+	if err != nil {
+		a.close()
+		return nil, fmt.Errorf("couldn't create payment client: %w", err)
+	}
+
+	a.server = &Server{
+		Svc:           NewService(repository, tax, cfg.Tax.DefaultRegion),
+		AccountClient: accpb.NewAccountServiceClient(a.accountConn),
+		CatalogClient: catpb.NewCatalogServiceClient(a.catalogConn),
+		PaymentClient: paypb.NewPaymentServiceClient(a.paymentConn),
+		Feed:          a.feed,
+	}
+
+	authenticate := apiKeyAuthenticator(a.server.AccountClient)
+
+	a.grpcServer = grpc.NewServer(
+		grpc.ChainUnaryInterceptor(
+			auth.UnaryInterceptor(authenticate, methodScopes),
+			audit.Interceptor("order", repository, auditSpecs(repository)),
+		),
+		grpc.ChainStreamInterceptor(auth.StreamInterceptor(authenticate, methodScopes)),
+	)
+	pb.RegisterOrderServiceServer(a.grpcServer, a.server)
+	pb.RegisterPromotionServiceServer(a.grpcServer, &PromotionServer{Repo: repository})
+	pb.RegisterCartServiceServer(a.grpcServer, &CartServer{
+		Repo:          repository,
+		AccountClient: a.server.AccountClient,
+		CatalogClient: a.server.CatalogClient,
+		Orders:        a.server,
+	})
+
+	a.healthServer = health.NewServer()
+	a.healthServer.SetServingStatus("", healthpb.HealthCheckResponse_NOT_SERVING)
+	healthpb.RegisterHealthServer(a.grpcServer, a.healthServer)
+	reflection.Register(a.grpcServer)
+
+	a.checker = healthcheck.New(a.healthServer, pb.OrderService_ServiceDesc.ServiceName, a.checkDependencies)
+
+	mux := http.NewServeMux()
+	mux.Handle("/", a.checker.HTTPHandler())
+	mux.Handle("/metrics", promhttp.Handler())
+
+	a.httpServer = &http.Server{Addr: cfg.HTTPPort, Handler: mux}
+
+	return a, nil
+}
+
+func openRepository(cfg config.Config) (Repository, error) {
+	if cfg.Storage == config.StorageMemory {
+		log.Println("WARNING: order app: using in-memory storage, data is lost on restart")
+		return NewMemoryRepository(), nil
+	}
+
+	return NewRepository(cfg.DatabaseURL, cfg.DB)
+}
+
+func openBus(cfg config.Config) (events.Bus, error) {
+	if cfg.Events.Bus == config.EventBusNATS {
+		return events.NewNATSBus(cfg.Events.NATSURL, "order")
+	}
+
+	return events.NewMemoryBus(), nil
+}
+
+// openTaxCalculator builds the local rules engine from the configured rules
+// file, or from DefaultTaxRules.
+func openTaxCalculator(cfg config.Config) (TaxCalculator, error) {
+	rules := DefaultTaxRules
+
+	if cfg.Tax.RulesFile != "" {
+		var err error
+		if rules, err = LoadTaxRules(cfg.Tax.RulesFile); err != nil {
+			return nil, err
+		}
+	}
+
+	if cfg.Tax.DefaultRegion != "" && !slices.ContainsFunc(rules, func(r TaxRule) bool { return r.Region == cfg.Tax.DefaultRegion }) {
+		return nil, fmt.Errorf("no tax rules for default region %s", cfg.Tax.DefaultRegion)
+	}
+
+	return NewTaxRules(rules), nil
+}
+
+func (a *app) checkDependencies(ctx context.Context) error {
+	return healthcheck.All(
+		a.repository.Ping,
+		healthcheck.Downstream(healthpb.NewHealthClient(a.accountConn), accpb.AccountService_ServiceDesc.ServiceName),
+		healthcheck.Downstream(healthpb.NewHealthClient(a.catalogConn), catpb.CatalogService_ServiceDesc.ServiceName),
+		healthcheck.Downstream(healthpb.NewHealthClient(a.paymentConn), paypb.PaymentService_ServiceDesc.ServiceName),
+	)(ctx)
+}
+
+// WaitForDependencies blocks until the database, account, catalog and
+// payment are reachable, or fails once ctx is done.
+func (a *app) WaitForDependencies(ctx context.Context) error {
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+
+	for {
+		checkCtx, cancel := context.WithTimeout(ctx, healthcheck.CheckTimeout)
+		err := a.checkDependencies(checkCtx)
+		cancel()
+
+		if err == nil {
+			return nil
+		}
+
+		log.Println("INFO: order app waiting for dependencies: ", err)
+
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("dependencies not reachable: %w", err)
+		case <-ticker.C:
+		}
+	}
+}
+
+// Run serves grpc and http until ctx is done or a server fails, and returns
+// the reason it stopped.
+func (a *app) Run(ctx context.Context) error {
+	lis, err := net.Listen("tcp", a.cfg.Port)
+	if err != nil {
+		return fmt.Errorf("couldn't listen on %s: %w", a.cfg.Port, err)
+	}
+
+	go a.checker.Run(ctx)
+
+	relay := events.NewRelay(a.repository, a.bus, a.cfg.Events.PollInterval, a.cfg.Events.BatchSize)
+	a.relayDone = make(chan struct{})
+
+	go func() {
+		relay.Run(ctx)
+		close(a.relayDone)
+	}()
+
+	// captures are retried on the outbox's schedule
+	captures := &captureRelay{
+		repo:      a.repository,
+		capture:   a.server.capturePayment,
+		interval:  a.cfg.Events.PollInterval,
+		batchSize: a.cfg.Events.BatchSize,
+	}
+	a.capturesDone = make(chan struct{})
+
+	go func() {
+		captures.Run(ctx)
+		close(a.capturesDone)
+	}()
+
+	exitChan := make(chan error, 2)
+
+	go func() {
+		err := a.grpcServer.Serve(lis)
+		if err != nil && err != grpc.ErrServerStopped {
+			exitChan <- fmt.Errorf("grpc serve error: %v", err)
+		}
+	}()
+
+	go func() {
+		err := a.httpServer.ListenAndServe()
+		if err != nil && err != http.ErrServerClosed {
+			exitChan <- fmt.Errorf("http serve error: %v", err)
+		}
+	}()
+
+	select {
+	case <-ctx.Done():
+		return context.Cause(ctx)
+	case err := <-exitChan:
+		return err
+	}
+}
+
+// Shutdown stops taking traffic, lets in-flight orders finish, then closes
+// the downstream connections and the repository. Whatever is still running
+// when ctx is done is cut off.
+func (a *app) Shutdown(ctx context.Context) {
+	a.healthServer.Shutdown()
+
+	// WatchOrders streams never end on their own and would hold up
+	// GracefulStop
+	a.feed.Close()
+
+	if err := a.httpServer.Shutdown(ctx); err != nil {
+		log.Println("ERROR: order app Shutdown (http): ", err)
+	}
+
+	stopped := make(chan struct{})
+	go func() {
+		a.grpcServer.GracefulStop()
+		close(stopped)
+	}()
+
+	select {
+	case <-stopped:
+	case <-ctx.Done():
+		a.grpcServer.Stop()
+	}
+
+	if err := a.server.WaitForSagas(ctx); err != nil {
+		log.Println("ERROR: order app Shutdown (in-flight orders): ", err)
+	}
+
+	// Run's ctx is done by now, so the relays are on their way out
+	for _, done := range []chan struct{}{a.relayDone, a.capturesDone} {
+		if done != nil {
+			select {
+			case <-done:
+			case <-ctx.Done():
+			}
+		}
+	}
+
+	a.close()
+}
+
+func (a *app) close() {
+	if a.paymentConn != nil {
+		a.paymentConn.Close()
+	}
+	if a.catalogConn != nil {
+		a.catalogConn.Close()
+	}
+	if a.accountConn != nil {
+		a.accountConn.Close()
+	}
+	if a.unsubscribe != nil {
+		a.unsubscribe()
+	}
+	if a.bus != nil {
+		a.bus.Close()
+	}
+	if a.repository != nil {
+		a.repository.Close()
+	}
+}
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"context"
+	"errors"
+
+	"github.com/airlangga-hub/microservices/audit"
+	"github.com/airlangga-hub/microservices/order/pb"
+	"google.golang.org/protobuf/proto"
+)
+
+// auditSpecs lists the order, cart and promotion RPCs that change
+// something. A cart is recorded as it is after the call, priced then; the
+// previous entry for the account has it as it was before. Checkout is
+// recorded as the order it placed.
+func auditSpecs(repo Repository) map[string]audit.Spec {
+	order := func(ctx context.Context, id int32) (proto.Message, error) {
+		o, err := repo.GetOrder(ctx, id)
+		if errors.Is(err, errOrderNotFound) {
+			return nil, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		return orderToPB(o)
+	}
+
+	promotion := func(ctx context.Context, id int32) (proto.Message, error) {
+		p, err := repo.GetPromotionByID(ctx, id)
+		if errors.Is(err, errPromotionNotFound) {
+			return nil, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		return promotionToPB(p)
+	}
+
+	return map[string]audit.Spec{
+		pb.OrderService_PostOrder_FullMethodName: audit.Audited(
+			"order",
+			func(_ *pb.PostOrderRequest, resp *pb.PostOrderResponse) string { return audit.IntID(resp.Order.Id) },
+			nil,
+			func(_ context.Context, _ *pb.PostOrderRequest, resp *pb.PostOrderResponse) (proto.Message, error) {
+				return resp.Order, nil
+			},
+		),
+		pb.OrderService_UpdateOrderStatus_FullMethodName: audit.Audited(
+			"order",
+			func(req *pb.UpdateOrderStatusRequest, _ *pb.UpdateOrderStatusResponse) string {
+				return audit.IntID(req.Id)
+			},
+			func(ctx context.Context, req *pb.UpdateOrderStatusRequest) (proto.Message, error) {
+				return order(ctx, req.Id)
+			},
+			func(_ context.Context, _ *pb.UpdateOrderStatusRequest, resp *pb.UpdateOrderStatusResponse) (proto.Message, error) {
+				return resp.Order, nil
+			},
+		),
+		pb.CartService_AddItem_FullMethodName: audit.Audited(
+			"cart",
+			func(req *pb.AddItemRequest, _ *pb.AddItemResponse) string { return audit.IntID(req.AccountId) },
+			nil,
+			func(_ context.Context, _ *pb.AddItemRequest, resp *pb.AddItemResponse) (proto.Message, error) {
+				return resp.Cart, nil
+			},
+		),
+		pb.CartService_UpdateQuantity_FullMethodName: audit.Audited(
+			"cart",
+			func(req *pb.UpdateQuantityRequest, _ *pb.UpdateQuantityResponse) string {
+				return audit.IntID(req.AccountId)
+			},
+			nil,
+			func(_ context.Context, _ *pb.UpdateQuantityRequest, resp *pb.UpdateQuantityResponse) (proto.Message, error) {
+				return resp.Cart, nil
+			},
+		),
+		pb.CartService_RemoveItem_FullMethodName: audit.Audited(
+			"cart",
+			func(req *pb.RemoveItemRequest, _ *pb.RemoveItemResponse) string { return audit.IntID(req.AccountId) },
+			nil,
+			func(_ context.Context, _ *pb.RemoveItemRequest, resp *pb.RemoveItemResponse) (proto.Message, error) {
+				return resp.Cart, nil
+			},
+		),
+		pb.CartService_Checkout_FullMethodName: audit.Audited(
+			"order",
+			func(_ *pb.CheckoutRequest, resp *pb.CheckoutResponse) string { return audit.IntID(resp.Order.Id) },
+			nil,
+			func(_ context.Context, _ *pb.CheckoutRequest, resp *pb.CheckoutResponse) (proto.Message, error) {
+				return resp.Order, nil
+			},
+		),
+		pb.PromotionService_CreatePromotion_FullMethodName: audit.Audited(
+			"promotion",
+			func(_ *pb.CreatePromotionRequest, resp *pb.CreatePromotionResponse) string {
+				return audit.IntID(resp.Promotion.Id)
+			},
+			nil,
+			func(_ context.Context, _ *pb.CreatePromotionRequest, resp *pb.CreatePromotionResponse) (proto.Message, error) {
+				return resp.Promotion, nil
+			},
+		),
+		pb.PromotionService_DeactivatePromotion_FullMethodName: audit.Audited(
+			"promotion",
+			func(req *pb.DeactivatePromotionRequest, _ *pb.DeactivatePromotionResponse) string {
+				return audit.IntID(req.Id)
+			},
+			func(ctx context.Context, req *pb.DeactivatePromotionRequest) (proto.Message, error) {
+				return promotion(ctx, req.Id)
+			},
+			func(_ context.Context, _ *pb.DeactivatePromotionRequest, resp *pb.DeactivatePromotionResponse) (proto.Message, error) {
+				return resp.Promotion, nil
+			},
+		),
+	}
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"context"
+
+	"github.com/airlangga-hub/microservices/auth"
+	accpb "github.com/airlangga-hub/microservices/order/account_pb"
+	"github.com/airlangga-hub/microservices/order/pb"
+)
+
+// Permissions, granted by account roles, that privileged order calls need.
+// permissionReadAnyOrders lets a caller read other accounts' orders.
+const (
+	permissionReadAnyOrders    = "order:orders:read_any"
+	permissionReadAudit        = "order:audit:read"
+	permissionManagePromotions = "order:promotions:manage"
+	permissionManageOrders     = "order:orders:manage"
+)
+
+// methodScopes is the scope an API key needs for each order, cart and
+// promotion RPC.
+var methodScopes = auth.MethodScopes{
+	pb.OrderService_PostOrder_FullMethodName:               "order:orders:write",
+	pb.OrderService_GetOrdersByAccountID_FullMethodName:    "order:orders:read",
+	pb.OrderService_UpdateOrderStatus_FullMethodName:       "order:orders:manage",
+	pb.OrderService_WatchOrders_FullMethodName:             "order:orders:read",
+	pb.OrderService_QueryAuditLog_FullMethodName:           "order:audit:read",
+	pb.CartService_AddItem_FullMethodName:                  "order:carts:write",
+	pb.CartService_UpdateQuantity_FullMethodName:           "order:carts:write",
+	pb.CartService_RemoveItem_FullMethodName:               "order:carts:write",
+	pb.CartService_GetCart_FullMethodName:                  "order:carts:read",
+	pb.CartService_Checkout_FullMethodName:                 "order:orders:write",
+	pb.PromotionService_CreatePromotion_FullMethodName:     "order:promotions:manage",
+	pb.PromotionService_GetPromotion_FullMethodName:        "order:promotions:manage",
+	pb.PromotionService_ListPromotions_FullMethodName:      "order:promotions:manage",
+	pb.PromotionService_DeactivatePromotion_FullMethodName: "order:promotions:manage",
+}
+
+// apiKeyAuthenticator asks the account service whose API key a caller sent.
+func apiKeyAuthenticator(accounts accpb.AccountServiceClient) auth.Authenticator {
+	return func(ctx context.Context, apiKey string) (auth.Identity, error) {
+		res, err := accounts.AuthenticateAPIKey(ctx, &accpb.AuthenticateAPIKeyRequest{Key: apiKey})
+		if err != nil {
+			return auth.Identity{}, err
+		}
+
+		return auth.Identity{
+			AccountID:   res.GetApiKey().GetAccountId(),
+			Scopes:      res.GetApiKey().GetScopes(),
+			Permissions: res.GetPermissions(),
+		}, nil
+	}
+}
@@ -0,0 +1,162 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log"
+	"time"
+
+	paypb "github.com/airlangga-hub/microservices/order/payment_pb"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// captureBackoff is how long a capture waits after failing attempts times:
+// ten seconds after the first failure, doubling up to an hour.
+func captureBackoff(attempts int) time.Duration {
+	return min(10*time.Second<<min(max(attempts-1, 0), 9), time.Hour)
+}
+
+// captureRelay retries capturing the payments of placed orders that
+// PostOrder couldn't capture. Capture is idempotent, so a payment captured
+// twice, by PostOrder and the relay, is fine.
+type captureRelay struct {
+	repo      CaptureRepository
+	capture   func(ctx context.Context, paymentID int32) error
+	interval  time.Duration
+	batchSize int
+}
+
+func (r *captureRelay) Run(ctx context.Context) {
+	ticker := time.NewTicker(r.interval)
+	defer ticker.Stop()
+
+	for {
+		r.drain(ctx)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// drain keeps attempting full batches until no capture is due. Failed
+// captures are put off, so they don't come round again in the same drain.
+func (r *captureRelay) drain(ctx context.Context) {
+	for ctx.Err() == nil {
+		n, err := r.repo.DispatchCaptures(ctx, r.batchSize, r.capture)
+		if err != nil {
+			log.Println("ERROR: order capture relay: ", err)
+			return
+		}
+
+		if n < r.batchSize {
+			return
+		}
+	}
+}
+
+// capturePayment captures an order's authorized payment. A payment that can
+// no longer be captured, because it was voided or is gone, is given up on,
+// since retrying won't help.
+func (s *Server) capturePayment(ctx context.Context, paymentID int32) error {
+	_, err := s.PaymentClient.Capture(ctx, &paypb.CaptureRequest{Id: paymentID})
+
+	switch status.Code(err) {
+	case codes.OK:
+		return nil
+	case codes.FailedPrecondition, codes.NotFound:
+		log.Printf("ERROR: order server capturePayment: giving up on payment %d: %v", paymentID, err)
+		return nil
+	default:
+		return err
+	}
+}
+
+func (r *repository) CaptureDone(ctx context.Context, paymentID int32) error {
+	if _, err := r.db.ExecContext(ctx, `DELETE FROM pending_captures WHERE payment_id = $1;`, paymentID); err != nil {
+		log.Println("ERROR: order repo CaptureDone: ", err)
+		return errors.New("error recording capture")
+	}
+	return nil
+}
+
+// DispatchCaptures locks a batch of due captures, so concurrent relays skip
+// them, and records each attempt's outcome in the same transaction.
+func (r *repository) DispatchCaptures(ctx context.Context, limit int, capture func(ctx context.Context, paymentID int32) error) (int, error) {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		log.Println("ERROR: order repo DispatchCaptures (tx init): ", err)
+		return 0, errors.New("error dispatching captures")
+	}
+	defer tx.Rollback()
+
+	rows, err := tx.QueryContext(
+		ctx,
+		`SELECT
+			payment_id,
+			attempts
+		FROM pending_captures
+		WHERE next_attempt_at <= NOW()
+		ORDER BY next_attempt_at, payment_id
+		LIMIT $1
+		FOR UPDATE SKIP LOCKED;`,
+		limit,
+	)
+	if err != nil {
+		log.Println("ERROR: order repo DispatchCaptures (tx.QueryContext): ", err)
+		return 0, errors.New("error dispatching captures")
+	}
+
+	type due struct {
+		paymentID int32
+		attempts  int
+	}
+	pending := []due{}
+
+	for rows.Next() {
+		d := due{}
+		if err := rows.Scan(&d.paymentID, &d.attempts); err != nil {
+			rows.Close()
+			log.Println("ERROR: order repo DispatchCaptures (rows.Scan): ", err)
+			return 0, errors.New("error dispatching captures")
+		}
+		pending = append(pending, d)
+	}
+	rows.Close()
+
+	if err := rows.Err(); err != nil {
+		log.Println("ERROR: order repo DispatchCaptures (rows.Err): ", err)
+		return 0, errors.New("error dispatching captures")
+	}
+
+	for _, d := range pending {
+		if captureErr := capture(ctx, d.paymentID); captureErr != nil {
+			log.Printf("ERROR: order repo DispatchCaptures (capture payment %d): %v", d.paymentID, captureErr)
+
+			_, err = tx.ExecContext(
+				ctx,
+				`UPDATE pending_captures
+				SET attempts = attempts + 1, next_attempt_at = $2, last_error = $3
+				WHERE payment_id = $1;`,
+				d.paymentID, time.Now().Add(captureBackoff(d.attempts+1)), captureErr.Error(),
+			)
+		} else {
+			_, err = tx.ExecContext(ctx, `DELETE FROM pending_captures WHERE payment_id = $1;`, d.paymentID)
+		}
+
+		if err != nil {
+			log.Println("ERROR: order repo DispatchCaptures (record attempt): ", err)
+			return 0, errors.New("error dispatching captures")
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		log.Println("ERROR: order repo DispatchCaptures (tx commit): ", err)
+		return 0, errors.New("error dispatching captures")
+	}
+
+	return len(pending), nil
+}
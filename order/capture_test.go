@@ -0,0 +1,137 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	paypb "github.com/airlangga-hub/microservices/order/payment_pb"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestCaptureBackoff(t *testing.T) {
+	tests := []struct {
+		attempts int
+		want     time.Duration
+	}{
+		{1, 10 * time.Second},
+		{2, 20 * time.Second},
+		{5, 160 * time.Second},
+		{9, 2560 * time.Second},
+		{10, time.Hour},
+		{100, time.Hour},
+	}
+
+	for _, tt := range tests {
+		if got := captureBackoff(tt.attempts); got != tt.want {
+			t.Errorf("captureBackoff(%d) = %v, want %v", tt.attempts, got, tt.want)
+		}
+	}
+}
+
+// captures returns a capture relay over the harness's repository and
+// payment service.
+func (h *harness) captures() *captureRelay {
+	return &captureRelay{repo: h.Repo, capture: h.Carts.Orders.capturePayment, interval: time.Second, batchSize: 10}
+}
+
+// makeCapturesDue brings every pending capture's next attempt forward to now.
+func (h *harness) makeCapturesDue() {
+	repo := h.Repo.(*memoryRepository)
+
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+
+	for id, c := range repo.captures {
+		c.nextAttemptAt = time.Now().UTC()
+		repo.captures[id] = c
+	}
+}
+
+func (h *harness) pendingCaptures() map[int32]pendingCapture {
+	repo := h.Repo.(*memoryRepository)
+
+	repo.mu.RLock()
+	defer repo.mu.RUnlock()
+
+	captures := map[int32]pendingCapture{}
+	for id, c := range repo.captures {
+		captures[id] = c
+	}
+
+	return captures
+}
+
+func TestCaptureRelayRetriesFailedCaptures(t *testing.T) {
+	h := newHarness(t)
+	account := h.account(t, "angga")
+	keyboard := h.product(t, "keyboard", 100)
+
+	h.Payments.captureErr = status.Error(codes.Unavailable, "payment provider is down")
+	order := h.order(t, account.Id, keyboard)
+
+	// the order is placed, and its payment left for the relay
+	if got := h.Payments.status(order.PaymentId); got != paypb.PaymentStatus_PAYMENT_STATUS_AUTHORIZED {
+		t.Fatalf("payment status = %v, want authorized", got)
+	}
+	if _, pending := h.pendingCaptures()[order.PaymentId]; !pending {
+		t.Fatal("payment isn't pending capture")
+	}
+
+	h.captures().drain(context.Background())
+
+	c, pending := h.pendingCaptures()[order.PaymentId]
+	if !pending || c.attempts != 1 || time.Until(c.nextAttemptAt) < 5*time.Second {
+		t.Fatalf("after a failed attempt the capture is %+v (pending %v), want one attempt and the next put off", c, pending)
+	}
+
+	// it isn't due yet, so the relay leaves it alone
+	h.Payments.captureErr = nil
+	h.captures().drain(context.Background())
+	if got := h.Payments.status(order.PaymentId); got != paypb.PaymentStatus_PAYMENT_STATUS_AUTHORIZED {
+		t.Fatalf("payment status = %v before the capture was due, want authorized", got)
+	}
+
+	h.makeCapturesDue()
+	h.captures().drain(context.Background())
+
+	if got := h.Payments.status(order.PaymentId); got != paypb.PaymentStatus_PAYMENT_STATUS_CAPTURED {
+		t.Errorf("payment status = %v, want captured", got)
+	}
+	if _, pending := h.pendingCaptures()[order.PaymentId]; pending {
+		t.Error("captured payment is still pending capture")
+	}
+}
+
+func TestCaptureRelayGivesUpOnVoidedPayments(t *testing.T) {
+	h := newHarness(t)
+	account := h.account(t, "angga")
+	keyboard := h.product(t, "keyboard", 100)
+
+	h.Payments.captureErr = status.Error(codes.Unavailable, "payment provider is down")
+	order := h.order(t, account.Id, keyboard)
+	h.Payments.captureErr = nil
+
+	if _, err := h.Payments.Void(context.Background(), &paypb.VoidRequest{Id: order.PaymentId}); err != nil {
+		t.Fatalf("Void: %v", err)
+	}
+
+	h.captures().drain(context.Background())
+
+	if _, pending := h.pendingCaptures()[order.PaymentId]; pending {
+		t.Error("a voided payment is still pending capture")
+	}
+}
+
+func TestPostOrderForgetsCapturedPayments(t *testing.T) {
+	h := newHarness(t)
+	account := h.account(t, "angga")
+	keyboard := h.product(t, "keyboard", 100)
+
+	order := h.order(t, account.Id, keyboard)
+
+	if _, pending := h.pendingCaptures()[order.PaymentId]; pending {
+		t.Error("payment captured by PostOrder is still pending capture")
+	}
+}
@@ -0,0 +1,254 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log"
+	"time"
+
+	"github.com/airlangga-hub/microservices/money"
+	accpb "github.com/airlangga-hub/microservices/order/account_pb"
+	catpb "github.com/airlangga-hub/microservices/order/catalog_pb"
+	"github.com/airlangga-hub/microservices/order/pb"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+var errCartItemNotFound = errors.New("product is not in the cart")
+
+// CartItem is a cart line as stored. Price is what the product cost when it
+// was added, so a later read can tell the customer the price moved.
+type CartItem struct {
+	ProductID string      `json:"product_id"`
+	Quantity  int32       `json:"quantity"`
+	Price     money.Money `json:"price"`
+	AddedAt   time.Time   `json:"added_at"`
+}
+
+// CartServer implements CartService. Carts only hold product IDs and
+// quantities; every read re-prices them against the catalog, and Checkout
+// places the order through Server.PostOrder. Like an order, a cart holds
+// products in one currency.
+type CartServer struct {
+	pb.UnimplementedCartServiceServer
+	Repo          CartRepository
+	AccountClient accpb.AccountServiceClient
+	CatalogClient catpb.CatalogServiceClient
+	Orders        *Server
+}
+
+func (s *CartServer) AddItem(ctx context.Context, r *pb.AddItemRequest) (*pb.AddItemResponse, error) {
+	if r.Quantity <= 0 {
+		return nil, status.Error(codes.InvalidArgument, "quantity must be positive")
+	}
+
+	_, err := s.AccountClient.GetAccount(ctx, &accpb.GetAccountRequest{Id: r.AccountId})
+	if err != nil {
+		return nil, err
+	}
+
+	products, err := s.CatalogClient.GetProducts(ctx, &catpb.GetProductsRequest{Ids: []string{r.ProductId}})
+	if err != nil {
+		return nil, err
+	}
+
+	if len(products.Products) == 0 {
+		return nil, status.Errorf(codes.NotFound, "product %s not found", r.ProductId)
+	}
+
+	price := moneyFromPB(products.Products[0].Price)
+
+	items, err := s.Repo.GetCartItems(ctx, r.AccountId)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, item := range items {
+		if item.ProductID != r.ProductId && item.Price.Currency != price.Currency {
+			return nil, status.Errorf(codes.FailedPrecondition, "cart is priced in %s and product %s in %s, check out separately", item.Price.Currency, r.ProductId, price.Currency)
+		}
+	}
+
+	err = s.Repo.AddCartItem(ctx, r.AccountId, CartItem{
+		ProductID: r.ProductId,
+		Quantity:  r.Quantity,
+		Price:     price,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	cart, err := s.cart(ctx, r.AccountId)
+	if err != nil {
+		return nil, err
+	}
+
+	return &pb.AddItemResponse{Cart: cart}, nil
+}
+
+func (s *CartServer) UpdateQuantity(ctx context.Context, r *pb.UpdateQuantityRequest) (*pb.UpdateQuantityResponse, error) {
+	if r.Quantity <= 0 {
+		return nil, status.Error(codes.InvalidArgument, "quantity must be positive, use RemoveItem to drop a product")
+	}
+
+	err := s.Repo.SetCartItemQuantity(ctx, r.AccountId, r.ProductId, r.Quantity)
+	if errors.Is(err, errCartItemNotFound) {
+		return nil, status.Errorf(codes.NotFound, "product %s is not in the cart", r.ProductId)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	cart, err := s.cart(ctx, r.AccountId)
+	if err != nil {
+		return nil, err
+	}
+
+	return &pb.UpdateQuantityResponse{Cart: cart}, nil
+}
+
+func (s *CartServer) RemoveItem(ctx context.Context, r *pb.RemoveItemRequest) (*pb.RemoveItemResponse, error) {
+	n, err := s.Repo.RemoveCartItems(ctx, r.AccountId, []string{r.ProductId})
+	if err != nil {
+		return nil, err
+	}
+
+	if n == 0 {
+		return nil, status.Errorf(codes.NotFound, "product %s is not in the cart", r.ProductId)
+	}
+
+	cart, err := s.cart(ctx, r.AccountId)
+	if err != nil {
+		return nil, err
+	}
+
+	return &pb.RemoveItemResponse{Cart: cart}, nil
+}
+
+func (s *CartServer) GetCart(ctx context.Context, r *pb.GetCartRequest) (*pb.GetCartResponse, error) {
+	cart, err := s.cart(ctx, r.AccountId)
+	if err != nil {
+		return nil, err
+	}
+
+	return &pb.GetCartResponse{Cart: cart}, nil
+}
+
+// Checkout places the cart as an order. The client sends the total it showed
+// the customer, before discounts; if re-pricing gives a different total, or a
+// product is gone, nothing is ordered and the client should show the cart
+// again. Coupon codes, the tax region and the address are passed on to
+// PostOrder.
+func (s *CartServer) Checkout(ctx context.Context, r *pb.CheckoutRequest) (*pb.CheckoutResponse, error) {
+	cart, err := s.cart(ctx, r.AccountId)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(cart.Items) == 0 {
+		return nil, status.Error(codes.FailedPrecondition, "cart is empty")
+	}
+
+	products := []*pb.OrderedProduct{}
+	productIDs := []string{}
+
+	for _, item := range cart.Items {
+		if item.Status == pb.CartItemStatus_CART_ITEM_STATUS_UNAVAILABLE {
+			return nil, status.Errorf(codes.FailedPrecondition, "product %s is no longer available, remove it and check out again", item.ProductId)
+		}
+
+		products = append(products, &pb.OrderedProduct{Id: item.ProductId, Quantity: item.Quantity})
+		productIDs = append(productIDs, item.ProductId)
+	}
+
+	if total, expected := moneyFromPB(cart.TotalPrice), moneyFromPB(r.ExpectedTotalPrice); total != expected {
+		return nil, status.Errorf(codes.FailedPrecondition, "cart total is %s, not %s, review the cart and check out again", total, expected)
+	}
+
+	placed, err := s.Orders.PostOrder(ctx, &pb.PostOrderRequest{AccountId: r.AccountId, Products: products, CouponCodes: r.CouponCodes, TaxRegion: r.TaxRegion, AddressId: r.AddressId, RequestId: r.RequestId})
+	if err != nil {
+		return nil, err
+	}
+
+	// only the ordered products are removed, so products added while
+	// checking out stay in the cart. The order is placed by now, so failing
+	// here would have the client check out, and pay, again; the items are
+	// left for the customer to remove instead.
+	if _, err := s.Repo.RemoveCartItems(context.WithoutCancel(ctx), r.AccountId, productIDs); err != nil {
+		log.Printf("ERROR: order CartServer Checkout (RemoveCartItems): order %d was placed but the cart wasn't emptied: %v", placed.Order.Id, err)
+	}
+
+	return &pb.CheckoutResponse{Order: placed.Order}, nil
+}
+
+// cart loads the account's cart and prices it against the catalog. Items
+// whose product is gone are flagged unavailable and left out of the total.
+func (s *CartServer) cart(ctx context.Context, accountID int32) (*pb.Cart, error) {
+	items, err := s.Repo.GetCartItems(ctx, accountID)
+	if err != nil {
+		return nil, err
+	}
+
+	cart := &pb.Cart{AccountId: accountID, Items: []*pb.CartItem{}, TotalPrice: pbMoney(money.New(money.DefaultCurrency, 0))}
+
+	if len(items) == 0 {
+		return cart, nil
+	}
+
+	total := money.New(items[0].Price.Currency, 0)
+
+	productIDs := []string{}
+	for _, item := range items {
+		productIDs = append(productIDs, item.ProductID)
+	}
+
+	products, err := s.CatalogClient.GetProducts(ctx, &catpb.GetProductsRequest{Ids: productIDs})
+	if err != nil {
+		return nil, err
+	}
+
+	mapCatalogProducts := map[string]*catpb.Product{}
+	for _, p := range products.Products {
+		mapCatalogProducts[p.Id] = p
+	}
+
+	for _, item := range items {
+		pbItem := &pb.CartItem{
+			ProductId:  item.ProductID,
+			Price:      pbMoney(item.Price),
+			Quantity:   item.Quantity,
+			AddedPrice: pbMoney(item.Price),
+			Status:     pb.CartItemStatus_CART_ITEM_STATUS_UNAVAILABLE,
+		}
+
+		if p, exist := mapCatalogProducts[item.ProductID]; exist {
+			price := moneyFromPB(p.Price)
+
+			pbItem.Name = p.Name
+			pbItem.Description = p.Description
+			pbItem.Price = p.Price
+			pbItem.Status = pb.CartItemStatus_CART_ITEM_STATUS_OK
+
+			if price != item.Price {
+				pbItem.Status = pb.CartItemStatus_CART_ITEM_STATUS_PRICE_CHANGED
+			}
+
+			line, err := price.Mul(int64(item.Quantity))
+			if err != nil {
+				return nil, status.Errorf(codes.FailedPrecondition, "product %s: %v", item.ProductID, err)
+			}
+
+			// a product repriced in another currency can't be totalled with
+			// the rest until it's removed
+			if total, err = total.Add(line); err != nil {
+				return nil, status.Errorf(codes.FailedPrecondition, "product %s: %v, remove it and add it again", item.ProductID, err)
+			}
+		}
+
+		cart.Items = append(cart.Items, pbItem)
+	}
+
+	cart.TotalPrice = pbMoney(total)
+
+	return cart, nil
+}
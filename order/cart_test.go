@@ -0,0 +1,120 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/airlangga-hub/microservices/order/pb"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestCartRepricesOnRead(t *testing.T) {
+	h := newHarness(t)
+	ctx := context.Background()
+	account := h.account(t, "angga")
+	keyboard := h.product(t, "keyboard", 100)
+	mouse := h.product(t, "mouse", 50)
+
+	for _, id := range []string{keyboard.Id, mouse.Id} {
+		if _, err := h.Cart.AddItem(ctx, &pb.AddItemRequest{AccountId: account.Id, ProductId: id, Quantity: 2}); err != nil {
+			t.Fatalf("AddItem: %v", err)
+		}
+	}
+
+	h.Catalog.mu.Lock()
+	h.Catalog.products[keyboard.Id].Price = pbUSD(120)
+	delete(h.Catalog.products, mouse.Id)
+	h.Catalog.mu.Unlock()
+
+	res, err := h.Cart.GetCart(ctx, &pb.GetCartRequest{AccountId: account.Id})
+	if err != nil {
+		t.Fatalf("GetCart: %v", err)
+	}
+
+	if len(res.Cart.Items) != 2 {
+		t.Fatalf("got %d items, want 2", len(res.Cart.Items))
+	}
+	if item := res.Cart.Items[0]; item.Status != pb.CartItemStatus_CART_ITEM_STATUS_PRICE_CHANGED || item.Price.GetUnits() != 120 || item.AddedPrice.GetUnits() != 100 {
+		t.Errorf("keyboard = %v, want price changed from 100 to 120", item)
+	}
+	if item := res.Cart.Items[1]; item.Status != pb.CartItemStatus_CART_ITEM_STATUS_UNAVAILABLE {
+		t.Errorf("mouse status = %v, want unavailable", item.Status)
+	}
+	if res.Cart.TotalPrice.GetUnits() != 240 {
+		t.Errorf("TotalPrice = %v, want 240", res.Cart.TotalPrice)
+	}
+}
+
+func TestCartCheckout(t *testing.T) {
+	h := newHarness(t)
+	ctx := context.Background()
+	account := h.account(t, "angga")
+	keyboard := h.product(t, "keyboard", 100)
+
+	if _, err := h.Cart.AddItem(ctx, &pb.AddItemRequest{AccountId: account.Id, ProductId: keyboard.Id, Quantity: 1}); err != nil {
+		t.Fatalf("AddItem: %v", err)
+	}
+	if _, err := h.Cart.UpdateQuantity(ctx, &pb.UpdateQuantityRequest{AccountId: account.Id, ProductId: keyboard.Id, Quantity: 3}); err != nil {
+		t.Fatalf("UpdateQuantity: %v", err)
+	}
+
+	_, err := h.Cart.Checkout(ctx, &pb.CheckoutRequest{AccountId: account.Id, ExpectedTotalPrice: pbUSD(100)})
+	if status.Code(err) != codes.FailedPrecondition {
+		t.Fatalf("Checkout with a stale total error = %v, want FailedPrecondition", err)
+	}
+
+	res, err := h.Cart.Checkout(ctx, &pb.CheckoutRequest{AccountId: account.Id, ExpectedTotalPrice: pbUSD(300)})
+	if err != nil {
+		t.Fatalf("Checkout: %v", err)
+	}
+	if res.Order.TotalPrice.GetUnits() != 300 || len(res.Order.Products) != 1 {
+		t.Errorf("order = %v, want three keyboards for 300", res.Order)
+	}
+
+	cart, err := h.Cart.GetCart(ctx, &pb.GetCartRequest{AccountId: account.Id})
+	if err != nil {
+		t.Fatalf("GetCart: %v", err)
+	}
+	if len(cart.Cart.Items) != 0 {
+		t.Errorf("cart has %d items after checkout, want 0", len(cart.Cart.Items))
+	}
+}
+
+// uncleanableCart can't remove items from carts.
+type uncleanableCart struct {
+	CartRepository
+}
+
+func (uncleanableCart) RemoveCartItems(ctx context.Context, accountID int32, productIDs []string) (int, error) {
+	return 0, errors.New("database is gone")
+}
+
+func TestCartCheckoutReturnsThePlacedOrder(t *testing.T) {
+	h := newHarness(t)
+	ctx := context.Background()
+	account := h.account(t, "angga")
+	keyboard := h.product(t, "keyboard", 100)
+
+	if _, err := h.Cart.AddItem(ctx, &pb.AddItemRequest{AccountId: account.Id, ProductId: keyboard.Id, Quantity: 1}); err != nil {
+		t.Fatalf("AddItem: %v", err)
+	}
+
+	h.Carts.Repo = uncleanableCart{h.Repo}
+
+	// the cart can't be emptied, but the order is placed, so checkout
+	// succeeds and the client doesn't place it again
+	res, err := h.Cart.Checkout(ctx, &pb.CheckoutRequest{AccountId: account.Id, ExpectedTotalPrice: pbUSD(100)})
+	if err != nil {
+		t.Fatalf("Checkout: %v", err)
+	}
+
+	orders, err := h.Order.GetOrdersByAccountID(h.as(account.Id), &pb.GetOrdersByAccountIDRequest{AccountId: account.Id})
+	if err != nil {
+		t.Fatalf("GetOrdersByAccountID: %v", err)
+	}
+	if len(orders.Orders) != 1 || orders.Orders[0].Id != res.Order.Id {
+		t.Errorf("orders = %v, want only order %d", orders.Orders, res.Order.Id)
+	}
+}
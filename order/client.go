@@ -0,0 +1,136 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/sony/gobreaker/v2"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	// the breaker opens after this many consecutive failures and stays open
+	// for breakerOpenTimeout before letting a single probe call through.
+	breakerConsecutiveFailures = 5
+	breakerOpenTimeout         = 10 * time.Second
+)
+
+// retryServiceConfig retries the idempotent calls the order service makes:
+// reads against account and catalog, and payment calls, which are keyed so
+// a repeat never moves money twice. Other writes are never retried.
+const retryServiceConfig = `{
+	"methodConfig": [{
+		"name": [
+			{"service": "pb.AccountService", "method": "GetAccount"},
+			{"service": "pb.AccountService", "method": "GetAccounts"},
+			{"service": "pb.CatalogService", "method": "GetProduct"},
+			{"service": "pb.CatalogService", "method": "GetProducts"},
+			{"service": "pb.PaymentService", "method": "Authorize"},
+			{"service": "pb.PaymentService", "method": "Capture"},
+			{"service": "pb.PaymentService", "method": "Void"},
+			{"service": "pb.PaymentService", "method": "GetPayment"}
+		],
+		"retryPolicy": {
+			"maxAttempts": 3,
+			"initialBackoff": "0.1s",
+			"maxBackoff": "1s",
+			"backoffMultiplier": 2,
+			"retryableStatusCodes": ["UNAVAILABLE", "RESOURCE_EXHAUSTED"]
+		}
+	}]
+}`
+
+// dialService creates a client connection to a downstream service with a
+// per-call deadline, retries for idempotent reads and a circuit breaker.
+// name identifies the downstream service in metrics and errors.
+func dialService(name, target string, callTimeout time.Duration, opts ...grpc.DialOption) (*grpc.ClientConn, error) {
+	opts = append(
+		[]grpc.DialOption{
+			grpc.WithTransportCredentials(insecure.NewCredentials()),
+			grpc.WithDefaultServiceConfig(retryServiceConfig),
+			grpc.WithStatsHandler(&attemptStats{service: name}),
+			grpc.WithChainUnaryInterceptor(
+				metricsInterceptor(name),
+				breakerInterceptor(name, breakerOpenTimeout),
+				timeoutInterceptor(callTimeout),
+			),
+		},
+		opts...,
+	)
+
+	conn, err := grpc.NewClient(target, opts...)
+	if err != nil {
+		return nil, fmt.Errorf("ERROR: order client dialService (%s): %w", name, err)
+	}
+
+	return conn, nil
+}
+
+func metricsInterceptor(service string) grpc.UnaryClientInterceptor {
+	return func(ctx context.Context, method string, req, reply any, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+		start := time.Now()
+
+		err := invoker(ctx, method, req, reply, cc, opts...)
+
+		clientRequests.WithLabelValues(service, method, status.Code(err).String()).Inc()
+		clientRequestDuration.WithLabelValues(service, method).Observe(time.Since(start).Seconds())
+
+		return err
+	}
+}
+
+// timeoutInterceptor bounds calls whose context has no deadline, or a later
+// one than timeout.
+func timeoutInterceptor(timeout time.Duration) grpc.UnaryClientInterceptor {
+	return func(ctx context.Context, method string, req, reply any, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+		ctx, cancel := context.WithTimeout(ctx, timeout)
+		defer cancel()
+
+		return invoker(ctx, method, req, reply, cc, opts...)
+	}
+}
+
+// breakerInterceptor fails fast with codes.Unavailable while the downstream
+// service is considered unhealthy, for openTimeout at a time. Only
+// transport-level failures count against the breaker; business errors like
+// NotFound do not.
+func breakerInterceptor(service string, openTimeout time.Duration) grpc.UnaryClientInterceptor {
+	breaker := gobreaker.NewCircuitBreaker[struct{}](gobreaker.Settings{
+		Name:        service,
+		MaxRequests: 1,
+		Timeout:     openTimeout,
+		ReadyToTrip: func(counts gobreaker.Counts) bool {
+			return counts.ConsecutiveFailures >= breakerConsecutiveFailures
+		},
+		OnStateChange: func(name string, from, to gobreaker.State) {
+			circuitBreakerState.WithLabelValues(name).Set(float64(to))
+		},
+		IsSuccessful: func(err error) bool {
+			switch status.Code(err) {
+			case codes.Unavailable, codes.DeadlineExceeded, codes.ResourceExhausted:
+				return false
+			}
+			return true
+		},
+	})
+
+	circuitBreakerState.WithLabelValues(service).Set(float64(gobreaker.StateClosed))
+
+	return func(ctx context.Context, method string, req, reply any, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+		_, err := breaker.Execute(func() (struct{}, error) {
+			return struct{}{}, invoker(ctx, method, req, reply, cc, opts...)
+		})
+
+		if errors.Is(err, gobreaker.ErrOpenState) || errors.Is(err, gobreaker.ErrTooManyRequests) {
+			circuitBreakerRejections.WithLabelValues(service).Inc()
+			return status.Errorf(codes.Unavailable, "%s is unavailable: %v", service, err)
+		}
+
+		return err
+	}
+}
@@ -0,0 +1,266 @@
+package main
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	catpb "github.com/airlangga-hub/microservices/order/catalog_pb"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/status"
+)
+
+// flakyCatalog fails each call with the next code in failures until they run
+// out, then succeeds, counting the calls it received per method.
+type flakyCatalog struct {
+	catpb.UnimplementedCatalogServiceServer
+
+	mu       sync.Mutex
+	failures []codes.Code
+	calls    map[string]int
+}
+
+func (c *flakyCatalog) next(method string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.calls == nil {
+		c.calls = map[string]int{}
+	}
+	c.calls[method]++
+
+	if len(c.failures) == 0 {
+		return nil
+	}
+	code := c.failures[0]
+	c.failures = c.failures[1:]
+
+	return status.Errorf(code, "catalog is flaky")
+}
+
+// failWith makes the next n calls fail with code.
+func (c *flakyCatalog) failWith(code codes.Code, n int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for range n {
+		c.failures = append(c.failures, code)
+	}
+}
+
+func (c *flakyCatalog) recover() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.failures = nil
+}
+
+func (c *flakyCatalog) callCount(method string) int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.calls[method]
+}
+
+func (c *flakyCatalog) GetProduct(ctx context.Context, r *catpb.GetProductRequest) (*catpb.GetProductResponse, error) {
+	if err := c.next("GetProduct"); err != nil {
+		return nil, err
+	}
+	return &catpb.GetProductResponse{Product: &catpb.Product{Id: r.Id}}, nil
+}
+
+func (c *flakyCatalog) PostProduct(ctx context.Context, r *catpb.PostProductRequest) (*catpb.PostProductResponse, error) {
+	if err := c.next("PostProduct"); err != nil {
+		return nil, err
+	}
+	return &catpb.PostProductResponse{Product: &catpb.Product{Name: r.Name}}, nil
+}
+
+func serveFlakyCatalog(t *testing.T) (*flakyCatalog, grpc.DialOption) {
+	t.Helper()
+
+	catalog := &flakyCatalog{}
+	lis := serve(t, func(s *grpc.Server) { catpb.RegisterCatalogServiceServer(s, catalog) })
+
+	return catalog, bufDialer(lis)
+}
+
+func TestRetryPolicy(t *testing.T) {
+	tests := []struct {
+		name     string
+		method   string
+		failures []codes.Code
+		wantCode codes.Code
+		// wantCalls is how many attempts reached the server
+		wantCalls int
+	}{
+		{"unavailable read succeeds on the third attempt", "GetProduct", []codes.Code{codes.Unavailable, codes.Unavailable}, codes.OK, 3},
+		{"resource exhausted read is retried", "GetProduct", []codes.Code{codes.ResourceExhausted}, codes.OK, 2},
+		{"read gives up after three attempts", "GetProduct", []codes.Code{codes.Unavailable, codes.Unavailable, codes.Unavailable, codes.Unavailable}, codes.Unavailable, 3},
+		{"not found is not retried", "GetProduct", []codes.Code{codes.NotFound}, codes.NotFound, 1},
+		{"deadline exceeded is not retried", "GetProduct", []codes.Code{codes.DeadlineExceeded}, codes.DeadlineExceeded, 1},
+		{"writes are not retried", "PostProduct", []codes.Code{codes.Unavailable}, codes.Unavailable, 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			catalog, dialer := serveFlakyCatalog(t)
+			catalog.failures = tt.failures
+
+			conn, err := dialService("catalog", "passthrough:///catalog", 5*time.Second, dialer)
+			if err != nil {
+				t.Fatalf("dialService: %v", err)
+			}
+			defer conn.Close()
+			client := catpb.NewCatalogServiceClient(conn)
+
+			switch tt.method {
+			case "GetProduct":
+				_, err = client.GetProduct(context.Background(), &catpb.GetProductRequest{Id: "p1"})
+			case "PostProduct":
+				_, err = client.PostProduct(context.Background(), &catpb.PostProductRequest{Name: "Keyboard"})
+			}
+
+			if got := status.Code(err); got != tt.wantCode {
+				t.Errorf("%s code = %v, want %v (err: %v)", tt.method, got, tt.wantCode, err)
+			}
+			if got := catalog.callCount(tt.method); got != tt.wantCalls {
+				t.Errorf("server saw %d %s attempts, want %d", got, tt.method, tt.wantCalls)
+			}
+		})
+	}
+}
+
+// dialBreaker connects to the flaky catalog through the breaker alone, so
+// each call reaches the server at most once.
+func dialBreaker(t *testing.T, dialer grpc.DialOption, openTimeout time.Duration) catpb.CatalogServiceClient {
+	t.Helper()
+
+	conn, err := grpc.NewClient("passthrough:///catalog",
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+		grpc.WithUnaryInterceptor(breakerInterceptor("catalog", openTimeout)),
+		dialer,
+	)
+	if err != nil {
+		t.Fatalf("NewClient: %v", err)
+	}
+	t.Cleanup(func() { conn.Close() })
+
+	return catpb.NewCatalogServiceClient(conn)
+}
+
+func postProduct(client catpb.CatalogServiceClient) error {
+	_, err := client.PostProduct(context.Background(), &catpb.PostProductRequest{Name: "Keyboard"})
+	return err
+}
+
+// isRejected reports whether err is the breaker failing fast rather than the
+// server's own error.
+func isRejected(err error) bool {
+	return status.Code(err) == codes.Unavailable && strings.Contains(err.Error(), "catalog is unavailable")
+}
+
+func TestBreakerOpensAfterConsecutiveFailures(t *testing.T) {
+	for _, code := range []codes.Code{codes.Unavailable, codes.DeadlineExceeded, codes.ResourceExhausted} {
+		t.Run(code.String(), func(t *testing.T) {
+			catalog, dialer := serveFlakyCatalog(t)
+			client := dialBreaker(t, dialer, time.Minute)
+			catalog.failWith(code, 100)
+
+			for i := range breakerConsecutiveFailures {
+				if err := postProduct(client); status.Code(err) != code || isRejected(err) {
+					t.Fatalf("call %d = %v, want the server's %v", i+1, err, code)
+				}
+			}
+
+			if err := postProduct(client); !isRejected(err) {
+				t.Fatalf("call after %d failures = %v, want the breaker to reject it", breakerConsecutiveFailures, err)
+			}
+			if got := catalog.callCount("PostProduct"); got != breakerConsecutiveFailures {
+				t.Errorf("server saw %d calls, want %d: the open breaker let a call through", got, breakerConsecutiveFailures)
+			}
+		})
+	}
+}
+
+func TestBreakerIgnoresBusinessErrors(t *testing.T) {
+	for _, code := range []codes.Code{codes.NotFound, codes.InvalidArgument, codes.FailedPrecondition, codes.PermissionDenied, codes.Internal} {
+		t.Run(code.String(), func(t *testing.T) {
+			catalog, dialer := serveFlakyCatalog(t)
+			client := dialBreaker(t, dialer, time.Minute)
+			catalog.failWith(code, 2*breakerConsecutiveFailures)
+
+			for i := range 2 * breakerConsecutiveFailures {
+				if err := postProduct(client); status.Code(err) != code {
+					t.Fatalf("call %d = %v, want the server's %v", i+1, err, code)
+				}
+			}
+
+			if err := postProduct(client); err != nil {
+				t.Errorf("call after the business errors = %v, want it to reach the server", err)
+			}
+		})
+	}
+}
+
+func TestBreakerSuccessResetsTheFailureCount(t *testing.T) {
+	catalog, dialer := serveFlakyCatalog(t)
+	client := dialBreaker(t, dialer, time.Minute)
+
+	for range 3 {
+		catalog.failWith(codes.Unavailable, breakerConsecutiveFailures-1)
+		for range breakerConsecutiveFailures - 1 {
+			postProduct(client)
+		}
+		if err := postProduct(client); err != nil {
+			t.Fatalf("call after %d failures = %v, want it to reach the server", breakerConsecutiveFailures-1, err)
+		}
+	}
+}
+
+func TestBreakerClosesAfterRecovery(t *testing.T) {
+	const openTimeout = 50 * time.Millisecond
+
+	catalog, dialer := serveFlakyCatalog(t)
+	client := dialBreaker(t, dialer, openTimeout)
+
+	trip := func() {
+		t.Helper()
+		catalog.failWith(codes.Unavailable, breakerConsecutiveFailures)
+		for range breakerConsecutiveFailures {
+			postProduct(client)
+		}
+		if err := postProduct(client); !isRejected(err) {
+			t.Fatalf("call after %d failures = %v, want the breaker open", breakerConsecutiveFailures, err)
+		}
+	}
+
+	// a probe that fails while half-open opens the breaker again
+	trip()
+	time.Sleep(2 * openTimeout)
+	catalog.failWith(codes.Unavailable, 1)
+	if err := postProduct(client); status.Code(err) != codes.Unavailable || isRejected(err) {
+		t.Fatalf("half-open probe = %v, want it to reach the server", err)
+	}
+	if err := postProduct(client); !isRejected(err) {
+		t.Fatalf("call after a failed probe = %v, want the breaker open again", err)
+	}
+
+	// a probe that succeeds closes it
+	catalog.recover()
+	time.Sleep(2 * openTimeout)
+	before := catalog.callCount("PostProduct")
+	for i := range 3 {
+		if err := postProduct(client); err != nil {
+			t.Fatalf("call %d after recovery = %v, want success", i+1, err)
+		}
+	}
+	if got := catalog.callCount("PostProduct") - before; got != 3 {
+		t.Errorf("server saw %d calls after recovery, want 3", got)
+	}
+
+	// and the count starts over, so it takes another run of failures to trip
+	trip()
+}
@@ -0,0 +1,149 @@
+// Package config loads and validates the order service configuration.
+//
+// Every setting is read from its environment variable, falling back to the
+// JSON file named by ORDER_CONFIG_FILE, then to a default. Load reports all
+// invalid or missing settings at once.
+package config
+
+import (
+	"time"
+
+	"github.com/airlangga-hub/microservices/settings"
+)
+
+const (
+	StoragePostgres = "postgres"
+	StorageMemory   = "memory"
+
+	EventBusMemory = "memory"
+	EventBusNATS   = "nats"
+)
+
+type Config struct {
+	Port              string
+	HTTPPort          string
+	Storage           string
+	DatabaseURL       string
+	MigrateOnStart    bool
+	DB                DB
+	AccountServiceURL string
+	CatalogServiceURL string
+	PaymentServiceURL string
+	ClientCallTimeout time.Duration
+	StartupTimeout    time.Duration
+	Events            Events
+	Watch             Watch
+	Tax               Tax
+	ShutdownTimeout   time.Duration
+}
+
+// DB holds the database/sql connection pool settings.
+type DB struct {
+	MaxOpenConns    int
+	MaxIdleConns    int
+	ConnMaxLifetime time.Duration
+}
+
+// Events configures the bus domain events are published to and how often the
+// outbox relay polls for them.
+type Events struct {
+	Bus          string
+	NATSURL      string
+	PollInterval time.Duration
+	BatchSize    int
+}
+
+// Watch sizes the WatchOrders feed: how many recent events are kept for
+// resuming, and how many may queue for one stream before it is dropped.
+type Watch struct {
+	History int
+	Buffer  int
+}
+
+// Tax configures the local tax rules engine. RulesFile is a JSON array of
+// rules replacing the built-in table, and orders placed without a tax region
+// are taxed for DefaultRegion, or not at all if it is empty.
+type Tax struct {
+	RulesFile     string
+	DefaultRegion string
+}
+
+func Load() (Config, error) {
+	l := settings.NewLoader("ORDER_CONFIG_FILE")
+
+	cfg := Config{
+		Port:              l.String("ORDER_PORT", ""),
+		HTTPPort:          l.String("ORDER_HTTP_PORT", ":8080"),
+		MigrateOnStart:    l.Bool("ORDER_MIGRATE_ON_START", false),
+		AccountServiceURL: l.String("ACCOUNT_SERVICE_URL", ""),
+		CatalogServiceURL: l.String("CATALOG_SERVICE_URL", ""),
+		PaymentServiceURL: l.String("PAYMENT_SERVICE_URL", ""),
+		ClientCallTimeout: l.Duration("ORDER_CLIENT_CALL_TIMEOUT", 3*time.Second),
+		StartupTimeout:    l.Duration("ORDER_STARTUP_TIMEOUT", 60*time.Second),
+		Events: Events{
+			Bus:          l.String("ORDER_EVENT_BUS", EventBusMemory),
+			NATSURL:      l.String("NATS_URL", ""),
+			PollInterval: l.Duration("ORDER_OUTBOX_POLL_INTERVAL", time.Second),
+			BatchSize:    l.Int("ORDER_OUTBOX_BATCH_SIZE", 100),
+		},
+		Watch: Watch{
+			History: l.Int("ORDER_WATCH_HISTORY", 1000),
+			Buffer:  l.Int("ORDER_WATCH_BUFFER", 64),
+		},
+		Tax: Tax{
+			RulesFile:     l.String("ORDER_TAX_RULES_FILE", ""),
+			DefaultRegion: l.String("ORDER_TAX_DEFAULT_REGION", ""),
+		},
+		ShutdownTimeout: l.Duration("ORDER_SHUTDOWN_TIMEOUT", 10*time.Second),
+	}
+
+	loadDatabase(l, &cfg)
+
+	l.Address("ORDER_PORT", cfg.Port)
+	l.Address("ORDER_HTTP_PORT", cfg.HTTPPort)
+	l.Required("ACCOUNT_SERVICE_URL", cfg.AccountServiceURL)
+	l.Required("CATALOG_SERVICE_URL", cfg.CatalogServiceURL)
+	l.Required("PAYMENT_SERVICE_URL", cfg.PaymentServiceURL)
+	l.Check(cfg.ClientCallTimeout > 0, "ORDER_CLIENT_CALL_TIMEOUT must be positive")
+	l.Check(cfg.StartupTimeout > 0, "ORDER_STARTUP_TIMEOUT must be positive")
+	l.Check(cfg.Events.Bus == EventBusMemory || cfg.Events.Bus == EventBusNATS, "ORDER_EVENT_BUS must be %q or %q", EventBusMemory, EventBusNATS)
+	if cfg.Events.Bus == EventBusNATS {
+		l.Required("NATS_URL", cfg.Events.NATSURL)
+	}
+	l.Check(cfg.Events.PollInterval > 0, "ORDER_OUTBOX_POLL_INTERVAL must be positive")
+	l.Check(cfg.Events.BatchSize > 0, "ORDER_OUTBOX_BATCH_SIZE must be positive")
+	l.Check(cfg.Watch.History > 0, "ORDER_WATCH_HISTORY must be positive")
+	l.Check(cfg.Watch.Buffer > 0, "ORDER_WATCH_BUFFER must be positive")
+	l.Check(cfg.ShutdownTimeout > 0, "ORDER_SHUTDOWN_TIMEOUT must be positive")
+
+	return cfg, l.Err("order")
+}
+
+// LoadDatabase loads only the storage and database settings, for commands
+// like migrate that don't run the service.
+func LoadDatabase() (Config, error) {
+	l := settings.NewLoader("ORDER_CONFIG_FILE")
+
+	cfg := Config{}
+	loadDatabase(l, &cfg)
+
+	return cfg, l.Err("order")
+}
+
+func loadDatabase(l *settings.Loader, cfg *Config) {
+	cfg.Storage = l.String("ORDER_STORAGE", StoragePostgres)
+	cfg.DatabaseURL = l.String("ORDER_DB_URL", "")
+	cfg.DB = DB{
+		MaxOpenConns:    l.Int("ORDER_DB_MAX_OPEN_CONNS", 25),
+		MaxIdleConns:    l.Int("ORDER_DB_MAX_IDLE_CONNS", 5),
+		ConnMaxLifetime: l.Duration("ORDER_DB_CONN_MAX_LIFETIME", 30*time.Minute),
+	}
+
+	l.Check(cfg.Storage == StoragePostgres || cfg.Storage == StorageMemory, "ORDER_STORAGE must be %q or %q", StoragePostgres, StorageMemory)
+	if cfg.Storage == StoragePostgres {
+		l.Required("ORDER_DB_URL", cfg.DatabaseURL)
+	}
+	l.Check(cfg.DB.MaxOpenConns > 0, "ORDER_DB_MAX_OPEN_CONNS must be positive")
+	l.Check(cfg.DB.MaxIdleConns >= 0 && cfg.DB.MaxIdleConns <= cfg.DB.MaxOpenConns, "ORDER_DB_MAX_IDLE_CONNS must be between 0 and ORDER_DB_MAX_OPEN_CONNS")
+	l.Check(cfg.DB.ConnMaxLifetime > 0, "ORDER_DB_CONN_MAX_LIFETIME must be positive")
+}
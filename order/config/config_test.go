@@ -0,0 +1,144 @@
+package config
+
+import (
+	"maps"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// required is the least a postgres-backed order service needs set.
+var required = map[string]string{
+	"ORDER_PORT":          ":9092",
+	"ORDER_DB_URL":        "postgres://order_db/order",
+	"ACCOUNT_SERVICE_URL": "account:9090",
+	"CATALOG_SERVICE_URL": "catalog:9091",
+	"PAYMENT_SERVICE_URL": "payment:9093",
+}
+
+// setEnv sets env, clearing the required settings it leaves out and the
+// config file.
+func setEnv(t *testing.T, env map[string]string) {
+	t.Helper()
+
+	t.Setenv("ORDER_CONFIG_FILE", "")
+	for key := range required {
+		t.Setenv(key, "")
+	}
+	for key, value := range env {
+		t.Setenv(key, value)
+	}
+}
+
+func with(changes map[string]string) map[string]string {
+	env := maps.Clone(required)
+	maps.Copy(env, changes)
+	return env
+}
+
+func TestLoadReportsProblems(t *testing.T) {
+	tests := []struct {
+		name     string
+		env      map[string]string
+		problems []string
+	}{
+		{"valid", required, nil},
+		{"nothing set", nil, []string{"ORDER_PORT is required", "ORDER_DB_URL is required", "ACCOUNT_SERVICE_URL is required", "CATALOG_SERVICE_URL is required", "PAYMENT_SERVICE_URL is required"}},
+		{"memory storage needs no database", with(map[string]string{"ORDER_STORAGE": "memory", "ORDER_DB_URL": ""}), nil},
+		{"unknown storage", with(map[string]string{"ORDER_STORAGE": "sqlite"}), []string{`ORDER_STORAGE must be "postgres" or "memory"`}},
+		{"nats needs a url", with(map[string]string{"ORDER_EVENT_BUS": "nats"}), []string{"NATS_URL is required"}},
+		{"bad values", with(map[string]string{"ORDER_WATCH_HISTORY": "0", "ORDER_CLIENT_CALL_TIMEOUT": "3"}), []string{"ORDER_WATCH_HISTORY must be positive", `ORDER_CLIENT_CALL_TIMEOUT: "3" is not a duration`}},
+		{"more idle than open connections", with(map[string]string{"ORDER_DB_MAX_OPEN_CONNS": "2"}), []string{"ORDER_DB_MAX_IDLE_CONNS must be between 0 and ORDER_DB_MAX_OPEN_CONNS"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setEnv(t, tt.env)
+
+			_, err := Load()
+			if len(tt.problems) == 0 {
+				if err != nil {
+					t.Errorf("Load = %v, want no problems", err)
+				}
+				return
+			}
+
+			if err == nil {
+				t.Fatalf("Load succeeded, want %v", tt.problems)
+			}
+			for _, problem := range tt.problems {
+				if !strings.Contains(err.Error(), problem) {
+					t.Errorf("Load = %v, want it to report %s", err, problem)
+				}
+			}
+		})
+	}
+}
+
+func TestLoadDefaults(t *testing.T) {
+	setEnv(t, required)
+
+	cfg, err := Load()
+	if err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+
+	if cfg.Storage != StoragePostgres || cfg.HTTPPort != ":8080" || cfg.MigrateOnStart {
+		t.Errorf("Load = %+v, want postgres storage, HTTP on :8080 and no migrating on start", cfg)
+	}
+	if cfg.DB != (DB{MaxOpenConns: 25, MaxIdleConns: 5, ConnMaxLifetime: 30 * time.Minute}) {
+		t.Errorf("DB = %+v, want the default pool", cfg.DB)
+	}
+	if cfg.Events != (Events{Bus: EventBusMemory, PollInterval: time.Second, BatchSize: 100}) {
+		t.Errorf("Events = %+v, want the default memory bus", cfg.Events)
+	}
+	if cfg.ClientCallTimeout != 3*time.Second || cfg.StartupTimeout != time.Minute || cfg.ShutdownTimeout != 10*time.Second {
+		t.Errorf("timeouts = %v, %v, %v; want the defaults", cfg.ClientCallTimeout, cfg.StartupTimeout, cfg.ShutdownTimeout)
+	}
+}
+
+func TestLoadFileAndEnv(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "order.json")
+	file := `{"ORDER_PORT": ":7000", "ORDER_WATCH_BUFFER": "8", "ORDER_TAX_DEFAULT_REGION": "EU"}`
+	if err := os.WriteFile(path, []byte(file), 0o600); err != nil {
+		t.Fatalf("WriteFile: %v", err)
+	}
+
+	setEnv(t, with(map[string]string{"ORDER_PORT": "", "ORDER_WATCH_BUFFER": "16"}))
+	t.Setenv("ORDER_CONFIG_FILE", path)
+
+	cfg, err := Load()
+	if err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+
+	if cfg.Port != ":7000" {
+		t.Errorf("Port = %q, want :7000 from the file", cfg.Port)
+	}
+	if cfg.Watch.Buffer != 16 {
+		t.Errorf("Watch.Buffer = %d, want 16 from the environment over the file", cfg.Watch.Buffer)
+	}
+	if cfg.Tax.DefaultRegion != "EU" {
+		t.Errorf("Tax.DefaultRegion = %q, want EU from the file", cfg.Tax.DefaultRegion)
+	}
+}
+
+// The migrate command runs without the service's port and dependencies.
+func TestLoadDatabase(t *testing.T) {
+	setEnv(t, map[string]string{"ORDER_DB_URL": "postgres://order_db/order"})
+
+	cfg, err := LoadDatabase()
+	if err != nil {
+		t.Fatalf("LoadDatabase: %v", err)
+	}
+	if cfg.Storage != StoragePostgres || cfg.DatabaseURL != "postgres://order_db/order" || cfg.DB.MaxOpenConns != 25 {
+		t.Errorf("LoadDatabase = %+v, want the database settings", cfg)
+	}
+
+	setEnv(t, nil)
+	if _, err := LoadDatabase(); err == nil || !strings.Contains(err.Error(), "ORDER_DB_URL is required") {
+		t.Errorf("LoadDatabase without a database = %v, want ORDER_DB_URL required", err)
+	}
+}
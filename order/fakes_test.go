@@ -0,0 +1,573 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"slices"
+	"strings"
+	"sync"
+
+	"github.com/airlangga-hub/microservices/auth"
+	"github.com/airlangga-hub/microservices/money"
+	accpb "github.com/airlangga-hub/microservices/order/account_pb"
+	catpb "github.com/airlangga-hub/microservices/order/catalog_pb"
+	paypb "github.com/airlangga-hub/microservices/order/payment_pb"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+)
+
+// fakeAccountServer stands in for the account service, keeping accounts,
+// their addresses, role permissions and API keys in memory. An account's
+// first address is its default.
+//
+// The real account Server lives in package main of its own module, so
+// order can't run it. The fake follows the real service's rules for what
+// order relies on: address validation, which status changes are allowed,
+// and how API keys are created and authenticated. Callers aren't
+// authorized; tests call it directly as the admin would.
+type fakeAccountServer struct {
+	accpb.UnimplementedAccountServiceServer
+
+	mu            sync.Mutex
+	accounts      map[int32]*accpb.Account
+	nextID        int32
+	addresses     []*accpb.Address
+	nextAddressID int32
+	// permissions are what each account's roles grant.
+	permissions map[int32][]string
+	apiKeys     map[string]*accpb.APIKey
+}
+
+func newFakeAccountServer() *fakeAccountServer {
+	return &fakeAccountServer{
+		accounts:    map[int32]*accpb.Account{},
+		permissions: map[int32][]string{},
+		apiKeys:     map[string]*accpb.APIKey{},
+	}
+}
+
+// rolePermissions are the permissions the account service's roles can
+// grant. A key scope naming one needs the account to hold it.
+var rolePermissions = []string{
+	"account:roles:manage",
+	"account:api_keys:manage",
+	"account:audit:read",
+	"catalog:products:write",
+	"catalog:exchange_rates:write",
+	"catalog:audit:read",
+	"order:orders:read_any",
+	"order:audit:read",
+	"order:promotions:manage",
+	"order:orders:manage",
+}
+
+var (
+	apiKeyScope = regexp.MustCompile(`^([a-z_]+|\*):([a-z_]+|\*):([a-z_]+|\*)$`)
+	countryCode = regexp.MustCompile(`^[A-Z]{2}$`)
+)
+
+// grant gives accountID permissions, as if it had been assigned a role
+// holding them.
+func (s *fakeAccountServer) grant(accountID int32, permissions ...string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, p := range permissions {
+		if !slices.Contains(s.permissions[accountID], p) {
+			s.permissions[accountID] = append(s.permissions[accountID], p)
+		}
+	}
+}
+
+func (s *fakeAccountServer) CreateAPIKey(ctx context.Context, r *accpb.CreateAPIKeyRequest) (*accpb.CreateAPIKeyResponse, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if strings.TrimSpace(r.Name) == "" {
+		return nil, status.Error(codes.InvalidArgument, "invalid api key: name is required")
+	}
+	if len(r.Scopes) == 0 {
+		return nil, status.Error(codes.InvalidArgument, "invalid api key: at least one scope is required")
+	}
+	for i, scope := range r.Scopes {
+		if !apiKeyScope.MatchString(scope) {
+			return nil, status.Errorf(codes.InvalidArgument, "invalid api key: scope %q isn't service:resource:action", scope)
+		}
+		if slices.Contains(r.Scopes[:i], scope) {
+			return nil, status.Errorf(codes.InvalidArgument, "invalid api key: scope %q is repeated", scope)
+		}
+	}
+
+	if _, exist := s.accounts[r.AccountId]; !exist {
+		return nil, status.Errorf(codes.NotFound, "account %d not found", r.AccountId)
+	}
+
+	for _, scope := range r.Scopes {
+		if slices.Contains(rolePermissions, scope) && !slices.Contains(s.permissions[r.AccountId], scope) {
+			return nil, status.Errorf(codes.PermissionDenied, "account lacks the permission for a scope: %s", scope)
+		}
+	}
+
+	id := int32(len(s.apiKeys) + 1)
+	prefix := fmt.Sprintf("%012x", id)
+	key := fmt.Sprintf("ak_%s_secret%d", prefix, id)
+	k := &accpb.APIKey{Id: id, AccountId: r.AccountId, Name: r.Name, Prefix: prefix, Scopes: r.Scopes}
+	s.apiKeys[key] = k
+
+	return &accpb.CreateAPIKeyResponse{ApiKey: k, Key: key}, nil
+}
+
+// AuthenticateAPIKey returns the permissions the account holds now that the
+// key's scopes cover, like the account service.
+func (s *fakeAccountServer) AuthenticateAPIKey(ctx context.Context, r *accpb.AuthenticateAPIKeyRequest) (*accpb.AuthenticateAPIKeyResponse, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	k, exist := s.apiKeys[strings.TrimSpace(r.Key)]
+	if !exist {
+		return nil, status.Error(codes.Unauthenticated, "api key is unknown, revoked or expired")
+	}
+
+	permissions := []string{}
+	for _, p := range s.permissions[k.AccountId] {
+		if auth.HasScope(k.Scopes, p) {
+			permissions = append(permissions, p)
+		}
+	}
+
+	return &accpb.AuthenticateAPIKeyResponse{ApiKey: k, Permissions: permissions}, nil
+}
+
+func (s *fakeAccountServer) PostAccount(ctx context.Context, r *accpb.PostAccountRequest) (*accpb.PostAccountResponse, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.nextID++
+	a := &accpb.Account{Id: s.nextID, Name: r.Name, Status: accpb.AccountStatus_ACCOUNT_STATUS_ACTIVE}
+	s.accounts[a.Id] = a
+
+	return &accpb.PostAccountResponse{Account: a}, nil
+}
+
+func (s *fakeAccountServer) GetAccount(ctx context.Context, r *accpb.GetAccountRequest) (*accpb.GetAccountResponse, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	a, exist := s.accounts[r.Id]
+	if !exist {
+		return nil, status.Errorf(codes.NotFound, "account %d not found", r.Id)
+	}
+
+	return &accpb.GetAccountResponse{Account: a}, nil
+}
+
+// SuspendAccount needs a reason and only suspends an active account.
+func (s *fakeAccountServer) SuspendAccount(ctx context.Context, r *accpb.SuspendAccountRequest) (*accpb.SuspendAccountResponse, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	reason := strings.TrimSpace(r.Reason)
+	if reason == "" {
+		return nil, status.Error(codes.InvalidArgument, "a reason is required to change the account status")
+	}
+
+	a, exist := s.accounts[r.Id]
+	if !exist {
+		return nil, status.Errorf(codes.NotFound, "account %d not found", r.Id)
+	}
+	if a.Status != accpb.AccountStatus_ACCOUNT_STATUS_ACTIVE {
+		return nil, status.Errorf(codes.FailedPrecondition, "invalid account status change: account %d is %s", r.Id, a.Status)
+	}
+
+	a.Status = accpb.AccountStatus_ACCOUNT_STATUS_SUSPENDED
+	a.StatusReason = reason
+
+	return &accpb.SuspendAccountResponse{Account: a}, nil
+}
+
+// normalizeAddress trims a and checks the fields the account service
+// requires.
+func normalizeAddress(a *accpb.Address) (*accpb.Address, error) {
+	a = proto.Clone(a).(*accpb.Address)
+	for _, field := range []*string{&a.Name, &a.Line1, &a.Line2, &a.City, &a.Region, &a.PostalCode, &a.Country, &a.Phone} {
+		*field = strings.TrimSpace(*field)
+	}
+	a.Country = strings.ToUpper(a.Country)
+
+	switch {
+	case a.Name == "":
+		return nil, status.Error(codes.InvalidArgument, "invalid address: name is required")
+	case a.Line1 == "":
+		return nil, status.Error(codes.InvalidArgument, "invalid address: line1 is required")
+	case a.City == "":
+		return nil, status.Error(codes.InvalidArgument, "invalid address: city is required")
+	case !countryCode.MatchString(a.Country):
+		return nil, status.Error(codes.InvalidArgument, "invalid address: country must be a two letter ISO 3166-1 code")
+	}
+
+	return a, nil
+}
+
+func (s *fakeAccountServer) AddAddress(ctx context.Context, r *accpb.AddAddressRequest) (*accpb.AddAddressResponse, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	a, err := normalizeAddress(r.Address)
+	if err != nil {
+		return nil, err
+	}
+	if _, exist := s.accounts[r.AccountId]; !exist {
+		return nil, status.Errorf(codes.NotFound, "account %d not found", r.AccountId)
+	}
+
+	s.nextAddressID++
+	a.Id = s.nextAddressID
+	a.AccountId = r.AccountId
+	a.IsDefault = !slices.ContainsFunc(s.addresses, func(o *accpb.Address) bool { return o.AccountId == r.AccountId })
+	s.addresses = append(s.addresses, a)
+
+	return &accpb.AddAddressResponse{Address: a}, nil
+}
+
+func (s *fakeAccountServer) UpdateAddress(ctx context.Context, r *accpb.UpdateAddressRequest) (*accpb.UpdateAddressResponse, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	updated, err := normalizeAddress(r.Address)
+	if err != nil {
+		return nil, err
+	}
+
+	for i, a := range s.addresses {
+		if a.Id == updated.Id && a.AccountId == r.AccountId {
+			updated.AccountId = a.AccountId
+			updated.IsDefault = a.IsDefault
+			s.addresses[i] = updated
+			return &accpb.UpdateAddressResponse{Address: updated}, nil
+		}
+	}
+
+	return nil, status.Errorf(codes.NotFound, "address %d not found", r.Address.Id)
+}
+
+func (s *fakeAccountServer) ListAddresses(ctx context.Context, r *accpb.ListAddressesRequest) (*accpb.ListAddressesResponse, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	addresses := []*accpb.Address{}
+	for _, a := range s.addresses {
+		if a.AccountId == r.AccountId {
+			addresses = append(addresses, a)
+		}
+	}
+
+	return &accpb.ListAddressesResponse{Addresses: addresses}, nil
+}
+
+// fakeCatalogServer stands in for the catalog service, keeping products and
+// exchange rates in memory. Like the catalog, it validates prices and rates,
+// defaults a product's currency and tax category, and prices products in the
+// currency asked for from their price list or at an exchange rate. Callers
+// aren't authorized.
+type fakeCatalogServer struct {
+	catpb.UnimplementedCatalogServiceServer
+
+	mu       sync.Mutex
+	products map[string]*catpb.Product
+	rates    map[string]*catpb.ExchangeRate
+	nextID   int
+}
+
+func newFakeCatalogServer() *fakeCatalogServer {
+	return &fakeCatalogServer{products: map[string]*catpb.Product{}, rates: map[string]*catpb.ExchangeRate{}}
+}
+
+func (s *fakeCatalogServer) PostProduct(ctx context.Context, r *catpb.PostProductRequest) (*catpb.PostProductResponse, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	price := proto.Clone(r.Price).(*catpb.Money)
+	if price == nil {
+		price = &catpb.Money{}
+	}
+	if price.Currency == "" {
+		price.Currency = money.DefaultCurrency
+	}
+
+	listed := map[string]bool{}
+	for _, p := range append([]*catpb.Money{price}, r.Prices...) {
+		if err := money.ValidateCurrency(p.Currency); err != nil {
+			return nil, status.Errorf(codes.InvalidArgument, "invalid price: %v", err)
+		}
+		if p.Units < 0 {
+			return nil, status.Error(codes.InvalidArgument, "invalid price: price can't be negative")
+		}
+		if listed[p.Currency] {
+			return nil, status.Errorf(codes.InvalidArgument, "invalid price: more than one price in %s", p.Currency)
+		}
+		listed[p.Currency] = true
+	}
+
+	taxCategory := r.TaxCategory
+	if taxCategory == "" {
+		taxCategory = DefaultTaxCategory
+	}
+
+	s.nextID++
+	p := &catpb.Product{Id: fmt.Sprintf("product-%d", s.nextID), Name: r.Name, Description: r.Description, Price: price, TaxCategory: taxCategory, Prices: r.Prices}
+	s.products[p.Id] = p
+
+	return &catpb.PostProductResponse{Product: p}, nil
+}
+
+func (s *fakeCatalogServer) GetProducts(ctx context.Context, r *catpb.GetProductsRequest) (*catpb.GetProductsResponse, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if r.Currency != "" {
+		if err := money.ValidateCurrency(r.Currency); err != nil {
+			return nil, status.Error(codes.InvalidArgument, err.Error())
+		}
+	}
+
+	products := []*catpb.Product{}
+	for _, id := range r.Ids {
+		p, exist := s.products[id]
+		if !exist {
+			continue
+		}
+
+		priced, err := s.priceIn(p, r.Currency)
+		if err != nil {
+			return nil, err
+		}
+		products = append(products, priced)
+	}
+
+	return &catpb.GetProductsResponse{Products: products}, nil
+}
+
+func (s *fakeCatalogServer) SetExchangeRate(ctx context.Context, r *catpb.SetExchangeRateRequest) (*catpb.SetExchangeRateResponse, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, currency := range []string{r.FromCurrency, r.ToCurrency} {
+		if err := money.ValidateCurrency(currency); err != nil {
+			return nil, status.Errorf(codes.InvalidArgument, "invalid exchange rate: %v", err)
+		}
+	}
+	if r.FromCurrency == r.ToCurrency {
+		return nil, status.Errorf(codes.InvalidArgument, "invalid exchange rate: %s to itself", r.FromCurrency)
+	}
+	if _, err := money.ParseRate(r.Rate); err != nil {
+		return nil, status.Errorf(codes.InvalidArgument, "invalid exchange rate: %v", err)
+	}
+
+	rate := &catpb.ExchangeRate{FromCurrency: r.FromCurrency, ToCurrency: r.ToCurrency, Rate: r.Rate}
+	s.rates[r.FromCurrency+"-"+r.ToCurrency] = rate
+
+	return &catpb.SetExchangeRateResponse{ExchangeRate: rate}, nil
+}
+
+func (s *fakeCatalogServer) priceIn(p *catpb.Product, currency string) (*catpb.Product, error) {
+	if currency == "" || currency == p.Price.Currency {
+		return p, nil
+	}
+
+	priced := proto.Clone(p).(*catpb.Product)
+
+	for _, listed := range p.Prices {
+		if listed.Currency == currency {
+			priced.Price = listed
+			return priced, nil
+		}
+	}
+
+	rate, exist := s.rates[p.Price.Currency+"-"+currency]
+	if !exist {
+		return nil, status.Errorf(codes.FailedPrecondition, "no %s to %s rate", p.Price.Currency, currency)
+	}
+
+	r, err := money.ParseRate(rate.Rate)
+	if err != nil {
+		return nil, err
+	}
+
+	converted, err := moneyFromPB(p.Price).Convert(currency, r)
+	if err != nil {
+		return nil, err
+	}
+
+	priced.Price = pbMoney(converted)
+	priced.ExchangeRate = rate
+
+	return priced, nil
+}
+
+// fakePaymentServer stands in for the payment service, keeping payments in
+// memory. Authorizations for declineAmount are declined, and captures fail
+// with captureErr while it is set. Like the real service, an Authorize with
+// an idempotency key already used returns that payment, and only an
+// authorized payment can be captured or voided.
+type fakePaymentServer struct {
+	paypb.UnimplementedPaymentServiceServer
+
+	mu            sync.Mutex
+	payments      map[int32]*paypb.Payment
+	declineAmount int64
+	captureErr    error
+}
+
+func newFakePaymentServer() *fakePaymentServer {
+	return &fakePaymentServer{payments: map[int32]*paypb.Payment{}}
+}
+
+func (s *fakePaymentServer) Authorize(ctx context.Context, r *paypb.AuthorizeRequest) (*paypb.AuthorizeResponse, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, p := range s.payments {
+		if p.IdempotencyKey == r.IdempotencyKey {
+			return &paypb.AuthorizeResponse{Payment: p}, nil
+		}
+	}
+
+	p := &paypb.Payment{
+		Id:             int32(len(s.payments) + 1),
+		IdempotencyKey: r.IdempotencyKey,
+		AccountId:      r.AccountId,
+		Amount:         r.Amount,
+		Currency:       r.Currency,
+		Status:         paypb.PaymentStatus_PAYMENT_STATUS_AUTHORIZED,
+	}
+	if r.Amount == s.declineAmount {
+		p.Status = paypb.PaymentStatus_PAYMENT_STATUS_DECLINED
+		p.DeclineReason = "insufficient funds"
+	}
+	s.payments[p.Id] = p
+
+	return &paypb.AuthorizeResponse{Payment: p}, nil
+}
+
+func (s *fakePaymentServer) Capture(ctx context.Context, r *paypb.CaptureRequest) (*paypb.CaptureResponse, error) {
+	s.mu.Lock()
+	err := s.captureErr
+	s.mu.Unlock()
+
+	if err != nil {
+		return nil, err
+	}
+
+	p, err := s.setStatus(r.Id, paypb.PaymentStatus_PAYMENT_STATUS_CAPTURED)
+	if err != nil {
+		return nil, err
+	}
+
+	return &paypb.CaptureResponse{Payment: p}, nil
+}
+
+func (s *fakePaymentServer) Void(ctx context.Context, r *paypb.VoidRequest) (*paypb.VoidResponse, error) {
+	p, err := s.setStatus(r.Id, paypb.PaymentStatus_PAYMENT_STATUS_VOIDED)
+	if err != nil {
+		return nil, err
+	}
+
+	return &paypb.VoidResponse{Payment: p}, nil
+}
+
+// setStatus moves an authorized payment to st. A payment already in st is
+// returned unchanged.
+func (s *fakePaymentServer) setStatus(id int32, st paypb.PaymentStatus) (*paypb.Payment, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	p, exist := s.payments[id]
+	switch {
+	case !exist:
+		return nil, status.Errorf(codes.NotFound, "payment %d not found", id)
+	case p.Status == st:
+		return p, nil
+	case p.Status != paypb.PaymentStatus_PAYMENT_STATUS_AUTHORIZED:
+		return nil, status.Errorf(codes.FailedPrecondition, "payment %d is %s", id, p.Status)
+	}
+
+	p.Status = st
+	return p, nil
+}
+
+func (s *fakePaymentServer) status(id int32) paypb.PaymentStatus {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.payments[id].Status
+}
+
+// fakePaymentClient is a PaymentServiceClient for tests that call Server
+// directly. It authorizes and captures everything.
+type fakePaymentClient struct {
+	paypb.PaymentServiceClient
+}
+
+func (c *fakePaymentClient) Authorize(ctx context.Context, in *paypb.AuthorizeRequest, opts ...grpc.CallOption) (*paypb.AuthorizeResponse, error) {
+	return &paypb.AuthorizeResponse{Payment: &paypb.Payment{Id: 1, Amount: in.Amount, Status: paypb.PaymentStatus_PAYMENT_STATUS_AUTHORIZED}}, nil
+}
+
+func (c *fakePaymentClient) Capture(ctx context.Context, in *paypb.CaptureRequest, opts ...grpc.CallOption) (*paypb.CaptureResponse, error) {
+	return &paypb.CaptureResponse{Payment: &paypb.Payment{Id: in.Id, Status: paypb.PaymentStatus_PAYMENT_STATUS_CAPTURED}}, nil
+}
+
+// fakeAccountClient is an AccountServiceClient for tests that call Server
+// directly; err, when set, is returned from every call.
+type fakeAccountClient struct {
+	accpb.AccountServiceClient
+	accounts map[int32]*accpb.Account
+	err      error
+}
+
+func (c *fakeAccountClient) GetAccount(ctx context.Context, in *accpb.GetAccountRequest, opts ...grpc.CallOption) (*accpb.GetAccountResponse, error) {
+	if c.err != nil {
+		return nil, c.err
+	}
+
+	a, exist := c.accounts[in.Id]
+	if !exist {
+		return nil, status.Errorf(codes.NotFound, "account %d not found", in.Id)
+	}
+
+	return &accpb.GetAccountResponse{Account: a}, nil
+}
+
+// ListAddresses returns no addresses, so orders ship nowhere.
+func (c *fakeAccountClient) ListAddresses(ctx context.Context, in *accpb.ListAddressesRequest, opts ...grpc.CallOption) (*accpb.ListAddressesResponse, error) {
+	if c.err != nil {
+		return nil, c.err
+	}
+
+	return &accpb.ListAddressesResponse{Addresses: []*accpb.Address{}}, nil
+}
+
+// fakeCatalogClient is a CatalogServiceClient for tests that call Server
+// directly; err, when set, is returned from every call.
+type fakeCatalogClient struct {
+	catpb.CatalogServiceClient
+	products map[string]*catpb.Product
+	err      error
+}
+
+func (c *fakeCatalogClient) GetProducts(ctx context.Context, in *catpb.GetProductsRequest, opts ...grpc.CallOption) (*catpb.GetProductsResponse, error) {
+	if c.err != nil {
+		return nil, c.err
+	}
+
+	products := []*catpb.Product{}
+	for _, id := range in.Ids {
+		if p, exist := c.products[id]; exist {
+			products = append(products, p)
+		}
+	}
+
+	return &catpb.GetProductsResponse{Products: products}, nil
+}
@@ -0,0 +1,218 @@
+package main
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/airlangga-hub/microservices/audit"
+	"github.com/airlangga-hub/microservices/auth"
+	"github.com/airlangga-hub/microservices/events"
+	accpb "github.com/airlangga-hub/microservices/order/account_pb"
+	catpb "github.com/airlangga-hub/microservices/order/catalog_pb"
+	paypb "github.com/airlangga-hub/microservices/order/payment_pb"
+	"github.com/airlangga-hub/microservices/order/pb"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/test/bufconn"
+)
+
+// harness runs the order service and fake account, catalog and payment
+// services in one process, wired together over bufconn and through order's
+// real client interceptors.
+//
+// The account, catalog and payment servers are each package main of their
+// own module, which Go doesn't let order import, so the harness can't run
+// them. The fakes in fakes_test.go follow the rules of the real services
+// that order depends on instead; keep them in step when those change.
+type harness struct {
+	t *testing.T
+
+	Order  pb.OrderServiceClient
+	Cart   pb.CartServiceClient
+	Promos pb.PromotionServiceClient
+	// Carts is the cart server behind Cart, for tests that swap its
+	// repository.
+	Carts    *CartServer
+	Accounts *fakeAccountServer
+	Catalog  *fakeCatalogServer
+	Payments *fakePaymentServer
+	Repo     Repository
+	Bus      *events.MemoryBus
+}
+
+func newHarness(t *testing.T) *harness {
+	t.Helper()
+
+	h := &harness{
+		t:        t,
+		Accounts: newFakeAccountServer(),
+		Catalog:  newFakeCatalogServer(),
+		Payments: newFakePaymentServer(),
+		Repo:     NewMemoryRepository(),
+		Bus:      events.NewMemoryBus(),
+	}
+
+	feed := newOrderFeed(100, 16)
+	unsubscribe, _ := h.Bus.Subscribe(feed.Publish)
+	t.Cleanup(unsubscribe)
+	t.Cleanup(feed.Close)
+
+	accountLis := serve(t, func(s *grpc.Server) { accpb.RegisterAccountServiceServer(s, h.Accounts) })
+	catalogLis := serve(t, func(s *grpc.Server) { catpb.RegisterCatalogServiceServer(s, h.Catalog) })
+	paymentLis := serve(t, func(s *grpc.Server) { paypb.RegisterPaymentServiceServer(s, h.Payments) })
+
+	accountConn, err := dialService("account", "passthrough:///account", time.Second, bufDialer(accountLis))
+	if err != nil {
+		t.Fatalf("dial account: %v", err)
+	}
+	t.Cleanup(func() { accountConn.Close() })
+
+	catalogConn, err := dialService("catalog", "passthrough:///catalog", time.Second, bufDialer(catalogLis))
+	if err != nil {
+		t.Fatalf("dial catalog: %v", err)
+	}
+	t.Cleanup(func() { catalogConn.Close() })
+
+	paymentConn, err := dialService("payment", "passthrough:///payment", time.Second, bufDialer(paymentLis))
+	if err != nil {
+		t.Fatalf("dial payment: %v", err)
+	}
+	t.Cleanup(func() { paymentConn.Close() })
+
+	server := &Server{
+		Svc:           NewService(h.Repo, NewTaxRules(DefaultTaxRules), ""),
+		AccountClient: accpb.NewAccountServiceClient(accountConn),
+		CatalogClient: catpb.NewCatalogServiceClient(catalogConn),
+		PaymentClient: paypb.NewPaymentServiceClient(paymentConn),
+		Feed:          feed,
+	}
+
+	h.Carts = &CartServer{
+		Repo:          h.Repo,
+		AccountClient: server.AccountClient,
+		CatalogClient: server.CatalogClient,
+		Orders:        server,
+	}
+
+	authenticate := apiKeyAuthenticator(server.AccountClient)
+
+	orderLis := serve(t, func(s *grpc.Server) {
+		pb.RegisterOrderServiceServer(s, server)
+		pb.RegisterCartServiceServer(s, h.Carts)
+		pb.RegisterPromotionServiceServer(s, &PromotionServer{Repo: h.Repo})
+	},
+		grpc.ChainUnaryInterceptor(
+			auth.UnaryInterceptor(authenticate, methodScopes),
+			audit.Interceptor("order", h.Repo, auditSpecs(h.Repo)),
+		),
+		grpc.ChainStreamInterceptor(auth.StreamInterceptor(authenticate, methodScopes)),
+	)
+
+	orderConn, err := grpc.NewClient("passthrough:///order", bufDialer(orderLis), grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		t.Fatalf("dial order: %v", err)
+	}
+	t.Cleanup(func() { orderConn.Close() })
+
+	h.Order = pb.NewOrderServiceClient(orderConn)
+	h.Cart = pb.NewCartServiceClient(orderConn)
+	h.Promos = pb.NewPromotionServiceClient(orderConn)
+
+	return h
+}
+
+func serve(t *testing.T, register func(s *grpc.Server), opts ...grpc.ServerOption) *bufconn.Listener {
+	t.Helper()
+
+	lis := bufconn.Listen(1 << 20)
+
+	s := grpc.NewServer(opts...)
+	register(s)
+
+	go s.Serve(lis)
+	t.Cleanup(s.Stop)
+
+	return lis
+}
+
+func bufDialer(lis *bufconn.Listener) grpc.DialOption {
+	return grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) {
+		return lis.DialContext(ctx)
+	})
+}
+
+func (h *harness) account(t *testing.T, name string) *accpb.Account {
+	t.Helper()
+
+	res, err := h.Accounts.PostAccount(context.Background(), &accpb.PostAccountRequest{Name: name})
+	if err != nil {
+		t.Fatalf("PostAccount: %v", err)
+	}
+
+	return res.Account
+}
+
+// withKey grants accountID permissions and returns a context sending a new
+// key of it with scopes, created as the account service would.
+func (h *harness) withKey(accountID int32, scopes []string, permissions ...string) context.Context {
+	h.t.Helper()
+
+	h.Accounts.grant(accountID, permissions...)
+
+	res, err := h.Accounts.CreateAPIKey(context.Background(), &accpb.CreateAPIKeyRequest{AccountId: accountID, Name: "test", Scopes: scopes})
+	if err != nil {
+		h.t.Fatalf("CreateAPIKey: %v", err)
+	}
+
+	return metadata.AppendToOutgoingContext(context.Background(), auth.APIKeyMetadataKey, res.Key)
+}
+
+// as returns a context sending a key of accountID scoped for everything,
+// like the account's own client would.
+func (h *harness) as(accountID int32) context.Context {
+	return h.withKey(accountID, []string{"*:*:*"})
+}
+
+func (h *harness) product(t *testing.T, name string, price int64) *catpb.Product {
+	t.Helper()
+
+	res, err := h.Catalog.PostProduct(context.Background(), &catpb.PostProductRequest{Name: name, Description: name + " description", Price: pbUSD(price)})
+	if err != nil {
+		t.Fatalf("PostProduct: %v", err)
+	}
+
+	return res.Product
+}
+
+// relay publishes the repository's pending events, standing in for the
+// outbox relay.
+func (h *harness) relay(t *testing.T) {
+	t.Helper()
+
+	if _, err := h.Repo.DispatchEvents(context.Background(), 100, h.Bus.Publish); err != nil {
+		t.Fatalf("DispatchEvents: %v", err)
+	}
+}
+
+func (h *harness) order(t *testing.T, accountID int32, products ...*catpb.Product) *pb.Order {
+	t.Helper()
+
+	pbProducts := []*pb.OrderedProduct{}
+	for _, p := range products {
+		pbProducts = append(pbProducts, &pb.OrderedProduct{Id: p.Id, Quantity: 1})
+	}
+
+	res, err := h.Order.PostOrder(context.Background(), &pb.PostOrderRequest{AccountId: accountID, Products: pbProducts})
+	if err != nil {
+		t.Fatalf("PostOrder: %v", err)
+	}
+
+	return res.Order
+}
+
+func pbUSD(units int64) *catpb.Money {
+	return &catpb.Money{Currency: "USD", Units: units}
+}
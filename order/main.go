@@ -1,51 +1,72 @@
 package main
 
 import (
-	"fmt"
+	"context"
+	"errors"
 	"log"
-	"net"
 	"os"
 	"os/signal"
 	"syscall"
 
-	"github.com/airlangga-hub/microservices/order/pb"
-	"google.golang.org/grpc"
+	"github.com/airlangga-hub/microservices/order/config"
 )
 
 func main() {
-	dbUrl := os.Getenv("ORDER_DB_URL")
-	port := os.Getenv("ORDER_PORT")
+	if len(os.Args) > 1 && os.Args[1] == "migrate" {
+		cfg, err := config.LoadDatabase()
+		if err != nil {
+			log.Fatalf("ERROR: order main: %v", err)
+		}
+		if cfg.Storage != config.StoragePostgres {
+			log.Fatalf("ERROR: order main: migrate needs %s storage", config.StoragePostgres)
+		}
+		if err := runMigrate(cfg.DatabaseURL, os.Args[2:]); err != nil {
+			log.Fatalf("ERROR: order main: migrate: %v", err)
+		}
+		return
+	}
 
-	repository, err := NewRepository(dbUrl)
+	cfg, err := config.Load()
 	if err != nil {
-		log.Fatalf("ERROR: order main: couldn't create repository: %v", err)
+		log.Fatalf("ERROR: order main: %v", err)
 	}
-	defer repository.Close()
 
-	service := NewService(repository)
+	if cfg.MigrateOnStart && cfg.Storage == config.StoragePostgres {
+		if err := runMigrate(cfg.DatabaseURL, nil); err != nil {
+			log.Fatalf("ERROR: order main: migrate on start: %v", err)
+		}
+	}
 
-	s := grpc.NewServer()
-	pb.RegisterOrderServiceServer(s, &Server{Svc: service})
+	app, err := newApp(cfg)
+	if err != nil {
+		log.Fatalf("ERROR: order main: %v", err)
+	}
 
-	exitChan := make(chan error, 1)
+	startupCtx, startupCancel := context.WithTimeout(context.Background(), cfg.StartupTimeout)
+	err = app.WaitForDependencies(startupCtx)
+	startupCancel()
+	if err != nil {
+		app.Shutdown(context.Background())
+		log.Fatalf("ERROR: order main: %v", err)
+	}
+
+	ctx, cancel := context.WithCancelCause(context.Background())
+	defer cancel(nil)
 
 	go func() {
 		sig := make(chan os.Signal, 1)
 		signal.Notify(sig, os.Interrupt, syscall.SIGTERM)
 		s := <-sig
-		exitChan <- fmt.Errorf("received signal: %v", s)
-	}()
-
-	go func() {
-		lis, _ := net.Listen("tcp", port)
-		err := s.Serve(lis)
-		if err != nil && err != grpc.ErrServerStopped {
-			exitChan <- fmt.Errorf("grpc serve error: %v", err)
-		}
+		cancel(errors.New("received signal: " + s.String()))
 	}()
 
-	err = <-exitChan
+	err = app.Run(ctx)
 	log.Printf("Shutting down. Reason: %v", err)
 
-	s.GracefulStop()
+	cancel(err)
+
+	shutdownCtx, shutdownCancel := context.WithTimeout(context.Background(), cfg.ShutdownTimeout)
+	defer shutdownCancel()
+
+	app.Shutdown(shutdownCtx)
 }
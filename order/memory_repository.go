@@ -0,0 +1,432 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/airlangga-hub/microservices/audit"
+	"github.com/airlangga-hub/microservices/events"
+)
+
+// memoryRepository is a concurrency-safe, in-memory Repository used for
+// running without a database and as a fake in tests. Like the postgres one it
+// keeps each line's product ID, quantity and unit price, so reads must be
+// enriched with names from catalog.
+type memoryRepository struct {
+	mu     sync.RWMutex
+	orders map[int32]Order
+	nextID int32
+	outbox []events.Event
+	carts  map[int32][]CartItem
+
+	promotions      map[int32]Promotion
+	nextPromotionID int32
+
+	captures map[int32]pendingCapture
+
+	auditLog []audit.Entry
+}
+
+// pendingCapture is a placed order's payment not captured yet.
+type pendingCapture struct {
+	attempts      int
+	nextAttemptAt time.Time
+}
+
+func NewMemoryRepository() Repository {
+	return &memoryRepository{
+		orders:     map[int32]Order{},
+		carts:      map[int32][]CartItem{},
+		promotions: map[int32]Promotion{},
+		captures:   map[int32]pendingCapture{},
+	}
+}
+
+func (r *memoryRepository) Close() error {
+	return nil
+}
+
+func (r *memoryRepository) Ping(ctx context.Context) error {
+	return nil
+}
+
+func (r *memoryRepository) CreateOrder(ctx context.Context, o Order) (Order, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	// check every promotion before counting any, so a failed order leaves
+	// usage untouched
+	used := map[int32]bool{}
+	for _, d := range o.Discounts {
+		used[d.PromotionID] = true
+	}
+	for id := range used {
+		if p := r.promotions[id]; p.UsageLimit != 0 && p.UsageCount >= p.UsageLimit {
+			return Order{}, errPromotionExhausted
+		}
+	}
+
+	for _, placed := range r.orders {
+		if o.RequestID != "" && placed.AccountID == o.AccountID && placed.RequestID == o.RequestID {
+			return Order{}, errOrderRequestTaken
+		}
+	}
+
+	o.ID = r.nextID + 1
+	o.CreatedAt = time.Now().UTC()
+
+	event, err := events.New(EventOrderPlaced, strconv.Itoa(int(o.ID)), o)
+	if err != nil {
+		return Order{}, errors.New("error creating order")
+	}
+
+	r.nextID = o.ID
+	r.outbox = append(r.outbox, event)
+
+	for id := range used {
+		p := r.promotions[id]
+		p.UsageCount++
+		r.promotions[id] = p
+	}
+
+	stored := o
+	stored.Discounts = slices.Clone(o.Discounts)
+	stored.Taxes = slices.Clone(o.Taxes)
+	stored.ExchangeRates = slices.Clone(o.ExchangeRates)
+	if o.ShippingAddress != nil {
+		address := *o.ShippingAddress
+		stored.ShippingAddress = &address
+	}
+	stored.Products = []OrderedProduct{}
+	for _, p := range o.Products {
+		stored.Products = append(stored.Products, OrderedProduct{ID: p.ID, Quantity: p.Quantity, Price: p.Price})
+	}
+	r.orders[o.ID] = stored
+
+	if o.PaymentID != 0 {
+		r.captures[o.PaymentID] = pendingCapture{nextAttemptAt: o.CreatedAt}
+	}
+
+	return o, nil
+}
+
+func (r *memoryRepository) GetOrdersByAccountID(ctx context.Context, accountID int32) ([]*Order, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	orders := []*Order{}
+	for _, o := range r.orders {
+		if o.AccountID == accountID {
+			order := cloneOrder(o)
+			orders = append(orders, &order)
+		}
+	}
+
+	sort.Slice(orders, func(i, j int) bool { return orders[i].ID < orders[j].ID })
+
+	return orders, nil
+}
+
+func (r *memoryRepository) GetOrder(ctx context.Context, id int32) (Order, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	o, exist := r.orders[id]
+	if !exist {
+		return Order{}, errOrderNotFound
+	}
+
+	return cloneOrder(o), nil
+}
+
+func (r *memoryRepository) GetOrderByRequestID(ctx context.Context, accountID int32, requestID string) (Order, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	for _, o := range r.orders {
+		if o.AccountID == accountID && o.RequestID == requestID {
+			return cloneOrder(o), nil
+		}
+	}
+
+	return Order{}, errOrderNotFound
+}
+
+func (r *memoryRepository) UpdateOrderStatus(ctx context.Context, o Order, to OrderStatus) (Order, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	stored, exist := r.orders[o.ID]
+	if !exist || stored.Status != o.Status {
+		return Order{}, errInvalidStatusChange
+	}
+
+	o.Status = to
+
+	event, err := events.New(EventOrderStatusChanged, strconv.Itoa(int(o.ID)), o)
+	if err != nil {
+		return Order{}, errors.New("error updating order status")
+	}
+
+	stored.Status = to
+	r.orders[o.ID] = stored
+	r.outbox = append(r.outbox, event)
+
+	return o, nil
+}
+
+// cloneOrder copies o so callers can't change the stored order.
+func cloneOrder(o Order) Order {
+	o.Products = append([]OrderedProduct{}, o.Products...)
+	o.Discounts = append([]Discount{}, o.Discounts...)
+	o.Taxes = append([]TaxLine{}, o.Taxes...)
+	o.ExchangeRates = append([]ExchangeRate{}, o.ExchangeRates...)
+	return o
+}
+
+// DispatchEvents publishes outside the lock so bus subscribers may call back
+// into the repository.
+func (r *memoryRepository) DispatchEvents(ctx context.Context, limit int, publish func(ctx context.Context, e events.Event) error) (int, error) {
+	r.mu.RLock()
+	pending := slices.Clone(r.outbox[:min(limit, len(r.outbox))])
+	r.mu.RUnlock()
+
+	published := map[string]bool{}
+	var publishErr error
+
+	for _, e := range pending {
+		if publishErr = publish(ctx, e); publishErr != nil {
+			break
+		}
+		published[e.ID] = true
+	}
+
+	r.mu.Lock()
+	r.outbox = slices.DeleteFunc(r.outbox, func(e events.Event) bool { return published[e.ID] })
+	r.mu.Unlock()
+
+	if publishErr != nil {
+		return len(published), errors.New("error publishing events")
+	}
+
+	return len(published), nil
+}
+
+func (r *memoryRepository) CaptureDone(ctx context.Context, paymentID int32) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	delete(r.captures, paymentID)
+	return nil
+}
+
+// DispatchCaptures captures outside the lock, like DispatchEvents publishes.
+func (r *memoryRepository) DispatchCaptures(ctx context.Context, limit int, capture func(ctx context.Context, paymentID int32) error) (int, error) {
+	now := time.Now().UTC()
+
+	r.mu.RLock()
+	due := []int32{}
+	for id, c := range r.captures {
+		if !c.nextAttemptAt.After(now) {
+			due = append(due, id)
+		}
+	}
+	r.mu.RUnlock()
+
+	slices.Sort(due)
+	due = due[:min(limit, len(due))]
+
+	for _, id := range due {
+		err := capture(ctx, id)
+
+		r.mu.Lock()
+		if c, pending := r.captures[id]; pending {
+			if err != nil {
+				c.attempts++
+				c.nextAttemptAt = now.Add(captureBackoff(c.attempts))
+				r.captures[id] = c
+			} else {
+				delete(r.captures, id)
+			}
+		}
+		r.mu.Unlock()
+	}
+
+	return len(due), nil
+}
+
+func (r *memoryRepository) GetCartItems(ctx context.Context, accountID int32) ([]CartItem, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	return slices.Clone(r.carts[accountID]), nil
+}
+
+func (r *memoryRepository) AddCartItem(ctx context.Context, accountID int32, item CartItem) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	items := r.carts[accountID]
+
+	i := slices.IndexFunc(items, func(c CartItem) bool { return c.ProductID == item.ProductID })
+	if i >= 0 {
+		items[i].Quantity += item.Quantity
+		items[i].Price = item.Price
+		return nil
+	}
+
+	item.AddedAt = time.Now().UTC()
+	r.carts[accountID] = append(items, item)
+
+	return nil
+}
+
+func (r *memoryRepository) SetCartItemQuantity(ctx context.Context, accountID int32, productID string, quantity int32) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	items := r.carts[accountID]
+
+	i := slices.IndexFunc(items, func(c CartItem) bool { return c.ProductID == productID })
+	if i < 0 {
+		return errCartItemNotFound
+	}
+
+	items[i].Quantity = quantity
+
+	return nil
+}
+
+func (r *memoryRepository) RemoveCartItems(ctx context.Context, accountID int32, productIDs []string) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	before := len(r.carts[accountID])
+	r.carts[accountID] = slices.DeleteFunc(r.carts[accountID], func(c CartItem) bool { return slices.Contains(productIDs, c.ProductID) })
+
+	return before - len(r.carts[accountID]), nil
+}
+
+func (r *memoryRepository) CreatePromotion(ctx context.Context, p Promotion) (Promotion, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if p.Code != "" {
+		for _, existing := range r.promotions {
+			if existing.Code == p.Code {
+				return Promotion{}, errPromotionCodeTaken
+			}
+		}
+	}
+
+	r.nextPromotionID++
+	p.ID = r.nextPromotionID
+	p.UsageCount = 0
+	p.Active = true
+	r.promotions[p.ID] = p
+
+	return p, nil
+}
+
+func (r *memoryRepository) GetPromotionByID(ctx context.Context, id int32) (Promotion, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	p, exist := r.promotions[id]
+	if !exist {
+		return Promotion{}, errPromotionNotFound
+	}
+
+	return p, nil
+}
+
+func (r *memoryRepository) ListPromotions(ctx context.Context, offset, limit int32) ([]Promotion, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	promotions := []Promotion{}
+	for _, p := range r.promotions {
+		promotions = append(promotions, p)
+	}
+
+	sort.Slice(promotions, func(i, j int) bool { return promotions[i].ID > promotions[j].ID })
+
+	start := min(int(offset), len(promotions))
+	end := min(start+int(limit), len(promotions))
+
+	return promotions[start:end], nil
+}
+
+func (r *memoryRepository) DeactivatePromotion(ctx context.Context, id int32) (Promotion, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	p, exist := r.promotions[id]
+	if !exist {
+		return Promotion{}, errPromotionNotFound
+	}
+
+	p.Active = false
+	r.promotions[id] = p
+
+	return p, nil
+}
+
+func (r *memoryRepository) LivePromotions(ctx context.Context, couponCodes []string, now time.Time) ([]Promotion, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	promotions := []Promotion{}
+	for _, p := range r.promotions {
+		if p.Live(now) && (p.Code == "" || slices.Contains(couponCodes, p.Code)) {
+			promotions = append(promotions, p)
+		}
+	}
+
+	sort.Slice(promotions, func(i, j int) bool { return promotions[i].ID < promotions[j].ID })
+
+	return promotions, nil
+}
+
+func (r *memoryRepository) RecordAudit(ctx context.Context, e audit.Entry) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.auditLog = append(r.auditLog, e)
+
+	return nil
+}
+
+func (r *memoryRepository) QueryAuditLog(ctx context.Context, q audit.Query) ([]audit.Entry, error) {
+	if q.Offset < 0 || q.Limit < 0 {
+		return nil, errors.New("error querying audit log")
+	}
+
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	entries := []audit.Entry{}
+
+	// newest first: entries are recorded in order
+	for _, e := range slices.Backward(r.auditLog) {
+		if q.Matches(e) {
+			entries = append(entries, e)
+		}
+	}
+
+	if int(q.Offset) >= len(entries) {
+		return []audit.Entry{}, nil
+	}
+	entries = entries[q.Offset:]
+
+	if int(q.Limit) < len(entries) {
+		entries = entries[:q.Limit]
+	}
+
+	return entries, nil
+}
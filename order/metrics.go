@@ -0,0 +1,79 @@
+package main
+
+import (
+	"context"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"google.golang.org/grpc/stats"
+)
+
+var (
+	clientRequests = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "order_client_requests_total",
+			Help: "Outgoing grpc calls made by the order service, by downstream service, method and status code.",
+		},
+		[]string{"service", "method", "code"},
+	)
+
+	clientRequestDuration = promauto.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Name:    "order_client_request_duration_seconds",
+			Help:    "Latency of outgoing grpc calls, including retries.",
+			Buckets: prometheus.DefBuckets,
+		},
+		[]string{"service", "method"},
+	)
+
+	clientAttempts = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "order_client_attempts_total",
+			Help: "Outgoing grpc call attempts; attempts above requests are retries.",
+		},
+		[]string{"service", "method"},
+	)
+
+	circuitBreakerState = promauto.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "order_client_circuit_breaker_state",
+			Help: "Circuit breaker state per downstream service (0 closed, 1 half-open, 2 open).",
+		},
+		[]string{"service"},
+	)
+
+	circuitBreakerRejections = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "order_client_circuit_breaker_rejections_total",
+			Help: "Outgoing grpc calls failed fast because the circuit breaker was open.",
+		},
+		[]string{"service"},
+	)
+)
+
+type methodKey struct{}
+
+// attemptStats counts every attempt of an outgoing call, so retries made by
+// the grpc retry policy show up in metrics.
+type attemptStats struct {
+	service string
+}
+
+func (a *attemptStats) TagRPC(ctx context.Context, info *stats.RPCTagInfo) context.Context {
+	return context.WithValue(ctx, methodKey{}, info.FullMethodName)
+}
+
+func (a *attemptStats) HandleRPC(ctx context.Context, s stats.RPCStats) {
+	if _, ok := s.(*stats.Begin); !ok {
+		return
+	}
+
+	method, _ := ctx.Value(methodKey{}).(string)
+	clientAttempts.WithLabelValues(a.service, method).Inc()
+}
+
+func (a *attemptStats) TagConn(ctx context.Context, _ *stats.ConnTagInfo) context.Context {
+	return ctx
+}
+
+func (a *attemptStats) HandleConn(context.Context, stats.ConnStats) {}
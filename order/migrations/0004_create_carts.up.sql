@@ -0,0 +1,8 @@
+CREATE TABLE IF NOT EXISTS cart_items (
+  account_id INTEGER NOT NULL,
+  product_id TEXT NOT NULL,
+  quantity INT NOT NULL CHECK (quantity > 0),
+  price BIGINT NOT NULL,
+  added_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
+  PRIMARY KEY (account_id, product_id)
+);
@@ -0,0 +1,5 @@
+DROP TABLE IF EXISTS order_discounts;
+
+ALTER TABLE orders DROP COLUMN IF EXISTS subtotal_price;
+
+DROP TABLE IF EXISTS promotions;
@@ -0,0 +1,33 @@
+CREATE TABLE IF NOT EXISTS promotions (
+  id SERIAL PRIMARY KEY,
+  code TEXT NOT NULL DEFAULT '',
+  name TEXT NOT NULL,
+  kind TEXT NOT NULL,
+  percent_off INT NOT NULL DEFAULT 0,
+  amount_off BIGINT NOT NULL DEFAULT 0,
+  product_id TEXT NOT NULL DEFAULT '',
+  buy_quantity INT NOT NULL DEFAULT 0,
+  get_quantity INT NOT NULL DEFAULT 0,
+  min_spend BIGINT NOT NULL DEFAULT 0,
+  starts_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
+  ends_at TIMESTAMP WITH TIME ZONE,
+  usage_limit INT NOT NULL DEFAULT 0,
+  usage_count INT NOT NULL DEFAULT 0,
+  active BOOLEAN NOT NULL DEFAULT TRUE
+);
+
+CREATE UNIQUE INDEX IF NOT EXISTS idx_promotions_code ON promotions (code) WHERE code <> '';
+
+ALTER TABLE orders ADD COLUMN IF NOT EXISTS subtotal_price BIGINT;
+
+CREATE TABLE IF NOT EXISTS order_discounts (
+  id SERIAL PRIMARY KEY,
+  order_id INTEGER NOT NULL REFERENCES orders (id) ON DELETE CASCADE,
+  promotion_id INTEGER NOT NULL REFERENCES promotions (id),
+  code TEXT NOT NULL DEFAULT '',
+  name TEXT NOT NULL,
+  product_id TEXT NOT NULL DEFAULT '',
+  amount BIGINT NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS idx_order_discounts_order_id ON order_discounts (order_id);
@@ -0,0 +1,4 @@
+DROP TABLE IF EXISTS order_taxes;
+
+ALTER TABLE orders DROP COLUMN IF EXISTS tax_total;
+ALTER TABLE orders DROP COLUMN IF EXISTS tax_region;
@@ -0,0 +1,15 @@
+ALTER TABLE orders ADD COLUMN IF NOT EXISTS tax_region TEXT NOT NULL DEFAULT '';
+ALTER TABLE orders ADD COLUMN IF NOT EXISTS tax_total BIGINT NOT NULL DEFAULT 0;
+
+CREATE TABLE IF NOT EXISTS order_taxes (
+  id SERIAL PRIMARY KEY,
+  order_id INTEGER NOT NULL REFERENCES orders (id) ON DELETE CASCADE,
+  product_id TEXT NOT NULL,
+  category TEXT NOT NULL,
+  name TEXT NOT NULL,
+  rate INT NOT NULL,
+  taxable BIGINT NOT NULL,
+  amount BIGINT NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS idx_order_taxes_order_id ON order_taxes (order_id);
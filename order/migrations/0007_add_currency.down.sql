@@ -0,0 +1,3 @@
+ALTER TABLE promotions DROP COLUMN IF EXISTS currency;
+ALTER TABLE cart_items DROP COLUMN IF EXISTS currency;
+ALTER TABLE orders DROP COLUMN IF EXISTS currency;
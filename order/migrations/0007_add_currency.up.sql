@@ -0,0 +1,9 @@
+-- amounts stored before they had a currency were all in USD
+ALTER TABLE orders ADD COLUMN IF NOT EXISTS currency TEXT NOT NULL DEFAULT 'USD';
+ALTER TABLE orders ALTER COLUMN currency DROP DEFAULT;
+
+ALTER TABLE cart_items ADD COLUMN IF NOT EXISTS currency TEXT NOT NULL DEFAULT 'USD';
+ALTER TABLE cart_items ALTER COLUMN currency DROP DEFAULT;
+
+ALTER TABLE promotions ADD COLUMN IF NOT EXISTS currency TEXT NOT NULL DEFAULT 'USD';
+ALTER TABLE promotions ALTER COLUMN currency DROP DEFAULT;
@@ -0,0 +1,10 @@
+-- rate is kept as the catalog quoted it, so the conversion can be redone
+CREATE TABLE IF NOT EXISTS order_exchange_rates (
+  id SERIAL PRIMARY KEY,
+  order_id INTEGER NOT NULL REFERENCES orders (id) ON DELETE CASCADE,
+  from_currency TEXT NOT NULL,
+  to_currency TEXT NOT NULL,
+  rate NUMERIC NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS idx_order_exchange_rates_order_id ON order_exchange_rates (order_id);
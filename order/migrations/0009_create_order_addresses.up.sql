@@ -0,0 +1,14 @@
+-- a copy of the account address, so editing the address book doesn't
+-- rewrite where past orders went
+CREATE TABLE IF NOT EXISTS order_addresses (
+  order_id INTEGER PRIMARY KEY REFERENCES orders (id) ON DELETE CASCADE,
+  address_id INTEGER NOT NULL,
+  name TEXT NOT NULL,
+  line1 TEXT NOT NULL,
+  line2 TEXT NOT NULL,
+  city TEXT NOT NULL,
+  region TEXT NOT NULL,
+  postal_code TEXT NOT NULL,
+  country TEXT NOT NULL,
+  phone TEXT NOT NULL
+);
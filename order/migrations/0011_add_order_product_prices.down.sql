@@ -0,0 +1,2 @@
+ALTER TABLE order_products DROP COLUMN IF EXISTS currency;
+ALTER TABLE order_products DROP COLUMN IF EXISTS unit_price_minor;
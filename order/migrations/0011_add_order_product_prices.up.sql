@@ -0,0 +1,4 @@
+-- what each line cost when the order was placed, in minor units of the
+-- order's currency; lines stored before this have no price
+ALTER TABLE order_products ADD COLUMN IF NOT EXISTS unit_price_minor BIGINT;
+ALTER TABLE order_products ADD COLUMN IF NOT EXISTS currency TEXT;
@@ -0,0 +1,2 @@
+DROP INDEX IF EXISTS idx_orders_account_request;
+ALTER TABLE orders DROP COLUMN IF EXISTS request_id;
@@ -0,0 +1,4 @@
+-- the client's id for the PostOrder call that placed the order, so a retry
+-- finds the order instead of placing another
+ALTER TABLE orders ADD COLUMN IF NOT EXISTS request_id TEXT;
+CREATE UNIQUE INDEX IF NOT EXISTS idx_orders_account_request ON orders (account_id, request_id);
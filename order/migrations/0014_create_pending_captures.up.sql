@@ -0,0 +1,11 @@
+-- payments authorized for placed orders and not captured yet; the capture
+-- relay retries each until it is captured
+CREATE TABLE IF NOT EXISTS pending_captures (
+  payment_id INT PRIMARY KEY,
+  order_id INT NOT NULL REFERENCES orders (id),
+  attempts INT NOT NULL DEFAULT 0,
+  next_attempt_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
+  last_error TEXT NOT NULL DEFAULT ''
+);
+
+CREATE INDEX IF NOT EXISTS idx_pending_captures_next_attempt_at ON pending_captures (next_attempt_at);
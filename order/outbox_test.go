@@ -0,0 +1,84 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"testing"
+
+	"github.com/airlangga-hub/microservices/events"
+)
+
+// recorder is a publish function that fails once it has published
+// failAfter events, or never if failAfter is negative.
+type recorder struct {
+	failAfter int
+	published []events.Event
+}
+
+func (r *recorder) publish(ctx context.Context, e events.Event) error {
+	if r.failAfter >= 0 && len(r.published) >= r.failAfter {
+		return errors.New("bus is down")
+	}
+	r.published = append(r.published, e)
+	return nil
+}
+
+func TestDispatchEventsOnlyMarksPublishedEvents(t *testing.T) {
+	h := newHarness(t)
+	account := h.account(t, "Ann")
+	product := h.product(t, "Keyboard", 100)
+
+	placed := []int32{}
+	for range 3 {
+		placed = append(placed, h.order(t, account.Id, product).Id)
+	}
+
+	failing := &recorder{failAfter: 1}
+	n, err := h.Repo.DispatchEvents(context.Background(), 10, failing.publish)
+	if err == nil || n != 1 {
+		t.Fatalf("DispatchEvents with a failing bus = %d, %v; want 1 and an error", n, err)
+	}
+
+	working := &recorder{failAfter: -1}
+	if n, err := h.Repo.DispatchEvents(context.Background(), 10, working.publish); err != nil || n != 2 {
+		t.Fatalf("DispatchEvents = %d, %v; want the 2 unpublished events", n, err)
+	}
+
+	got := append(failing.published, working.published...)
+	for i, e := range got {
+		if e.Type != EventOrderPlaced || e.AggregateID != strconv.Itoa(int(placed[i])) {
+			t.Errorf("event %d = %s for %s, want %s for order %d", i, e.Type, e.AggregateID, EventOrderPlaced, placed[i])
+		}
+	}
+
+	if n, err := h.Repo.DispatchEvents(context.Background(), 10, working.publish); err != nil || n != 0 {
+		t.Errorf("DispatchEvents of an empty outbox = %d, %v; want 0", n, err)
+	}
+}
+
+func TestDispatchEventsLimit(t *testing.T) {
+	h := newHarness(t)
+	account := h.account(t, "Ann")
+	product := h.product(t, "Keyboard", 100)
+
+	for range 3 {
+		h.order(t, account.Id, product)
+	}
+
+	all := &recorder{failAfter: -1}
+	if n, err := h.Repo.DispatchEvents(context.Background(), 2, all.publish); err != nil || n != 2 {
+		t.Fatalf("DispatchEvents(limit 2) = %d, %v; want 2", n, err)
+	}
+	if n, err := h.Repo.DispatchEvents(context.Background(), 2, all.publish); err != nil || n != 1 {
+		t.Fatalf("second DispatchEvents(limit 2) = %d, %v; want the last event", n, err)
+	}
+
+	seen := map[string]bool{}
+	for _, e := range all.published {
+		if seen[e.ID] {
+			t.Errorf("event %s was published twice", e.ID)
+		}
+		seen[e.ID] = true
+	}
+}
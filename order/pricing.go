@@ -0,0 +1,240 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"time"
+
+	"github.com/airlangga-hub/microservices/money"
+)
+
+type PromotionKind string
+
+const (
+	PromotionPercentage PromotionKind = "percentage"
+	PromotionFixed      PromotionKind = "fixed"
+	PromotionBuyXGetY   PromotionKind = "buy_x_get_y"
+)
+
+var (
+	errUnknownCoupon       = errors.New("unknown or expired coupon code")
+	errCouponNotApplicable = errors.New("coupon does not apply to this order")
+	errPromotionExhausted  = errors.New("promotion has reached its usage limit")
+	errMixedCurrency       = errors.New("products are priced in different currencies")
+)
+
+// Promotion is a discount rule. With a ProductID it discounts that product's
+// line, otherwise the whole order. Promotions without a Code apply
+// automatically; the rest only when the customer enters the code. AmountOff
+// and MinSpend are in the same currency.
+type Promotion struct {
+	ID          int32         `json:"id"`
+	Code        string        `json:"code"`
+	Name        string        `json:"name"`
+	Kind        PromotionKind `json:"kind"`
+	PercentOff  int32         `json:"percent_off"`
+	AmountOff   money.Money   `json:"amount_off"`
+	ProductID   string        `json:"product_id"`
+	BuyQuantity int32         `json:"buy_quantity"`
+	GetQuantity int32         `json:"get_quantity"`
+	MinSpend    money.Money   `json:"min_spend"`
+	StartsAt    time.Time     `json:"starts_at"`
+	EndsAt      time.Time     `json:"ends_at"`
+	UsageLimit  int32         `json:"usage_limit"`
+	UsageCount  int32         `json:"usage_count"`
+	Active      bool          `json:"active"`
+}
+
+// Live reports whether p can be used at now. A zero EndsAt never expires and
+// a zero UsageLimit is unlimited.
+func (p Promotion) Live(now time.Time) bool {
+	return p.Active &&
+		!now.Before(p.StartsAt) &&
+		(p.EndsAt.IsZero() || now.Before(p.EndsAt)) &&
+		(p.UsageLimit == 0 || p.UsageCount < p.UsageLimit)
+}
+
+// appliesTo reports whether p can discount an order in currency. Only
+// promotions with an amount off or a minimum spend are tied to a currency.
+func (p Promotion) appliesTo(currency string) bool {
+	if p.AmountOff.IsZero() && p.MinSpend.IsZero() {
+		return true
+	}
+
+	return p.AmountOff.Currency == currency
+}
+
+func (p Promotion) discount(base money.Money, line OrderedProduct) (money.Money, error) {
+	zero := money.New(base.Currency, 0)
+
+	switch p.Kind {
+	case PromotionPercentage:
+		return base.MulRatio(int64(p.PercentOff), 100)
+	case PromotionFixed:
+		return p.AmountOff.Min(base)
+	case PromotionBuyXGetY:
+		if p.BuyQuantity <= 0 || p.GetQuantity <= 0 {
+			return zero, nil
+		}
+		free := line.Quantity / (p.BuyQuantity + p.GetQuantity) * p.GetQuantity
+		return line.Price.Mul(int64(free))
+	default:
+		return zero, nil
+	}
+}
+
+// Discount is one promotion applied to an order: to a single line when
+// ProductID is set, otherwise to the whole order.
+type Discount struct {
+	PromotionID int32       `json:"promotion_id"`
+	Code        string      `json:"code"`
+	Name        string      `json:"name"`
+	ProductID   string      `json:"product_id"`
+	Amount      money.Money `json:"amount"`
+}
+
+// ExchangeRate is the rate the catalog converted a product's base price at:
+// one unit of From buys Rate units of To.
+type ExchangeRate struct {
+	From string `json:"from_currency"`
+	To   string `json:"to_currency"`
+	Rate string `json:"rate"`
+}
+
+// Pricing is what an order costs: Total is Subtotal less the Discounts plus
+// TaxTotal, all in the products' currency. ExchangeRates are the rates the
+// products' prices were converted at, once per pair.
+type Pricing struct {
+	Products      []OrderedProduct
+	Subtotal      money.Money
+	Discounts     []Discount
+	TaxRegion     string
+	Taxes         []TaxLine
+	TaxTotal      money.Money
+	Total         money.Money
+	ExchangeRates []ExchangeRate
+}
+
+// priceOrder applies promotions to products. Each line gets its best line
+// promotion, then the best order promotion is taken off what remains.
+// Minimum spend is checked against the subtotal before any discount. Every
+// coupon code must end up in the breakdown, or pricing fails. All products
+// must be priced in the same currency.
+func priceOrder(products []OrderedProduct, promotions []Promotion, couponCodes []string, now time.Time) (Pricing, error) {
+	currency := money.DefaultCurrency
+	if len(products) > 0 {
+		currency = products[0].Price.Currency
+	}
+
+	q := Pricing{
+		Products:      products,
+		Subtotal:      money.New(currency, 0),
+		Discounts:     []Discount{},
+		Taxes:         []TaxLine{},
+		TaxTotal:      money.New(currency, 0),
+		ExchangeRates: []ExchangeRate{},
+	}
+
+	for _, p := range products {
+		if p.Price.Currency != currency {
+			return Pricing{}, fmt.Errorf("%w: %s and %s", errMixedCurrency, currency, p.Price.Currency)
+		}
+
+		if p.ExchangeRate != nil && !slices.Contains(q.ExchangeRates, *p.ExchangeRate) {
+			q.ExchangeRates = append(q.ExchangeRates, *p.ExchangeRate)
+		}
+
+		line, err := p.Price.Mul(int64(p.Quantity))
+		if err != nil {
+			return Pricing{}, err
+		}
+
+		if q.Subtotal, err = q.Subtotal.Add(line); err != nil {
+			return Pricing{}, err
+		}
+	}
+
+	eligible := []Promotion{}
+
+	for _, p := range promotions {
+		if p.Code != "" && !slices.Contains(couponCodes, p.Code) {
+			continue
+		}
+		if p.Live(now) && p.appliesTo(currency) && q.Subtotal.Units >= p.MinSpend.Units {
+			eligible = append(eligible, p)
+		}
+	}
+
+	for _, code := range couponCodes {
+		if !slices.ContainsFunc(promotions, func(p Promotion) bool { return p.Code == code && p.Live(now) }) {
+			return Pricing{}, fmt.Errorf("%w: %s", errUnknownCoupon, code)
+		}
+	}
+
+	q.Total = q.Subtotal
+
+	for _, line := range products {
+		best := Discount{}
+
+		base, err := line.Price.Mul(int64(line.Quantity))
+		if err != nil {
+			return Pricing{}, err
+		}
+
+		for _, p := range eligible {
+			if p.ProductID != line.ID {
+				continue
+			}
+
+			amount, err := p.discount(base, line)
+			if err != nil {
+				return Pricing{}, err
+			}
+
+			if amount.Units > best.Amount.Units {
+				best = Discount{PromotionID: p.ID, Code: p.Code, Name: p.Name, ProductID: line.ID, Amount: amount}
+			}
+		}
+
+		if best.Amount.Units > 0 {
+			q.Discounts = append(q.Discounts, best)
+			if q.Total, err = q.Total.Sub(best.Amount); err != nil {
+				return Pricing{}, err
+			}
+		}
+	}
+
+	best := Discount{}
+
+	for _, p := range eligible {
+		if p.ProductID != "" {
+			continue
+		}
+
+		amount, err := p.discount(q.Total, OrderedProduct{})
+		if err != nil {
+			return Pricing{}, err
+		}
+
+		if amount.Units > best.Amount.Units {
+			best = Discount{PromotionID: p.ID, Code: p.Code, Name: p.Name, Amount: amount}
+		}
+	}
+
+	if best.Amount.Units > 0 {
+		var err error
+		if q.Total, err = q.Total.Sub(best.Amount); err != nil {
+			return Pricing{}, err
+		}
+		q.Discounts = append(q.Discounts, best)
+	}
+
+	for _, code := range couponCodes {
+		if !slices.ContainsFunc(q.Discounts, func(d Discount) bool { return d.Code == code }) {
+			return Pricing{}, fmt.Errorf("%w: %s", errCouponNotApplicable, code)
+		}
+	}
+
+	return q, nil
+}
@@ -0,0 +1,137 @@
+package main
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/airlangga-hub/microservices/money"
+)
+
+func usd(units int64) money.Money {
+	return money.New("USD", units)
+}
+
+func TestPriceOrder(t *testing.T) {
+	now := time.Date(2026, 1, 10, 0, 0, 0, 0, time.UTC)
+	live := func(p Promotion) Promotion {
+		p.Active = true
+		p.StartsAt = now.Add(-time.Hour)
+		return p
+	}
+
+	keyboard := OrderedProduct{ID: "keyboard", Price: usd(100), Quantity: 3}
+	mouse := OrderedProduct{ID: "mouse", Price: usd(50), Quantity: 1}
+
+	tests := []struct {
+		name       string
+		promotions []Promotion
+		coupons    []string
+		wantTotal  int64
+		wantErr    error
+	}{
+		{
+			name:      "no promotions",
+			wantTotal: 350,
+		},
+		{
+			name:       "percentage off a line",
+			promotions: []Promotion{live(Promotion{ID: 1, Kind: PromotionPercentage, PercentOff: 10, ProductID: "keyboard"})},
+			wantTotal:  320,
+		},
+		{
+			name:       "buy two get one free",
+			promotions: []Promotion{live(Promotion{ID: 1, Kind: PromotionBuyXGetY, ProductID: "keyboard", BuyQuantity: 2, GetQuantity: 1})},
+			wantTotal:  250,
+		},
+		{
+			name: "best line promotion wins",
+			promotions: []Promotion{
+				live(Promotion{ID: 1, Kind: PromotionFixed, AmountOff: usd(20), ProductID: "keyboard"}),
+				live(Promotion{ID: 2, Kind: PromotionPercentage, PercentOff: 50, ProductID: "keyboard"}),
+			},
+			wantTotal: 200,
+		},
+		{
+			name: "order promotion applies after line discounts",
+			promotions: []Promotion{
+				live(Promotion{ID: 1, Kind: PromotionFixed, AmountOff: usd(50), ProductID: "mouse"}),
+				live(Promotion{ID: 2, Kind: PromotionPercentage, PercentOff: 10}),
+			},
+			wantTotal: 270,
+		},
+		{
+			name:       "minimum spend not met",
+			promotions: []Promotion{live(Promotion{ID: 1, Kind: PromotionFixed, AmountOff: usd(50), MinSpend: usd(500)})},
+			wantTotal:  350,
+		},
+		{
+			name:       "coupon",
+			promotions: []Promotion{live(Promotion{ID: 1, Code: "SAVE30", Kind: PromotionFixed, AmountOff: usd(30)})},
+			coupons:    []string{"SAVE30"},
+			wantTotal:  320,
+		},
+		{
+			name:       "coupon not entered",
+			promotions: []Promotion{live(Promotion{ID: 1, Code: "SAVE30", Kind: PromotionFixed, AmountOff: usd(30)})},
+			wantTotal:  350,
+		},
+		{
+			name:       "expired coupon",
+			promotions: []Promotion{{ID: 1, Code: "OLD", Kind: PromotionFixed, AmountOff: usd(30), Active: true, StartsAt: now.Add(-2 * time.Hour), EndsAt: now.Add(-time.Hour)}},
+			coupons:    []string{"OLD"},
+			wantErr:    errUnknownCoupon,
+		},
+		{
+			name:       "coupon under its minimum spend",
+			promotions: []Promotion{live(Promotion{ID: 1, Code: "BIG", Kind: PromotionFixed, AmountOff: usd(30), MinSpend: usd(1000)})},
+			coupons:    []string{"BIG"},
+			wantErr:    errCouponNotApplicable,
+		},
+		{
+			name:       "used up promotion",
+			promotions: []Promotion{live(Promotion{ID: 1, Kind: PromotionFixed, AmountOff: usd(30), UsageLimit: 5, UsageCount: 5})},
+			wantTotal:  350,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			q, err := priceOrder([]OrderedProduct{keyboard, mouse}, tt.promotions, tt.coupons, now)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("err = %v, want %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+
+			if q.Subtotal != usd(350) {
+				t.Errorf("Subtotal = %v, want 350", q.Subtotal)
+			}
+			if q.Total != usd(tt.wantTotal) {
+				t.Errorf("Total = %v, want %d (discounts %v)", q.Total, tt.wantTotal, q.Discounts)
+			}
+		})
+	}
+}
+
+func TestPriceOrderCurrency(t *testing.T) {
+	now := time.Date(2026, 1, 10, 0, 0, 0, 0, time.UTC)
+	keyboard := OrderedProduct{ID: "keyboard", Price: usd(100), Quantity: 1}
+	mouse := OrderedProduct{ID: "mouse", Price: money.New("EUR", 50), Quantity: 1}
+
+	if _, err := priceOrder([]OrderedProduct{keyboard, mouse}, nil, nil, now); !errors.Is(err, errMixedCurrency) {
+		t.Errorf("mixed currency order err = %v, want errMixedCurrency", err)
+	}
+
+	// a fixed amount off in euros can't discount a dollar order
+	euros := Promotion{ID: 1, Code: "EURO10", Kind: PromotionFixed, AmountOff: money.New("EUR", 10), Active: true, StartsAt: now.Add(-time.Hour)}
+	if _, err := priceOrder([]OrderedProduct{keyboard}, []Promotion{euros}, []string{"EURO10"}, now); !errors.Is(err, errCouponNotApplicable) {
+		t.Errorf("EUR coupon on a USD order err = %v, want errCouponNotApplicable", err)
+	}
+
+	huge := OrderedProduct{ID: "yacht", Price: usd(1 << 62), Quantity: 4}
+	if _, err := priceOrder([]OrderedProduct{huge}, nil, nil, now); !errors.Is(err, money.ErrOverflow) {
+		t.Errorf("overflowing order err = %v, want money.ErrOverflow", err)
+	}
+}
@@ -0,0 +1,258 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log"
+	"time"
+
+	"github.com/airlangga-hub/microservices/auth"
+	"github.com/airlangga-hub/microservices/money"
+	catpb "github.com/airlangga-hub/microservices/order/catalog_pb"
+	"github.com/airlangga-hub/microservices/order/pb"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+var (
+	errPromotionNotFound  = errors.New("promotion not found")
+	errPromotionCodeTaken = errors.New("another promotion already uses this code")
+)
+
+var promotionKinds = map[pb.PromotionKind]PromotionKind{
+	pb.PromotionKind_PROMOTION_KIND_PERCENTAGE:  PromotionPercentage,
+	pb.PromotionKind_PROMOTION_KIND_FIXED:       PromotionFixed,
+	pb.PromotionKind_PROMOTION_KIND_BUY_X_GET_Y: PromotionBuyXGetY,
+}
+
+// PromotionServer implements the admin PromotionService; every call needs
+// permissionManagePromotions. Promotions are never deleted, only
+// deactivated, so orders keep pointing at them.
+type PromotionServer struct {
+	pb.UnimplementedPromotionServiceServer
+	Repo PromotionRepository
+}
+
+func (s *PromotionServer) CreatePromotion(ctx context.Context, r *pb.CreatePromotionRequest) (*pb.CreatePromotionResponse, error) {
+	if err := auth.Require(ctx, permissionManagePromotions); err != nil {
+		return nil, err
+	}
+
+	p, err := promotionFromPB(r.Promotion)
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+
+	if p.StartsAt.IsZero() {
+		p.StartsAt = time.Now().UTC()
+	}
+
+	if err := validatePromotion(p); err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+
+	created, err := s.Repo.CreatePromotion(ctx, p)
+	if errors.Is(err, errPromotionCodeTaken) {
+		return nil, status.Error(codes.AlreadyExists, err.Error())
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	pbPromotion, err := promotionToPB(created)
+	if err != nil {
+		return nil, err
+	}
+
+	return &pb.CreatePromotionResponse{Promotion: pbPromotion}, nil
+}
+
+func (s *PromotionServer) GetPromotion(ctx context.Context, r *pb.GetPromotionRequest) (*pb.GetPromotionResponse, error) {
+	if err := auth.Require(ctx, permissionManagePromotions); err != nil {
+		return nil, err
+	}
+
+	p, err := s.Repo.GetPromotionByID(ctx, r.Id)
+	if errors.Is(err, errPromotionNotFound) {
+		return nil, status.Error(codes.NotFound, err.Error())
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	pbPromotion, err := promotionToPB(p)
+	if err != nil {
+		return nil, err
+	}
+
+	return &pb.GetPromotionResponse{Promotion: pbPromotion}, nil
+}
+
+func (s *PromotionServer) ListPromotions(ctx context.Context, r *pb.ListPromotionsRequest) (*pb.ListPromotionsResponse, error) {
+	if err := auth.Require(ctx, permissionManagePromotions); err != nil {
+		return nil, err
+	}
+
+	limit := r.Limit
+	if limit > 100 || (r.Offset == 0 && limit == 0) {
+		limit = 100
+	}
+
+	promotions, err := s.Repo.ListPromotions(ctx, r.Offset, limit)
+	if err != nil {
+		return nil, err
+	}
+
+	pbPromotions := []*pb.Promotion{}
+
+	for _, p := range promotions {
+		pbPromotion, err := promotionToPB(p)
+		if err != nil {
+			return nil, err
+		}
+		pbPromotions = append(pbPromotions, pbPromotion)
+	}
+
+	return &pb.ListPromotionsResponse{Promotions: pbPromotions}, nil
+}
+
+func (s *PromotionServer) DeactivatePromotion(ctx context.Context, r *pb.DeactivatePromotionRequest) (*pb.DeactivatePromotionResponse, error) {
+	if err := auth.Require(ctx, permissionManagePromotions); err != nil {
+		return nil, err
+	}
+
+	p, err := s.Repo.DeactivatePromotion(ctx, r.Id)
+	if errors.Is(err, errPromotionNotFound) {
+		return nil, status.Error(codes.NotFound, err.Error())
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	pbPromotion, err := promotionToPB(p)
+	if err != nil {
+		return nil, err
+	}
+
+	return &pb.DeactivatePromotionResponse{Promotion: pbPromotion}, nil
+}
+
+func validatePromotion(p Promotion) error {
+	switch {
+	case p.Name == "":
+		return errors.New("name is required")
+	case p.Kind == PromotionPercentage && (p.PercentOff < 1 || p.PercentOff > 100):
+		return errors.New("percent_off must be between 1 and 100")
+	case money.ValidateCurrency(p.AmountOff.Currency) != nil:
+		return errors.New("currency must be a three letter ISO 4217 code")
+	case p.Kind == PromotionFixed && p.AmountOff.Units <= 0:
+		return errors.New("amount_off must be positive")
+	case p.Kind == PromotionBuyXGetY && p.ProductID == "":
+		return errors.New("buy X get Y promotions need a product_id")
+	case p.Kind == PromotionBuyXGetY && (p.BuyQuantity <= 0 || p.GetQuantity <= 0):
+		return errors.New("buy_quantity and get_quantity must be positive")
+	case p.MinSpend.Units < 0:
+		return errors.New("min_spend can't be negative")
+	case p.UsageLimit < 0:
+		return errors.New("usage_limit can't be negative")
+	case !p.EndsAt.IsZero() && !p.EndsAt.After(p.StartsAt):
+		return errors.New("ends_at must be after starts_at")
+	}
+
+	return nil
+}
+
+func promotionFromPB(p *pb.Promotion) (Promotion, error) {
+	if p == nil {
+		return Promotion{}, errors.New("promotion is required")
+	}
+
+	kind, exist := promotionKinds[p.Kind]
+	if !exist {
+		return Promotion{}, errors.New("kind is required")
+	}
+
+	// percentage and buy X get Y promotions without a minimum spend work in
+	// any currency, so they may leave it out
+	currency := money.DefaultCurrency
+	for _, m := range []*catpb.Money{p.AmountOff, p.MinSpend} {
+		if m.GetCurrency() != "" {
+			currency = m.GetCurrency()
+			break
+		}
+	}
+
+	promotion := Promotion{
+		Code:        p.Code,
+		Name:        p.Name,
+		Kind:        kind,
+		PercentOff:  p.PercentOff,
+		AmountOff:   money.New(currency, p.AmountOff.GetUnits()),
+		ProductID:   p.ProductId,
+		BuyQuantity: p.BuyQuantity,
+		GetQuantity: p.GetQuantity,
+		MinSpend:    money.New(currency, p.MinSpend.GetUnits()),
+		UsageLimit:  p.UsageLimit,
+	}
+
+	if c := p.MinSpend.GetCurrency(); c != "" && c != currency {
+		return Promotion{}, errors.New("amount_off and min_spend must be in the same currency")
+	}
+
+	if len(p.StartsAt) > 0 {
+		if err := promotion.StartsAt.UnmarshalBinary(p.StartsAt); err != nil {
+			return Promotion{}, errors.New("invalid starts_at")
+		}
+	}
+
+	if len(p.EndsAt) > 0 {
+		if err := promotion.EndsAt.UnmarshalBinary(p.EndsAt); err != nil {
+			return Promotion{}, errors.New("invalid ends_at")
+		}
+	}
+
+	return promotion, nil
+}
+
+func promotionToPB(p Promotion) (*pb.Promotion, error) {
+	startsAt, err := p.StartsAt.MarshalBinary()
+	if err != nil {
+		log.Println("ERROR: order server promotionToPB (MarshalBinary): ", err)
+		return nil, errors.New("error encoding promotion")
+	}
+
+	// a promotion without an end is sent without ends_at
+	var endsAt []byte
+	if !p.EndsAt.IsZero() {
+		endsAt, err = p.EndsAt.MarshalBinary()
+		if err != nil {
+			log.Println("ERROR: order server promotionToPB (MarshalBinary): ", err)
+			return nil, errors.New("error encoding promotion")
+		}
+	}
+
+	var kind pb.PromotionKind
+	for pbKind, k := range promotionKinds {
+		if k == p.Kind {
+			kind = pbKind
+		}
+	}
+
+	return &pb.Promotion{
+		Id:          p.ID,
+		Code:        p.Code,
+		Name:        p.Name,
+		Kind:        kind,
+		PercentOff:  p.PercentOff,
+		AmountOff:   pbMoney(p.AmountOff),
+		ProductId:   p.ProductID,
+		BuyQuantity: p.BuyQuantity,
+		GetQuantity: p.GetQuantity,
+		MinSpend:    pbMoney(p.MinSpend),
+		StartsAt:    startsAt,
+		EndsAt:      endsAt,
+		UsageLimit:  p.UsageLimit,
+		UsageCount:  p.UsageCount,
+		Active:      p.Active,
+	}, nil
+}
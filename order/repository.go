@@ -5,44 +5,157 @@ import (
 	"database/sql"
 	"errors"
 	"log"
+	"strconv"
 	"time"
 
+	"github.com/airlangga-hub/microservices/audit"
+	"github.com/airlangga-hub/microservices/events"
+	"github.com/airlangga-hub/microservices/money"
+	"github.com/airlangga-hub/microservices/order/config"
 	"github.com/lib/pq"
 )
 
+// OrderedProduct's Price is the unit price the line was ordered at, and its
+// ExchangeRate is set when the catalog converted that price into the order's
+// currency.
 type OrderedProduct struct {
-	ID          string `json:"id"`
-	Name        string `json:"name"`
-	Description string `json:"description"`
-	Price       int64  `json:"price"`
-	Quantity    int32  `json:"quantity"`
+	ID           string        `json:"id"`
+	Name         string        `json:"name"`
+	Description  string        `json:"description"`
+	Price        money.Money   `json:"price"`
+	Quantity     int32         `json:"quantity"`
+	TaxCategory  string        `json:"tax_category"`
+	ExchangeRate *ExchangeRate `json:"exchange_rate,omitempty"`
 }
 
+// OrderStatus is where an order is in fulfilment. Orders are placed, then
+// shipped, then delivered.
+type OrderStatus string
+
+const (
+	OrderPlaced    OrderStatus = "placed"
+	OrderShipped   OrderStatus = "shipped"
+	OrderDelivered OrderStatus = "delivered"
+)
+
+// Order's SubtotalPrice is before discounts and tax; TotalPrice is what the
+// account pays. Every amount is in the same currency. ShippingAddress is nil
+// for an order placed without one.
 type Order struct {
-	ID         int32            `json:"id"`
-	AccountID  int32            `json:"account_id"`
-	Products   []OrderedProduct `json:"products"`
-	TotalPrice int64            `json:"total_price"`
-	CreatedAt  time.Time        `json:"created_at"`
+	ID              int32            `json:"id"`
+	AccountID       int32            `json:"account_id"`
+	Products        []OrderedProduct `json:"products"`
+	SubtotalPrice   money.Money      `json:"subtotal_price"`
+	Discounts       []Discount       `json:"discounts"`
+	TaxRegion       string           `json:"tax_region"`
+	Taxes           []TaxLine        `json:"taxes"`
+	TaxTotal        money.Money      `json:"tax_total"`
+	TotalPrice      money.Money      `json:"total_price"`
+	CreatedAt       time.Time        `json:"created_at"`
+	PaymentID       int32            `json:"payment_id"`
+	ExchangeRates   []ExchangeRate   `json:"exchange_rates"`
+	ShippingAddress *Address         `json:"shipping_address,omitempty"`
+	Status          OrderStatus      `json:"status"`
+	RequestID       string           `json:"request_id,omitempty"`
+}
+
+// Address is a copy of the account address an order ships to, taken when
+// the order is placed so later edits to the address book don't change it.
+// AddressID is the address it was copied from.
+type Address struct {
+	AddressID  int32  `json:"address_id"`
+	Name       string `json:"name"`
+	Line1      string `json:"line1"`
+	Line2      string `json:"line2"`
+	City       string `json:"city"`
+	Region     string `json:"region"`
+	PostalCode string `json:"postal_code"`
+	Country    string `json:"country"`
+	Phone      string `json:"phone"`
+}
+
+// CartRepository stores each account's cart as product IDs, quantities and
+// the price seen when the product was added.
+type CartRepository interface {
+	GetCartItems(ctx context.Context, accountID int32) ([]CartItem, error)
+	// AddCartItem adds item.Quantity to the product's line, creating it if
+	// needed, and records item.Price as the price seen.
+	AddCartItem(ctx context.Context, accountID int32, item CartItem) error
+	// SetCartItemQuantity returns errCartItemNotFound if the product isn't
+	// in the cart.
+	SetCartItemQuantity(ctx context.Context, accountID int32, productID string, quantity int32) error
+	// RemoveCartItems returns how many of productIDs were in the cart.
+	RemoveCartItems(ctx context.Context, accountID int32, productIDs []string) (int, error)
+}
+
+// PromotionRepository stores promotions and their usage. Usage is counted
+// by CreateOrder, in the same transaction as the order.
+type PromotionRepository interface {
+	CreatePromotion(ctx context.Context, p Promotion) (Promotion, error)
+	GetPromotionByID(ctx context.Context, id int32) (Promotion, error)
+	ListPromotions(ctx context.Context, offset, limit int32) ([]Promotion, error)
+	DeactivatePromotion(ctx context.Context, id int32) (Promotion, error)
+	// LivePromotions returns the automatic promotions live at now, and the
+	// live ones among couponCodes.
+	LivePromotions(ctx context.Context, couponCodes []string, now time.Time) ([]Promotion, error)
+}
+
+// CaptureRepository tracks the payments of placed orders until they are
+// captured. CreateOrder adds an order's payment, in the same transaction as
+// the order.
+type CaptureRepository interface {
+	// CaptureDone forgets a payment that was captured.
+	CaptureDone(ctx context.Context, paymentID int32) error
+	// DispatchCaptures hands up to limit payments due for an attempt to
+	// capture, oldest due first. It forgets those captured and puts the
+	// others' next attempt off by captureBackoff. It returns how many it
+	// attempted.
+	DispatchCaptures(ctx context.Context, limit int, capture func(ctx context.Context, paymentID int32) error) (int, error)
+}
+
+// AuditRepository is the append-only audit log.
+type AuditRepository interface {
+	RecordAudit(ctx context.Context, e audit.Entry) error
+	// QueryAuditLog returns matching entries newest first.
+	QueryAuditLog(ctx context.Context, q audit.Query) ([]audit.Entry, error)
 }
 
 type Repository interface {
+	events.Outbox
+	CartRepository
+	PromotionRepository
+	CaptureRepository
+	AuditRepository
 	Close() error
+	Ping(ctx context.Context) error
 	CreateOrder(ctx context.Context, o Order) (Order, error)
 	GetOrdersByAccountID(ctx context.Context, accountID int32) ([]*Order, error)
+	// GetOrder returns errOrderNotFound if there is no order id.
+	GetOrder(ctx context.Context, id int32) (Order, error)
+	// GetOrderByRequestID returns errOrderNotFound if the account placed no
+	// order with requestID.
+	GetOrderByRequestID(ctx context.Context, accountID int32, requestID string) (Order, error)
+	// UpdateOrderStatus moves o to status to, and records an
+	// OrderStatusChanged event, if it is still in o.Status. Otherwise it
+	// returns errInvalidStatusChange.
+	UpdateOrderStatus(ctx context.Context, o Order, to OrderStatus) (Order, error)
 }
 
 type repository struct {
 	db *sql.DB
 }
 
-func NewRepository(dbUrl string) (Repository, error) {
+func NewRepository(dbUrl string, pool config.DB) (Repository, error) {
 	db, err := sql.Open("postgres", dbUrl)
 	if err != nil {
 		log.Println("ERROR: order repo NewRepository (sql.Open): ", err)
 		return nil, errors.New("error opening postgres")
 	}
 
+	db.SetMaxOpenConns(pool.MaxOpenConns)
+	db.SetMaxIdleConns(pool.MaxIdleConns)
+	db.SetConnMaxLifetime(pool.ConnMaxLifetime)
+
 	if err := db.Ping(); err != nil {
 		log.Println("ERROR: order repo NewRepository (db.Ping): ", err)
 		return nil, errors.New("error pinging db")
@@ -59,6 +172,14 @@ func (r *repository) Close() error {
 	return nil
 }
 
+func (r *repository) Ping(ctx context.Context) error {
+	if err := r.db.PingContext(ctx); err != nil {
+		log.Println("ERROR: order repo Ping: ", err)
+		return errors.New("error pinging db")
+	}
+	return nil
+}
+
 func (r *repository) CreateOrder(ctx context.Context, o Order) (Order, error) {
 	tx, err := r.db.BeginTx(ctx, nil)
 	if err != nil {
@@ -68,24 +189,129 @@ func (r *repository) CreateOrder(ctx context.Context, o Order) (Order, error) {
 	defer tx.Rollback()
 
 	// insert order
-	if err = tx.QueryRowContext(
+	err = tx.QueryRowContext(
 		ctx,
-		`INSERT INTO orders (account_id, total_price)
-		VALUES ($1, $2)
+		`INSERT INTO orders (account_id, currency, subtotal_price, tax_region, tax_total, total_price, payment_id, status, request_id)
+		VALUES ($1, $2, $3, $4, $5, $6, NULLIF($7, 0), $8, NULLIF($9, ''))
 		RETURNING
 			id,
 			created_at;`,
-		o.AccountID, o.TotalPrice,
+		o.AccountID, o.TotalPrice.Currency, o.SubtotalPrice.Units, o.TaxRegion, o.TaxTotal.Units, o.TotalPrice.Units, o.PaymentID, o.Status, o.RequestID,
 	).Scan(
 		&o.ID,
 		&o.CreatedAt,
-	); err != nil {
+	)
+
+	var pqErr *pq.Error
+	if errors.As(err, &pqErr) && pqErr.Code == "23505" {
+		return Order{}, errOrderRequestTaken
+	}
+	if err != nil {
 		log.Println("ERROR: order repo CreateOrder (insert order): ", err)
 		return Order{}, errors.New("error creating order")
 	}
 
-	// insert order products
-	stmt, err := tx.PrepareContext(ctx, pq.CopyIn("order_products", "order_id", "product_id", "quantity"))
+	// the payment is captured after the order commits; recording it here
+	// lets the capture relay finish the job if that fails
+	if o.PaymentID != 0 {
+		if _, err = tx.ExecContext(
+			ctx,
+			`INSERT INTO pending_captures (payment_id, order_id)
+			VALUES ($1, $2);`,
+			o.PaymentID, o.ID,
+		); err != nil {
+			log.Println("ERROR: order repo CreateOrder (insert pending capture): ", err)
+			return Order{}, errors.New("error creating order")
+		}
+	}
+
+	// record the discounts and count each promotion's use; a promotion that
+	// ran out since the order was priced fails the whole order
+	counted := map[int32]bool{}
+
+	for _, d := range o.Discounts {
+		if _, err = tx.ExecContext(
+			ctx,
+			`INSERT INTO order_discounts (order_id, promotion_id, code, name, product_id, amount)
+			VALUES ($1, $2, $3, $4, $5, $6);`,
+			o.ID, d.PromotionID, d.Code, d.Name, d.ProductID, d.Amount.Units,
+		); err != nil {
+			log.Println("ERROR: order repo CreateOrder (insert discount): ", err)
+			return Order{}, errors.New("error creating order")
+		}
+
+		if counted[d.PromotionID] {
+			continue
+		}
+		counted[d.PromotionID] = true
+
+		res, err := tx.ExecContext(
+			ctx,
+			`UPDATE promotions
+			SET usage_count = usage_count + 1
+			WHERE id = $1 AND (usage_limit = 0 OR usage_count < usage_limit);`,
+			d.PromotionID,
+		)
+		if err != nil {
+			log.Println("ERROR: order repo CreateOrder (count promotion use): ", err)
+			return Order{}, errors.New("error creating order")
+		}
+
+		if n, err := res.RowsAffected(); err != nil || n == 0 {
+			return Order{}, errPromotionExhausted
+		}
+	}
+
+	for _, t := range o.Taxes {
+		if _, err = tx.ExecContext(
+			ctx,
+			`INSERT INTO order_taxes (order_id, product_id, category, name, rate, taxable, amount)
+			VALUES ($1, $2, $3, $4, $5, $6, $7);`,
+			o.ID, t.ProductID, t.Category, t.Name, t.Rate, t.Taxable.Units, t.Amount.Units,
+		); err != nil {
+			log.Println("ERROR: order repo CreateOrder (insert tax): ", err)
+			return Order{}, errors.New("error creating order")
+		}
+	}
+
+	for _, rate := range o.ExchangeRates {
+		if _, err = tx.ExecContext(
+			ctx,
+			`INSERT INTO order_exchange_rates (order_id, from_currency, to_currency, rate)
+			VALUES ($1, $2, $3, $4);`,
+			o.ID, rate.From, rate.To, rate.Rate,
+		); err != nil {
+			log.Println("ERROR: order repo CreateOrder (insert exchange rate): ", err)
+			return Order{}, errors.New("error creating order")
+		}
+	}
+
+	if a := o.ShippingAddress; a != nil {
+		if _, err = tx.ExecContext(
+			ctx,
+			`INSERT INTO order_addresses (order_id, address_id, name, line1, line2, city, region, postal_code, country, phone)
+			VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10);`,
+			o.ID, a.AddressID, a.Name, a.Line1, a.Line2, a.City, a.Region, a.PostalCode, a.Country, a.Phone,
+		); err != nil {
+			log.Println("ERROR: order repo CreateOrder (insert address): ", err)
+			return Order{}, errors.New("error creating order")
+		}
+	}
+
+	// record the OrderPlaced event in the same transaction
+	event, err := events.New(EventOrderPlaced, strconv.Itoa(int(o.ID)), o)
+	if err != nil {
+		log.Println("ERROR: order repo CreateOrder (events.New): ", err)
+		return Order{}, errors.New("error creating order")
+	}
+
+	if err = insertEvent(ctx, tx, event); err != nil {
+		log.Println("ERROR: order repo CreateOrder (insert event): ", err)
+		return Order{}, errors.New("error creating order")
+	}
+
+	// insert order products with the unit price they were ordered at
+	stmt, err := tx.PrepareContext(ctx, pq.CopyIn("order_products", "order_id", "product_id", "quantity", "unit_price_minor", "currency"))
 	if err != nil {
 		log.Println("ERROR: order repo CreateOrder (stmt prepare): ", err)
 		return Order{}, errors.New("error creating order")
@@ -93,7 +319,7 @@ func (r *repository) CreateOrder(ctx context.Context, o Order) (Order, error) {
 	defer stmt.Close()
 
 	for _, p := range o.Products {
-		_, err := stmt.ExecContext(ctx, o.ID, p.ID, p.Quantity)
+		_, err := stmt.ExecContext(ctx, o.ID, p.ID, p.Quantity, p.Price.Units, p.Price.Currency)
 		if err != nil {
 			log.Println("ERROR: order repo CreateOrder (insert order products): ", err)
 			return Order{}, errors.New("error creating order")
@@ -116,25 +342,106 @@ func (r *repository) CreateOrder(ctx context.Context, o Order) (Order, error) {
 }
 
 func (r *repository) GetOrdersByAccountID(ctx context.Context, accountID int32) ([]*Order, error) {
+	return r.findOrders(ctx, "o.account_id = $1", accountID)
+}
+
+func (r *repository) GetOrder(ctx context.Context, id int32) (Order, error) {
+	return r.findOrder(ctx, "o.id = $1", id)
+}
+
+func (r *repository) GetOrderByRequestID(ctx context.Context, accountID int32, requestID string) (Order, error) {
+	return r.findOrder(ctx, "o.account_id = $1 AND o.request_id = $2", accountID, requestID)
+}
+
+func (r *repository) findOrder(ctx context.Context, filter string, args ...any) (Order, error) {
+	orders, err := r.findOrders(ctx, filter, args...)
+	if err != nil {
+		return Order{}, err
+	}
+	if len(orders) == 0 {
+		return Order{}, errOrderNotFound
+	}
+
+	return *orders[0], nil
+}
+
+func (r *repository) UpdateOrderStatus(ctx context.Context, o Order, to OrderStatus) (Order, error) {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		log.Println("ERROR: order repo UpdateOrderStatus (tx init): ", err)
+		return Order{}, errors.New("error updating order status")
+	}
+	defer tx.Rollback()
+
+	res, err := tx.ExecContext(
+		ctx,
+		`UPDATE orders
+		SET status = $3
+		WHERE id = $1 AND status = $2;`,
+		o.ID, o.Status, to,
+	)
+	if err != nil {
+		log.Println("ERROR: order repo UpdateOrderStatus: ", err)
+		return Order{}, errors.New("error updating order status")
+	}
+
+	if n, err := res.RowsAffected(); err != nil || n == 0 {
+		return Order{}, errInvalidStatusChange
+	}
+
+	o.Status = to
+
+	event, err := events.New(EventOrderStatusChanged, strconv.Itoa(int(o.ID)), o)
+	if err != nil {
+		log.Println("ERROR: order repo UpdateOrderStatus (events.New): ", err)
+		return Order{}, errors.New("error updating order status")
+	}
+
+	if err = insertEvent(ctx, tx, event); err != nil {
+		log.Println("ERROR: order repo UpdateOrderStatus (insert event): ", err)
+		return Order{}, errors.New("error updating order status")
+	}
+
+	if err = tx.Commit(); err != nil {
+		log.Println("ERROR: order repo UpdateOrderStatus (tx commit): ", err)
+		return Order{}, errors.New("error updating order status")
+	}
+
+	return o, nil
+}
+
+// findOrders loads the orders matching filter, a condition on orders o with
+// args as $1 and on, with their lines, discounts, taxes, exchange rates and
+// address.
+func (r *repository) findOrders(ctx context.Context, filter string, args ...any) ([]*Order, error) {
 	rows, err := r.db.QueryContext(
 		ctx,
 		`SELECT
 			o.id,
 			o.account_id,
+			o.currency,
+			COALESCE(o.subtotal_price, o.total_price),
+			o.tax_region,
+			o.tax_total,
 			o.total_price,
 			o.created_at,
+			COALESCE(o.payment_id, 0),
+			o.status,
+			COALESCE(o.request_id, ''),
 			op.product_id,
-			op.quantity
+			op.quantity,
+			op.unit_price_minor,
+			op.currency
 		FROM orders o
 		JOIN order_products op
 		ON o.id = op.order_id
-		WHERE account_id = $1
+		WHERE `+filter+`
 		ORDER BY o.id;`,
-		accountID,
+		args...,
 	)
 
 	if err != nil {
-		log.Println("ERROR: order repo GetOrdersByAccountID (r.db.QueryContext): ", err)
+		log.Println("ERROR: order repo findOrders (r.db.QueryContext): ", err)
 		return nil, errors.New("error finding account's orders")
 	}
 
@@ -144,55 +451,96 @@ func (r *repository) GetOrdersByAccountID(ctx context.Context, accountID int32)
 
 	for rows.Next() {
 		var (
-			id          int32
-			account_id  int32
-			total_price int64
-			created_at  time.Time
-			product_id  string
-			quantity    int32
+			id             int32
+			account_id     int32
+			currency       string
+			subtotal_price int64
+			tax_region     string
+			tax_total      int64
+			total_price    int64
+			created_at     time.Time
+			payment_id     int32
+			order_status   OrderStatus
+			request_id     string
+			product_id     string
+			quantity       int32
+			unit_price     sql.NullInt64
+			unit_currency  sql.NullString
 		)
 
 		if err := rows.Scan(
 			&id,
 			&account_id,
+			&currency,
+			&subtotal_price,
+			&tax_region,
+			&tax_total,
 			&total_price,
 			&created_at,
+			&payment_id,
+			&order_status,
+			&request_id,
 			&product_id,
 			&quantity,
+			&unit_price,
+			&unit_currency,
 		); err != nil {
-			log.Println("ERROR: order repo GetOrdersByAccountID (rows.Scan): ", err)
+			log.Println("ERROR: order repo findOrders (rows.Scan): ", err)
 			return nil, errors.New("error finding account's orders")
 		}
 
-		if order, exist := ordersMap[id]; !exist {
+		if _, exist := ordersMap[id]; !exist {
 			ordersMap[id] = &Order{
-				ID:         id,
-				AccountID:  account_id,
-				TotalPrice: total_price,
-				CreatedAt:  created_at,
-				Products: []OrderedProduct{
-					{
-						ID:       product_id,
-						Quantity: quantity,
-					},
-				},
+				ID:            id,
+				AccountID:     account_id,
+				SubtotalPrice: money.New(currency, subtotal_price),
+				Discounts:     []Discount{},
+				TaxRegion:     tax_region,
+				Taxes:         []TaxLine{},
+				TaxTotal:      money.New(currency, tax_total),
+				TotalPrice:    money.New(currency, total_price),
+				CreatedAt:     created_at,
+				PaymentID:     payment_id,
+				Status:        order_status,
+				RequestID:     request_id,
+				ExchangeRates: []ExchangeRate{},
+				Products:      []OrderedProduct{},
 			}
-		} else {
-			order.Products = append(
-				order.Products,
-				OrderedProduct{
-					ID:       product_id,
-					Quantity: quantity,
-				},
-			)
 		}
+
+		// lines stored before line prices were recorded have a zero Price
+		product := OrderedProduct{
+			ID:       product_id,
+			Quantity: quantity,
+		}
+		if unit_price.Valid && unit_currency.Valid {
+			product.Price = money.New(unit_currency.String, unit_price.Int64)
+		}
+
+		ordersMap[id].Products = append(ordersMap[id].Products, product)
 	}
 
 	if err = rows.Err(); err != nil {
-		log.Println("ERROR: order repo GetOrdersByAccountID (rows.Err): ", err)
+		log.Println("ERROR: order repo findOrders (rows.Err): ", err)
 		return nil, errors.New("error finding account's orders")
 	}
 
+	if err := r.loadDiscounts(ctx, filter, args, ordersMap); err != nil {
+		return nil, err
+	}
+
+	if err := r.loadTaxes(ctx, filter, args, ordersMap); err != nil {
+		return nil, err
+	}
+
+	if err := r.loadExchangeRates(ctx, filter, args, ordersMap); err != nil {
+		return nil, err
+	}
+
+	if err := r.loadAddresses(ctx, filter, args, ordersMap); err != nil {
+		return nil, err
+	}
+
 	orders := []*Order{}
 
 	for _, order := range ordersMap {
@@ -201,3 +549,646 @@ func (r *repository) GetOrdersByAccountID(ctx context.Context, accountID int32)
 
 	return orders, nil
 }
+
+func (r *repository) loadDiscounts(ctx context.Context, filter string, args []any, ordersMap map[int32]*Order) error {
+	rows, err := r.db.QueryContext(
+		ctx,
+		`SELECT
+			d.order_id,
+			d.promotion_id,
+			d.code,
+			d.name,
+			d.product_id,
+			d.amount
+		FROM order_discounts d
+		JOIN orders o
+		ON o.id = d.order_id
+		WHERE `+filter+`
+		ORDER BY d.id;`,
+		args...,
+	)
+	if err != nil {
+		log.Println("ERROR: order repo findOrders (discounts query): ", err)
+		return errors.New("error finding account's orders")
+	}
+
+	defer rows.Close()
+
+	for rows.Next() {
+		var (
+			orderID int32
+			amount  int64
+			d       Discount
+		)
+
+		if err := rows.Scan(
+			&orderID,
+			&d.PromotionID,
+			&d.Code,
+			&d.Name,
+			&d.ProductID,
+			&amount,
+		); err != nil {
+			log.Println("ERROR: order repo findOrders (discounts rows.Scan): ", err)
+			return errors.New("error finding account's orders")
+		}
+
+		if order, exist := ordersMap[orderID]; exist {
+			d.Amount = money.New(order.TotalPrice.Currency, amount)
+			order.Discounts = append(order.Discounts, d)
+		}
+	}
+
+	if err := rows.Err(); err != nil {
+		log.Println("ERROR: order repo findOrders (discounts rows.Err): ", err)
+		return errors.New("error finding account's orders")
+	}
+
+	return nil
+}
+
+func (r *repository) loadTaxes(ctx context.Context, filter string, args []any, ordersMap map[int32]*Order) error {
+	rows, err := r.db.QueryContext(
+		ctx,
+		`SELECT
+			t.order_id,
+			t.product_id,
+			t.category,
+			t.name,
+			t.rate,
+			t.taxable,
+			t.amount
+		FROM order_taxes t
+		JOIN orders o
+		ON o.id = t.order_id
+		WHERE `+filter+`
+		ORDER BY t.id;`,
+		args...,
+	)
+	if err != nil {
+		log.Println("ERROR: order repo findOrders (taxes query): ", err)
+		return errors.New("error finding account's orders")
+	}
+
+	defer rows.Close()
+
+	for rows.Next() {
+		var (
+			orderID int32
+			taxable int64
+			amount  int64
+			t       TaxLine
+		)
+
+		if err := rows.Scan(
+			&orderID,
+			&t.ProductID,
+			&t.Category,
+			&t.Name,
+			&t.Rate,
+			&taxable,
+			&amount,
+		); err != nil {
+			log.Println("ERROR: order repo findOrders (taxes rows.Scan): ", err)
+			return errors.New("error finding account's orders")
+		}
+
+		if order, exist := ordersMap[orderID]; exist {
+			t.Taxable = money.New(order.TotalPrice.Currency, taxable)
+			t.Amount = money.New(order.TotalPrice.Currency, amount)
+			order.Taxes = append(order.Taxes, t)
+		}
+	}
+
+	if err := rows.Err(); err != nil {
+		log.Println("ERROR: order repo findOrders (taxes rows.Err): ", err)
+		return errors.New("error finding account's orders")
+	}
+
+	return nil
+}
+
+func (r *repository) loadExchangeRates(ctx context.Context, filter string, args []any, ordersMap map[int32]*Order) error {
+	rows, err := r.db.QueryContext(
+		ctx,
+		`SELECT
+			x.order_id,
+			x.from_currency,
+			x.to_currency,
+			x.rate
+		FROM order_exchange_rates x
+		JOIN orders o
+		ON o.id = x.order_id
+		WHERE `+filter+`
+		ORDER BY x.id;`,
+		args...,
+	)
+	if err != nil {
+		log.Println("ERROR: order repo findOrders (exchange rates query): ", err)
+		return errors.New("error finding account's orders")
+	}
+
+	defer rows.Close()
+
+	for rows.Next() {
+		var (
+			orderID int32
+			rate    ExchangeRate
+		)
+
+		if err := rows.Scan(
+			&orderID,
+			&rate.From,
+			&rate.To,
+			&rate.Rate,
+		); err != nil {
+			log.Println("ERROR: order repo findOrders (exchange rates rows.Scan): ", err)
+			return errors.New("error finding account's orders")
+		}
+
+		if order, exist := ordersMap[orderID]; exist {
+			order.ExchangeRates = append(order.ExchangeRates, rate)
+		}
+	}
+
+	if err := rows.Err(); err != nil {
+		log.Println("ERROR: order repo findOrders (exchange rates rows.Err): ", err)
+		return errors.New("error finding account's orders")
+	}
+
+	return nil
+}
+
+func (r *repository) loadAddresses(ctx context.Context, filter string, args []any, ordersMap map[int32]*Order) error {
+	rows, err := r.db.QueryContext(
+		ctx,
+		`SELECT
+			a.order_id,
+			a.address_id,
+			a.name,
+			a.line1,
+			a.line2,
+			a.city,
+			a.region,
+			a.postal_code,
+			a.country,
+			a.phone
+		FROM order_addresses a
+		JOIN orders o
+		ON o.id = a.order_id
+		WHERE `+filter+`;`,
+		args...,
+	)
+	if err != nil {
+		log.Println("ERROR: order repo findOrders (addresses query): ", err)
+		return errors.New("error finding account's orders")
+	}
+
+	defer rows.Close()
+
+	for rows.Next() {
+		var (
+			orderID int32
+			a       Address
+		)
+
+		if err := rows.Scan(
+			&orderID,
+			&a.AddressID,
+			&a.Name,
+			&a.Line1,
+			&a.Line2,
+			&a.City,
+			&a.Region,
+			&a.PostalCode,
+			&a.Country,
+			&a.Phone,
+		); err != nil {
+			log.Println("ERROR: order repo findOrders (addresses rows.Scan): ", err)
+			return errors.New("error finding account's orders")
+		}
+
+		if order, exist := ordersMap[orderID]; exist {
+			order.ShippingAddress = &a
+		}
+	}
+
+	if err := rows.Err(); err != nil {
+		log.Println("ERROR: order repo findOrders (addresses rows.Err): ", err)
+		return errors.New("error finding account's orders")
+	}
+
+	return nil
+}
+
+func (r *repository) GetCartItems(ctx context.Context, accountID int32) ([]CartItem, error) {
+	rows, err := r.db.QueryContext(
+		ctx,
+		`SELECT
+			product_id,
+			quantity,
+			currency,
+			price,
+			added_at
+		FROM cart_items
+		WHERE account_id = $1
+		ORDER BY added_at, product_id;`,
+		accountID,
+	)
+	if err != nil {
+		log.Println("ERROR: order repo GetCartItems (r.db.QueryContext): ", err)
+		return nil, errors.New("error finding cart")
+	}
+
+	defer rows.Close()
+
+	items := []CartItem{}
+
+	for rows.Next() {
+		item := CartItem{}
+		if err := rows.Scan(
+			&item.ProductID,
+			&item.Quantity,
+			&item.Price.Currency,
+			&item.Price.Units,
+			&item.AddedAt,
+		); err != nil {
+			log.Println("ERROR: order repo GetCartItems (rows.Scan): ", err)
+			return nil, errors.New("error finding cart")
+		}
+		items = append(items, item)
+	}
+
+	if err := rows.Err(); err != nil {
+		log.Println("ERROR: order repo GetCartItems (rows.Err): ", err)
+		return nil, errors.New("error finding cart")
+	}
+
+	return items, nil
+}
+
+func (r *repository) AddCartItem(ctx context.Context, accountID int32, item CartItem) error {
+	if _, err := r.db.ExecContext(
+		ctx,
+		`INSERT INTO cart_items (account_id, product_id, quantity, currency, price)
+		VALUES ($1, $2, $3, $4, $5)
+		ON CONFLICT (account_id, product_id) DO UPDATE
+		SET
+			quantity = cart_items.quantity + EXCLUDED.quantity,
+			currency = EXCLUDED.currency,
+			price = EXCLUDED.price;`,
+		accountID,
+		item.ProductID,
+		item.Quantity,
+		item.Price.Currency,
+		item.Price.Units,
+	); err != nil {
+		log.Println("ERROR: order repo AddCartItem: ", err)
+		return errors.New("error adding cart item")
+	}
+
+	return nil
+}
+
+func (r *repository) SetCartItemQuantity(ctx context.Context, accountID int32, productID string, quantity int32) error {
+	res, err := r.db.ExecContext(
+		ctx,
+		`UPDATE cart_items
+		SET quantity = $1
+		WHERE account_id = $2 AND product_id = $3;`,
+		quantity,
+		accountID,
+		productID,
+	)
+	if err != nil {
+		log.Println("ERROR: order repo SetCartItemQuantity: ", err)
+		return errors.New("error updating cart item")
+	}
+
+	n, err := res.RowsAffected()
+	if err != nil {
+		log.Println("ERROR: order repo SetCartItemQuantity (RowsAffected): ", err)
+		return errors.New("error updating cart item")
+	}
+
+	if n == 0 {
+		return errCartItemNotFound
+	}
+
+	return nil
+}
+
+func (r *repository) RemoveCartItems(ctx context.Context, accountID int32, productIDs []string) (int, error) {
+	res, err := r.db.ExecContext(
+		ctx,
+		`DELETE FROM cart_items
+		WHERE account_id = $1 AND product_id = ANY($2);`,
+		accountID,
+		pq.Array(productIDs),
+	)
+	if err != nil {
+		log.Println("ERROR: order repo RemoveCartItems: ", err)
+		return 0, errors.New("error removing cart items")
+	}
+
+	n, err := res.RowsAffected()
+	if err != nil {
+		log.Println("ERROR: order repo RemoveCartItems (RowsAffected): ", err)
+		return 0, errors.New("error removing cart items")
+	}
+
+	return int(n), nil
+}
+
+const promotionColumns = `
+	id,
+	code,
+	name,
+	kind,
+	percent_off,
+	currency,
+	amount_off,
+	product_id,
+	buy_quantity,
+	get_quantity,
+	min_spend,
+	starts_at,
+	ends_at,
+	usage_limit,
+	usage_count,
+	active`
+
+type scanner interface {
+	Scan(dest ...any) error
+}
+
+func scanPromotion(row scanner) (Promotion, error) {
+	var (
+		p         Promotion
+		currency  string
+		amountOff int64
+		minSpend  int64
+		endsAt    sql.NullTime
+	)
+
+	err := row.Scan(
+		&p.ID,
+		&p.Code,
+		&p.Name,
+		&p.Kind,
+		&p.PercentOff,
+		&currency,
+		&amountOff,
+		&p.ProductID,
+		&p.BuyQuantity,
+		&p.GetQuantity,
+		&minSpend,
+		&p.StartsAt,
+		&endsAt,
+		&p.UsageLimit,
+		&p.UsageCount,
+		&p.Active,
+	)
+	p.AmountOff = money.New(currency, amountOff)
+	p.MinSpend = money.New(currency, minSpend)
+	p.EndsAt = endsAt.Time
+
+	return p, err
+}
+
+func (r *repository) CreatePromotion(ctx context.Context, p Promotion) (Promotion, error) {
+	created, err := scanPromotion(r.db.QueryRowContext(
+		ctx,
+		`INSERT INTO promotions (
+			code,
+			name,
+			kind,
+			percent_off,
+			currency,
+			amount_off,
+			product_id,
+			buy_quantity,
+			get_quantity,
+			min_spend,
+			starts_at,
+			ends_at,
+			usage_limit,
+			active
+		)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, TRUE)
+		RETURNING`+promotionColumns+`;`,
+		p.Code,
+		p.Name,
+		p.Kind,
+		p.PercentOff,
+		p.AmountOff.Currency,
+		p.AmountOff.Units,
+		p.ProductID,
+		p.BuyQuantity,
+		p.GetQuantity,
+		p.MinSpend.Units,
+		p.StartsAt,
+		sql.NullTime{Time: p.EndsAt, Valid: !p.EndsAt.IsZero()},
+		p.UsageLimit,
+	))
+
+	var pqErr *pq.Error
+	if errors.As(err, &pqErr) && pqErr.Code == "23505" {
+		return Promotion{}, errPromotionCodeTaken
+	}
+	if err != nil {
+		log.Println("ERROR: order repo CreatePromotion: ", err)
+		return Promotion{}, errors.New("error creating promotion")
+	}
+
+	return created, nil
+}
+
+func (r *repository) GetPromotionByID(ctx context.Context, id int32) (Promotion, error) {
+	p, err := scanPromotion(r.db.QueryRowContext(
+		ctx,
+		`SELECT`+promotionColumns+`
+		FROM promotions
+		WHERE id = $1;`,
+		id,
+	))
+	if errors.Is(err, sql.ErrNoRows) {
+		return Promotion{}, errPromotionNotFound
+	}
+	if err != nil {
+		log.Println("ERROR: order repo GetPromotionByID: ", err)
+		return Promotion{}, errors.New("error finding promotion")
+	}
+
+	return p, nil
+}
+
+func (r *repository) ListPromotions(ctx context.Context, offset, limit int32) ([]Promotion, error) {
+	return r.queryPromotions(
+		ctx,
+		"ListPromotions",
+		`SELECT`+promotionColumns+`
+		FROM promotions
+		ORDER BY id DESC
+		OFFSET $1
+		LIMIT $2;`,
+		offset,
+		limit,
+	)
+}
+
+func (r *repository) DeactivatePromotion(ctx context.Context, id int32) (Promotion, error) {
+	p, err := scanPromotion(r.db.QueryRowContext(
+		ctx,
+		`UPDATE promotions
+		SET active = FALSE
+		WHERE id = $1
+		RETURNING`+promotionColumns+`;`,
+		id,
+	))
+	if errors.Is(err, sql.ErrNoRows) {
+		return Promotion{}, errPromotionNotFound
+	}
+	if err != nil {
+		log.Println("ERROR: order repo DeactivatePromotion: ", err)
+		return Promotion{}, errors.New("error deactivating promotion")
+	}
+
+	return p, nil
+}
+
+func (r *repository) LivePromotions(ctx context.Context, couponCodes []string, now time.Time) ([]Promotion, error) {
+	return r.queryPromotions(
+		ctx,
+		"LivePromotions",
+		`SELECT`+promotionColumns+`
+		FROM promotions
+		WHERE active
+			AND starts_at <= $1
+			AND (ends_at IS NULL OR ends_at > $1)
+			AND (usage_limit = 0 OR usage_count < usage_limit)
+			AND (code = '' OR code = ANY($2))
+		ORDER BY id;`,
+		now,
+		pq.Array(couponCodes),
+	)
+}
+
+func (r *repository) queryPromotions(ctx context.Context, caller, query string, args ...any) ([]Promotion, error) {
+	rows, err := r.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		log.Printf("ERROR: order repo %s (r.db.QueryContext): %v", caller, err)
+		return nil, errors.New("error finding promotions")
+	}
+
+	defer rows.Close()
+
+	promotions := []Promotion{}
+
+	for rows.Next() {
+		p, err := scanPromotion(rows)
+		if err != nil {
+			log.Printf("ERROR: order repo %s (rows.Scan): %v", caller, err)
+			return nil, errors.New("error finding promotions")
+		}
+		promotions = append(promotions, p)
+	}
+
+	if err := rows.Err(); err != nil {
+		log.Printf("ERROR: order repo %s (rows.Err): %v", caller, err)
+		return nil, errors.New("error finding promotions")
+	}
+
+	return promotions, nil
+}
+
+func (r *repository) RecordAudit(ctx context.Context, e audit.Entry) error {
+	if _, err := r.db.ExecContext(
+		ctx,
+		`INSERT INTO audit_log (id, actor, action, entity_type, entity_id, before, after, request_id, occurred_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9);`,
+		e.ID, e.Actor, e.Action, e.EntityType, e.EntityID, nullJSON(e.Before), nullJSON(e.After), e.RequestID, e.OccurredAt,
+	); err != nil {
+		log.Println("ERROR: order repo RecordAudit: ", err)
+		return errors.New("error recording audit entry")
+	}
+
+	return nil
+}
+
+// QueryAuditLog treats each empty filter as matching everything.
+func (r *repository) QueryAuditLog(ctx context.Context, q audit.Query) ([]audit.Entry, error) {
+	rows, err := r.db.QueryContext(
+		ctx,
+		`SELECT
+			id,
+			actor,
+			action,
+			entity_type,
+			entity_id,
+			before,
+			after,
+			request_id,
+			occurred_at
+		FROM audit_log
+		WHERE ($1 = '' OR entity_type = $1)
+			AND ($2 = '' OR entity_id = $2)
+			AND ($3 = '' OR actor = $3)
+			AND ($4::TIMESTAMPTZ IS NULL OR occurred_at >= $4)
+			AND ($5::TIMESTAMPTZ IS NULL OR occurred_at < $5)
+		ORDER BY occurred_at DESC, id
+		OFFSET $6
+		LIMIT $7;`,
+		q.EntityType, q.EntityID, q.Actor,
+		sql.NullTime{Time: q.From, Valid: !q.From.IsZero()},
+		sql.NullTime{Time: q.To, Valid: !q.To.IsZero()},
+		q.Offset, q.Limit,
+	)
+	if err != nil {
+		log.Println("ERROR: order repo QueryAuditLog (r.db.QueryContext): ", err)
+		return nil, errors.New("error querying audit log")
+	}
+
+	defer rows.Close()
+
+	entries := []audit.Entry{}
+
+	for rows.Next() {
+		e := audit.Entry{}
+		var before, after []byte
+
+		if err := rows.Scan(
+			&e.ID,
+			&e.Actor,
+			&e.Action,
+			&e.EntityType,
+			&e.EntityID,
+			&before,
+			&after,
+			&e.RequestID,
+			&e.OccurredAt,
+		); err != nil {
+			log.Println("ERROR: order repo QueryAuditLog (rows.Scan): ", err)
+			return nil, errors.New("error querying audit log")
+		}
+
+		e.Before, e.After = before, after
+		entries = append(entries, e)
+	}
+
+	if err := rows.Err(); err != nil {
+		log.Println("ERROR: order repo QueryAuditLog (rows.Err): ", err)
+		return nil, errors.New("error querying audit log")
+	}
+
+	return entries, nil
+}
+
+// nullJSON stores an empty document as NULL.
+func nullJSON(b []byte) any {
+	if len(b) == 0 {
+		return nil
+	}
+
+	return string(b)
+}
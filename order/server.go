@@ -2,17 +2,25 @@ package main
 
 import (
 	"context"
+	"crypto/rand"
+	"encoding/hex"
 	"errors"
 	"fmt"
 	"log"
-	"net"
-	"os"
+	"strings"
+	"sync"
 
+	"github.com/airlangga-hub/microservices/audit"
+	"github.com/airlangga-hub/microservices/auth"
+	"github.com/airlangga-hub/microservices/money"
 	accpb "github.com/airlangga-hub/microservices/order/account_pb"
 	catpb "github.com/airlangga-hub/microservices/order/catalog_pb"
+	paypb "github.com/airlangga-hub/microservices/order/payment_pb"
 	"github.com/airlangga-hub/microservices/order/pb"
 	"google.golang.org/grpc"
-	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
 )
 
 type Server struct {
@@ -20,46 +28,56 @@ type Server struct {
 	Svc           Service
 	AccountClient accpb.AccountServiceClient
 	CatalogClient catpb.CatalogServiceClient
+	PaymentClient paypb.PaymentServiceClient
+	Feed          *orderFeed
+
+	// sagas tracks in-flight PostOrder calls so shutdown can let them finish
+	// before closing the clients and repository they use.
+	sagas sync.WaitGroup
 }
 
-func ListenGrpc(service Service, port string) error {
-	accountConn, err := grpc.NewClient(os.Getenv("ACCOUNT_SERVICE_URL"), grpc.WithTransportCredentials(insecure.NewCredentials()))
-	if err != nil {
-		return fmt.Errorf("ERROR: order server ListenGrpc (accpb.NewClient): %v", err)
+// WaitForSagas blocks until in-flight PostOrder calls finish or ctx is done.
+func (s *Server) WaitForSagas(ctx context.Context) error {
+	done := make(chan struct{})
+	go func() {
+		s.sagas.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
 	}
-	defer accountConn.Close()
-
-	accountClient := accpb.NewAccountServiceClient(accountConn)
+}
 
-	catalogConn, err := grpc.NewClient(os.Getenv("CATALOG_SERVICE_URL"), grpc.WithTransportCredentials(insecure.NewCredentials()))
-	if err != nil {
-		return fmt.Errorf("ERROR: order server ListenGrpc (accpb.NewClient): %v", err)
+func (s *Server) PostOrder(ctx context.Context, r *pb.PostOrderRequest) (*pb.PostOrderResponse, error) {
+	s.sagas.Add(1)
+	defer s.sagas.Done()
+
+	// a retry of a call that placed its order gets that order back
+	if r.RequestId != "" {
+		placed, err := s.Svc.GetOrderByRequestID(ctx, r.AccountId, r.RequestId)
+		if !errors.Is(err, errOrderNotFound) {
+			return s.placedOrder(ctx, placed, err)
+		}
 	}
-	defer catalogConn.Close()
-
-	catalogClient := catpb.NewCatalogServiceClient(catalogConn)
 
-	lis, err := net.Listen("tcp", port)
+	account, err := s.AccountClient.GetAccount(ctx, &accpb.GetAccountRequest{Id: r.AccountId})
 	if err != nil {
-		return fmt.Errorf("ERROR: order server ListenGrpc (net.Listen): %v", err)
+		return nil, err
 	}
 
-	s := grpc.NewServer()
-
-	pb.RegisterOrderServiceServer(
-		s,
-		&Server{
-			Svc:           service,
-			AccountClient: accountClient,
-			CatalogClient: catalogClient,
-		},
-	)
-
-	return s.Serve(lis)
-}
+	// suspended and closed accounts keep their order history but can't add
+	// to it; an account service from before statuses sends none
+	switch account.Account.GetStatus() {
+	case accpb.AccountStatus_ACCOUNT_STATUS_ACTIVE, accpb.AccountStatus_ACCOUNT_STATUS_UNSPECIFIED:
+	default:
+		return nil, status.Errorf(codes.FailedPrecondition, "account %d is %s and can't place orders", r.AccountId, strings.ToLower(strings.TrimPrefix(account.Account.GetStatus().String(), "ACCOUNT_STATUS_")))
+	}
 
-func (s *Server) PostOrder(ctx context.Context, r *pb.PostOrderRequest) (*pb.PostOrderResponse, error) {
-	_, err := s.AccountClient.GetAccount(ctx, &accpb.GetAccountRequest{Id: r.AccountId})
+	shippingAddress, err := s.shippingAddress(ctx, r.AccountId, r.AddressId)
 	if err != nil {
 		return nil, err
 	}
@@ -75,10 +93,11 @@ func (s *Server) PostOrder(ctx context.Context, r *pb.PostOrderRequest) (*pb.Pos
 	products, err := s.CatalogClient.GetProducts(
 		ctx,
 		&catpb.GetProductsRequest{
-			Offset: 0,
-			Limit:  0,
-			Ids:    productIDs,
-			Query:  "",
+			Offset:   0,
+			Limit:    0,
+			Ids:      productIDs,
+			Query:    "",
+			Currency: r.Currency,
 		},
 	)
 	if err != nil {
@@ -92,11 +111,13 @@ func (s *Server) PostOrder(ctx context.Context, r *pb.PostOrderRequest) (*pb.Pos
 			orderedProducts = append(
 				orderedProducts,
 				OrderedProduct{
-					ID:          p.Id,
-					Name:        p.Name,
-					Description: p.Description,
-					Price:       p.Price,
-					Quantity:    qty,
+					ID:           p.Id,
+					Name:         p.Name,
+					Description:  p.Description,
+					Price:        moneyFromPB(p.Price),
+					Quantity:     qty,
+					TaxCategory:  p.TaxCategory,
+					ExchangeRate: exchangeRateFromPB(p.ExchangeRate),
 				},
 			)
 		}
@@ -107,51 +128,181 @@ func (s *Server) PostOrder(ctx context.Context, r *pb.PostOrderRequest) (*pb.Pos
 		return nil, errors.New("one or more products not found")
 	}
 
-	order, err := s.Svc.PostOrder(ctx, r.AccountId, orderedProducts)
+	pricing, err := s.Svc.PriceOrder(ctx, orderedProducts, r.CouponCodes, r.TaxRegion)
+	switch {
+	case errors.Is(err, errUnknownCoupon), errors.Is(err, errUnknownTaxRegion), errors.Is(err, errMixedCurrency):
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	case errors.Is(err, money.ErrOverflow):
+		return nil, status.Error(codes.InvalidArgument, "order total is too large")
+	case errors.Is(err, errCouponNotApplicable):
+		return nil, status.Error(codes.FailedPrecondition, err.Error())
+	case err != nil:
+		return nil, err
+	}
+
+	// a fully discounted order has nothing to pay
+	var paymentID int32
+	if pricing.Total.Units > 0 {
+		paymentID, err = s.authorizePayment(ctx, r.AccountId, r.RequestId, pricing.Total)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	order, err := s.Svc.PostOrder(ctx, r.AccountId, r.RequestId, pricing, shippingAddress, paymentID)
+	if errors.Is(err, errOrderRequestTaken) {
+		// a concurrent retry placed the order, with this same payment, so
+		// it must not be voided
+		placed, err := s.Svc.GetOrderByRequestID(ctx, r.AccountId, r.RequestId)
+		return s.placedOrder(ctx, placed, err)
+	}
 	if err != nil {
+		// release the hold even if the caller has gone away
+		if paymentID != 0 {
+			if _, voidErr := s.PaymentClient.Void(context.WithoutCancel(ctx), &paypb.VoidRequest{Id: paymentID}); voidErr != nil {
+				log.Println("ERROR: order server PostOrder (void payment): ", voidErr)
+			}
+		}
+		if errors.Is(err, errPromotionExhausted) {
+			return nil, status.Error(codes.FailedPrecondition, err.Error())
+		}
 		return nil, err
 	}
 
-	pbProducts := []*pb.OrderedProduct{}
+	// the order is placed either way; its payment was recorded as pending
+	// capture with it, and the capture relay retries it if this fails
+	if paymentID != 0 {
+		if err := s.capturePayment(ctx, paymentID); err != nil {
+			log.Println("ERROR: order server PostOrder (capture payment): ", err)
+		} else if err := s.Svc.CaptureDone(ctx, paymentID); err != nil {
+			log.Println("ERROR: order server PostOrder (capture done): ", err)
+		}
+	}
+
+	pbOrder, err := orderToPB(order)
+	if err != nil {
+		log.Println("ERROR: order server PostOrder (orderToPB): ", err)
+		return nil, errors.New("error creating order")
+	}
+
+	return &pb.PostOrderResponse{Order: pbOrder}, nil
+}
 
+// placedOrder answers a PostOrder retry with the order the first call
+// placed, naming its lines from the catalog like GetOrdersByAccountID.
+func (s *Server) placedOrder(ctx context.Context, order Order, err error) (*pb.PostOrderResponse, error) {
+	if err != nil {
+		return nil, err
+	}
+
+	productIDs := []string{}
 	for _, p := range order.Products {
-		pbProducts = append(
-			pbProducts,
-			&pb.OrderedProduct{
-				Id:          p.ID,
-				Name:        p.Name,
-				Description: p.Description,
-				Price:       p.Price,
-				Quantity:    p.Quantity,
-			},
-		)
+		productIDs = append(productIDs, p.ID)
+	}
+
+	products, err := s.CatalogClient.GetProducts(ctx, &catpb.GetProductsRequest{Ids: productIDs})
+	if err != nil {
+		return nil, err
+	}
+
+	for i, p := range order.Products {
+		for _, cp := range products.Products {
+			if cp.Id == p.ID {
+				order.Products[i].Name = cp.Name
+				order.Products[i].Description = cp.Description
+			}
+		}
 	}
 
-	createdAt, err := order.CreatedAt.MarshalBinary()
+	pbOrder, err := orderToPB(order)
 	if err != nil {
-		log.Println("ERROR: order server PostOrder (MarshalBinary): ", err)
+		log.Println("ERROR: order server PostOrder (orderToPB): ", err)
 		return nil, errors.New("error creating order")
 	}
 
-	return &pb.PostOrderResponse{
-		Order: &pb.Order{
-			Id:         order.ID,
-			AccountId:  order.AccountID,
-			Products:   pbProducts,
-			TotalPrice: order.TotalPrice,
-			CreatedAt:  createdAt,
-		},
-	}, nil
+	return &pb.PostOrderResponse{Order: pbOrder}, nil
 }
 
+// shippingAddress copies the account's address addressID, or its default
+// address when addressID is 0. Without an address ID or a default the order
+// has no shipping address.
+func (s *Server) shippingAddress(ctx context.Context, accountID, addressID int32) (*Address, error) {
+	addresses, err := s.AccountClient.ListAddresses(ctx, &accpb.ListAddressesRequest{AccountId: accountID})
+	if err != nil {
+		return nil, err
+	}
+
+	for _, a := range addresses.Addresses {
+		if a.Id == addressID || (addressID == 0 && a.IsDefault) {
+			return &Address{
+				AddressID:  a.Id,
+				Name:       a.Name,
+				Line1:      a.Line1,
+				Line2:      a.Line2,
+				City:       a.City,
+				Region:     a.Region,
+				PostalCode: a.PostalCode,
+				Country:    a.Country,
+				Phone:      a.Phone,
+			}, nil
+		}
+	}
+
+	if addressID != 0 {
+		return nil, status.Errorf(codes.NotFound, "address %d not found", addressID)
+	}
+
+	return nil, nil
+}
+
+// authorizePayment holds amount on the account and returns the payment ID.
+// Retries of one request ID send the same idempotency key, so they get the
+// payment the first call authorized instead of holding the amount again.
+func (s *Server) authorizePayment(ctx context.Context, accountID int32, requestID string, amount money.Money) (int32, error) {
+	idempotencyKey, err := newIdempotencyKey(accountID, requestID)
+	if err != nil {
+		log.Println("ERROR: order server PostOrder (newIdempotencyKey): ", err)
+		return 0, errors.New("error creating order")
+	}
+
+	authorized, err := s.PaymentClient.Authorize(ctx, &paypb.AuthorizeRequest{
+		IdempotencyKey: idempotencyKey,
+		AccountId:      accountID,
+		Amount:         amount.Units,
+		Currency:       amount.Currency,
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	payment := authorized.Payment
+	switch payment.Status {
+	case paypb.PaymentStatus_PAYMENT_STATUS_AUTHORIZED:
+	case paypb.PaymentStatus_PAYMENT_STATUS_DECLINED:
+		return 0, status.Errorf(codes.FailedPrecondition, "payment declined: %s", payment.DeclineReason)
+	default:
+		// an earlier call with this request ID failed and released its
+		// payment
+		return 0, status.Errorf(codes.FailedPrecondition, "payment for request %q is %s, place the order with a new request id", requestID, strings.ToLower(strings.TrimPrefix(payment.Status.String(), "PAYMENT_STATUS_")))
+	}
+
+	return payment.Id, nil
+}
+
+// GetOrdersByAccountID returns the caller's own orders, or any account's to
+// a caller that may read them.
 func (s *Server) GetOrdersByAccountID(ctx context.Context, r *pb.GetOrdersByAccountIDRequest) (*pb.GetOrdersByAccountIDResponse, error) {
+	if err := auth.RequireAccount(ctx, r.AccountId, permissionReadAnyOrders); err != nil {
+		return nil, err
+	}
+
 	_, err := s.AccountClient.GetAccount(ctx, &accpb.GetAccountRequest{Id: r.AccountId})
 	if err != nil {
 		return nil, err
 	}
 
-	// the products inside each order only contains product_id and quantity
-	// we need to get the name, description, price from catpb
+	// orders keep each line's price as it was when the order was placed;
+	// only the name and description come from the catalog
 	orders, err := s.Svc.GetOrdersByAccountID(ctx, r.AccountId)
 	if err != nil {
 		return nil, err
@@ -161,35 +312,27 @@ func (s *Server) GetOrdersByAccountID(ctx context.Context, r *pb.GetOrdersByAcco
 
 	for _, order := range orders {
 		for _, product := range order.Products {
-
 			productIdSet[product.ID] = struct{}{}
-
 		}
 	}
 
-	productIDs := []string{}
+	mapCatalogProducts := map[string]*catpb.Product{}
 
-	for productID, _ := range productIdSet {
-		productIDs = append(productIDs, productID)
-	}
+	if len(productIdSet) > 0 {
+		productIDs := []string{}
 
-	catalogProducts, err := s.CatalogClient.GetProducts(
-		ctx,
-		&catpb.GetProductsRequest{
-			Offset: 0,
-			Limit:  0,
-			Ids:    productIDs,
-			Query:  "",
-		},
-	)
-	if err != nil {
-		return nil, err
-	}
+		for productID := range productIdSet {
+			productIDs = append(productIDs, productID)
+		}
 
-	mapCatalogProducts := map[string]*catpb.Product{}
+		catalogProducts, err := s.CatalogClient.GetProducts(ctx, &catpb.GetProductsRequest{Ids: productIDs})
+		if err != nil {
+			return nil, err
+		}
 
-	for _, cp := range catalogProducts.Products {
-		mapCatalogProducts[cp.Id] = cp
+		for _, cp := range catalogProducts.Products {
+			mapCatalogProducts[cp.Id] = cp
+		}
 	}
 
 	pbOrders := []*pb.Order{}
@@ -198,25 +341,24 @@ func (s *Server) GetOrdersByAccountID(ctx context.Context, r *pb.GetOrdersByAcco
 
 		pbProducts := []*pb.OrderedProduct{}
 
+		// a product since removed from the catalog keeps its price and
+		// quantity but has no name
 		for _, product := range order.Products {
-			if cp, exist := mapCatalogProducts[product.ID]; exist {
+			pbProduct := &pb.OrderedProduct{
+				Id:       product.ID,
+				Quantity: product.Quantity,
+			}
 
-				pbProducts = append(
-					pbProducts,
-					&pb.OrderedProduct{
-						Id:          cp.Id,
-						Name:        cp.Name,
-						Description: cp.Description,
-						Price:       cp.Price,
-						Quantity:    product.Quantity,
-					},
-				)
+			if product.Price.Currency != "" {
+				pbProduct.Price = pbMoney(product.Price)
 			}
-		}
 
-		if len(order.Products) != len(pbProducts) {
-			log.Println("ERROR: order server GetOrdersByAccountID (check length): ", err)
-			return nil, errors.New("error finding accpb's order")
+			if cp, exist := mapCatalogProducts[product.ID]; exist {
+				pbProduct.Name = cp.Name
+				pbProduct.Description = cp.Description
+			}
+
+			pbProducts = append(pbProducts, pbProduct)
 		}
 
 		createdAt, err := order.CreatedAt.MarshalBinary()
@@ -228,11 +370,20 @@ func (s *Server) GetOrdersByAccountID(ctx context.Context, r *pb.GetOrdersByAcco
 		pbOrders = append(
 			pbOrders,
 			&pb.Order{
-				Id:         order.ID,
-				AccountId:  order.AccountID,
-				Products:   pbProducts,
-				TotalPrice: order.TotalPrice,
-				CreatedAt:  createdAt,
+				Id:              order.ID,
+				AccountId:       order.AccountID,
+				Products:        pbProducts,
+				TotalPrice:      pbMoney(order.TotalPrice),
+				CreatedAt:       createdAt,
+				PaymentId:       order.PaymentID,
+				SubtotalPrice:   pbMoney(order.SubtotalPrice),
+				Discounts:       pbDiscounts(order.Discounts),
+				TaxRegion:       order.TaxRegion,
+				Taxes:           pbTaxLines(order.Taxes),
+				TaxTotal:        pbMoney(order.TaxTotal),
+				ExchangeRates:   pbExchangeRates(order.ExchangeRates),
+				ShippingAddress: pbAddress(order.ShippingAddress),
+				Status:          pbOrderStatus[order.Status],
 			},
 		)
 	}
@@ -241,3 +392,326 @@ func (s *Server) GetOrdersByAccountID(ctx context.Context, r *pb.GetOrdersByAcco
 		Orders: pbOrders,
 	}, nil
 }
+
+// UpdateOrderStatus moves an order on in fulfilment, from placed to shipped
+// to delivered, for callers that may manage orders.
+func (s *Server) UpdateOrderStatus(ctx context.Context, r *pb.UpdateOrderStatusRequest) (*pb.UpdateOrderStatusResponse, error) {
+	if err := auth.Require(ctx, permissionManageOrders); err != nil {
+		return nil, err
+	}
+
+	to, ok := orderStatuses[r.Status]
+	if !ok {
+		return nil, status.Error(codes.InvalidArgument, "unknown order status")
+	}
+
+	order, err := s.Svc.UpdateOrderStatus(ctx, r.Id, to)
+	switch {
+	case errors.Is(err, errOrderNotFound):
+		return nil, status.Error(codes.NotFound, err.Error())
+	case errors.Is(err, errInvalidStatusChange):
+		return nil, status.Error(codes.FailedPrecondition, err.Error())
+	case err != nil:
+		return nil, err
+	}
+
+	pbOrder, err := orderToPB(order)
+	if err != nil {
+		log.Println("ERROR: order server UpdateOrderStatus (orderToPB): ", err)
+		return nil, errors.New("error updating order status")
+	}
+
+	return &pb.UpdateOrderStatusResponse{Order: pbOrder}, nil
+}
+
+var orderStatuses = map[pb.OrderStatus]OrderStatus{
+	pb.OrderStatus_ORDER_STATUS_PLACED:    OrderPlaced,
+	pb.OrderStatus_ORDER_STATUS_SHIPPED:   OrderShipped,
+	pb.OrderStatus_ORDER_STATUS_DELIVERED: OrderDelivered,
+}
+
+// QueryAuditLog returns audit entries newest first, filtered by entity,
+// actor and an occurred_at range, to callers that may read the audit log.
+func (s *Server) QueryAuditLog(ctx context.Context, r *pb.QueryOrderAuditLogRequest) (*pb.QueryOrderAuditLogResponse, error) {
+	if err := auth.Require(ctx, permissionReadAudit); err != nil {
+		return nil, err
+	}
+
+	q := audit.Query{EntityType: r.EntityType, EntityID: r.EntityId, Actor: r.Actor, Offset: r.Offset, Limit: r.Limit}
+
+	if len(r.From) > 0 {
+		if err := q.From.UnmarshalBinary(r.From); err != nil {
+			return nil, status.Error(codes.InvalidArgument, "invalid from")
+		}
+	}
+	if len(r.To) > 0 {
+		if err := q.To.UnmarshalBinary(r.To); err != nil {
+			return nil, status.Error(codes.InvalidArgument, "invalid to")
+		}
+	}
+
+	entries, err := s.Svc.QueryAuditLog(ctx, q)
+	if err != nil {
+		return nil, err
+	}
+
+	pbEntries := []*pb.OrderAuditEntry{}
+
+	for _, e := range entries {
+		occurredAt, err := e.OccurredAt.MarshalBinary()
+		if err != nil {
+			log.Println("ERROR: order server QueryAuditLog (MarshalBinary): ", err)
+			return nil, errors.New("error encoding audit entry")
+		}
+
+		pbEntries = append(pbEntries, &pb.OrderAuditEntry{
+			Id:         e.ID,
+			Actor:      e.Actor,
+			Action:     e.Action,
+			EntityType: e.EntityType,
+			EntityId:   e.EntityID,
+			Before:     string(e.Before),
+			After:      string(e.After),
+			RequestId:  e.RequestID,
+			OccurredAt: occurredAt,
+		})
+	}
+
+	return &pb.QueryOrderAuditLogResponse{Entries: pbEntries}, nil
+}
+
+func pbDiscounts(discounts []Discount) []*pb.AppliedDiscount {
+	pbDiscounts := []*pb.AppliedDiscount{}
+
+	for _, d := range discounts {
+		pbDiscounts = append(
+			pbDiscounts,
+			&pb.AppliedDiscount{
+				PromotionId: d.PromotionID,
+				Code:        d.Code,
+				Name:        d.Name,
+				ProductId:   d.ProductID,
+				Amount:      pbMoney(d.Amount),
+			},
+		)
+	}
+
+	return pbDiscounts
+}
+
+func pbTaxLines(taxes []TaxLine) []*pb.TaxLine {
+	pbTaxes := []*pb.TaxLine{}
+
+	for _, t := range taxes {
+		pbTaxes = append(
+			pbTaxes,
+			&pb.TaxLine{
+				ProductId: t.ProductID,
+				Category:  t.Category,
+				Name:      t.Name,
+				Rate:      t.Rate,
+				Taxable:   pbMoney(t.Taxable),
+				Amount:    pbMoney(t.Amount),
+			},
+		)
+	}
+
+	return pbTaxes
+}
+
+func pbExchangeRates(rates []ExchangeRate) []*catpb.ExchangeRate {
+	pbRates := []*catpb.ExchangeRate{}
+
+	for _, r := range rates {
+		pbRates = append(
+			pbRates,
+			&catpb.ExchangeRate{
+				FromCurrency: r.From,
+				ToCurrency:   r.To,
+				Rate:         r.Rate,
+			},
+		)
+	}
+
+	return pbRates
+}
+
+// pbAddress is nil for an order without a shipping address.
+func pbAddress(a *Address) *accpb.Address {
+	if a == nil {
+		return nil
+	}
+
+	return &accpb.Address{
+		Id:         a.AddressID,
+		Name:       a.Name,
+		Line1:      a.Line1,
+		Line2:      a.Line2,
+		City:       a.City,
+		Region:     a.Region,
+		PostalCode: a.PostalCode,
+		Country:    a.Country,
+		Phone:      a.Phone,
+	}
+}
+
+// exchangeRateFromPB is nil for a product the catalog didn't convert.
+func exchangeRateFromPB(r *catpb.ExchangeRate) *ExchangeRate {
+	if r == nil {
+		return nil
+	}
+
+	return &ExchangeRate{From: r.FromCurrency, To: r.ToCurrency, Rate: r.Rate}
+}
+
+func pbMoney(m money.Money) *catpb.Money {
+	return &catpb.Money{Currency: m.Currency, Units: m.Units}
+}
+
+func moneyFromPB(m *catpb.Money) money.Money {
+	return money.New(m.GetCurrency(), m.GetUnits())
+}
+
+// newIdempotencyKey identifies one order placement to the payment service,
+// so retries of the same Authorize call can't charge twice. It is derived
+// from the client's request ID when there is one, so the client's own
+// retries can't either.
+func newIdempotencyKey(accountID int32, requestID string) (string, error) {
+	if requestID != "" {
+		return fmt.Sprintf("order-%d-%s", accountID, requestID), nil
+	}
+
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+
+	return "order-" + hex.EncodeToString(b), nil
+}
+
+// WatchOrders streams the account's order events, placed orders and status
+// changes, until the client goes away. Like GetOrdersByAccountID it is for
+// the account itself or a caller that may read any account's orders. A
+// client that falls behind is cut off with ResourceExhausted and can
+// reconnect with the last event id it received.
+func (s *Server) WatchOrders(r *pb.WatchOrdersRequest, stream grpc.ServerStreamingServer[pb.OrderEvent]) error {
+	ctx := stream.Context()
+
+	if err := auth.RequireAccount(ctx, r.AccountId, permissionReadAnyOrders); err != nil {
+		return err
+	}
+
+	_, err := s.AccountClient.GetAccount(ctx, &accpb.GetAccountRequest{Id: r.AccountId})
+	if err != nil {
+		return err
+	}
+
+	w, replay, err := s.Feed.Subscribe(r.AccountId, r.LastEventId)
+	switch {
+	case errors.Is(err, errUnknownEvent):
+		return status.Error(codes.OutOfRange, "last event id is too old or unknown, reload orders and watch again without it")
+	case errors.Is(err, errFeedClosed):
+		return status.Error(codes.Unavailable, "order service is shutting down")
+	case err != nil:
+		return err
+	}
+	defer s.Feed.Unsubscribe(w)
+
+	// headers tell the client the watch is live, so nothing placed from now
+	// on is missed
+	if err := stream.SendHeader(metadata.MD{}); err != nil {
+		return err
+	}
+
+	for _, e := range replay {
+		if err := sendOrderEvent(stream, e); err != nil {
+			return err
+		}
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case e, ok := <-w.events:
+			if !ok {
+				if errors.Is(w.err, errWatcherSlow) {
+					return status.Error(codes.ResourceExhausted, "watcher fell behind, resume from the last received event id")
+				}
+				return status.Error(codes.Unavailable, "order service is shutting down")
+			}
+
+			if err := sendOrderEvent(stream, e); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+func sendOrderEvent(stream grpc.ServerStreamingServer[pb.OrderEvent], e orderEvent) error {
+	pbOrder, err := orderToPB(e.Order)
+	if err != nil {
+		log.Println("ERROR: order server WatchOrders (orderToPB): ", err)
+		return errors.New("error sending order event")
+	}
+
+	occurredAt, err := e.OccurredAt.MarshalBinary()
+	if err != nil {
+		log.Println("ERROR: order server WatchOrders (MarshalBinary): ", err)
+		return errors.New("error sending order event")
+	}
+
+	return stream.Send(&pb.OrderEvent{
+		Id:         e.ID,
+		Type:       e.Type,
+		Order:      pbOrder,
+		OccurredAt: occurredAt,
+	})
+}
+
+// orderToPB converts an order whose lines carry their own names, as placed or
+// as recorded in an event.
+func orderToPB(o Order) (*pb.Order, error) {
+	pbProducts := []*pb.OrderedProduct{}
+
+	for _, p := range o.Products {
+		pbProducts = append(
+			pbProducts,
+			&pb.OrderedProduct{
+				Id:          p.ID,
+				Name:        p.Name,
+				Description: p.Description,
+				Price:       pbMoney(p.Price),
+				Quantity:    p.Quantity,
+			},
+		)
+	}
+
+	createdAt, err := o.CreatedAt.MarshalBinary()
+	if err != nil {
+		return nil, err
+	}
+
+	return &pb.Order{
+		Id:              o.ID,
+		AccountId:       o.AccountID,
+		Products:        pbProducts,
+		TotalPrice:      pbMoney(o.TotalPrice),
+		CreatedAt:       createdAt,
+		PaymentId:       o.PaymentID,
+		SubtotalPrice:   pbMoney(o.SubtotalPrice),
+		Discounts:       pbDiscounts(o.Discounts),
+		TaxRegion:       o.TaxRegion,
+		Taxes:           pbTaxLines(o.Taxes),
+		TaxTotal:        pbMoney(o.TaxTotal),
+		ExchangeRates:   pbExchangeRates(o.ExchangeRates),
+		ShippingAddress: pbAddress(o.ShippingAddress),
+		Status:          pbOrderStatus[o.Status],
+	}, nil
+}
+
+var pbOrderStatus = map[OrderStatus]pb.OrderStatus{
+	OrderPlaced:    pb.OrderStatus_ORDER_STATUS_PLACED,
+	OrderShipped:   pb.OrderStatus_ORDER_STATUS_SHIPPED,
+	OrderDelivered: pb.OrderStatus_ORDER_STATUS_DELIVERED,
+}
@@ -0,0 +1,866 @@
+package main
+
+import (
+	"context"
+	"strconv"
+	"strings"
+	"testing"
+
+	accpb "github.com/airlangga-hub/microservices/order/account_pb"
+	catpb "github.com/airlangga-hub/microservices/order/catalog_pb"
+	paypb "github.com/airlangga-hub/microservices/order/payment_pb"
+	"github.com/airlangga-hub/microservices/order/pb"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+func TestPostOrder(t *testing.T) {
+	h := newHarness(t)
+	account := h.account(t, "angga")
+	keyboard := h.product(t, "keyboard", 100)
+	mouse := h.product(t, "mouse", 50)
+
+	res, err := h.Order.PostOrder(context.Background(), &pb.PostOrderRequest{
+		AccountId: account.Id,
+		Products: []*pb.OrderedProduct{
+			{Id: keyboard.Id, Quantity: 2},
+			{Id: mouse.Id, Quantity: 1},
+		},
+	})
+	if err != nil {
+		t.Fatalf("PostOrder: %v", err)
+	}
+
+	if res.Order.TotalPrice.GetUnits() != 250 {
+		t.Errorf("TotalPrice = %v, want 250", res.Order.TotalPrice)
+	}
+	if res.Order.AccountId != account.Id {
+		t.Errorf("AccountId = %d, want %d", res.Order.AccountId, account.Id)
+	}
+	if len(res.Order.Products) != 2 {
+		t.Errorf("got %d products, want 2", len(res.Order.Products))
+	}
+}
+
+func TestPostOrderCapturesPayment(t *testing.T) {
+	h := newHarness(t)
+	account := h.account(t, "angga")
+	keyboard := h.product(t, "keyboard", 100)
+
+	order := h.order(t, account.Id, keyboard)
+
+	if order.PaymentId == 0 {
+		t.Fatal("order has no payment")
+	}
+	if got := h.Payments.status(order.PaymentId); got != paypb.PaymentStatus_PAYMENT_STATUS_CAPTURED {
+		t.Errorf("payment status = %v, want captured", got)
+	}
+}
+
+func TestPostOrderRetriedWithRequestID(t *testing.T) {
+	h := newHarness(t)
+	account := h.account(t, "angga")
+	keyboard := h.product(t, "keyboard", 100)
+
+	req := &pb.PostOrderRequest{
+		AccountId: account.Id,
+		Products:  []*pb.OrderedProduct{{Id: keyboard.Id, Quantity: 1}},
+		RequestId: "checkout-1",
+	}
+
+	first, err := h.Order.PostOrder(context.Background(), req)
+	if err != nil {
+		t.Fatalf("PostOrder: %v", err)
+	}
+
+	// the client timed out and tries again
+	retry, err := h.Order.PostOrder(context.Background(), req)
+	if err != nil {
+		t.Fatalf("retried PostOrder: %v", err)
+	}
+
+	if retry.Order.Id != first.Order.Id || retry.Order.PaymentId != first.Order.PaymentId {
+		t.Errorf("retry placed order %d paid by %d, want order %d paid by %d", retry.Order.Id, retry.Order.PaymentId, first.Order.Id, first.Order.PaymentId)
+	}
+	if p := retry.Order.Products; len(p) != 1 || p[0].Name != "keyboard" {
+		t.Errorf("retried order products = %v, want the named keyboard", p)
+	}
+
+	h.Payments.mu.Lock()
+	payments := len(h.Payments.payments)
+	h.Payments.mu.Unlock()
+	if payments != 1 {
+		t.Errorf("authorized %d payments, want 1", payments)
+	}
+
+	// another request is another order
+	req.RequestId = "checkout-2"
+	second, err := h.Order.PostOrder(context.Background(), req)
+	if err != nil {
+		t.Fatalf("PostOrder: %v", err)
+	}
+	if second.Order.Id == first.Order.Id || second.Order.PaymentId == first.Order.PaymentId {
+		t.Errorf("second request got order %d paid by %d, want a new order and payment", second.Order.Id, second.Order.PaymentId)
+	}
+}
+
+func TestPostOrderPaymentDeclined(t *testing.T) {
+	h := newHarness(t)
+	account := h.account(t, "angga")
+	keyboard := h.product(t, "keyboard", 100)
+	h.Payments.declineAmount = 100
+
+	_, err := h.Order.PostOrder(context.Background(), &pb.PostOrderRequest{
+		AccountId: account.Id,
+		Products:  []*pb.OrderedProduct{{Id: keyboard.Id, Quantity: 1}},
+	})
+	if status.Code(err) != codes.FailedPrecondition {
+		t.Fatalf("PostOrder error = %v, want FailedPrecondition", err)
+	}
+
+	orders, err := h.Repo.GetOrdersByAccountID(context.Background(), account.Id)
+	if err != nil {
+		t.Fatalf("GetOrdersByAccountID: %v", err)
+	}
+	if len(orders) != 0 {
+		t.Errorf("got %d orders after a declined payment, want 0", len(orders))
+	}
+}
+
+func TestPostOrderWithTax(t *testing.T) {
+	h := newHarness(t)
+	ctx := context.Background()
+	account := h.account(t, "angga")
+	keyboard := h.product(t, "keyboard", 100)
+
+	book, err := h.Catalog.PostProduct(ctx, &catpb.PostProductRequest{Name: "book", Price: pbUSD(40), TaxCategory: "reduced"})
+	if err != nil {
+		t.Fatalf("PostProduct: %v", err)
+	}
+
+	res, err := h.Order.PostOrder(ctx, &pb.PostOrderRequest{
+		AccountId: account.Id,
+		Products:  []*pb.OrderedProduct{{Id: keyboard.Id, Quantity: 1}, {Id: book.Product.Id, Quantity: 1}},
+		TaxRegion: "GB",
+	})
+	if err != nil {
+		t.Fatalf("PostOrder: %v", err)
+	}
+
+	// 20% on the keyboard, 5% on the book
+	order := res.Order
+	if order.SubtotalPrice.GetUnits() != 140 || order.TaxTotal.GetUnits() != 22 || order.TotalPrice.GetUnits() != 162 {
+		t.Errorf("subtotal %v tax %v total %v, want 140, 22 and 162", order.SubtotalPrice, order.TaxTotal, order.TotalPrice)
+	}
+	if order.TaxRegion != "GB" || len(order.Taxes) != 2 {
+		t.Errorf("tax region %q with %d tax lines, want GB with 2", order.TaxRegion, len(order.Taxes))
+	}
+
+	h.Payments.mu.Lock()
+	paid := h.Payments.payments[order.PaymentId].Amount
+	h.Payments.mu.Unlock()
+	if paid != 162 {
+		t.Errorf("authorized %d, want 162 including tax", paid)
+	}
+
+	_, err = h.Order.PostOrder(ctx, &pb.PostOrderRequest{
+		AccountId: account.Id,
+		Products:  []*pb.OrderedProduct{{Id: keyboard.Id, Quantity: 1}},
+		TaxRegion: "XX",
+	})
+	if status.Code(err) != codes.InvalidArgument {
+		t.Errorf("PostOrder in an unknown region error = %v, want InvalidArgument", err)
+	}
+}
+
+func TestPostOrderInCurrency(t *testing.T) {
+	h := newHarness(t)
+	ctx := context.Background()
+	account := h.account(t, "angga")
+	mouse := h.product(t, "mouse", 500)
+
+	keyboard, err := h.Catalog.PostProduct(ctx, &catpb.PostProductRequest{Name: "keyboard", Price: pbUSD(1000), Prices: []*catpb.Money{{Currency: "EUR", Units: 899}}})
+	if err != nil {
+		t.Fatalf("PostProduct: %v", err)
+	}
+
+	products := []*pb.OrderedProduct{{Id: keyboard.Product.Id, Quantity: 1}, {Id: mouse.Id, Quantity: 1}}
+
+	_, err = h.Order.PostOrder(ctx, &pb.PostOrderRequest{AccountId: account.Id, Products: products, Currency: "EUR"})
+	if status.Code(err) != codes.FailedPrecondition {
+		t.Fatalf("PostOrder in EUR without a rate error = %v, want FailedPrecondition", err)
+	}
+
+	if _, err := h.Catalog.SetExchangeRate(ctx, &catpb.SetExchangeRateRequest{FromCurrency: "USD", ToCurrency: "EUR", Rate: "0.92"}); err != nil {
+		t.Fatalf("SetExchangeRate: %v", err)
+	}
+
+	res, err := h.Order.PostOrder(ctx, &pb.PostOrderRequest{AccountId: account.Id, Products: products, Currency: "EUR"})
+	if err != nil {
+		t.Fatalf("PostOrder: %v", err)
+	}
+
+	// the keyboard at its EUR list price, the mouse converted
+	order := res.Order
+	if order.TotalPrice.GetCurrency() != "EUR" || order.TotalPrice.GetUnits() != 899+460 {
+		t.Errorf("total = %v, want EUR 1359", order.TotalPrice)
+	}
+	if len(order.ExchangeRates) != 1 || order.ExchangeRates[0].FromCurrency != "USD" || order.ExchangeRates[0].Rate != "0.92" {
+		t.Errorf("exchange rates = %v, want only USD to EUR at 0.92", order.ExchangeRates)
+	}
+
+	h.Payments.mu.Lock()
+	paid := h.Payments.payments[order.PaymentId]
+	h.Payments.mu.Unlock()
+	if paid.Currency != "EUR" || paid.Amount != 1359 {
+		t.Errorf("authorized %d %s, want 1359 EUR", paid.Amount, paid.Currency)
+	}
+
+	orders, err := h.Order.GetOrdersByAccountID(h.as(account.Id), &pb.GetOrdersByAccountIDRequest{AccountId: account.Id})
+	if err != nil {
+		t.Fatalf("GetOrdersByAccountID: %v", err)
+	}
+
+	got := orders.Orders[0]
+	if len(got.ExchangeRates) != 1 {
+		t.Errorf("stored exchange rates = %v, want the one used", got.ExchangeRates)
+	}
+	for _, p := range got.Products {
+		if p.Price.GetCurrency() != "EUR" {
+			t.Errorf("product %s priced %v, want EUR like its order", p.Id, p.Price)
+		}
+	}
+}
+
+func TestPostOrderShippingAddress(t *testing.T) {
+	h := newHarness(t)
+	ctx := context.Background()
+	account := h.account(t, "angga")
+	keyboard := h.product(t, "keyboard", 100)
+	products := []*pb.OrderedProduct{{Id: keyboard.Id, Quantity: 1}}
+
+	res, err := h.Order.PostOrder(ctx, &pb.PostOrderRequest{AccountId: account.Id, Products: products})
+	if err != nil {
+		t.Fatalf("PostOrder: %v", err)
+	}
+	if res.Order.ShippingAddress != nil {
+		t.Errorf("shipping address = %v, want none for an account without addresses", res.Order.ShippingAddress)
+	}
+
+	home, err := h.Accounts.AddAddress(ctx, &accpb.AddAddressRequest{AccountId: account.Id, Address: &accpb.Address{Name: "Angga", Line1: "Jl. Sudirman 1", City: "Jakarta", Country: "ID"}})
+	if err != nil {
+		t.Fatalf("AddAddress: %v", err)
+	}
+	work, err := h.Accounts.AddAddress(ctx, &accpb.AddAddressRequest{AccountId: account.Id, Address: &accpb.Address{Name: "Angga", Line1: "Jl. Thamrin 2", City: "Jakarta", Country: "ID"}})
+	if err != nil {
+		t.Fatalf("AddAddress: %v", err)
+	}
+
+	res, err = h.Order.PostOrder(ctx, &pb.PostOrderRequest{AccountId: account.Id, Products: products})
+	if err != nil {
+		t.Fatalf("PostOrder: %v", err)
+	}
+	if res.Order.ShippingAddress.GetId() != home.Address.Id {
+		t.Errorf("shipping address = %v, want the default %d", res.Order.ShippingAddress, home.Address.Id)
+	}
+
+	res, err = h.Order.PostOrder(ctx, &pb.PostOrderRequest{AccountId: account.Id, Products: products, AddressId: work.Address.Id})
+	if err != nil {
+		t.Fatalf("PostOrder: %v", err)
+	}
+	orderID := res.Order.Id
+
+	// editing the address book doesn't change where the order went
+	if _, err := h.Accounts.UpdateAddress(ctx, &accpb.UpdateAddressRequest{AccountId: account.Id, Address: &accpb.Address{Id: work.Address.Id, Name: "Angga", Line1: "Jl. Gatot Subroto 3", City: "Jakarta", Country: "ID"}}); err != nil {
+		t.Fatalf("UpdateAddress: %v", err)
+	}
+
+	orders, err := h.Order.GetOrdersByAccountID(h.as(account.Id), &pb.GetOrdersByAccountIDRequest{AccountId: account.Id})
+	if err != nil {
+		t.Fatalf("GetOrdersByAccountID: %v", err)
+	}
+	for _, o := range orders.Orders {
+		if o.Id == orderID && o.ShippingAddress.GetLine1() != "Jl. Thamrin 2" {
+			t.Errorf("order shipping address = %v, want the address as it was ordered", o.ShippingAddress)
+		}
+	}
+
+	other := h.account(t, "other")
+	_, err = h.Order.PostOrder(ctx, &pb.PostOrderRequest{AccountId: other.Id, Products: products, AddressId: home.Address.Id})
+	if status.Code(err) != codes.NotFound {
+		t.Errorf("PostOrder to another account's address error = %v, want NotFound", err)
+	}
+}
+
+func TestPostOrderWithCoupon(t *testing.T) {
+	h := newHarness(t)
+	ctx := context.Background()
+	account := h.account(t, "angga")
+	keyboard := h.product(t, "keyboard", 100)
+	admin := h.withKey(h.account(t, "admin").Id, []string{"*:*:*"}, permissionManagePromotions)
+
+	promo, err := h.Promos.CreatePromotion(admin, &pb.CreatePromotionRequest{Promotion: &pb.Promotion{
+		Code:       "HALF",
+		Name:       "half off keyboards",
+		Kind:       pb.PromotionKind_PROMOTION_KIND_PERCENTAGE,
+		PercentOff: 50,
+		ProductId:  keyboard.Id,
+		UsageLimit: 1,
+	}})
+	if err != nil {
+		t.Fatalf("CreatePromotion: %v", err)
+	}
+
+	req := &pb.PostOrderRequest{
+		AccountId:   account.Id,
+		Products:    []*pb.OrderedProduct{{Id: keyboard.Id, Quantity: 2}},
+		CouponCodes: []string{"HALF"},
+	}
+
+	res, err := h.Order.PostOrder(ctx, req)
+	if err != nil {
+		t.Fatalf("PostOrder: %v", err)
+	}
+
+	order := res.Order
+	if order.SubtotalPrice.GetUnits() != 200 || order.TotalPrice.GetUnits() != 100 {
+		t.Errorf("subtotal %v total %v, want 200 and 100", order.SubtotalPrice, order.TotalPrice)
+	}
+	if len(order.Discounts) != 1 || order.Discounts[0].PromotionId != promo.Promotion.Id || order.Discounts[0].ProductId != keyboard.Id {
+		t.Errorf("discounts = %v, want one line discount from promotion %d", order.Discounts, promo.Promotion.Id)
+	}
+
+	h.Payments.mu.Lock()
+	paid := h.Payments.payments[order.PaymentId].Amount
+	h.Payments.mu.Unlock()
+	if paid != 100 {
+		t.Errorf("authorized %d, want the discounted 100", paid)
+	}
+
+	// the coupon was limited to one use
+	if _, err := h.Order.PostOrder(ctx, req); status.Code(err) != codes.InvalidArgument {
+		t.Errorf("second PostOrder error = %v, want InvalidArgument", err)
+	}
+}
+
+func TestPromotionsNeedAManager(t *testing.T) {
+	h := newHarness(t)
+	customer := h.account(t, "angga")
+	free := &pb.CreatePromotionRequest{Promotion: &pb.Promotion{Name: "everything free", Kind: pb.PromotionKind_PROMOTION_KIND_PERCENTAGE, PercentOff: 100}}
+
+	if _, err := h.Promos.CreatePromotion(context.Background(), free); status.Code(err) != codes.Unauthenticated {
+		t.Errorf("anonymous CreatePromotion error = %v, want Unauthenticated", err)
+	}
+	if _, err := h.Promos.CreatePromotion(h.as(customer.Id), free); status.Code(err) != codes.PermissionDenied {
+		t.Errorf("CreatePromotion by a customer error = %v, want PermissionDenied", err)
+	}
+	if _, err := h.Promos.ListPromotions(h.as(customer.Id), &pb.ListPromotionsRequest{}); status.Code(err) != codes.PermissionDenied {
+		t.Errorf("ListPromotions by a customer error = %v, want PermissionDenied", err)
+	}
+
+	manager := h.withKey(h.account(t, "ops").Id, []string{"order:promotions:manage"}, permissionManagePromotions)
+	res, err := h.Promos.CreatePromotion(manager, free)
+	if err != nil {
+		t.Fatalf("CreatePromotion by a manager: %v", err)
+	}
+	if _, err := h.Promos.DeactivatePromotion(h.as(customer.Id), &pb.DeactivatePromotionRequest{Id: res.Promotion.Id}); status.Code(err) != codes.PermissionDenied {
+		t.Errorf("DeactivatePromotion by a customer error = %v, want PermissionDenied", err)
+	}
+}
+
+func TestPostOrderUnknownAccount(t *testing.T) {
+	h := newHarness(t)
+	keyboard := h.product(t, "keyboard", 100)
+
+	_, err := h.Order.PostOrder(context.Background(), &pb.PostOrderRequest{
+		AccountId: 42,
+		Products:  []*pb.OrderedProduct{{Id: keyboard.Id, Quantity: 1}},
+	})
+
+	if status.Code(err) != codes.NotFound {
+		t.Fatalf("PostOrder error = %v, want NotFound", err)
+	}
+}
+
+func TestPostOrderSuspendedAccount(t *testing.T) {
+	h := newHarness(t)
+	account := h.account(t, "angga")
+	keyboard := h.product(t, "keyboard", 100)
+	ctx := context.Background()
+
+	req := &pb.PostOrderRequest{AccountId: account.Id, Products: []*pb.OrderedProduct{{Id: keyboard.Id, Quantity: 1}}}
+
+	if _, err := h.Order.PostOrder(ctx, req); err != nil {
+		t.Fatalf("PostOrder: %v", err)
+	}
+
+	if _, err := h.Accounts.SuspendAccount(ctx, &accpb.SuspendAccountRequest{Id: account.Id, Reason: "chargeback"}); err != nil {
+		t.Fatalf("SuspendAccount: %v", err)
+	}
+
+	if _, err := h.Order.PostOrder(ctx, req); status.Code(err) != codes.FailedPrecondition {
+		t.Errorf("PostOrder for a suspended account error = %v, want FailedPrecondition", err)
+	}
+
+	res, err := h.Order.GetOrdersByAccountID(h.as(account.Id), &pb.GetOrdersByAccountIDRequest{AccountId: account.Id})
+	if err != nil {
+		t.Fatalf("GetOrdersByAccountID for a suspended account: %v", err)
+	}
+	if len(res.Orders) != 1 {
+		t.Errorf("got %d orders, want the 1 placed before suspension", len(res.Orders))
+	}
+}
+
+func TestPostOrderUnknownProduct(t *testing.T) {
+	h := newHarness(t)
+	account := h.account(t, "angga")
+	keyboard := h.product(t, "keyboard", 100)
+
+	_, err := h.Order.PostOrder(context.Background(), &pb.PostOrderRequest{
+		AccountId: account.Id,
+		Products: []*pb.OrderedProduct{
+			{Id: keyboard.Id, Quantity: 1},
+			{Id: "missing", Quantity: 1},
+		},
+	})
+	if err == nil {
+		t.Fatal("PostOrder with a missing product succeeded")
+	}
+
+	orders, _ := h.Repo.GetOrdersByAccountID(context.Background(), account.Id)
+	if len(orders) != 0 {
+		t.Errorf("stored %d orders after a failed PostOrder, want 0", len(orders))
+	}
+}
+
+func TestGetOrdersByAccountID(t *testing.T) {
+	h := newHarness(t)
+	account := h.account(t, "angga")
+	other := h.account(t, "other")
+	keyboard := h.product(t, "keyboard", 100)
+	mouse := h.product(t, "mouse", 50)
+
+	ctx := context.Background()
+
+	for _, r := range []*pb.PostOrderRequest{
+		{AccountId: account.Id, Products: []*pb.OrderedProduct{{Id: keyboard.Id, Quantity: 1}}},
+		{AccountId: account.Id, Products: []*pb.OrderedProduct{{Id: mouse.Id, Quantity: 3}}},
+		{AccountId: other.Id, Products: []*pb.OrderedProduct{{Id: mouse.Id, Quantity: 1}}},
+	} {
+		if _, err := h.Order.PostOrder(ctx, r); err != nil {
+			t.Fatalf("PostOrder: %v", err)
+		}
+	}
+
+	res, err := h.Order.GetOrdersByAccountID(h.as(account.Id), &pb.GetOrdersByAccountIDRequest{AccountId: account.Id})
+	if err != nil {
+		t.Fatalf("GetOrdersByAccountID: %v", err)
+	}
+
+	if len(res.Orders) != 2 {
+		t.Fatalf("got %d orders, want 2", len(res.Orders))
+	}
+
+	for _, o := range res.Orders {
+		for _, p := range o.Products {
+			if p.Name == "" || p.Price.GetUnits() == 0 {
+				t.Errorf("order %d product %s was not enriched from catalog: %v", o.Id, p.Id, p)
+			}
+		}
+	}
+}
+
+func TestGetOrdersByAccountIDKeepsOrderedPrices(t *testing.T) {
+	h := newHarness(t)
+	ctx := context.Background()
+	account := h.account(t, "angga")
+	mouse := h.product(t, "mouse", 500)
+
+	if _, err := h.Catalog.SetExchangeRate(ctx, &catpb.SetExchangeRateRequest{FromCurrency: "USD", ToCurrency: "EUR", Rate: "0.92"}); err != nil {
+		t.Fatalf("SetExchangeRate: %v", err)
+	}
+
+	if _, err := h.Order.PostOrder(ctx, &pb.PostOrderRequest{AccountId: account.Id, Products: []*pb.OrderedProduct{{Id: mouse.Id, Quantity: 2}}, Currency: "EUR"}); err != nil {
+		t.Fatalf("PostOrder: %v", err)
+	}
+
+	// the mouse is repriced and the rate it was converted at is removed
+	h.Catalog.mu.Lock()
+	h.Catalog.products[mouse.Id].Price = pbUSD(700)
+	delete(h.Catalog.rates, "USD-EUR")
+	h.Catalog.mu.Unlock()
+
+	res, err := h.Order.GetOrdersByAccountID(h.as(account.Id), &pb.GetOrdersByAccountIDRequest{AccountId: account.Id})
+	if err != nil {
+		t.Fatalf("GetOrdersByAccountID: %v", err)
+	}
+
+	if len(res.Orders) != 1 || len(res.Orders[0].Products) != 1 {
+		t.Fatalf("orders = %v, want one order of one product", res.Orders)
+	}
+
+	if p := res.Orders[0].Products[0]; p.Price.GetCurrency() != "EUR" || p.Price.GetUnits() != 460 || p.Name != "mouse" {
+		t.Errorf("ordered product = %v, want the mouse at the EUR 460 it was ordered at", p)
+	}
+}
+
+func TestGetOrdersByAccountIDOfAnotherAccount(t *testing.T) {
+	h := newHarness(t)
+	account := h.account(t, "angga")
+	other := h.account(t, "other")
+	// permissions come from the account's roles, so support is its own account
+	support := h.account(t, "support")
+	h.order(t, account.Id, h.product(t, "keyboard", 100))
+
+	for _, tt := range []struct {
+		name string
+		ctx  context.Context
+		code codes.Code
+	}{
+		{"another customer", h.as(other.Id), codes.PermissionDenied},
+		{"support", h.withKey(support.Id, []string{"order:orders:*"}, permissionReadAnyOrders), codes.OK},
+		{"support with a key not scoped for it", h.withKey(support.Id, []string{"order:orders:read"}, permissionReadAnyOrders), codes.PermissionDenied},
+	} {
+		_, err := h.Order.GetOrdersByAccountID(tt.ctx, &pb.GetOrdersByAccountIDRequest{AccountId: account.Id})
+		if status.Code(err) != tt.code {
+			t.Errorf("GetOrdersByAccountID as %s error = %v, want %v", tt.name, err, tt.code)
+		}
+	}
+}
+
+func TestGetOrdersByAccountIDUnknownAccount(t *testing.T) {
+	h := newHarness(t)
+
+	support := h.withKey(h.account(t, "support").Id, []string{"*:*:*"}, permissionReadAnyOrders)
+
+	_, err := h.Order.GetOrdersByAccountID(support, &pb.GetOrdersByAccountIDRequest{AccountId: 42})
+
+	if status.Code(err) != codes.NotFound {
+		t.Fatalf("GetOrdersByAccountID error = %v, want NotFound", err)
+	}
+}
+
+func TestAPIKeysAreChecked(t *testing.T) {
+	h := newHarness(t)
+	account := h.account(t, "angga")
+
+	for _, tt := range []struct {
+		name string
+		ctx  context.Context
+		code codes.Code
+	}{
+		{"no key", context.Background(), codes.Unauthenticated},
+		{"scoped key", h.withKey(account.Id, []string{"order:orders:read"}), codes.OK},
+		{"wildcard key", h.withKey(account.Id, []string{"order:*:*"}), codes.OK},
+		{"key for carts", h.withKey(account.Id, []string{"order:carts:read"}), codes.PermissionDenied},
+		{"unknown key", metadata.AppendToOutgoingContext(context.Background(), "x-api-key", "ak_made_up"), codes.Unauthenticated},
+	} {
+		_, err := h.Order.GetOrdersByAccountID(tt.ctx, &pb.GetOrdersByAccountIDRequest{AccountId: account.Id})
+		if status.Code(err) != tt.code {
+			t.Errorf("GetOrdersByAccountID with %s error = %v, want %v", tt.name, err, tt.code)
+		}
+	}
+}
+
+func TestPostOrderCatalogUnavailable(t *testing.T) {
+	s := &Server{
+		Svc:           NewService(NewMemoryRepository(), NewTaxRules(DefaultTaxRules), ""),
+		AccountClient: &fakeAccountClient{accounts: map[int32]*accpb.Account{1: {Id: 1, Name: "angga"}}},
+		CatalogClient: &fakeCatalogClient{err: status.Error(codes.Unavailable, "catalog is down")},
+	}
+
+	_, err := s.PostOrder(context.Background(), &pb.PostOrderRequest{
+		AccountId: 1,
+		Products:  []*pb.OrderedProduct{{Id: "product-1", Quantity: 1}},
+	})
+
+	if status.Code(err) != codes.Unavailable {
+		t.Fatalf("PostOrder error = %v, want Unavailable", err)
+	}
+}
+
+func TestPostOrderUsesCatalogPrices(t *testing.T) {
+	s := &Server{
+		Svc:           NewService(NewMemoryRepository(), NewTaxRules(DefaultTaxRules), ""),
+		AccountClient: &fakeAccountClient{accounts: map[int32]*accpb.Account{1: {Id: 1, Name: "angga"}}},
+		CatalogClient: &fakeCatalogClient{products: map[string]*catpb.Product{"p": {Id: "p", Name: "p", Price: pbUSD(7)}}},
+		PaymentClient: &fakePaymentClient{},
+	}
+
+	// the price sent by the caller must be ignored in favour of the catalog's
+	res, err := s.PostOrder(context.Background(), &pb.PostOrderRequest{
+		AccountId: 1,
+		Products:  []*pb.OrderedProduct{{Id: "p", Price: pbUSD(1), Quantity: 3}},
+	})
+	if err != nil {
+		t.Fatalf("PostOrder: %v", err)
+	}
+
+	if res.Order.TotalPrice.GetUnits() != 21 {
+		t.Errorf("TotalPrice = %v, want 21", res.Order.TotalPrice)
+	}
+}
+
+func TestWatchOrders(t *testing.T) {
+	h := newHarness(t)
+	angga := h.account(t, "angga")
+	other := h.account(t, "other")
+	keyboard := h.product(t, "keyboard", 100)
+
+	ctx, cancel := context.WithCancel(h.as(angga.Id))
+	defer cancel()
+
+	stream, err := h.Order.WatchOrders(ctx, &pb.WatchOrdersRequest{AccountId: angga.Id})
+	if err != nil {
+		t.Fatalf("WatchOrders: %v", err)
+	}
+	// headers are sent once the watch is subscribed
+	if _, err := stream.Header(); err != nil {
+		t.Fatalf("Header: %v", err)
+	}
+
+	h.order(t, other.Id, keyboard)
+	placed := h.order(t, angga.Id, keyboard)
+	h.relay(t)
+
+	e, err := stream.Recv()
+	if err != nil {
+		t.Fatalf("Recv: %v", err)
+	}
+
+	if e.Type != EventOrderPlaced || e.Order.Id != placed.Id {
+		t.Errorf("got %s for order %d, want %s for order %d", e.Type, e.Order.Id, EventOrderPlaced, placed.Id)
+	}
+	if e.Order.TotalPrice.GetUnits() != 100 || len(e.Order.Products) != 1 {
+		t.Errorf("got order %v, want one keyboard for 100", e.Order)
+	}
+
+	admin := h.withKey(h.account(t, "admin").Id, []string{"*:*:*"}, permissionManageOrders)
+	if _, err := h.Order.UpdateOrderStatus(admin, &pb.UpdateOrderStatusRequest{Id: placed.Id, Status: pb.OrderStatus_ORDER_STATUS_SHIPPED}); err != nil {
+		t.Fatalf("UpdateOrderStatus: %v", err)
+	}
+	h.relay(t)
+
+	e, err = stream.Recv()
+	if err != nil {
+		t.Fatalf("Recv: %v", err)
+	}
+
+	if e.Type != EventOrderStatusChanged || e.Order.Id != placed.Id || e.Order.Status != pb.OrderStatus_ORDER_STATUS_SHIPPED {
+		t.Errorf("got %s for order %d (%v), want %s to shipped for order %d", e.Type, e.Order.Id, e.Order.Status, EventOrderStatusChanged, placed.Id)
+	}
+}
+
+func TestWatchOrdersOfAnotherAccount(t *testing.T) {
+	h := newHarness(t)
+	angga := h.account(t, "angga")
+	other := h.account(t, "other")
+
+	tests := []struct {
+		name string
+		ctx  context.Context
+		code codes.Code
+	}{
+		{"anonymous", context.Background(), codes.Unauthenticated},
+		{"another account", h.as(other.Id), codes.PermissionDenied},
+		{"key not scoped for orders", h.withKey(angga.Id, []string{"order:carts:read"}), codes.PermissionDenied},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			stream, err := h.Order.WatchOrders(tt.ctx, &pb.WatchOrdersRequest{AccountId: angga.Id})
+			if err != nil {
+				t.Fatalf("WatchOrders: %v", err)
+			}
+
+			if _, err := stream.Recv(); status.Code(err) != tt.code {
+				t.Errorf("Recv error = %v, want %v", err, tt.code)
+			}
+		})
+	}
+
+	support := h.withKey(other.Id, []string{"*:*:*"}, permissionReadAnyOrders)
+	stream, err := h.Order.WatchOrders(support, &pb.WatchOrdersRequest{AccountId: angga.Id})
+	if err != nil {
+		t.Fatalf("WatchOrders: %v", err)
+	}
+	if _, err := stream.Header(); err != nil {
+		t.Errorf("WatchOrders by a caller that may read any orders: %v", err)
+	}
+}
+
+func TestUpdateOrderStatus(t *testing.T) {
+	h := newHarness(t)
+	account := h.account(t, "angga")
+	keyboard := h.product(t, "keyboard", 100)
+	order := h.order(t, account.Id, keyboard)
+	admin := h.withKey(h.account(t, "admin").Id, []string{"*:*:*"}, permissionManageOrders)
+
+	update := func(ctx context.Context, id int32, s pb.OrderStatus) error {
+		_, err := h.Order.UpdateOrderStatus(ctx, &pb.UpdateOrderStatusRequest{Id: id, Status: s})
+		return err
+	}
+
+	if err := update(h.as(account.Id), order.Id, pb.OrderStatus_ORDER_STATUS_DELIVERED); status.Code(err) != codes.PermissionDenied {
+		t.Errorf("UpdateOrderStatus by the customer error = %v, want PermissionDenied", err)
+	}
+	if err := update(admin, order.Id+1, pb.OrderStatus_ORDER_STATUS_SHIPPED); status.Code(err) != codes.NotFound {
+		t.Errorf("UpdateOrderStatus of an unknown order error = %v, want NotFound", err)
+	}
+	if err := update(admin, order.Id, pb.OrderStatus_ORDER_STATUS_UNSPECIFIED); status.Code(err) != codes.InvalidArgument {
+		t.Errorf("UpdateOrderStatus to no status error = %v, want InvalidArgument", err)
+	}
+	// orders are shipped before they are delivered
+	if err := update(admin, order.Id, pb.OrderStatus_ORDER_STATUS_DELIVERED); status.Code(err) != codes.FailedPrecondition {
+		t.Errorf("UpdateOrderStatus from placed to delivered error = %v, want FailedPrecondition", err)
+	}
+	if err := update(admin, order.Id, pb.OrderStatus_ORDER_STATUS_SHIPPED); err != nil {
+		t.Fatalf("UpdateOrderStatus to shipped: %v", err)
+	}
+	if err := update(admin, order.Id, pb.OrderStatus_ORDER_STATUS_SHIPPED); status.Code(err) != codes.FailedPrecondition {
+		t.Errorf("UpdateOrderStatus to shipped again error = %v, want FailedPrecondition", err)
+	}
+
+	res, err := h.Order.GetOrdersByAccountID(h.as(account.Id), &pb.GetOrdersByAccountIDRequest{AccountId: account.Id})
+	if err != nil {
+		t.Fatalf("GetOrdersByAccountID: %v", err)
+	}
+	if len(res.Orders) != 1 || res.Orders[0].Status != pb.OrderStatus_ORDER_STATUS_SHIPPED {
+		t.Errorf("orders = %v, want the order shipped", res.Orders)
+	}
+}
+
+func TestWatchOrdersResume(t *testing.T) {
+	h := newHarness(t)
+	account := h.account(t, "angga")
+	keyboard := h.product(t, "keyboard", 100)
+
+	h.order(t, account.Id, keyboard)
+	h.relay(t)
+
+	ctx, cancel := context.WithCancel(h.as(account.Id))
+	stream, err := h.Order.WatchOrders(ctx, &pb.WatchOrdersRequest{AccountId: account.Id})
+	if err != nil {
+		t.Fatalf("WatchOrders: %v", err)
+	}
+	if _, err := stream.Header(); err != nil {
+		t.Fatalf("Header: %v", err)
+	}
+
+	h.order(t, account.Id, keyboard)
+	h.relay(t)
+
+	first, err := stream.Recv()
+	if err != nil {
+		t.Fatalf("Recv: %v", err)
+	}
+	cancel()
+
+	// orders placed while disconnected are replayed on resume
+	missed := h.order(t, account.Id, keyboard)
+	h.relay(t)
+
+	ctx, cancel = context.WithCancel(h.as(account.Id))
+	defer cancel()
+
+	stream, err = h.Order.WatchOrders(ctx, &pb.WatchOrdersRequest{AccountId: account.Id, LastEventId: first.Id})
+	if err != nil {
+		t.Fatalf("WatchOrders: %v", err)
+	}
+
+	e, err := stream.Recv()
+	if err != nil {
+		t.Fatalf("Recv: %v", err)
+	}
+	if e.Order.Id != missed.Id {
+		t.Errorf("resumed with order %d, want %d", e.Order.Id, missed.Id)
+	}
+}
+
+func TestWatchOrdersUnknownLastEvent(t *testing.T) {
+	h := newHarness(t)
+	account := h.account(t, "angga")
+
+	stream, err := h.Order.WatchOrders(h.as(account.Id), &pb.WatchOrdersRequest{AccountId: account.Id, LastEventId: "gone"})
+	if err != nil {
+		t.Fatalf("WatchOrders: %v", err)
+	}
+
+	if _, err := stream.Recv(); status.Code(err) != codes.OutOfRange {
+		t.Fatalf("Recv error = %v, want OutOfRange", err)
+	}
+}
+
+func TestAuditLog(t *testing.T) {
+	h := newHarness(t)
+	ops := h.account(t, "ops")
+	// x-actor is ignored: calls are attributed to the key's account
+	ctx := metadata.AppendToOutgoingContext(h.withKey(ops.Id, []string{"*:*:*"}, permissionReadAudit, permissionManagePromotions), "x-actor", "spoofed", "x-request-id", "req-1")
+	opsActor := "account:" + strconv.Itoa(int(ops.Id))
+	account := h.account(t, "angga")
+	keyboard := h.product(t, "keyboard", 100)
+
+	promo, err := h.Promos.CreatePromotion(ctx, &pb.CreatePromotionRequest{Promotion: &pb.Promotion{
+		Name:       "ten off",
+		Kind:       pb.PromotionKind_PROMOTION_KIND_PERCENTAGE,
+		PercentOff: 10,
+	}})
+	if err != nil {
+		t.Fatalf("CreatePromotion: %v", err)
+	}
+
+	if _, err := h.Promos.DeactivatePromotion(ctx, &pb.DeactivatePromotionRequest{Id: promo.Promotion.Id}); err != nil {
+		t.Fatalf("DeactivatePromotion: %v", err)
+	}
+
+	// failed calls change nothing, so they aren't audited
+	if _, err := h.Promos.DeactivatePromotion(ctx, &pb.DeactivatePromotionRequest{Id: promo.Promotion.Id + 1}); err == nil {
+		t.Fatal("DeactivatePromotion of an unknown promotion succeeded")
+	}
+
+	if _, err := h.Cart.AddItem(context.Background(), &pb.AddItemRequest{AccountId: account.Id, ProductId: keyboard.Id, Quantity: 1}); err != nil {
+		t.Fatalf("AddItem: %v", err)
+	}
+
+	order := h.order(t, account.Id, keyboard)
+
+	res, err := h.Order.QueryAuditLog(ctx, &pb.QueryOrderAuditLogRequest{EntityType: "promotion", EntityId: strconv.Itoa(int(promo.Promotion.Id))})
+	if err != nil {
+		t.Fatalf("QueryAuditLog: %v", err)
+	}
+
+	if len(res.Entries) != 2 {
+		t.Fatalf("QueryAuditLog returned %d entries, want 2: %v", len(res.Entries), res.Entries)
+	}
+
+	deactivated, created := res.Entries[0], res.Entries[1]
+
+	if deactivated.Action != "DeactivatePromotion" || deactivated.Actor != opsActor || deactivated.RequestId != "req-1" {
+		t.Errorf("newest entry = %v, want DeactivatePromotion by %s in req-1", deactivated, opsActor)
+	}
+	if !strings.Contains(deactivated.Before, `"active":true`) || strings.Contains(deactivated.After, `"active":true`) {
+		t.Errorf("DeactivatePromotion entry before = %s, after = %s, want active then inactive", deactivated.Before, deactivated.After)
+	}
+	if created.Action != "CreatePromotion" || created.Before != "" || created.After == "" {
+		t.Errorf("oldest entry = %v, want CreatePromotion with only an after", created)
+	}
+
+	anonymous, err := h.Order.QueryAuditLog(ctx, &pb.QueryOrderAuditLogRequest{Actor: "anonymous"})
+	if err != nil {
+		t.Fatalf("QueryAuditLog by actor: %v", err)
+	}
+	if len(anonymous.Entries) != 2 {
+		t.Fatalf("QueryAuditLog by anonymous = %v, want the PostOrder and AddItem entries", anonymous.Entries)
+	}
+	if e := anonymous.Entries[0]; e.Action != "PostOrder" || e.EntityType != "order" || e.EntityId != strconv.Itoa(int(order.Id)) {
+		t.Errorf("newest anonymous entry = %v, want PostOrder of order %d", e, order.Id)
+	}
+	if e := anonymous.Entries[1]; e.Action != "AddItem" || e.EntityType != "cart" || !strings.Contains(e.After, keyboard.Id) {
+		t.Errorf("oldest anonymous entry = %v, want AddItem of %s to the cart", e, keyboard.Id)
+	}
+
+	if _, err := h.Order.QueryAuditLog(h.as(account.Id), &pb.QueryOrderAuditLogRequest{}); status.Code(err) != codes.PermissionDenied {
+		t.Errorf("QueryAuditLog by a customer error = %v, want PermissionDenied", err)
+	}
+}
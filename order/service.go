@@ -1,28 +1,114 @@
 package main
 
-import "context"
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"time"
+
+	"github.com/airlangga-hub/microservices/audit"
+)
 
 type Service interface {
-	PostOrder(ctx context.Context, accountID int32, products []OrderedProduct) (Order, error)
+	PriceOrder(ctx context.Context, products []OrderedProduct, couponCodes []string, taxRegion string) (Pricing, error)
+	PostOrder(ctx context.Context, accountID int32, requestID string, pricing Pricing, shippingAddress *Address, paymentID int32) (Order, error)
 	GetOrdersByAccountID(ctx context.Context, accountID int32) ([]*Order, error)
+	GetOrder(ctx context.Context, id int32) (Order, error)
+	GetOrderByRequestID(ctx context.Context, accountID int32, requestID string) (Order, error)
+	CaptureDone(ctx context.Context, paymentID int32) error
+	UpdateOrderStatus(ctx context.Context, id int32, to OrderStatus) (Order, error)
+	QueryAuditLog(ctx context.Context, q audit.Query) ([]audit.Entry, error)
+}
+
+var (
+	errOrderNotFound       = errors.New("order not found")
+	errInvalidStatusChange = errors.New("invalid order status change")
+	errOrderRequestTaken   = errors.New("the account already placed an order with this request id")
+)
+
+// orderStatusChanges lists the statuses an order may move to from each
+// status.
+var orderStatusChanges = map[OrderStatus][]OrderStatus{
+	OrderPlaced:  {OrderShipped},
+	OrderShipped: {OrderDelivered},
 }
 
 type service struct {
-	repository Repository
+	repository       Repository
+	tax              TaxCalculator
+	defaultTaxRegion string
 }
 
-func NewService(r Repository) Service {
-	return &service{r}
+// NewService prices orders with tax, charging orders placed without a tax
+// region as if they were shipped to defaultTaxRegion. With no default those
+// orders are not taxed.
+func NewService(r Repository, tax TaxCalculator, defaultTaxRegion string) Service {
+	return &service{r, tax, defaultTaxRegion}
 }
 
-func (s *service) PostOrder(ctx context.Context, accountID int32, products []OrderedProduct) (Order, error) {
-	order := Order{
-		AccountID: accountID,
-		Products:  products,
+// PriceOrder applies promotions, then taxes what is left of each line, so
+// the total it returns is the amount to authorize before PostOrder.
+func (s *service) PriceOrder(ctx context.Context, products []OrderedProduct, couponCodes []string, taxRegion string) (Pricing, error) {
+	now := time.Now().UTC()
+
+	promotions, err := s.repository.LivePromotions(ctx, couponCodes, now)
+	if err != nil {
+		return Pricing{}, err
+	}
+
+	pricing, err := priceOrder(products, promotions, couponCodes, now)
+	if err != nil {
+		return Pricing{}, err
+	}
+
+	if taxRegion == "" {
+		taxRegion = s.defaultTaxRegion
+	}
+	if taxRegion == "" {
+		return pricing, nil
+	}
+
+	lines, err := taxableLines(pricing)
+	if err != nil {
+		return Pricing{}, err
+	}
+
+	taxes, err := s.tax.Calculate(ctx, taxRegion, lines)
+	if err != nil {
+		return Pricing{}, err
+	}
+
+	pricing.TaxRegion = taxRegion
+	pricing.Taxes = taxes
+	for _, t := range taxes {
+		if pricing.TaxTotal, err = pricing.TaxTotal.Add(t.Amount); err != nil {
+			return Pricing{}, err
+		}
+	}
+
+	if pricing.Total, err = pricing.Total.Add(pricing.TaxTotal); err != nil {
+		return Pricing{}, err
 	}
 
-	for _, p := range products {
-		order.TotalPrice += p.Price * int64(p.Quantity)
+	return pricing, nil
+}
+
+func (s *service) PostOrder(ctx context.Context, accountID int32, requestID string, pricing Pricing, shippingAddress *Address, paymentID int32) (Order, error) {
+	order := Order{
+		AccountID:       accountID,
+		Products:        pricing.Products,
+		SubtotalPrice:   pricing.Subtotal,
+		Discounts:       pricing.Discounts,
+		TaxRegion:       pricing.TaxRegion,
+		Taxes:           pricing.Taxes,
+		TaxTotal:        pricing.TaxTotal,
+		TotalPrice:      pricing.Total,
+		PaymentID:       paymentID,
+		ExchangeRates:   pricing.ExchangeRates,
+		ShippingAddress: shippingAddress,
+		Status:          OrderPlaced,
+		RequestID:       requestID,
 	}
 
 	return s.repository.CreateOrder(ctx, order)
@@ -31,3 +117,36 @@ func (s *service) PostOrder(ctx context.Context, accountID int32, products []Ord
 func (s *service) GetOrdersByAccountID(ctx context.Context, accountID int32) ([]*Order, error) {
 	return s.repository.GetOrdersByAccountID(ctx, accountID)
 }
+
+func (s *service) GetOrder(ctx context.Context, id int32) (Order, error) {
+	return s.repository.GetOrder(ctx, id)
+}
+
+func (s *service) GetOrderByRequestID(ctx context.Context, accountID int32, requestID string) (Order, error) {
+	return s.repository.GetOrderByRequestID(ctx, accountID, requestID)
+}
+
+func (s *service) CaptureDone(ctx context.Context, paymentID int32) error {
+	return s.repository.CaptureDone(ctx, paymentID)
+}
+
+func (s *service) UpdateOrderStatus(ctx context.Context, id int32, to OrderStatus) (Order, error) {
+	order, err := s.repository.GetOrder(ctx, id)
+	if err != nil {
+		return Order{}, err
+	}
+
+	if !slices.Contains(orderStatusChanges[order.Status], to) {
+		return Order{}, fmt.Errorf("%w: order %d is %s", errInvalidStatusChange, id, order.Status)
+	}
+
+	return s.repository.UpdateOrderStatus(ctx, order, to)
+}
+
+func (s *service) QueryAuditLog(ctx context.Context, q audit.Query) ([]audit.Entry, error) {
+	if q.Limit > 100 || (q.Offset == 0 && q.Limit == 0) {
+		q.Limit = 100
+	}
+
+	return s.repository.QueryAuditLog(ctx, q)
+}
@@ -0,0 +1,227 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/airlangga-hub/microservices/money"
+)
+
+// DefaultTaxCategory is assumed for products the catalog has no tax category
+// for.
+const DefaultTaxCategory = "standard"
+
+var errUnknownTaxRegion = errors.New("no tax rules for region")
+
+// TaxableLine is an order line as taxed: its price after line and order
+// discounts.
+type TaxableLine struct {
+	ProductID string
+	Category  string
+	Amount    money.Money
+}
+
+// TaxLine is one tax charged on one order line. Rate is in basis points.
+type TaxLine struct {
+	ProductID string      `json:"product_id"`
+	Category  string      `json:"category"`
+	Name      string      `json:"name"`
+	Rate      int32       `json:"rate"`
+	Taxable   money.Money `json:"taxable"`
+	Amount    money.Money `json:"amount"`
+}
+
+// TaxCalculator works out the taxes on an order shipped to region. The local
+// rules engine implements it; an external tax service can be dropped in
+// behind the same interface. An unknown region is errUnknownTaxRegion.
+type TaxCalculator interface {
+	Calculate(ctx context.Context, region string, lines []TaxableLine) ([]TaxLine, error)
+}
+
+// TaxRule charges Rate basis points on products of Category shipped to
+// Region. A rule without a Category applies to the region's products that no
+// rule names the category of, and several rules for the same region and
+// category stack, like a state and a city sales tax.
+type TaxRule struct {
+	Region   string `json:"region"`
+	Category string `json:"category"`
+	Name     string `json:"name"`
+	Rate     int32  `json:"rate"`
+}
+
+// DefaultTaxRules is used when no rules file is configured.
+var DefaultTaxRules = []TaxRule{
+	{Region: "ID", Name: "PPN", Rate: 1100},
+	{Region: "ID", Category: "exempt", Name: "PPN", Rate: 0},
+	{Region: "GB", Name: "VAT", Rate: 2000},
+	{Region: "GB", Category: "reduced", Name: "VAT", Rate: 500},
+	{Region: "GB", Category: "exempt", Name: "VAT", Rate: 0},
+	{Region: "DE", Name: "MwSt", Rate: 1900},
+	{Region: "DE", Category: "reduced", Name: "MwSt", Rate: 700},
+	{Region: "DE", Category: "exempt", Name: "MwSt", Rate: 0},
+	{Region: "US-CA", Name: "CA sales tax", Rate: 725},
+	{Region: "US-CA", Category: "exempt", Name: "CA sales tax", Rate: 0},
+}
+
+// LoadTaxRules reads a JSON array of TaxRule from path.
+func LoadTaxRules(path string) ([]TaxRule, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	rules := []TaxRule{}
+	if err := json.Unmarshal(content, &rules); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+
+	for i, r := range rules {
+		if r.Region == "" || r.Name == "" || r.Rate < 0 || r.Rate > 10000 {
+			return nil, fmt.Errorf("%s: rule %d needs a region, a name and a rate between 0 and 10000", path, i)
+		}
+	}
+
+	return rules, nil
+}
+
+// taxRules is the local, table-driven TaxCalculator.
+type taxRules struct {
+	byRegion map[string][]TaxRule
+}
+
+func NewTaxRules(rules []TaxRule) TaxCalculator {
+	t := &taxRules{byRegion: map[string][]TaxRule{}}
+
+	for _, r := range rules {
+		t.byRegion[r.Region] = append(t.byRegion[r.Region], r)
+	}
+
+	return t
+}
+
+func (t *taxRules) Calculate(ctx context.Context, region string, lines []TaxableLine) ([]TaxLine, error) {
+	regionRules, exist := t.byRegion[region]
+	if !exist {
+		return nil, fmt.Errorf("%w: %s", errUnknownTaxRegion, region)
+	}
+
+	taxes := []TaxLine{}
+
+	for _, line := range lines {
+		for _, r := range matchTaxRules(regionRules, line.Category) {
+			// half up, to the smallest currency unit
+			amount, err := line.Amount.MulRatio(int64(r.Rate), 10000)
+			if err != nil {
+				return nil, err
+			}
+			if amount.IsZero() {
+				continue
+			}
+
+			taxes = append(taxes, TaxLine{
+				ProductID: line.ProductID,
+				Category:  line.Category,
+				Name:      r.Name,
+				Rate:      r.Rate,
+				Taxable:   line.Amount,
+				Amount:    amount,
+			})
+		}
+	}
+
+	return taxes, nil
+}
+
+func matchTaxRules(rules []TaxRule, category string) []TaxRule {
+	matched := []TaxRule{}
+	fallback := []TaxRule{}
+
+	for _, r := range rules {
+		switch r.Category {
+		case category:
+			matched = append(matched, r)
+		case "":
+			fallback = append(fallback, r)
+		}
+	}
+
+	if len(matched) > 0 {
+		return matched
+	}
+
+	return fallback
+}
+
+// taxableLines spreads q's discounts over its lines: a line discount comes
+// off its own line, and the order discount is split in proportion to what
+// is left of each line, with the rounding remainder on the last one.
+func taxableLines(q Pricing) ([]TaxableLine, error) {
+	lines := []TaxableLine{}
+	orderDiscount := money.New(q.Subtotal.Currency, 0)
+	net := money.New(q.Subtotal.Currency, 0)
+
+	for _, d := range q.Discounts {
+		if d.ProductID != "" {
+			continue
+		}
+
+		var err error
+		if orderDiscount, err = orderDiscount.Add(d.Amount); err != nil {
+			return nil, err
+		}
+	}
+
+	for _, p := range q.Products {
+		amount, err := p.Price.Mul(int64(p.Quantity))
+		if err != nil {
+			return nil, err
+		}
+
+		for _, d := range q.Discounts {
+			if d.ProductID != p.ID {
+				continue
+			}
+			if amount, err = amount.Sub(d.Amount); err != nil {
+				return nil, err
+			}
+		}
+
+		category := p.TaxCategory
+		if category == "" {
+			category = DefaultTaxCategory
+		}
+
+		lines = append(lines, TaxableLine{ProductID: p.ID, Category: category, Amount: amount})
+
+		if net, err = net.Add(amount); err != nil {
+			return nil, err
+		}
+	}
+
+	if orderDiscount.IsZero() || net.IsZero() {
+		return lines, nil
+	}
+
+	remaining := orderDiscount
+	for i := range lines {
+		share, err := orderDiscount.MulRatio(lines[i].Amount.Units, net.Units)
+		if err != nil {
+			return nil, err
+		}
+		if i == len(lines)-1 {
+			share = remaining
+		}
+
+		if lines[i].Amount, err = lines[i].Amount.Sub(share); err != nil {
+			return nil, err
+		}
+		if remaining, err = remaining.Sub(share); err != nil {
+			return nil, err
+		}
+	}
+
+	return lines, nil
+}
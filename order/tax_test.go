@@ -0,0 +1,119 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"testing"
+)
+
+func TestTaxRules(t *testing.T) {
+	tax := NewTaxRules([]TaxRule{
+		{Region: "GB", Name: "VAT", Rate: 2000},
+		{Region: "GB", Category: "reduced", Name: "VAT", Rate: 500},
+		{Region: "GB", Category: "exempt", Name: "VAT", Rate: 0},
+		{Region: "US-NY", Name: "NY state tax", Rate: 400},
+		{Region: "US-NY", Name: "NYC sales tax", Rate: 450},
+	})
+
+	tests := []struct {
+		name      string
+		region    string
+		lines     []TaxableLine
+		wantTotal int64
+		wantLines int
+		wantErr   error
+	}{
+		{
+			name:      "region default rate",
+			region:    "GB",
+			lines:     []TaxableLine{{ProductID: "keyboard", Category: "standard", Amount: usd(1000)}},
+			wantTotal: 200,
+			wantLines: 1,
+		},
+		{
+			name:   "category rate",
+			region: "GB",
+			lines: []TaxableLine{
+				{ProductID: "keyboard", Category: "standard", Amount: usd(1000)},
+				{ProductID: "book", Category: "reduced", Amount: usd(1000)},
+			},
+			wantTotal: 250,
+			wantLines: 2,
+		},
+		{
+			name:      "exempt category",
+			region:    "GB",
+			lines:     []TaxableLine{{ProductID: "bread", Category: "exempt", Amount: usd(1000)}},
+			wantTotal: 0,
+			wantLines: 0,
+		},
+		{
+			name:      "stacked rules",
+			region:    "US-NY",
+			lines:     []TaxableLine{{ProductID: "keyboard", Category: "standard", Amount: usd(1000)}},
+			wantTotal: 85,
+			wantLines: 2,
+		},
+		{
+			name:      "rounds half up",
+			region:    "GB",
+			lines:     []TaxableLine{{ProductID: "cable", Category: "reduced", Amount: usd(10)}},
+			wantTotal: 1,
+			wantLines: 1,
+		},
+		{
+			name:    "unknown region",
+			region:  "FR",
+			lines:   []TaxableLine{{ProductID: "keyboard", Category: "standard", Amount: usd(1000)}},
+			wantErr: errUnknownTaxRegion,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			taxes, err := tax.Calculate(context.Background(), tt.region, tt.lines)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("error = %v, want %v", err, tt.wantErr)
+			}
+
+			var total int64
+			for _, l := range taxes {
+				total += l.Amount.Units
+			}
+
+			if total != tt.wantTotal || len(taxes) != tt.wantLines {
+				t.Errorf("got %d in %d lines, want %d in %d lines", total, len(taxes), tt.wantTotal, tt.wantLines)
+			}
+		})
+	}
+}
+
+func TestTaxableLines(t *testing.T) {
+	q := Pricing{
+		Subtotal: usd(350),
+		Products: []OrderedProduct{
+			{ID: "keyboard", Price: usd(100), Quantity: 3, TaxCategory: "standard"},
+			{ID: "mouse", Price: usd(50), Quantity: 1},
+		},
+		Discounts: []Discount{
+			{PromotionID: 1, ProductID: "keyboard", Amount: usd(50)},
+			{PromotionID: 2, Amount: usd(31)},
+		},
+	}
+
+	// keyboard 250 and mouse 50 after the line discount, then the order
+	// discount is split 26 to 5
+	want := []TaxableLine{
+		{ProductID: "keyboard", Category: "standard", Amount: usd(224)},
+		{ProductID: "mouse", Category: DefaultTaxCategory, Amount: usd(45)},
+	}
+
+	got, err := taxableLines(q)
+	if err != nil {
+		t.Fatalf("taxableLines: %v", err)
+	}
+	if !slices.Equal(got, want) {
+		t.Errorf("taxableLines = %v, want %v", got, want)
+	}
+}
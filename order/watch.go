@@ -0,0 +1,154 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/airlangga-hub/microservices/events"
+)
+
+var (
+	errFeedClosed   = errors.New("order feed is closed")
+	errUnknownEvent = errors.New("last event id is not in the order feed history")
+	errWatcherSlow  = errors.New("watcher fell behind the order feed")
+)
+
+type orderEvent struct {
+	ID         string
+	Type       string
+	Order      Order
+	OccurredAt time.Time
+}
+
+// watcher receives the order events of one account. err is set before events
+// is closed and says why the feed stopped delivering.
+type watcher struct {
+	accountID int32
+	events    chan orderEvent
+	err       error
+}
+
+// orderFeed fans order events from the bus out to WatchOrders streams. It
+// keeps the most recent events so a client can resume after a disconnect, and
+// drops a watcher whose buffer is full instead of blocking the bus.
+type orderFeed struct {
+	mu          sync.Mutex
+	history     []orderEvent
+	historySize int
+	bufferSize  int
+	watchers    map[*watcher]struct{}
+	closed      bool
+}
+
+func newOrderFeed(historySize, bufferSize int) *orderFeed {
+	return &orderFeed{
+		historySize: historySize,
+		bufferSize:  bufferSize,
+		watchers:    map[*watcher]struct{}{},
+	}
+}
+
+// Publish is the bus handler. Events that are not about orders are ignored,
+// and events already in the history are dropped, since the outbox delivers
+// at least once.
+func (f *orderFeed) Publish(e events.Event) {
+	if e.Type != EventOrderPlaced && e.Type != EventOrderStatusChanged {
+		return
+	}
+
+	var order Order
+	if err := json.Unmarshal(e.Payload, &order); err != nil {
+		log.Println("ERROR: order feed Publish (unmarshal): ", err)
+		return
+	}
+
+	oe := orderEvent{ID: e.ID, Type: e.Type, Order: order, OccurredAt: e.OccurredAt}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.closed || slices.ContainsFunc(f.history, func(h orderEvent) bool { return h.ID == e.ID }) {
+		return
+	}
+
+	f.history = append(f.history, oe)
+	if len(f.history) > f.historySize {
+		f.history = slices.Delete(f.history, 0, len(f.history)-f.historySize)
+	}
+
+	for w := range f.watchers {
+		if w.accountID != order.AccountID {
+			continue
+		}
+
+		select {
+		case w.events <- oe:
+		default:
+			f.remove(w, errWatcherSlow)
+		}
+	}
+}
+
+// Subscribe registers a watcher for accountID. If lastEventID is set, the
+// events after it are returned for replay; they are not sent on the
+// watcher's channel.
+func (f *orderFeed) Subscribe(accountID int32, lastEventID string) (*watcher, []orderEvent, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.closed {
+		return nil, nil, errFeedClosed
+	}
+
+	replay := []orderEvent{}
+
+	if lastEventID != "" {
+		i := slices.IndexFunc(f.history, func(h orderEvent) bool { return h.ID == lastEventID })
+		if i < 0 {
+			return nil, nil, errUnknownEvent
+		}
+
+		for _, e := range f.history[i+1:] {
+			if e.Order.AccountID == accountID {
+				replay = append(replay, e)
+			}
+		}
+	}
+
+	w := &watcher{accountID: accountID, events: make(chan orderEvent, f.bufferSize)}
+	f.watchers[w] = struct{}{}
+
+	return w, replay, nil
+}
+
+func (f *orderFeed) Unsubscribe(w *watcher) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.remove(w, nil)
+}
+
+// Close ends every watch so graceful shutdown isn't held up by open streams.
+func (f *orderFeed) Close() {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.closed = true
+	for w := range f.watchers {
+		f.remove(w, errFeedClosed)
+	}
+}
+
+func (f *orderFeed) remove(w *watcher, err error) {
+	if _, ok := f.watchers[w]; !ok {
+		return
+	}
+
+	delete(f.watchers, w)
+	w.err = err
+	close(w.events)
+}
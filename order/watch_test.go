@@ -0,0 +1,53 @@
+package main
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/airlangga-hub/microservices/events"
+)
+
+func TestOrderFeedDropsSlowWatcher(t *testing.T) {
+	feed := newOrderFeed(10, 1)
+
+	slow, _, err := feed.Subscribe(1, "")
+	if err != nil {
+		t.Fatalf("Subscribe: %v", err)
+	}
+
+	for i := range 2 {
+		e, err := events.New(EventOrderPlaced, "1", Order{ID: int32(i + 1), AccountID: 1})
+		if err != nil {
+			t.Fatalf("events.New: %v", err)
+		}
+		feed.Publish(e)
+	}
+
+	<-slow.events
+	if _, ok := <-slow.events; ok {
+		t.Fatal("slow watcher still subscribed")
+	}
+	if !errors.Is(slow.err, errWatcherSlow) {
+		t.Errorf("err = %v, want errWatcherSlow", slow.err)
+	}
+}
+
+func TestOrderFeedDropsDuplicates(t *testing.T) {
+	feed := newOrderFeed(10, 10)
+
+	w, _, err := feed.Subscribe(1, "")
+	if err != nil {
+		t.Fatalf("Subscribe: %v", err)
+	}
+
+	e, err := events.New(EventOrderPlaced, "1", Order{ID: 1, AccountID: 1})
+	if err != nil {
+		t.Fatalf("events.New: %v", err)
+	}
+	feed.Publish(e)
+	feed.Publish(e)
+
+	if len(w.events) != 1 {
+		t.Errorf("got %d events, want 1", len(w.events))
+	}
+}
@@ -0,0 +1,97 @@
+// Package config loads and validates the payment service configuration.
+//
+// Every setting is read from its environment variable, falling back to the
+// JSON file named by PAYMENT_CONFIG_FILE, then to a default. Load reports all
+// invalid or missing settings at once.
+package config
+
+import (
+	"time"
+
+	"github.com/airlangga-hub/microservices/settings"
+)
+
+const (
+	StoragePostgres = "postgres"
+	StorageMemory   = "memory"
+
+	ProviderFake = "fake"
+)
+
+type Config struct {
+	Port            string
+	HTTPPort        string
+	Storage         string
+	DatabaseURL     string
+	MigrateOnStart  bool
+	DB              DB
+	Provider        Provider
+	ShutdownTimeout time.Duration
+}
+
+// DB holds the database/sql connection pool settings.
+type DB struct {
+	MaxOpenConns    int
+	MaxIdleConns    int
+	ConnMaxLifetime time.Duration
+}
+
+// Provider selects the payment provider and bounds every call made to it.
+type Provider struct {
+	Name        string
+	CallTimeout time.Duration
+}
+
+func Load() (Config, error) {
+	l := settings.NewLoader("PAYMENT_CONFIG_FILE")
+
+	cfg := Config{
+		Port:           l.String("PAYMENT_PORT", ""),
+		HTTPPort:       l.String("PAYMENT_HTTP_PORT", ":8080"),
+		MigrateOnStart: l.Bool("PAYMENT_MIGRATE_ON_START", false),
+		Provider: Provider{
+			Name:        l.String("PAYMENT_PROVIDER", ProviderFake),
+			CallTimeout: l.Duration("PAYMENT_PROVIDER_CALL_TIMEOUT", 5*time.Second),
+		},
+		ShutdownTimeout: l.Duration("PAYMENT_SHUTDOWN_TIMEOUT", 10*time.Second),
+	}
+
+	loadDatabase(l, &cfg)
+
+	l.Address("PAYMENT_PORT", cfg.Port)
+	l.Address("PAYMENT_HTTP_PORT", cfg.HTTPPort)
+	l.Check(cfg.Provider.Name == ProviderFake, "PAYMENT_PROVIDER must be %q", ProviderFake)
+	l.Check(cfg.Provider.CallTimeout > 0, "PAYMENT_PROVIDER_CALL_TIMEOUT must be positive")
+	l.Check(cfg.ShutdownTimeout > 0, "PAYMENT_SHUTDOWN_TIMEOUT must be positive")
+
+	return cfg, l.Err("payment")
+}
+
+// LoadDatabase loads only the storage and database settings, for commands
+// like migrate that don't run the service.
+func LoadDatabase() (Config, error) {
+	l := settings.NewLoader("PAYMENT_CONFIG_FILE")
+
+	cfg := Config{}
+	loadDatabase(l, &cfg)
+
+	return cfg, l.Err("payment")
+}
+
+func loadDatabase(l *settings.Loader, cfg *Config) {
+	cfg.Storage = l.String("PAYMENT_STORAGE", StoragePostgres)
+	cfg.DatabaseURL = l.String("PAYMENT_DB_URL", "")
+	cfg.DB = DB{
+		MaxOpenConns:    l.Int("PAYMENT_DB_MAX_OPEN_CONNS", 25),
+		MaxIdleConns:    l.Int("PAYMENT_DB_MAX_IDLE_CONNS", 5),
+		ConnMaxLifetime: l.Duration("PAYMENT_DB_CONN_MAX_LIFETIME", 30*time.Minute),
+	}
+
+	l.Check(cfg.Storage == StoragePostgres || cfg.Storage == StorageMemory, "PAYMENT_STORAGE must be %q or %q", StoragePostgres, StorageMemory)
+	if cfg.Storage == StoragePostgres {
+		l.Required("PAYMENT_DB_URL", cfg.DatabaseURL)
+	}
+	l.Check(cfg.DB.MaxOpenConns > 0, "PAYMENT_DB_MAX_OPEN_CONNS must be positive")
+	l.Check(cfg.DB.MaxIdleConns >= 0 && cfg.DB.MaxIdleConns <= cfg.DB.MaxOpenConns, "PAYMENT_DB_MAX_IDLE_CONNS must be between 0 and PAYMENT_DB_MAX_OPEN_CONNS")
+	l.Check(cfg.DB.ConnMaxLifetime > 0, "PAYMENT_DB_CONN_MAX_LIFETIME must be positive")
+}
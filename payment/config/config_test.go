@@ -0,0 +1,108 @@
+package config
+
+import (
+	"maps"
+	"strings"
+	"testing"
+	"time"
+)
+
+// required is the least a postgres-backed payment service needs set.
+var required = map[string]string{
+	"PAYMENT_PORT":   ":9093",
+	"PAYMENT_DB_URL": "postgres://payment_db/payment",
+}
+
+// setEnv sets env, clearing the required settings it leaves out and the
+// config file.
+func setEnv(t *testing.T, env map[string]string) {
+	t.Helper()
+
+	t.Setenv("PAYMENT_CONFIG_FILE", "")
+	for key := range required {
+		t.Setenv(key, "")
+	}
+	for key, value := range env {
+		t.Setenv(key, value)
+	}
+}
+
+func with(changes map[string]string) map[string]string {
+	env := maps.Clone(required)
+	maps.Copy(env, changes)
+	return env
+}
+
+func TestLoadReportsProblems(t *testing.T) {
+	tests := []struct {
+		name     string
+		env      map[string]string
+		problems []string
+	}{
+		{"valid", required, nil},
+		{"nothing set", nil, []string{"PAYMENT_PORT is required", "PAYMENT_DB_URL is required"}},
+		{"memory storage needs no database", with(map[string]string{"PAYMENT_STORAGE": "memory", "PAYMENT_DB_URL": ""}), nil},
+		{"unknown provider", with(map[string]string{"PAYMENT_PROVIDER": "stripe"}), []string{`PAYMENT_PROVIDER must be "fake"`}},
+		{"bad values", with(map[string]string{"PAYMENT_PROVIDER_CALL_TIMEOUT": "0s"}), []string{"PAYMENT_PROVIDER_CALL_TIMEOUT must be positive"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setEnv(t, tt.env)
+
+			_, err := Load()
+			if len(tt.problems) == 0 {
+				if err != nil {
+					t.Errorf("Load = %v, want no problems", err)
+				}
+				return
+			}
+
+			if err == nil {
+				t.Fatalf("Load succeeded, want %v", tt.problems)
+			}
+			for _, problem := range tt.problems {
+				if !strings.Contains(err.Error(), problem) {
+					t.Errorf("Load = %v, want it to report %s", err, problem)
+				}
+			}
+		})
+	}
+}
+
+func TestLoadDefaults(t *testing.T) {
+	setEnv(t, required)
+
+	cfg, err := Load()
+	if err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+
+	if cfg.Storage != StoragePostgres || cfg.HTTPPort != ":8080" || cfg.MigrateOnStart {
+		t.Errorf("Load = %+v, want postgres storage, HTTP on :8080 and no migrating on start", cfg)
+	}
+	if cfg.DB != (DB{MaxOpenConns: 25, MaxIdleConns: 5, ConnMaxLifetime: 30 * time.Minute}) {
+		t.Errorf("DB = %+v, want the default pool", cfg.DB)
+	}
+	if cfg.Provider != (Provider{Name: ProviderFake, CallTimeout: 5 * time.Second}) {
+		t.Errorf("Provider = %+v, want the fake provider", cfg.Provider)
+	}
+}
+
+// The migrate command runs without the service's port.
+func TestLoadDatabase(t *testing.T) {
+	setEnv(t, map[string]string{"PAYMENT_DB_URL": "postgres://payment_db/payment"})
+
+	cfg, err := LoadDatabase()
+	if err != nil {
+		t.Fatalf("LoadDatabase: %v", err)
+	}
+	if cfg.Storage != StoragePostgres || cfg.DatabaseURL != "postgres://payment_db/payment" || cfg.DB.MaxOpenConns != 25 {
+		t.Errorf("LoadDatabase = %+v, want the database settings", cfg)
+	}
+
+	setEnv(t, nil)
+	if _, err := LoadDatabase(); err == nil || !strings.Contains(err.Error(), "PAYMENT_DB_URL is required") {
+		t.Errorf("LoadDatabase without a database = %v, want PAYMENT_DB_URL required", err)
+	}
+}
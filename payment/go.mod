@@ -0,0 +1,25 @@
+module github.com/airlangga-hub/microservices/payment
+
+go 1.25.3
+
+require (
+	github.com/airlangga-hub/microservices/healthcheck v0.0.0
+	github.com/airlangga-hub/microservices/migrate v0.0.0
+	github.com/airlangga-hub/microservices/settings v0.0.0
+	github.com/lib/pq v1.10.9
+	google.golang.org/grpc v1.78.0
+	google.golang.org/protobuf v1.36.11
+)
+
+require (
+	golang.org/x/net v0.47.0 // indirect
+	golang.org/x/sys v0.38.0 // indirect
+	golang.org/x/text v0.31.0 // indirect
+	google.golang.org/genproto/googleapis/rpc v0.0.0-20251029180050-ab9386a59fda // indirect
+)
+
+replace (
+	github.com/airlangga-hub/microservices/healthcheck => ../healthcheck
+	github.com/airlangga-hub/microservices/migrate => ../migrate
+	github.com/airlangga-hub/microservices/settings => ../settings
+)
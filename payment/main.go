@@ -0,0 +1,146 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/airlangga-hub/microservices/healthcheck"
+	"github.com/airlangga-hub/microservices/payment/config"
+	"github.com/airlangga-hub/microservices/payment/pb"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/reflection"
+)
+
+func main() {
+	if len(os.Args) > 1 && os.Args[1] == "migrate" {
+		cfg, err := config.LoadDatabase()
+		if err != nil {
+			log.Fatalf("ERROR: payment main: %v", err)
+		}
+		if cfg.Storage != config.StoragePostgres {
+			log.Fatalf("ERROR: payment main: migrate needs %s storage", config.StoragePostgres)
+		}
+		if err := runMigrate(cfg.DatabaseURL, os.Args[2:]); err != nil {
+			log.Fatalf("ERROR: payment main: migrate: %v", err)
+		}
+		return
+	}
+
+	cfg, err := config.Load()
+	if err != nil {
+		log.Fatalf("ERROR: payment main: %v", err)
+	}
+
+	if cfg.MigrateOnStart && cfg.Storage == config.StoragePostgres {
+		if err := runMigrate(cfg.DatabaseURL, nil); err != nil {
+			log.Fatalf("ERROR: payment main: migrate on start: %v", err)
+		}
+	}
+
+	repository, err := openRepository(cfg)
+	if err != nil {
+		log.Fatalf("ERROR: payment main: couldn't create repository: %v", err)
+	}
+	defer repository.Close()
+
+	provider, err := openProvider(cfg)
+	if err != nil {
+		log.Fatalf("ERROR: payment main: couldn't create provider: %v", err)
+	}
+
+	service := NewService(repository, provider, cfg.Provider.CallTimeout)
+
+	lis, err := net.Listen("tcp", cfg.Port)
+	if err != nil {
+		log.Fatalf("ERROR: payment main: couldn't listen on %s: %v", cfg.Port, err)
+	}
+
+	s := grpc.NewServer()
+	pb.RegisterPaymentServiceServer(s, &Server{Svc: service})
+
+	healthServer := health.NewServer()
+	healthServer.SetServingStatus("", healthpb.HealthCheckResponse_NOT_SERVING)
+	healthpb.RegisterHealthServer(s, healthServer)
+	reflection.Register(s)
+
+	checker := healthcheck.New(healthServer, pb.PaymentService_ServiceDesc.ServiceName, repository.Ping)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	go checker.Run(ctx)
+
+	httpServer := &http.Server{Addr: cfg.HTTPPort, Handler: checker.HTTPHandler()}
+
+	exitChan := make(chan error, 1)
+
+	go func() {
+		sig := make(chan os.Signal, 1)
+		signal.Notify(sig, os.Interrupt, syscall.SIGTERM)
+		s := <-sig
+		exitChan <- fmt.Errorf("received signal: %v", s)
+	}()
+
+	go func() {
+		err := s.Serve(lis)
+		if err != nil && err != grpc.ErrServerStopped {
+			exitChan <- fmt.Errorf("grpc serve error: %v", err)
+		}
+	}()
+
+	go func() {
+		err := httpServer.ListenAndServe()
+		if err != nil && err != http.ErrServerClosed {
+			exitChan <- fmt.Errorf("http serve error: %v", err)
+		}
+	}()
+
+	err = <-exitChan
+	log.Printf("Shutting down. Reason: %v", err)
+
+	cancel()
+	healthServer.Shutdown()
+
+	shutdownCtx, shutdownCancel := context.WithTimeout(context.Background(), cfg.ShutdownTimeout)
+	defer shutdownCancel()
+	httpServer.Shutdown(shutdownCtx)
+
+	stopped := make(chan struct{})
+	go func() {
+		s.GracefulStop()
+		close(stopped)
+	}()
+
+	select {
+	case <-stopped:
+	case <-shutdownCtx.Done():
+		s.Stop()
+	}
+}
+
+func openRepository(cfg config.Config) (Repository, error) {
+	if cfg.Storage == config.StorageMemory {
+		log.Println("WARNING: payment main: using in-memory storage, data is lost on restart")
+		return NewMemoryRepository(), nil
+	}
+
+	return NewRepository(cfg.DatabaseURL, cfg.DB)
+}
+
+func openProvider(cfg config.Config) (Provider, error) {
+	switch cfg.Provider.Name {
+	case config.ProviderFake:
+		log.Println("WARNING: payment main: using the fake provider, no money is moved")
+		return NewFakeProvider(), nil
+	default:
+		return nil, fmt.Errorf("unknown provider %q", cfg.Provider.Name)
+	}
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// memoryRepository is a concurrency-safe, in-memory Repository with the same
+// semantics as the postgres one: serial IDs, one payment per idempotency key
+// and compare-and-set status updates. It is used for running without a
+// database and as a fake in tests.
+type memoryRepository struct {
+	mu       sync.RWMutex
+	payments map[int32]Payment
+	byKey    map[string]int32
+	nextID   int32
+}
+
+func NewMemoryRepository() Repository {
+	return &memoryRepository{payments: map[int32]Payment{}, byKey: map[string]int32{}}
+}
+
+func (r *memoryRepository) Close() error {
+	return nil
+}
+
+func (r *memoryRepository) Ping(ctx context.Context) error {
+	return nil
+}
+
+func (r *memoryRepository) CreatePayment(ctx context.Context, p Payment) (Payment, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if id, exist := r.byKey[p.IdempotencyKey]; exist {
+		return r.payments[id], nil
+	}
+
+	r.nextID++
+	p.ID = r.nextID
+	p.CreatedAt = time.Now().UTC()
+	p.UpdatedAt = p.CreatedAt
+
+	r.payments[p.ID] = p
+	r.byKey[p.IdempotencyKey] = p.ID
+
+	return p, nil
+}
+
+func (r *memoryRepository) GetPaymentByID(ctx context.Context, id int32) (Payment, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	p, exist := r.payments[id]
+	if !exist {
+		return Payment{}, ErrNotFound
+	}
+
+	return p, nil
+}
+
+func (r *memoryRepository) UpdatePayment(ctx context.Context, p Payment, from PaymentStatus) (Payment, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	stored, exist := r.payments[p.ID]
+	if !exist || stored.Status != from {
+		return Payment{}, errStaleStatus
+	}
+
+	stored.Status = p.Status
+	stored.ProviderRef = p.ProviderRef
+	stored.DeclineReason = p.DeclineReason
+	stored.UpdatedAt = time.Now().UTC()
+	r.payments[p.ID] = stored
+
+	return stored, nil
+}
@@ -0,0 +1,3 @@
+-- payments made before amounts had a currency were in USD
+ALTER TABLE payments ADD COLUMN IF NOT EXISTS currency TEXT NOT NULL DEFAULT 'USD';
+ALTER TABLE payments ALTER COLUMN currency DROP DEFAULT;
@@ -0,0 +1,34 @@
+// Package migrations holds the payment schema as embedded, versioned SQL files,
+// named and applied as the shared migrate package describes.
+package migrations
+
+import (
+	"context"
+	"database/sql"
+	"embed"
+
+	"github.com/airlangga-hub/microservices/migrate"
+)
+
+//go:embed *.sql
+var files embed.FS
+
+// advisoryLockID is an arbitrary key shared by every payment migration runner.
+const advisoryLockID = 7_301_004
+
+var runner = migrate.New(files, advisoryLockID)
+
+// Up applies every migration that hasn't been applied yet, in version order.
+func Up(ctx context.Context, db *sql.DB) error {
+	return runner.Up(ctx, db)
+}
+
+// Down reverts the latest steps applied migrations, newest first.
+func Down(ctx context.Context, db *sql.DB, steps int) error {
+	return runner.Down(ctx, db, steps)
+}
+
+// Version returns the highest applied migration version, or 0 when none are.
+func Version(ctx context.Context, db *sql.DB) (int64, error) {
+	return runner.Version(ctx, db)
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"sync"
+)
+
+// ErrDeclined is wrapped by providers when they refuse an authorization. Any
+// other error means the outcome is unknown and the call may be retried with
+// the same idempotency key.
+var ErrDeclined = errors.New("payment declined")
+
+// Provider is the payment processor the service delegates to. Every call
+// carries an idempotency key, and repeating a call with the same key must not
+// move money twice.
+type Provider interface {
+	Authorize(ctx context.Context, idempotencyKey string, amount int64, currency string) (ref string, err error)
+	Capture(ctx context.Context, idempotencyKey, ref string) error
+	Refund(ctx context.Context, idempotencyKey, ref string) error
+	Void(ctx context.Context, idempotencyKey, ref string) error
+}
+
+const (
+	// amounts ending in these cents make the fake provider misbehave, the
+	// same way card processors reserve test card numbers.
+	FakeDeclineCents = 51
+	FakeTimeoutCents = 52
+)
+
+// FakeProvider is a deterministic in-memory Provider for local runs and
+// tests. References are derived from the idempotency key, an amount ending
+// in FakeDeclineCents is declined and one ending in FakeTimeoutCents blocks
+// until ctx is done.
+type FakeProvider struct {
+	mu     sync.Mutex
+	states map[string]string
+}
+
+func NewFakeProvider() *FakeProvider {
+	return &FakeProvider{states: map[string]string{}}
+}
+
+func (p *FakeProvider) Authorize(ctx context.Context, idempotencyKey string, amount int64, currency string) (string, error) {
+	switch amount % 100 {
+	case FakeDeclineCents:
+		return "", fmt.Errorf("%w: insufficient funds", ErrDeclined)
+	case FakeTimeoutCents:
+		<-ctx.Done()
+		return "", ctx.Err()
+	}
+
+	sum := sha256.Sum256([]byte(idempotencyKey))
+	ref := "fake_" + hex.EncodeToString(sum[:12])
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if _, exist := p.states[ref]; !exist {
+		p.states[ref] = "authorized"
+	}
+
+	return ref, nil
+}
+
+func (p *FakeProvider) Capture(ctx context.Context, idempotencyKey, ref string) error {
+	return p.transition(ref, "authorized", "captured")
+}
+
+func (p *FakeProvider) Refund(ctx context.Context, idempotencyKey, ref string) error {
+	return p.transition(ref, "captured", "refunded")
+}
+
+func (p *FakeProvider) Void(ctx context.Context, idempotencyKey, ref string) error {
+	return p.transition(ref, "authorized", "voided")
+}
+
+// transition is idempotent: repeating a call that already succeeded is a
+// no-op, like a real provider replaying the stored response for a key.
+func (p *FakeProvider) transition(ref, from, to string) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	state, exist := p.states[ref]
+	if !exist {
+		// the fake forgets everything on restart, so trust the caller
+		state = from
+	}
+
+	switch {
+	case state == to:
+		return nil
+	case state != from:
+		return fmt.Errorf("fake provider: %s is %s, not %s", ref, state, from)
+	}
+
+	p.states[ref] = to
+	return nil
+}
@@ -0,0 +1,175 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"log"
+
+	"github.com/airlangga-hub/microservices/payment/config"
+	_ "github.com/lib/pq"
+)
+
+// errStaleStatus is returned by UpdatePayment when the payment is no longer
+// in the status the caller read it in.
+var errStaleStatus = errors.New("payment status changed concurrently")
+
+type Repository interface {
+	Close() error
+	Ping(ctx context.Context) error
+	// CreatePayment stores p unless a payment with its idempotency key
+	// exists, and returns whichever payment holds the key.
+	CreatePayment(ctx context.Context, p Payment) (Payment, error)
+	GetPaymentByID(ctx context.Context, id int32) (Payment, error)
+	// UpdatePayment saves p's status, provider reference and decline reason
+	// if the stored payment is still in status from.
+	UpdatePayment(ctx context.Context, p Payment, from PaymentStatus) (Payment, error)
+}
+
+type repository struct {
+	db *sql.DB
+}
+
+func NewRepository(dbUrl string, pool config.DB) (Repository, error) {
+	db, err := sql.Open("postgres", dbUrl)
+	if err != nil {
+		log.Println("ERROR: payment repo NewRepository (sql.Open): ", err)
+		return nil, errors.New("error connecting to db")
+	}
+
+	db.SetMaxOpenConns(pool.MaxOpenConns)
+	db.SetMaxIdleConns(pool.MaxIdleConns)
+	db.SetConnMaxLifetime(pool.ConnMaxLifetime)
+
+	if err := db.Ping(); err != nil {
+		log.Println("ERROR: payment repo NewRepository (db.Ping): ", err)
+		return nil, errors.New("error pinging db")
+	}
+
+	return &repository{db}, nil
+}
+
+func (r *repository) Close() error {
+	if err := r.db.Close(); err != nil {
+		log.Println("ERROR: payment repo Close: ", err)
+		return errors.New("error closing db")
+	}
+	return nil
+}
+
+func (r *repository) Ping(ctx context.Context) error {
+	if err := r.db.PingContext(ctx); err != nil {
+		log.Println("ERROR: payment repo Ping: ", err)
+		return errors.New("error pinging db")
+	}
+	return nil
+}
+
+const paymentColumns = `
+	id,
+	idempotency_key,
+	account_id,
+	amount,
+	currency,
+	status,
+	COALESCE(provider_ref, ''),
+	decline_reason,
+	created_at,
+	updated_at`
+
+func scanPayment(row *sql.Row) (Payment, error) {
+	p := Payment{}
+
+	err := row.Scan(
+		&p.ID,
+		&p.IdempotencyKey,
+		&p.AccountID,
+		&p.Amount,
+		&p.Currency,
+		&p.Status,
+		&p.ProviderRef,
+		&p.DeclineReason,
+		&p.CreatedAt,
+		&p.UpdatedAt,
+	)
+
+	return p, err
+}
+
+func (r *repository) CreatePayment(ctx context.Context, p Payment) (Payment, error) {
+	if _, err := r.db.ExecContext(
+		ctx,
+		`INSERT INTO payments (idempotency_key, account_id, amount, currency, status)
+		VALUES ($1, $2, $3, $4, $5)
+		ON CONFLICT (idempotency_key) DO NOTHING;`,
+		p.IdempotencyKey,
+		p.AccountID,
+		p.Amount,
+		p.Currency,
+		p.Status,
+	); err != nil {
+		log.Println("ERROR: payment repo CreatePayment (insert): ", err)
+		return Payment{}, errors.New("error creating payment")
+	}
+
+	p, err := scanPayment(r.db.QueryRowContext(
+		ctx,
+		`SELECT`+paymentColumns+`
+		FROM payments
+		WHERE idempotency_key = $1;`,
+		p.IdempotencyKey,
+	))
+	if err != nil {
+		log.Println("ERROR: payment repo CreatePayment (select): ", err)
+		return Payment{}, errors.New("error creating payment")
+	}
+
+	return p, nil
+}
+
+func (r *repository) GetPaymentByID(ctx context.Context, id int32) (Payment, error) {
+	p, err := scanPayment(r.db.QueryRowContext(
+		ctx,
+		`SELECT`+paymentColumns+`
+		FROM payments
+		WHERE id = $1;`,
+		id,
+	))
+	if errors.Is(err, sql.ErrNoRows) {
+		return Payment{}, ErrNotFound
+	}
+	if err != nil {
+		log.Println("ERROR: payment repo GetPaymentByID: ", err)
+		return Payment{}, errors.New("error finding payment")
+	}
+
+	return p, nil
+}
+
+func (r *repository) UpdatePayment(ctx context.Context, p Payment, from PaymentStatus) (Payment, error) {
+	updated, err := scanPayment(r.db.QueryRowContext(
+		ctx,
+		`UPDATE payments
+		SET
+			status = $1,
+			provider_ref = NULLIF($2, ''),
+			decline_reason = $3,
+			updated_at = NOW()
+		WHERE id = $4 AND status = $5
+		RETURNING`+paymentColumns+`;`,
+		p.Status,
+		p.ProviderRef,
+		p.DeclineReason,
+		p.ID,
+		from,
+	))
+	if errors.Is(err, sql.ErrNoRows) {
+		return Payment{}, errStaleStatus
+	}
+	if err != nil {
+		log.Println("ERROR: payment repo UpdatePayment: ", err)
+		return Payment{}, errors.New("error updating payment")
+	}
+
+	return updated, nil
+}
@@ -0,0 +1,141 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log"
+
+	"github.com/airlangga-hub/microservices/payment/pb"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+type Server struct {
+	pb.UnimplementedPaymentServiceServer
+	Svc Service
+}
+
+var pbStatuses = map[PaymentStatus]pb.PaymentStatus{
+	StatusPending:    pb.PaymentStatus_PAYMENT_STATUS_PENDING,
+	StatusAuthorized: pb.PaymentStatus_PAYMENT_STATUS_AUTHORIZED,
+	StatusDeclined:   pb.PaymentStatus_PAYMENT_STATUS_DECLINED,
+	StatusCaptured:   pb.PaymentStatus_PAYMENT_STATUS_CAPTURED,
+	StatusRefunded:   pb.PaymentStatus_PAYMENT_STATUS_REFUNDED,
+	StatusVoided:     pb.PaymentStatus_PAYMENT_STATUS_VOIDED,
+}
+
+func (s *Server) Authorize(ctx context.Context, r *pb.AuthorizeRequest) (*pb.AuthorizeResponse, error) {
+	payment, err := s.Svc.Authorize(ctx, r.IdempotencyKey, r.AccountId, r.Amount, r.Currency)
+	if err != nil {
+		return nil, toStatus(err)
+	}
+
+	p, err := toPB(payment)
+	if err != nil {
+		return nil, err
+	}
+
+	return &pb.AuthorizeResponse{Payment: p}, nil
+}
+
+func (s *Server) Capture(ctx context.Context, r *pb.CaptureRequest) (*pb.CaptureResponse, error) {
+	payment, err := s.Svc.Capture(ctx, r.Id)
+	if err != nil {
+		return nil, toStatus(err)
+	}
+
+	p, err := toPB(payment)
+	if err != nil {
+		return nil, err
+	}
+
+	return &pb.CaptureResponse{Payment: p}, nil
+}
+
+func (s *Server) Refund(ctx context.Context, r *pb.RefundRequest) (*pb.RefundResponse, error) {
+	payment, err := s.Svc.Refund(ctx, r.Id)
+	if err != nil {
+		return nil, toStatus(err)
+	}
+
+	p, err := toPB(payment)
+	if err != nil {
+		return nil, err
+	}
+
+	return &pb.RefundResponse{Payment: p}, nil
+}
+
+func (s *Server) Void(ctx context.Context, r *pb.VoidRequest) (*pb.VoidResponse, error) {
+	payment, err := s.Svc.Void(ctx, r.Id)
+	if err != nil {
+		return nil, toStatus(err)
+	}
+
+	p, err := toPB(payment)
+	if err != nil {
+		return nil, err
+	}
+
+	return &pb.VoidResponse{Payment: p}, nil
+}
+
+func (s *Server) GetPayment(ctx context.Context, r *pb.GetPaymentRequest) (*pb.GetPaymentResponse, error) {
+	payment, err := s.Svc.GetPayment(ctx, r.Id)
+	if err != nil {
+		return nil, toStatus(err)
+	}
+
+	p, err := toPB(payment)
+	if err != nil {
+		return nil, err
+	}
+
+	return &pb.GetPaymentResponse{Payment: p}, nil
+}
+
+// toStatus gives the service's errors grpc codes so callers can tell a
+// retryable failure from a request that will never succeed.
+func toStatus(err error) error {
+	switch {
+	case errors.Is(err, ErrNotFound):
+		return status.Error(codes.NotFound, err.Error())
+	case errors.Is(err, ErrInvalidArgument):
+		return status.Error(codes.InvalidArgument, err.Error())
+	case errors.Is(err, ErrIdempotencyMismatch):
+		return status.Error(codes.AlreadyExists, err.Error())
+	case errors.Is(err, ErrInvalidState):
+		return status.Error(codes.FailedPrecondition, err.Error())
+	case errors.Is(err, ErrProviderUnavailable):
+		return status.Error(codes.Unavailable, err.Error())
+	default:
+		return err
+	}
+}
+
+func toPB(p Payment) (*pb.Payment, error) {
+	createdAt, err := p.CreatedAt.MarshalBinary()
+	if err != nil {
+		log.Println("ERROR: payment server toPB (MarshalBinary): ", err)
+		return nil, errors.New("error encoding payment")
+	}
+
+	updatedAt, err := p.UpdatedAt.MarshalBinary()
+	if err != nil {
+		log.Println("ERROR: payment server toPB (MarshalBinary): ", err)
+		return nil, errors.New("error encoding payment")
+	}
+
+	return &pb.Payment{
+		Id:             p.ID,
+		IdempotencyKey: p.IdempotencyKey,
+		AccountId:      p.AccountID,
+		Amount:         p.Amount,
+		Currency:       p.Currency,
+		Status:         pbStatuses[p.Status],
+		ProviderRef:    p.ProviderRef,
+		DeclineReason:  p.DeclineReason,
+		CreatedAt:      createdAt,
+		UpdatedAt:      updatedAt,
+	}, nil
+}
@@ -0,0 +1,129 @@
+package main
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/airlangga-hub/microservices/payment/pb"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+)
+
+// startServer serves the payment service over an in-process bufconn listener
+// backed by an in-memory repository and the fake provider.
+func startServer(t *testing.T) pb.PaymentServiceClient {
+	t.Helper()
+
+	lis := bufconn.Listen(1 << 20)
+
+	s := grpc.NewServer()
+	pb.RegisterPaymentServiceServer(s, &Server{Svc: NewService(NewMemoryRepository(), NewFakeProvider(), 50*time.Millisecond)})
+
+	go s.Serve(lis)
+	t.Cleanup(s.Stop)
+
+	conn, err := grpc.NewClient(
+		"passthrough:///bufnet",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) { return lis.DialContext(ctx) }),
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+	)
+	if err != nil {
+		t.Fatalf("grpc.NewClient: %v", err)
+	}
+	t.Cleanup(func() { conn.Close() })
+
+	return pb.NewPaymentServiceClient(conn)
+}
+
+func TestAuthorizeCaptureRefund(t *testing.T) {
+	client := startServer(t)
+	ctx := context.Background()
+
+	auth, err := client.Authorize(ctx, &pb.AuthorizeRequest{IdempotencyKey: "order-1", AccountId: 1, Amount: 1000, Currency: "USD"})
+	if err != nil {
+		t.Fatalf("Authorize: %v", err)
+	}
+	if auth.Payment.Status != pb.PaymentStatus_PAYMENT_STATUS_AUTHORIZED || auth.Payment.ProviderRef == "" {
+		t.Fatalf("Authorize = %v, want authorized with a provider reference", auth.Payment)
+	}
+
+	captured, err := client.Capture(ctx, &pb.CaptureRequest{Id: auth.Payment.Id})
+	if err != nil {
+		t.Fatalf("Capture: %v", err)
+	}
+	if captured.Payment.Status != pb.PaymentStatus_PAYMENT_STATUS_CAPTURED {
+		t.Errorf("Capture status = %v, want captured", captured.Payment.Status)
+	}
+
+	if _, err := client.Void(ctx, &pb.VoidRequest{Id: auth.Payment.Id}); status.Code(err) != codes.FailedPrecondition {
+		t.Errorf("Void of a captured payment error = %v, want FailedPrecondition", err)
+	}
+
+	refunded, err := client.Refund(ctx, &pb.RefundRequest{Id: auth.Payment.Id})
+	if err != nil {
+		t.Fatalf("Refund: %v", err)
+	}
+	if refunded.Payment.Status != pb.PaymentStatus_PAYMENT_STATUS_REFUNDED {
+		t.Errorf("Refund status = %v, want refunded", refunded.Payment.Status)
+	}
+}
+
+func TestAuthorizeIsIdempotent(t *testing.T) {
+	client := startServer(t)
+	ctx := context.Background()
+
+	first, err := client.Authorize(ctx, &pb.AuthorizeRequest{IdempotencyKey: "order-1", AccountId: 1, Amount: 1000, Currency: "USD"})
+	if err != nil {
+		t.Fatalf("Authorize: %v", err)
+	}
+
+	again, err := client.Authorize(ctx, &pb.AuthorizeRequest{IdempotencyKey: "order-1", AccountId: 1, Amount: 1000, Currency: "USD"})
+	if err != nil {
+		t.Fatalf("Authorize again: %v", err)
+	}
+	if again.Payment.Id != first.Payment.Id || again.Payment.ProviderRef != first.Payment.ProviderRef {
+		t.Errorf("retried Authorize = %v, want %v", again.Payment, first.Payment)
+	}
+
+	_, err = client.Authorize(ctx, &pb.AuthorizeRequest{IdempotencyKey: "order-1", AccountId: 1, Amount: 2000, Currency: "USD"})
+	if status.Code(err) != codes.AlreadyExists {
+		t.Errorf("Authorize with a reused key error = %v, want AlreadyExists", err)
+	}
+}
+
+func TestAuthorizeDeclined(t *testing.T) {
+	client := startServer(t)
+
+	res, err := client.Authorize(context.Background(), &pb.AuthorizeRequest{IdempotencyKey: "order-1", AccountId: 1, Amount: 1000 + FakeDeclineCents, Currency: "USD"})
+	if err != nil {
+		t.Fatalf("Authorize: %v", err)
+	}
+
+	if res.Payment.Status != pb.PaymentStatus_PAYMENT_STATUS_DECLINED || res.Payment.DeclineReason == "" {
+		t.Errorf("Authorize = %v, want declined with a reason", res.Payment)
+	}
+}
+
+func TestAuthorizeProviderTimeout(t *testing.T) {
+	client := startServer(t)
+	ctx := context.Background()
+
+	_, err := client.Authorize(ctx, &pb.AuthorizeRequest{IdempotencyKey: "order-1", AccountId: 1, Amount: 1000 + FakeTimeoutCents, Currency: "USD"})
+	if status.Code(err) != codes.Unavailable {
+		t.Fatalf("Authorize error = %v, want Unavailable", err)
+	}
+
+	// the payment stays pending, so a later retry still reaches the provider
+	res, err := client.GetPayment(ctx, &pb.GetPaymentRequest{Id: 1})
+	if err != nil {
+		t.Fatalf("GetPayment: %v", err)
+	}
+	if res.Payment.Status != pb.PaymentStatus_PAYMENT_STATUS_PENDING {
+		t.Errorf("status = %v, want pending", res.Payment.Status)
+	}
+}
@@ -0,0 +1,181 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+)
+
+type PaymentStatus string
+
+const (
+	StatusPending    PaymentStatus = "pending"
+	StatusAuthorized PaymentStatus = "authorized"
+	StatusDeclined   PaymentStatus = "declined"
+	StatusCaptured   PaymentStatus = "captured"
+	StatusRefunded   PaymentStatus = "refunded"
+	StatusVoided     PaymentStatus = "voided"
+)
+
+var (
+	ErrNotFound            = errors.New("payment not found")
+	ErrInvalidArgument     = errors.New("invalid payment request")
+	ErrIdempotencyMismatch = errors.New("idempotency key was already used for a different payment")
+	ErrInvalidState        = errors.New("payment is not in a state that allows this")
+	ErrProviderUnavailable = errors.New("payment provider unavailable, retry with the same idempotency key")
+)
+
+// Payment is created pending before the provider is called, so a retry with
+// the same idempotency key finds it and repeats the provider call under the
+// same key instead of authorizing twice.
+type Payment struct {
+	ID             int32         `json:"id"`
+	IdempotencyKey string        `json:"idempotency_key"`
+	AccountID      int32         `json:"account_id"`
+	Amount         int64         `json:"amount"`
+	Currency       string        `json:"currency"`
+	Status         PaymentStatus `json:"status"`
+	ProviderRef    string        `json:"provider_ref"`
+	DeclineReason  string        `json:"decline_reason"`
+	CreatedAt      time.Time     `json:"created_at"`
+	UpdatedAt      time.Time     `json:"updated_at"`
+}
+
+type Service interface {
+	Authorize(ctx context.Context, idempotencyKey string, accountID int32, amount int64, currency string) (Payment, error)
+	Capture(ctx context.Context, id int32) (Payment, error)
+	Refund(ctx context.Context, id int32) (Payment, error)
+	Void(ctx context.Context, id int32) (Payment, error)
+	GetPayment(ctx context.Context, id int32) (Payment, error)
+}
+
+type service struct {
+	repository  Repository
+	provider    Provider
+	callTimeout time.Duration
+}
+
+func NewService(r Repository, p Provider, callTimeout time.Duration) Service {
+	return &service{r, p, callTimeout}
+}
+
+func (s *service) Authorize(ctx context.Context, idempotencyKey string, accountID int32, amount int64, currency string) (Payment, error) {
+	if idempotencyKey == "" || amount <= 0 {
+		return Payment{}, fmt.Errorf("%w: idempotency key and a positive amount are required", ErrInvalidArgument)
+	}
+
+	if !validCurrency(currency) {
+		return Payment{}, fmt.Errorf("%w: currency must be a three letter ISO 4217 code", ErrInvalidArgument)
+	}
+
+	p, err := s.repository.CreatePayment(ctx, Payment{
+		IdempotencyKey: idempotencyKey,
+		AccountID:      accountID,
+		Amount:         amount,
+		Currency:       currency,
+		Status:         StatusPending,
+	})
+	if err != nil {
+		return Payment{}, err
+	}
+
+	if p.AccountID != accountID || p.Amount != amount || p.Currency != currency {
+		return Payment{}, ErrIdempotencyMismatch
+	}
+
+	if p.Status != StatusPending {
+		return p, nil
+	}
+
+	callCtx, cancel := context.WithTimeout(ctx, s.callTimeout)
+	ref, err := s.provider.Authorize(callCtx, idempotencyKey, amount, currency)
+	cancel()
+
+	switch {
+	case errors.Is(err, ErrDeclined):
+		p.Status = StatusDeclined
+		p.DeclineReason = err.Error()
+	case err != nil:
+		log.Println("ERROR: payment service Authorize (provider): ", err)
+		return Payment{}, ErrProviderUnavailable
+	default:
+		p.Status = StatusAuthorized
+		p.ProviderRef = ref
+	}
+
+	return s.update(ctx, p, StatusPending)
+}
+
+func (s *service) Capture(ctx context.Context, id int32) (Payment, error) {
+	return s.transition(ctx, id, StatusAuthorized, StatusCaptured, "capture", s.provider.Capture)
+}
+
+func (s *service) Refund(ctx context.Context, id int32) (Payment, error) {
+	return s.transition(ctx, id, StatusCaptured, StatusRefunded, "refund", s.provider.Refund)
+}
+
+func (s *service) Void(ctx context.Context, id int32) (Payment, error) {
+	return s.transition(ctx, id, StatusAuthorized, StatusVoided, "void", s.provider.Void)
+}
+
+func (s *service) GetPayment(ctx context.Context, id int32) (Payment, error) {
+	return s.repository.GetPaymentByID(ctx, id)
+}
+
+// transition moves a payment from one status to another through the
+// provider. Asking for the status the payment is already in returns it
+// unchanged, so callers can retry freely.
+func (s *service) transition(ctx context.Context, id int32, from, to PaymentStatus, op string, call func(ctx context.Context, idempotencyKey, ref string) error) (Payment, error) {
+	p, err := s.repository.GetPaymentByID(ctx, id)
+	if err != nil {
+		return Payment{}, err
+	}
+
+	if p.Status == to {
+		return p, nil
+	}
+
+	if p.Status != from {
+		return Payment{}, fmt.Errorf("%w: payment %d is %s", ErrInvalidState, id, p.Status)
+	}
+
+	callCtx, cancel := context.WithTimeout(ctx, s.callTimeout)
+	err = call(callCtx, p.IdempotencyKey+":"+op, p.ProviderRef)
+	cancel()
+
+	if err != nil {
+		log.Printf("ERROR: payment service %s (provider): %v", op, err)
+		return Payment{}, ErrProviderUnavailable
+	}
+
+	p.Status = to
+
+	return s.update(ctx, p, from)
+}
+
+// update saves p if it is still in status from. If a concurrent call got
+// there first, the payment as that call left it is returned.
+func (s *service) update(ctx context.Context, p Payment, from PaymentStatus) (Payment, error) {
+	updated, err := s.repository.UpdatePayment(ctx, p, from)
+	if errors.Is(err, errStaleStatus) {
+		return s.repository.GetPaymentByID(ctx, p.ID)
+	}
+
+	return updated, err
+}
+
+func validCurrency(code string) bool {
+	if len(code) != 3 {
+		return false
+	}
+
+	for _, c := range code {
+		if c < 'A' || c > 'Z' {
+			return false
+		}
+	}
+
+	return true
+}
@@ -0,0 +1,3 @@
+module github.com/airlangga-hub/microservices/settings
+
+go 1.25.3
@@ -0,0 +1,151 @@
+// Package settings reads a service's settings from environment variables,
+// falling back to an optional JSON config file, then to defaults, and
+// collects every invalid or missing setting so they are reported together.
+package settings
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Loader reads settings and collects the problems with them. Its getters
+// return the default for a setting that is unset or empty, or invalid.
+type Loader struct {
+	file     map[string]string
+	problems []string
+}
+
+// NewLoader reads the JSON file named by the fileVar environment variable,
+// if set. The file is a flat object keyed by the same names as the
+// environment variables, which take precedence over it.
+func NewLoader(fileVar string) *Loader {
+	l := &Loader{file: map[string]string{}}
+
+	path := os.Getenv(fileVar)
+	if path == "" {
+		return l
+	}
+
+	content, err := os.ReadFile(path)
+	if err != nil {
+		l.Problemf("%s: %v", fileVar, err)
+		return l
+	}
+
+	if err := json.Unmarshal(content, &l.file); err != nil {
+		l.Problemf("%s: %s is not a flat JSON object of strings: %v", fileVar, path, err)
+	}
+
+	return l
+}
+
+func (l *Loader) lookup(key string) (string, bool) {
+	if v, ok := os.LookupEnv(key); ok && v != "" {
+		return v, true
+	}
+
+	v, ok := l.file[key]
+	return v, ok && v != ""
+}
+
+func (l *Loader) String(key, def string) string {
+	if v, ok := l.lookup(key); ok {
+		return v
+	}
+	return def
+}
+
+func (l *Loader) Int(key string, def int) int {
+	v, ok := l.lookup(key)
+	if !ok {
+		return def
+	}
+
+	n, err := strconv.Atoi(v)
+	if err != nil {
+		l.Problemf("%s: %q is not an integer", key, v)
+		return def
+	}
+	return n
+}
+
+func (l *Loader) Bool(key string, def bool) bool {
+	v, ok := l.lookup(key)
+	if !ok {
+		return def
+	}
+
+	b, err := strconv.ParseBool(v)
+	if err != nil {
+		l.Problemf("%s: %q is not a boolean", key, v)
+		return def
+	}
+	return b
+}
+
+func (l *Loader) Duration(key string, def time.Duration) time.Duration {
+	v, ok := l.lookup(key)
+	if !ok {
+		return def
+	}
+
+	d, err := time.ParseDuration(v)
+	if err != nil {
+		l.Problemf("%s: %q is not a duration", key, v)
+		return def
+	}
+	return d
+}
+
+// Required reports key if value is empty.
+func (l *Loader) Required(key, value string) {
+	if value == "" {
+		l.Problemf("%s is required", key)
+	}
+}
+
+// Address checks value is a [host]:port listen address with an explicit port,
+// so a missing setting never ends up on a random port.
+func (l *Loader) Address(key, value string) {
+	if value == "" {
+		l.Problemf("%s is required", key)
+		return
+	}
+
+	_, port, err := net.SplitHostPort(value)
+	if err != nil {
+		l.Problemf("%s: %q is not a [host]:port address", key, value)
+		return
+	}
+
+	if n, err := strconv.Atoi(port); err != nil || n < 1 || n > 65535 {
+		l.Problemf("%s: %q has no valid port", key, value)
+	}
+}
+
+// Check reports the problem format describes unless ok.
+func (l *Loader) Check(ok bool, format string, args ...any) {
+	if !ok {
+		l.Problemf(format, args...)
+	}
+}
+
+// Problemf reports a problem that no other method checks for.
+func (l *Loader) Problemf(format string, args ...any) {
+	l.problems = append(l.problems, fmt.Sprintf(format, args...))
+}
+
+// Err returns every problem found so far as one error, or nil.
+func (l *Loader) Err(service string) error {
+	if len(l.problems) == 0 {
+		return nil
+	}
+
+	return errors.New("invalid " + service + " configuration:\n  - " + strings.Join(l.problems, "\n  - "))
+}
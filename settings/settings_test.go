@@ -0,0 +1,172 @@
+package settings
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// writeFile writes a config file and points TEST_CONFIG_FILE at it.
+func writeFile(t *testing.T, content string) {
+	t.Helper()
+
+	path := filepath.Join(t.TempDir(), "config.json")
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatalf("WriteFile: %v", err)
+	}
+	t.Setenv("TEST_CONFIG_FILE", path)
+}
+
+func TestPrecedence(t *testing.T) {
+	tests := []struct {
+		name string
+		env  map[string]string
+		file string
+		want string
+	}{
+		{"default", nil, "", "default"},
+		{"file", nil, `{"TEST_NAME": "from-file"}`, "from-file"},
+		{"env", map[string]string{"TEST_NAME": "from-env"}, "", "from-env"},
+		{"env over file", map[string]string{"TEST_NAME": "from-env"}, `{"TEST_NAME": "from-file"}`, "from-env"},
+		{"empty env falls back to the file", map[string]string{"TEST_NAME": ""}, `{"TEST_NAME": "from-file"}`, "from-file"},
+		{"empty file value falls back to the default", nil, `{"TEST_NAME": ""}`, "default"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("TEST_CONFIG_FILE", "")
+			for k, v := range tt.env {
+				t.Setenv(k, v)
+			}
+			if tt.file != "" {
+				writeFile(t, tt.file)
+			}
+
+			l := NewLoader("TEST_CONFIG_FILE")
+			if got := l.String("TEST_NAME", "default"); got != tt.want {
+				t.Errorf("String = %q, want %q", got, tt.want)
+			}
+			if err := l.Err("test"); err != nil {
+				t.Errorf("Err = %v", err)
+			}
+		})
+	}
+}
+
+func TestTypedSettings(t *testing.T) {
+	tests := []struct {
+		name    string
+		value   string
+		get     func(l *Loader) any
+		want    any
+		problem string
+	}{
+		{"int default", "", func(l *Loader) any { return l.Int("TEST_VALUE", 5) }, 5, ""},
+		{"int", "25", func(l *Loader) any { return l.Int("TEST_VALUE", 5) }, 25, ""},
+		{"bad int", "many", func(l *Loader) any { return l.Int("TEST_VALUE", 5) }, 5, `TEST_VALUE: "many" is not an integer`},
+		{"bool default", "", func(l *Loader) any { return l.Bool("TEST_VALUE", true) }, true, ""},
+		{"bool", "false", func(l *Loader) any { return l.Bool("TEST_VALUE", true) }, false, ""},
+		{"bad bool", "nope", func(l *Loader) any { return l.Bool("TEST_VALUE", true) }, true, `TEST_VALUE: "nope" is not a boolean`},
+		{"duration default", "", func(l *Loader) any { return l.Duration("TEST_VALUE", time.Second) }, time.Second, ""},
+		{"duration", "1m30s", func(l *Loader) any { return l.Duration("TEST_VALUE", time.Second) }, 90 * time.Second, ""},
+		{"bad duration", "90", func(l *Loader) any { return l.Duration("TEST_VALUE", time.Second) }, time.Second, `TEST_VALUE: "90" is not a duration`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("TEST_CONFIG_FILE", "")
+			t.Setenv("TEST_VALUE", tt.value)
+
+			l := NewLoader("TEST_CONFIG_FILE")
+			if got := tt.get(l); got != tt.want {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+
+			err := l.Err("test")
+			if tt.problem == "" && err != nil {
+				t.Errorf("Err = %v, want nil", err)
+			}
+			if tt.problem != "" && (err == nil || !strings.Contains(err.Error(), tt.problem)) {
+				t.Errorf("Err = %v, want it to report %s", err, tt.problem)
+			}
+		})
+	}
+}
+
+func TestRequiredAndAddress(t *testing.T) {
+	tests := []struct {
+		name    string
+		check   func(l *Loader)
+		problem string
+	}{
+		{"required set", func(l *Loader) { l.Required("TEST_URL", "postgres://db") }, ""},
+		{"required missing", func(l *Loader) { l.Required("TEST_URL", "") }, "TEST_URL is required"},
+		{"address", func(l *Loader) { l.Address("TEST_PORT", ":8080") }, ""},
+		{"address with host", func(l *Loader) { l.Address("TEST_PORT", "localhost:8080") }, ""},
+		{"address missing", func(l *Loader) { l.Address("TEST_PORT", "") }, "TEST_PORT is required"},
+		{"address without a port", func(l *Loader) { l.Address("TEST_PORT", "8080") }, `TEST_PORT: "8080" is not a [host]:port address`},
+		{"address with port zero", func(l *Loader) { l.Address("TEST_PORT", ":0") }, `TEST_PORT: ":0" has no valid port`},
+		{"address with a named port", func(l *Loader) { l.Address("TEST_PORT", ":http") }, `TEST_PORT: ":http" has no valid port`},
+		{"check", func(l *Loader) { l.Check(false, "TEST_SIZE must be %s", "positive") }, "TEST_SIZE must be positive"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("TEST_CONFIG_FILE", "")
+
+			l := NewLoader("TEST_CONFIG_FILE")
+			tt.check(l)
+
+			err := l.Err("test")
+			if tt.problem == "" && err != nil {
+				t.Errorf("Err = %v, want nil", err)
+			}
+			if tt.problem != "" && (err == nil || !strings.Contains(err.Error(), tt.problem)) {
+				t.Errorf("Err = %v, want it to report %s", err, tt.problem)
+			}
+		})
+	}
+}
+
+func TestErrReportsEveryProblem(t *testing.T) {
+	t.Setenv("TEST_CONFIG_FILE", "")
+	t.Setenv("TEST_SIZE", "big")
+
+	l := NewLoader("TEST_CONFIG_FILE")
+	l.Int("TEST_SIZE", 1)
+	l.Required("TEST_URL", "")
+
+	err := l.Err("test")
+	if err == nil {
+		t.Fatal("Err = nil, want both problems")
+	}
+	want := "invalid test configuration:\n  - TEST_SIZE: \"big\" is not an integer\n  - TEST_URL is required"
+	if err.Error() != want {
+		t.Errorf("Err = %q, want %q", err, want)
+	}
+}
+
+func TestBadConfigFile(t *testing.T) {
+	tests := []struct {
+		name    string
+		setup   func(t *testing.T)
+		problem string
+	}{
+		{"missing file", func(t *testing.T) { t.Setenv("TEST_CONFIG_FILE", filepath.Join(t.TempDir(), "missing.json")) }, "TEST_CONFIG_FILE:"},
+		{"not json", func(t *testing.T) { writeFile(t, "TEST_NAME=x") }, "is not a flat JSON object of strings"},
+		{"nested", func(t *testing.T) { writeFile(t, `{"TEST_DB": {"URL": "x"}}`) }, "is not a flat JSON object of strings"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.setup(t)
+
+			err := NewLoader("TEST_CONFIG_FILE").Err("test")
+			if err == nil || !strings.Contains(err.Error(), tt.problem) {
+				t.Errorf("Err = %v, want it to report %s", err, tt.problem)
+			}
+		})
+	}
+}